@@ -2,11 +2,15 @@ module github.com/haikoschol/btc-node-challenge
 
 go 1.22.5
 
-require github.com/stretchr/testify v1.9.0
+require (
+	github.com/deckarep/golang-set/v2 v2.6.0
+	github.com/stretchr/testify v1.9.0
+	golang.org/x/crypto v0.33.0
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/deckarep/golang-set/v2 v2.6.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,89 @@
+// Package address decodes Bitcoin addresses into the output scripts they stand for.
+package address
+
+import (
+	"errors"
+	"fmt"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"strings"
+)
+
+const (
+	opDup         = 0x76
+	opHash160     = 0xa9
+	opEqual       = 0x87
+	opEqualVerify = 0x88
+	opCheckSig    = 0xac
+	op1           = 0x51
+	hash160Size   = 20
+)
+
+var (
+	ErrInvalidAddress = errors.New("invalid address")
+	ErrWrongNetwork   = errors.New("address belongs to another network")
+)
+
+// ToScript returns the output script paying to the given address on the network described by params. Base58 encoded
+// pay-to-pubkey-hash and pay-to-script-hash addresses as well as bech32 and bech32m encoded segwit addresses are
+// supported.
+func ToScript(addr string, params *btc.ChainParams) ([]byte, error) {
+	if strings.HasPrefix(strings.ToLower(addr), params.Bech32HRP+"1") {
+		return segwitScript(addr, params)
+	}
+
+	version, hash, err := decodeBase58Check(addr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidAddress, err)
+	}
+
+	if len(hash) != hash160Size {
+		return nil, fmt.Errorf("%w: payload has %d bytes", ErrInvalidAddress, len(hash))
+	}
+
+	switch version {
+	case params.PubKeyHashAddrID:
+		script := append([]byte{opDup, opHash160, hash160Size}, hash...)
+		return append(script, opEqualVerify, opCheckSig), nil
+	case params.ScriptHashAddrID:
+		script := append([]byte{opHash160, hash160Size}, hash...)
+		return append(script, opEqual), nil
+	default:
+		return nil, fmt.Errorf("%w: version byte %#x", ErrWrongNetwork, version)
+	}
+}
+
+// segwitScript decodes a segwit address (BIP173, BIP350) into a witness program output script.
+func segwitScript(addr string, params *btc.ChainParams) ([]byte, error) {
+	hrp, data, checksumConst, err := decodeBech32(addr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidAddress, err)
+	}
+
+	if hrp != params.Bech32HRP {
+		return nil, ErrWrongNetwork
+	}
+
+	if len(data) < 1 || data[0] > 16 {
+		return nil, fmt.Errorf("%w: invalid witness version", ErrInvalidAddress)
+	}
+
+	version := data[0]
+	if (version == 0) != (checksumConst == bech32Const) {
+		return nil, fmt.Errorf("%w: wrong checksum variant for witness version %d", ErrInvalidAddress, version)
+	}
+
+	program, ok := convertBits(data[1:])
+	if !ok || len(program) < 2 || len(program) > 40 {
+		return nil, fmt.Errorf("%w: invalid witness program", ErrInvalidAddress)
+	}
+
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		return nil, fmt.Errorf("%w: invalid witness program length %d", ErrInvalidAddress, len(program))
+	}
+
+	versionOp := byte(0)
+	if version > 0 {
+		versionOp = op1 + version - 1
+	}
+	return append([]byte{versionOp, byte(len(program))}, program...), nil
+}
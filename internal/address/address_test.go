@@ -0,0 +1,60 @@
+package address
+
+import (
+	"encoding/hex"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestToScript(t *testing.T) {
+	valid := []struct {
+		name   string
+		addr   string
+		params *btc.ChainParams
+		script string
+	}{
+		{"p2pkh", "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", &btc.MainNetParams, "76a91477bff20c60e522dfaa3350c39b030a5d004e839a88ac"},
+		{"p2pkh with leading zeros", "1111111111111111111114oLvT2", &btc.MainNetParams, "76a914000000000000000000000000000000000000000088ac"},
+		{"p2sh", "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", &btc.MainNetParams, "a914b472a266d0bd89c13706a4132ccfb16f7c3b9fcb87"},
+		{"testnet p2pkh", "mfWyW5fc9NUj75YAnFgoRLrjxgLDn2MMth", &btc.TestNet4Params, "76a914000102030405060708090a0b0c0d0e0f1011121388ac"},
+		{"testnet p2sh", "2MsFFCK16VhsCcvPXruztdzzcTZEQCbNKjJ", &btc.RegTestParams, "a914000102030405060708090a0b0c0d0e0f1011121387"},
+		{"p2wpkh upper case", "BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", &btc.MainNetParams, "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"p2wsh", "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", &btc.TestNet3Params, "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{"p2tr", "tb1pqqqsyqcyq5rqwzqfpg9scrgwpugpzysnzs23v9ccrydpk8qarc0slua5fd", &btc.SigNetParams, "5120000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"},
+		{"regtest p2wpkh", "bcrt1qqqqsyqcyq5rqwzqfpg9scrgwpugpzysnard0ew", &btc.RegTestParams, "0014000102030405060708090a0b0c0d0e0f10111213"},
+	}
+
+	for _, tc := range valid {
+		t.Run(tc.name, func(t *testing.T) {
+			script, err := ToScript(tc.addr, tc.params)
+			require.NoError(t, err)
+			assert.Equal(t, tc.script, hex.EncodeToString(script))
+		})
+	}
+
+	invalid := []struct {
+		name   string
+		addr   string
+		params *btc.ChainParams
+		err    error
+	}{
+		{"bad base58 checksum", "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN3", &btc.MainNetParams, ErrInvalidChecksum},
+		{"invalid base58 character", "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN0", &btc.MainNetParams, ErrInvalidBase58},
+		{"base58 address of another network", "mfWyW5fc9NUj75YAnFgoRLrjxgLDn2MMth", &btc.MainNetParams, ErrWrongNetwork},
+		{"segwit address of another network", "bcrt1qqqqsyqcyq5rqwzqfpg9scrgwpugpzysnard0ew", &btc.MainNetParams, ErrInvalidAddress},
+		{"bad bech32 checksum", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5", &btc.MainNetParams, ErrInvalidChecksum},
+		{"mixed case", "bc1qW508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", &btc.MainNetParams, ErrMixedCase},
+		// BIP350 test vectors
+		{"bech32 checksum for version 1", "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd", &btc.MainNetParams, ErrInvalidAddress},
+		{"bech32m checksum for version 0", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh", &btc.MainNetParams, ErrInvalidAddress},
+	}
+
+	for _, tc := range invalid {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ToScript(tc.addr, tc.params)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package address
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+const (
+	base58Alphabet      = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	base58ChecksumBytes = 4
+)
+
+var (
+	ErrInvalidBase58   = errors.New("invalid base58 string")
+	ErrInvalidChecksum = errors.New("invalid checksum")
+	ErrMissingVersion  = errors.New("base58check payload has no version byte")
+
+	bigRadix = big.NewInt(58)
+)
+
+// decodeBase58 decodes a base58 string. Leading '1' characters are decoded to leading zero bytes.
+func decodeBase58(s string) ([]byte, error) {
+	n := new(big.Int)
+	for _, c := range s {
+		digit := strings.IndexRune(base58Alphabet, c)
+		if digit < 0 {
+			return nil, ErrInvalidBase58
+		}
+		n.Mul(n, bigRadix)
+		n.Add(n, big.NewInt(int64(digit)))
+	}
+
+	zeros := len(s) - len(strings.TrimLeft(s, "1"))
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+// decodeBase58Check decodes a base58 string with a version byte and a checksum of four bytes, which are the start of
+// the double SHA256 hash of version and payload.
+func decodeBase58Check(s string) (version byte, payload []byte, err error) {
+	decoded, err := decodeBase58(s)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(decoded) < 1+base58ChecksumBytes {
+		return 0, nil, ErrMissingVersion
+	}
+
+	data := decoded[:len(decoded)-base58ChecksumBytes]
+	sum := sha256.Sum256(data)
+	sum = sha256.Sum256(sum[:])
+
+	if !bytes.Equal(sum[:base58ChecksumBytes], decoded[len(data):]) {
+		return 0, nil, ErrInvalidChecksum
+	}
+	return data[0], data[1:], nil
+}
@@ -0,0 +1,110 @@
+package address
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	// bech32Const and bech32mConst are the values the checksum of bech32 (BIP173) and bech32m (BIP350) strings must
+	// produce. Witness version 0 uses bech32, later versions use bech32m.
+	bech32Const     = 1
+	bech32mConst    = 0x2bc830a3
+	bech32MaxLength = 90
+	// bech32ChecksumLen is the number of five bit groups at the end of a bech32 string that make up the checksum.
+	bech32ChecksumLen = 6
+)
+
+var (
+	ErrInvalidBech32 = errors.New("invalid bech32 string")
+	ErrMixedCase     = errors.New("bech32 string has mixed case")
+)
+
+// decodeBech32 splits a bech32 or bech32m string into its human-readable part and its data in groups of five bits,
+// without the checksum. It returns the constant the checksum matched.
+func decodeBech32(s string) (hrp string, data []byte, checksumConst uint32, err error) {
+	if len(s) > bech32MaxLength {
+		return "", nil, 0, ErrInvalidBech32
+	}
+
+	lower := strings.ToLower(s)
+	if lower != s && strings.ToUpper(s) != s {
+		return "", nil, 0, ErrMixedCase
+	}
+
+	sep := strings.LastIndexByte(lower, '1')
+	if sep < 1 || sep+bech32ChecksumLen+1 > len(lower) {
+		return "", nil, 0, ErrInvalidBech32
+	}
+
+	hrp = lower[:sep]
+	for _, c := range hrp {
+		if c < 33 || c > 126 {
+			return "", nil, 0, ErrInvalidBech32
+		}
+	}
+
+	for _, c := range lower[sep+1:] {
+		digit := strings.IndexRune(bech32Charset, c)
+		if digit < 0 {
+			return "", nil, 0, ErrInvalidBech32
+		}
+		data = append(data, byte(digit))
+	}
+
+	checksumConst = bech32Polymod(append(hrpExpand(hrp), data...))
+	if checksumConst != bech32Const && checksumConst != bech32mConst {
+		return "", nil, 0, ErrInvalidChecksum
+	}
+	return hrp, data[:len(data)-bech32ChecksumLen], checksumConst, nil
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i, g := range generator {
+			if (top>>i)&1 == 1 {
+				chk ^= g
+			}
+		}
+	}
+	return chk
+}
+
+func hrpExpand(hrp string) []byte {
+	expanded := make([]byte, 0, len(hrp)*2+1)
+	for _, c := range hrp {
+		expanded = append(expanded, byte(c>>5))
+	}
+	expanded = append(expanded, 0)
+	for _, c := range hrp {
+		expanded = append(expanded, byte(c&31))
+	}
+	return expanded
+}
+
+// convertBits regroups data from groups of five bits to bytes. Leftover bits must be zero padding of less than five
+// bits.
+func convertBits(data []byte) ([]byte, bool) {
+	var out []byte
+	acc, bits := uint32(0), uint(0)
+
+	for _, v := range data {
+		acc = acc<<5 | uint32(v)
+		bits += 5
+		if bits >= 8 {
+			bits -= 8
+			out = append(out, byte(acc>>bits))
+		}
+	}
+
+	if bits >= 5 || acc&(1<<bits-1) != 0 {
+		return nil, false
+	}
+	return out, true
+}
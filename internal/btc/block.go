@@ -15,11 +15,24 @@ func (b *Block) Hash() (BlockHash, error) {
 	return b.Header.Hash()
 }
 
+// Encode serializes the block including the witness data of its transactions. The transaction count is taken from
+// the list of transactions, not from the header.
 func (b *Block) Encode() ([]byte, error) {
+	return b.encode(true)
+}
+
+// EncodeWithoutWitness serializes the block with its transactions in the legacy format, for peers that don't support
+// segregated witness.
+func (b *Block) EncodeWithoutWitness() ([]byte, error) {
+	return b.encode(false)
+}
+
+func (b *Block) encode(withWitnesses bool) ([]byte, error) {
 	encHeader, err := b.Header.Encode()
 	if err != nil {
 		return nil, err
 	}
+	encHeader = encHeader[:staticHeaderSize]
 
 	buf := new(bytes.Buffer)
 	written, err := buf.Write(encHeader)
@@ -36,7 +49,12 @@ func (b *Block) Encode() ([]byte, error) {
 	}
 
 	for _, tx := range b.Transactions {
-		encoded, err := tx.Encode()
+		encode := tx.Encode
+		if !withWitnesses {
+			encode = tx.EncodeWithoutWitness
+		}
+
+		encoded, err := encode()
 		if err != nil {
 			return nil, err
 		}
@@ -52,12 +70,33 @@ func (b *Block) Encode() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// Weight returns the weight of the block as defined in BIP141, which is the weight of its transactions plus the
+// header and transaction count weighted like non-witness data.
+func (b *Block) Weight() (int, error) {
+	count := vartypes.NewVarInt(uint64(len(b.Transactions)))
+	weight := (staticHeaderSize + int(count.Size)) * WitnessScaleFactor
+
+	for i := range b.Transactions {
+		txWeight, err := b.Transactions[i].Weight()
+		if err != nil {
+			return 0, err
+		}
+		weight += txWeight
+	}
+	return weight, nil
+}
+
 func DecodeBlock(buf *bytes.Buffer) (*Block, error) {
 	header, err := DecodeHeader(buf)
 	if err != nil {
 		return nil, err
 	}
 
+	// a transaction is at least ten bytes long, so a larger count than that can't be valid
+	if header.TxnCount.Value > uint64(buf.Len()/10) {
+		return nil, ErrInvalidTransaction
+	}
+
 	txns := make([]Transaction, header.TxnCount.Value)
 	for i := 0; uint64(i) < header.TxnCount.Value; i++ {
 		txn, err := DecodeTransaction(buf)
@@ -72,9 +111,3 @@ func DecodeBlock(buf *bytes.Buffer) (*Block, error) {
 		Transactions: txns,
 	}, nil
 }
-
-type BlocksByTimestamp []*Block
-
-func (a BlocksByTimestamp) Len() int           { return len(a) }
-func (a BlocksByTimestamp) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a BlocksByTimestamp) Less(i, j int) bool { return a[i].Header.Timestamp < a[j].Header.Timestamp }
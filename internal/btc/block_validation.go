@@ -0,0 +1,302 @@
+package btc
+
+import (
+	"bytes"
+	"errors"
+)
+
+// witnessCommitmentHeader starts the output script of the coinbase that commits to the witness data of a block. It
+// is OP_RETURN, a push of 36 bytes and the tag 0xaa21a9ed, followed by the 32 byte commitment (BIP141).
+var witnessCommitmentHeader = []byte{0x6a, 0x24, 0xaa, 0x21, 0xa9, 0xed}
+
+const (
+	witnessCommitmentSize = 38
+	// maxHalvings is the number of halvings after which the block subsidy is zero.
+	maxHalvings = 64
+)
+
+var (
+	ErrFirstTxNotCoinbase   = errors.New("first transaction of block is not a coinbase")
+	ErrMultipleCoinbases    = errors.New("block contains more than one coinbase")
+	ErrBlockTooHeavy        = errors.New("block weight exceeds the maximum")
+	ErrTooManySigOps        = errors.New("block exceeds the signature operation limit")
+	ErrBadCoinbaseHeight    = errors.New("coinbase doesn't start with the block height")
+	ErrBadCoinbaseValue     = errors.New("coinbase pays more than the block subsidy plus fees")
+	ErrBadWitnessNonce      = errors.New("coinbase witness is not a single 32 byte value")
+	ErrBadWitnessCommitment = errors.New("witness commitment doesn't match the witness merkle root")
+	ErrUnexpectedWitness    = errors.New("transaction has witness data but block has no witness commitment")
+	ErrBadScript            = errors.New("script verification failed")
+)
+
+// ScriptVerifier verifies the scripts of all inputs of tx. spentOutputs are the outputs spent by the inputs, in the
+// same order. Script execution is implemented by the script package, which depends on this one.
+type ScriptVerifier func(tx *Transaction, spentOutputs []TxOutput) error
+
+// BlockSubsidy returns the amount of new coins a block at the given height may create. It starts at 50 bitcoin and is
+// halved every params.SubsidyHalvingInterval blocks.
+func BlockSubsidy(height int32, params *ConsensusParams) int64 {
+	halvings := height / params.SubsidyHalvingInterval
+	if halvings >= maxHalvings {
+		return 0
+	}
+	return 50 * SatoshisPerBitcoin >> halvings
+}
+
+// CheckBlock performs the checks of a block that don't depend on the chain it is part of: the coinbase placement, the
+// merkle root, CheckTransaction for all transactions, the block weight and the number of signature operations in
+// the scripts of the block itself.
+func CheckBlock(block *Block) error {
+	if len(block.Transactions) == 0 {
+		return &RuleError{Rule: ErrNoTransactions}
+	}
+
+	if err := block.CheckMerkleRoot(); err != nil {
+		if errors.Is(err, ErrBadMerkleRoot) || errors.Is(err, ErrMutatedMerkleTree) {
+			return &RuleError{Rule: err}
+		}
+		return err
+	}
+
+	if !block.Transactions[0].IsCoinbase() {
+		return &RuleError{Rule: ErrFirstTxNotCoinbase}
+	}
+
+	sigOps := 0
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		if i > 0 && tx.IsCoinbase() {
+			return ruleError(ErrMultipleCoinbases, "transaction %d", i)
+		}
+
+		if err := CheckTransaction(tx); err != nil {
+			return err
+		}
+		sigOps += tx.LegacySigOpCount()
+	}
+
+	if sigOps*WitnessScaleFactor > MaxBlockSigOpsCost {
+		return ruleError(ErrTooManySigOps, "%d legacy signature operations", sigOps)
+	}
+
+	weight, err := block.Weight()
+	if err != nil {
+		return err
+	}
+	if weight > MaxBlockWeight {
+		return ruleError(ErrBlockTooHeavy, "weight is %d", weight)
+	}
+	return nil
+}
+
+// ValidateBlock checks that a block is valid on top of chain, with the outputs spent by its transactions looked up in
+// view. In addition to CheckBlock, it enforces the height in the coinbase (BIP34), the witness commitment (BIP141), the
+// lock times of all transactions (BIP113) and the relative lock times of their inputs (BIP68), the rules of
+// CheckTxInputs, the signature operation cost, the scripts of all inputs with verify and that the coinbase doesn't pay
+// more than the block subsidy plus fees. Violations of consensus rules are returned as *RuleError.
+func ValidateBlock(
+	block *Block,
+	chain HeaderChain,
+	view CoinView,
+	params *ConsensusParams,
+	verify ScriptVerifier,
+) error {
+	if err := CheckBlock(block); err != nil {
+		return err
+	}
+
+	height := chain.Height() + 1
+	csv := height >= params.CSVHeight
+	lockTimeCutoff := int64(block.Header.Timestamp)
+	if csv {
+		lockTimeCutoff = int64(MedianTimePast(chain))
+	}
+
+	coinbase := &block.Transactions[0]
+	if height >= params.BIP34Height {
+		expected := encodeHeight(height)
+		if !bytes.HasPrefix(coinbase.TxIn[0].SignatureScript, expected) {
+			return ruleError(ErrBadCoinbaseHeight, "expected height %d", height)
+		}
+	}
+
+	if err := checkWitnessCommitment(block, height, params); err != nil {
+		return err
+	}
+
+	blockView := newBlockView(view)
+	var fees int64
+	sigOpCost := 0
+
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		if err := checkFinalTx(tx, height, lockTimeCutoff); err != nil {
+			return err
+		}
+
+		if i > 0 {
+			fee, err := CheckTxInputs(tx, blockView, height)
+			if err != nil {
+				return err
+			}
+
+			if csv {
+				if err := checkSequenceLocks(tx, blockView, height, chain); err != nil {
+					return err
+				}
+			}
+
+			fees += fee
+			if !MoneyRange(fees) {
+				return ruleError(ErrInputValueOutOfRange, "total fees are %d after transaction %d", fees, i)
+			}
+		}
+
+		sigOpCost += tx.SigOpCost(blockView, height, params)
+		if sigOpCost > MaxBlockSigOpsCost {
+			return ruleError(ErrTooManySigOps, "cost is %d after transaction %d", sigOpCost, i)
+		}
+
+		if i > 0 {
+			spentOutputs := make([]TxOutput, len(tx.TxIn))
+			for j, in := range tx.TxIn {
+				coin, _ := blockView.Coin(in.PreviousOutput)
+				spentOutputs[j] = coin.Output
+			}
+
+			if err := verify(tx, spentOutputs); err != nil {
+				return ruleError(ErrBadScript, "transaction %d: %v", i, err)
+			}
+		}
+
+		if err := blockView.add(tx, height); err != nil {
+			return err
+		}
+	}
+
+	var coinbaseValue int64
+	for _, out := range coinbase.TxOut {
+		coinbaseValue += out.Value
+	}
+
+	limit := BlockSubsidy(height, params) + fees
+	if coinbaseValue > limit {
+		return ruleError(ErrBadCoinbaseValue, "pays %d, limit is %d", coinbaseValue, limit)
+	}
+	return nil
+}
+
+// checkWitnessCommitment verifies the commitment to the witness data in the coinbase once segwit is active. Without a
+// commitment, no transaction in the block may have witness data.
+func checkWitnessCommitment(block *Block, height int32, params *ConsensusParams) error {
+	coinbase := &block.Transactions[0]
+	index := -1
+	if height >= params.SegwitHeight {
+		index = witnessCommitmentIndex(coinbase)
+	}
+
+	if index < 0 {
+		for i := range block.Transactions {
+			if block.Transactions[i].HasWitness() {
+				return ruleError(ErrUnexpectedWitness, "transaction %d", i)
+			}
+		}
+		return nil
+	}
+
+	witness := coinbase.TxIn[0].Witness
+	if len(witness) != 1 || len(witness[0]) != 32 {
+		return &RuleError{Rule: ErrBadWitnessNonce}
+	}
+
+	root, err := block.WitnessMerkleRoot()
+	if err != nil {
+		return err
+	}
+
+	commitment := doubleSHA256(append(root[:], witness[0]...))
+	script := coinbase.TxOut[index].ScriptPubKey
+	if !bytes.Equal(commitment[:], script[len(witnessCommitmentHeader):witnessCommitmentSize]) {
+		return &RuleError{Rule: ErrBadWitnessCommitment}
+	}
+	return nil
+}
+
+// witnessCommitmentIndex returns the index of the last output of the coinbase that contains a witness commitment, or
+// -1 if there is none.
+func witnessCommitmentIndex(coinbase *Transaction) int {
+	index := -1
+	for i, out := range coinbase.TxOut {
+		if len(out.ScriptPubKey) >= witnessCommitmentSize && bytes.HasPrefix(out.ScriptPubKey, witnessCommitmentHeader) {
+			index = i
+		}
+	}
+	return index
+}
+
+// encodeHeight returns the script that pushes the block height as a minimally encoded number, which is how BIP34
+// requires the signature script of the coinbase to start.
+func encodeHeight(height int32) []byte {
+	if height == 0 {
+		return []byte{op0}
+	}
+	if height <= 16 {
+		return []byte{op1 + byte(height-1)}
+	}
+
+	var num []byte
+	for n := uint32(height); n > 0; n >>= 8 {
+		num = append(num, byte(n))
+	}
+	// the most significant bit is the sign bit
+	if num[len(num)-1]&0x80 != 0 {
+		num = append(num, 0)
+	}
+	return append([]byte{byte(len(num))}, num...)
+}
+
+// blockView is a CoinView that includes the outputs created by the transactions of a block that have been validated
+// so far and excludes the outputs they spent.
+type blockView struct {
+	base    CoinView
+	created map[OutPoint]Coin
+	spent   map[OutPoint]bool
+}
+
+func newBlockView(base CoinView) *blockView {
+	return &blockView{
+		base:    base,
+		created: make(map[OutPoint]Coin),
+		spent:   make(map[OutPoint]bool),
+	}
+}
+
+func (v *blockView) Coin(outPoint OutPoint) (Coin, bool) {
+	if v.spent[outPoint] {
+		return Coin{}, false
+	}
+	if coin, ok := v.created[outPoint]; ok {
+		return coin, true
+	}
+	return v.base.Coin(outPoint)
+}
+
+func (v *blockView) add(tx *Transaction, height int32) error {
+	txid, err := tx.TxID()
+	if err != nil {
+		return err
+	}
+
+	isCoinbase := tx.IsCoinbase()
+	if !isCoinbase {
+		for _, in := range tx.TxIn {
+			v.spent[in.PreviousOutput] = true
+		}
+	}
+
+	for i, out := range tx.TxOut {
+		outPoint := OutPoint{Hash: txid, Index: uint32(i)}
+		v.created[outPoint] = Coin{Output: out, Height: height, IsCoinbase: isCoinbase}
+		delete(v.spent, outPoint)
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package btc
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func testBlock(t *testing.T, txs ...Transaction) *Block {
+	t.Helper()
+
+	block := &Block{Transactions: txs}
+	hashes := make([][32]byte, len(txs))
+	for i := range txs {
+		txid, err := txs[i].TxID()
+		require.NoError(t, err)
+		hashes[i] = txid
+	}
+	block.Header.MerkleRoot, _ = MerkleRoot(hashes)
+	return block
+}
+
+// chainAt returns a chain for validating a block at the given height. All timestamps of the chain are zero.
+func chainAt(height int32) *sparseChain {
+	return &sparseChain{height: height - 1}
+}
+
+func coinbaseAt(height int32, value int64) Transaction {
+	tx := validCoinbase()
+	tx.TxIn[0].SignatureScript = append(encodeHeight(height), 0x00)
+	tx.TxOut[0].Value = value
+	return *tx
+}
+
+// addWitnessCommitment adds the witness nonce and commitment to the coinbase of the block.
+func addWitnessCommitment(t *testing.T, block *Block) {
+	t.Helper()
+
+	coinbase := &block.Transactions[0]
+	coinbase.HasWitnesses = true
+	coinbase.TxIn[0].Witness = TxWitness{make([]byte, 32)}
+
+	root, err := block.WitnessMerkleRoot()
+	require.NoError(t, err)
+	commitment := doubleSHA256(append(root[:], make([]byte, 32)...))
+
+	script := append(bytes.Clone(witnessCommitmentHeader), commitment[:]...)
+	coinbase.TxOut = append(coinbase.TxOut, TxOutput{ScriptPubKey: script})
+
+	txid, err := coinbase.TxID()
+	require.NoError(t, err)
+	hashes := [][32]byte{txid}
+	for i := 1; i < len(block.Transactions); i++ {
+		txid, err := block.Transactions[i].TxID()
+		require.NoError(t, err)
+		hashes = append(hashes, txid)
+	}
+	block.Header.MerkleRoot, _ = MerkleRoot(hashes)
+}
+
+func TestBlockSubsidy(t *testing.T) {
+	params := &MainNetConsensus
+	assert.Equal(t, int64(50*SatoshisPerBitcoin), BlockSubsidy(0, params))
+	assert.Equal(t, int64(50*SatoshisPerBitcoin), BlockSubsidy(209999, params))
+	assert.Equal(t, int64(25*SatoshisPerBitcoin), BlockSubsidy(210000, params))
+	assert.Equal(t, int64(3.125*SatoshisPerBitcoin), BlockSubsidy(840000, params))
+	assert.Equal(t, int64(1), BlockSubsidy(32*210000, params))
+	assert.Equal(t, int64(0), BlockSubsidy(33*210000, params))
+	assert.Equal(t, int64(0), BlockSubsidy(64*210000, params))
+}
+
+func TestEncodeHeight(t *testing.T) {
+	assert.Equal(t, []byte{0x00}, encodeHeight(0))
+	assert.Equal(t, []byte{0x51}, encodeHeight(1))
+	assert.Equal(t, []byte{0x60}, encodeHeight(16))
+	assert.Equal(t, []byte{0x01, 0x11}, encodeHeight(17))
+	assert.Equal(t, []byte{0x02, 0x80, 0x00}, encodeHeight(128))
+	// block 227931, where BIP34 activated on mainnet
+	assert.Equal(t, []byte{0x03, 0x5b, 0x7a, 0x03}, encodeHeight(227931))
+}
+
+func TestWeight(t *testing.T) {
+	coinbase := decodeGenesisCoinbase(t)
+	weight, err := coinbase.Weight()
+	require.NoError(t, err)
+	assert.Equal(t, 204*WitnessScaleFactor, weight)
+
+	block := Block{Transactions: []Transaction{coinbase}}
+	weight, err = block.Weight()
+	require.NoError(t, err)
+	assert.Equal(t, 285*WitnessScaleFactor, weight)
+
+	coinbase.HasWitnesses = true
+	coinbase.TxIn[0].Witness = TxWitness{make([]byte, 32)}
+	weight, err = coinbase.Weight()
+	require.NoError(t, err)
+	// marker, flag, the witness item count, the length of the item and the item itself
+	assert.Equal(t, 204*WitnessScaleFactor+2+1+1+32, weight)
+}
+
+func TestSigOps(t *testing.T) {
+	p2pkh := []byte{0x76, 0xa9, 0x14}
+	p2pkh = append(p2pkh, make([]byte, 20)...)
+	p2pkh = append(p2pkh, 0x88, 0xac)
+	multiSig := []byte{0x52, 0x21, 0x02}
+	multiSig = append(multiSig, make([]byte, 32)...)
+	multiSig = append(multiSig, 0x51, 0xae)
+
+	assert.Equal(t, 1, countSigOps(p2pkh, false))
+	assert.Equal(t, 20, countSigOps(multiSig, false))
+	assert.Equal(t, 1, countSigOps(multiSig, true))
+	assert.Equal(t, 0, countSigOps([]byte{0x4c}, false), "truncated push")
+	assert.Equal(t, 1, countSigOps([]byte{0xac, 0x4d, 0xff}, false), "counts until the malformed push")
+
+	redeemHash := make([]byte, 20)
+	p2sh := append(append([]byte{0xa9, 0x14}, redeemHash...), 0x87)
+	p2wpkh := append([]byte{0x00, 0x14}, make([]byte, 20)...)
+	p2wsh := append([]byte{0x00, 0x20}, make([]byte, 32)...)
+
+	view := coinMap{
+		{Index: 0}: {Output: TxOutput{ScriptPubKey: p2pkh}},
+		{Index: 1}: {Output: TxOutput{ScriptPubKey: p2sh}},
+		{Index: 2}: {Output: TxOutput{ScriptPubKey: p2wpkh}},
+		{Index: 3}: {Output: TxOutput{ScriptPubKey: p2wsh}},
+		{Index: 4}: {Output: TxOutput{ScriptPubKey: p2sh}},
+	}
+
+	tx := Transaction{
+		TxIn: []TxInput{
+			{PreviousOutput: OutPoint{Index: 0}, SignatureScript: []byte{0x01, 0x00}},
+			{PreviousOutput: OutPoint{Index: 1}, SignatureScript: append([]byte{byte(len(multiSig))}, multiSig...)},
+			{PreviousOutput: OutPoint{Index: 2}, Witness: TxWitness{{0x01}, {0x02}}},
+			{PreviousOutput: OutPoint{Index: 3}, Witness: TxWitness{{0x01}, multiSig}},
+			// P2SH wrapped P2WPKH
+			{PreviousOutput: OutPoint{Index: 4}, SignatureScript: append([]byte{byte(len(p2wpkh))}, p2wpkh...)},
+		},
+		TxOut: []TxOutput{{ScriptPubKey: p2pkh}},
+	}
+
+	assert.Equal(t, 1, tx.LegacySigOpCount())
+	params := &MainNetConsensus
+	// legacy output and P2SH multisig with one key count four times, P2WPKH, P2WSH and P2SH-P2WPKH once
+	assert.Equal(t, 4+4+1+1+1, tx.SigOpCost(view, params.SegwitHeight, params))
+	assert.Equal(t, 4+4, tx.SigOpCost(view, params.SegwitHeight-1, params), "witness sigops before segwit")
+	assert.Equal(t, 4, tx.SigOpCost(view, params.BIP16Height-1, params), "P2SH sigops before BIP16")
+}
+
+// acceptScripts is a ScriptVerifier that accepts all scripts, for testing the other rules.
+func acceptScripts(*Transaction, []TxOutput) error {
+	return nil
+}
+
+func TestValidateBlock(t *testing.T) {
+	params := &MainNetConsensus
+	height := params.SegwitHeight
+	subsidy := BlockSubsidy(height, params)
+
+	funding := Transaction{Version: 1, TxIn: []TxInput{{PreviousOutput: OutPoint{Hash: TxHash{1}}}}, TxOut: []TxOutput{{Value: 1000, ScriptPubKey: []byte{0x51}}}}
+	fundingOut := OutPoint{Hash: TxHash{1}}
+	view := coinMap{fundingOut: {Output: TxOutput{Value: 5000, ScriptPubKey: []byte{0x51}}, Height: 1}}
+	fee := int64(4000)
+
+	fundingID, err := funding.TxID()
+	require.NoError(t, err)
+	chained := Transaction{Version: 1, TxIn: []TxInput{{PreviousOutput: OutPoint{Hash: fundingID}}}, TxOut: []TxOutput{{Value: 1000, ScriptPubKey: []byte{0x51}}}}
+
+	t.Run("valid", func(t *testing.T) {
+		block := testBlock(t, coinbaseAt(height, subsidy+fee), funding, chained)
+		assert.NoError(t, ValidateBlock(block, chainAt(height), view, params, acceptScripts))
+	})
+
+	t.Run("scripts", func(t *testing.T) {
+		block := testBlock(t, coinbaseAt(height, subsidy+fee), funding, chained)
+
+		var spent [][]TxOutput
+		verify := func(tx *Transaction, spentOutputs []TxOutput) error {
+			spent = append(spent, spentOutputs)
+			return nil
+		}
+		assert.NoError(t, ValidateBlock(block, chainAt(height), view, params, verify))
+		assert.Equal(t, [][]TxOutput{{view[fundingOut].Output}, {funding.TxOut[0]}}, spent)
+
+		reject := func(tx *Transaction, spentOutputs []TxOutput) error {
+			return errors.New("signature doesn't match")
+		}
+		assert.ErrorIs(t, ValidateBlock(block, chainAt(height), view, params, reject), ErrBadScript)
+	})
+
+	t.Run("lock time", func(t *testing.T) {
+		locked := funding
+		locked.LockTime = LockTimeThreshold + 1000
+		block := testBlock(t, coinbaseAt(height, subsidy+fee), locked)
+		block.Header.Timestamp = LockTimeThreshold + 2000
+
+		// the median time past of the chain is zero, only the block timestamp has passed the lock time
+		assert.ErrorIs(t, ValidateBlock(block, chainAt(height), view, params, acceptScripts), ErrNonFinalTx)
+
+		preCSV := *params
+		preCSV.CSVHeight = height + 1
+		assert.NoError(t, ValidateBlock(block, chainAt(height), view, &preCSV, acceptScripts))
+	})
+
+	t.Run("sequence locks", func(t *testing.T) {
+		recent := OutPoint{Hash: TxHash{2}}
+		view := coinMap{recent: {Output: TxOutput{Value: 5000, ScriptPubKey: []byte{0x51}}, Height: height - 10}}
+
+		relative := funding
+		relative.Version = 2
+		relative.TxIn = []TxInput{{PreviousOutput: recent, Sequence: 11}}
+		block := testBlock(t, coinbaseAt(height, subsidy+fee), relative)
+		assert.ErrorIs(t, ValidateBlock(block, chainAt(height), view, params, acceptScripts), ErrSequenceLocks)
+
+		preCSV := *params
+		preCSV.CSVHeight = height + 1
+		assert.NoError(t, ValidateBlock(block, chainAt(height), view, &preCSV, acceptScripts))
+
+		relative.TxIn[0].Sequence = 10
+		block = testBlock(t, coinbaseAt(height, subsidy+fee), relative)
+		assert.NoError(t, ValidateBlock(block, chainAt(height), view, params, acceptScripts))
+	})
+
+	t.Run("coinbase value", func(t *testing.T) {
+		block := testBlock(t, coinbaseAt(height, subsidy+fee+1), funding)
+		assert.ErrorIs(t, ValidateBlock(block, chainAt(height), view, params, acceptScripts), ErrBadCoinbaseValue)
+	})
+
+	t.Run("coinbase height", func(t *testing.T) {
+		block := testBlock(t, coinbaseAt(height+1, subsidy))
+		assert.ErrorIs(t, ValidateBlock(block, chainAt(height), view, params, acceptScripts), ErrBadCoinbaseHeight)
+
+		// not enforced before BIP34
+		block = testBlock(t, coinbaseAt(params.BIP34Height, subsidy))
+		assert.NoError(t, ValidateBlock(block, chainAt(params.BIP34Height-1), view, params, acceptScripts))
+	})
+
+	t.Run("missing and double spent inputs", func(t *testing.T) {
+		block := testBlock(t, coinbaseAt(height, subsidy), chained)
+		assert.ErrorIs(t, ValidateBlock(block, chainAt(height), view, params, acceptScripts), ErrMissingInputs)
+
+		doubleSpend := funding
+		doubleSpend.TxOut = []TxOutput{{Value: 999, ScriptPubKey: []byte{0x51}}}
+		block = testBlock(t, coinbaseAt(height, subsidy), funding, doubleSpend)
+		assert.ErrorIs(t, ValidateBlock(block, chainAt(height), view, params, acceptScripts), ErrMissingInputs)
+	})
+
+	t.Run("coinbase placement", func(t *testing.T) {
+		block := testBlock(t, funding)
+		assert.ErrorIs(t, ValidateBlock(block, chainAt(height), view, params, acceptScripts), ErrFirstTxNotCoinbase)
+
+		block = testBlock(t, coinbaseAt(height, subsidy), coinbaseAt(height, 0))
+		assert.ErrorIs(t, ValidateBlock(block, chainAt(height), view, params, acceptScripts), ErrMultipleCoinbases)
+	})
+
+	t.Run("merkle root", func(t *testing.T) {
+		block := testBlock(t, coinbaseAt(height, subsidy))
+		block.Header.MerkleRoot[0] ^= 1
+		assert.ErrorIs(t, ValidateBlock(block, chainAt(height), view, params, acceptScripts), ErrBadMerkleRoot)
+	})
+
+	t.Run("weight", func(t *testing.T) {
+		large := funding
+		large.TxOut = []TxOutput{{Value: 1000, ScriptPubKey: make([]byte, MaxBlockWeight/WitnessScaleFactor/2)}}
+		larger := chained
+		larger.TxOut = large.TxOut
+
+		block := testBlock(t, coinbaseAt(height, subsidy), large, larger)
+		assert.ErrorIs(t, ValidateBlock(block, chainAt(height), view, params, acceptScripts), ErrBlockTooHeavy)
+	})
+
+	t.Run("sigops", func(t *testing.T) {
+		sigOps := funding
+		sigOps.TxOut = []TxOutput{{Value: 1000, ScriptPubKey: bytes.Repeat([]byte{0xac}, MaxBlockSigOpsCost/WitnessScaleFactor)}}
+		block := testBlock(t, coinbaseAt(height, subsidy), sigOps)
+		assert.NoError(t, ValidateBlock(block, chainAt(height), view, params, acceptScripts))
+
+		sigOps.TxOut[0].ScriptPubKey = append(sigOps.TxOut[0].ScriptPubKey, 0xac)
+		block = testBlock(t, coinbaseAt(height, subsidy), sigOps)
+		assert.ErrorIs(t, ValidateBlock(block, chainAt(height), view, params, acceptScripts), ErrTooManySigOps)
+	})
+
+	t.Run("witness commitment", func(t *testing.T) {
+		witnessTx := funding
+		witnessTx.HasWitnesses = true
+		witnessTx.TxIn = []TxInput{{PreviousOutput: fundingOut, Witness: TxWitness{{0x01}}}}
+
+		block := testBlock(t, coinbaseAt(height, subsidy+fee), witnessTx)
+		assert.ErrorIs(t, ValidateBlock(block, chainAt(height), view, params, acceptScripts), ErrUnexpectedWitness)
+
+		addWitnessCommitment(t, block)
+		assert.NoError(t, ValidateBlock(block, chainAt(height), view, params, acceptScripts))
+
+		// commitments are ignored before segwit activation
+		preSegwit := *params
+		preSegwit.SegwitHeight = height + 1
+		assert.ErrorIs(t, ValidateBlock(block, chainAt(height), view, &preSegwit, acceptScripts), ErrUnexpectedWitness)
+
+		block.Transactions[1].TxIn = []TxInput{{PreviousOutput: fundingOut, Witness: TxWitness{{0x02}}}}
+		assert.ErrorIs(t, ValidateBlock(block, chainAt(height), view, params, acceptScripts), ErrBadWitnessCommitment)
+
+		block = testBlock(t, coinbaseAt(height, subsidy+fee), witnessTx)
+		addWitnessCommitment(t, block)
+		block.Transactions[0].TxIn[0].Witness = TxWitness{make([]byte, 31)}
+		assert.ErrorIs(t, ValidateBlock(block, chainAt(height), view, params, acceptScripts), ErrBadWitnessNonce)
+	})
+}
@@ -0,0 +1,16 @@
+package btc
+
+// Coin is an unspent transaction output together with the information about its creation that is needed to validate
+// spending it.
+type Coin struct {
+	Output TxOutput
+	// Height is the height of the block containing the transaction that created the output.
+	Height     int32
+	IsCoinbase bool
+}
+
+// CoinView provides access to the unspent transaction outputs of a chain.
+type CoinView interface {
+	// Coin returns the unspent output referenced by outPoint, if there is one.
+	Coin(outPoint OutPoint) (Coin, bool)
+}
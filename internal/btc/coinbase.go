@@ -0,0 +1,24 @@
+package btc
+
+import "encoding/binary"
+
+// ExtraNonceSize is the size of the extra nonce at the end of the signature script of coinbases built by NewCoinbase.
+const ExtraNonceSize = 4
+
+// NewCoinbase returns a coinbase transaction for a block at the given height, which pays value to pkScript. Its
+// signature script starts with the height as required by BIP34 and ends with a push of extraNonce, which miners change
+// to get a different merkle root once they have tried all nonces of a header.
+func NewCoinbase(height int32, value int64, pkScript []byte, extraNonce uint32) Transaction {
+	sigScript := append(encodeHeight(height), ExtraNonceSize)
+	sigScript = binary.LittleEndian.AppendUint32(sigScript, extraNonce)
+
+	return Transaction{
+		Version: 1,
+		TxIn: []TxInput{{
+			PreviousOutput:  OutPoint{Index: 0xffffffff},
+			SignatureScript: sigScript,
+			Sequence:        0xffffffff,
+		}},
+		TxOut: []TxOutput{{Value: value, ScriptPubKey: pkScript}},
+	}
+}
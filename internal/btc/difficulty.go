@@ -0,0 +1,277 @@
+package btc
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+)
+
+const (
+	// medianTimeSpan is the number of previous blocks whose timestamps determine the median time past.
+	medianTimeSpan = 11
+	// maxFutureBlockTime is how far the timestamp of a block may be ahead of the local clock.
+	maxFutureBlockTime = 2 * 60 * 60
+	// maxTimewarp is how far the first block of a difficulty period may go back in time compared to the last block of
+	// the previous period when BIP94 is enforced.
+	maxTimewarp = 600
+)
+
+var (
+	ErrUnexpectedDifficulty = errors.New("difficulty bits don't match the difficulty adjustment")
+	ErrTimeTooOld           = errors.New("block timestamp is not after the median time past")
+	ErrTimeTooNew           = errors.New("block timestamp is too far in the future")
+	ErrTimewarpAttack       = errors.New("block timestamp goes back too far at the start of a difficulty period")
+)
+
+// ConsensusParams contains the consensus rules that differ between networks.
+type ConsensusParams struct {
+	// PowLimit is the highest target, i.e. the lowest difficulty, allowed on the network.
+	PowLimit *big.Int
+	// PowLimitBits is PowLimit in the compact representation used in block headers.
+	PowLimitBits uint32
+	// TargetTimespan is the duration in seconds that one difficulty period is supposed to take.
+	TargetTimespan int64
+	// TargetSpacing is the desired number of seconds between two blocks.
+	TargetSpacing int64
+	// AllowMinDifficultyBlocks allows mining a block with the lowest difficulty if more than twice the target spacing
+	// has passed since the previous block, as on the test networks.
+	AllowMinDifficultyBlocks bool
+	// NoRetargeting keeps the difficulty constant, as on regtest.
+	NoRetargeting bool
+	// EnforceBIP94 bases the difficulty adjustment on the first block of a period instead of the last one, so that
+	// minimum difficulty blocks can't reset the difficulty, and rejects blocks exploiting the timewarp attack.
+	EnforceBIP94 bool
+	// SubsidyHalvingInterval is the number of blocks after which the block subsidy is halved.
+	SubsidyHalvingInterval int32
+	// BIP16Height is the height from which pay-to-script-hash (BIP16) is enforced.
+	BIP16Height int32
+	// BIP34Height is the height from which coinbase transactions must start with the block height.
+	BIP34Height int32
+	// BIP65Height is the height from which OP_CHECKLOCKTIMEVERIFY is enforced.
+	BIP65Height int32
+	// BIP66Height is the height from which signatures must be strict DER.
+	BIP66Height int32
+	// CSVHeight is the height from which relative lock times (BIP68, BIP112) and the median time past as lock time
+	// cutoff (BIP113) are enforced.
+	CSVHeight int32
+	// SegwitHeight is the height from which segregated witness (BIP141, BIP143, BIP147) is enforced.
+	SegwitHeight int32
+	// TaprootHeight is the height from which taproot (BIP341, BIP342) is enforced.
+	TaprootHeight int32
+}
+
+// DifficultyAdjustmentInterval returns the number of blocks after which the difficulty is adjusted.
+func (p *ConsensusParams) DifficultyAdjustmentInterval() int32 {
+	return int32(p.TargetTimespan / p.TargetSpacing)
+}
+
+var (
+	MainNetConsensus = ConsensusParams{
+		PowLimit:               MainNetPowLimit,
+		PowLimitBits:           0x1d00ffff,
+		TargetTimespan:         14 * 24 * 60 * 60,
+		TargetSpacing:          10 * 60,
+		SubsidyHalvingInterval: 210000,
+		BIP16Height:            173805,
+		BIP34Height:            227931,
+		BIP65Height:            388381,
+		BIP66Height:            363725,
+		CSVHeight:              419328,
+		SegwitHeight:           481824,
+		TaprootHeight:          709632,
+	}
+
+	TestNet3Consensus = ConsensusParams{
+		PowLimit:                 MainNetPowLimit,
+		PowLimitBits:             0x1d00ffff,
+		TargetTimespan:           14 * 24 * 60 * 60,
+		TargetSpacing:            10 * 60,
+		AllowMinDifficultyBlocks: true,
+		SubsidyHalvingInterval:   210000,
+		BIP16Height:              514,
+		BIP34Height:              21111,
+		BIP65Height:              581885,
+		BIP66Height:              330776,
+		CSVHeight:                770112,
+		SegwitHeight:             834624,
+		TaprootHeight:            2011968,
+	}
+
+	TestNet4Consensus = ConsensusParams{
+		PowLimit:                 MainNetPowLimit,
+		PowLimitBits:             0x1d00ffff,
+		TargetTimespan:           14 * 24 * 60 * 60,
+		TargetSpacing:            10 * 60,
+		AllowMinDifficultyBlocks: true,
+		EnforceBIP94:             true,
+		SubsidyHalvingInterval:   210000,
+		BIP16Height:              1,
+		BIP34Height:              1,
+		BIP65Height:              1,
+		BIP66Height:              1,
+		CSVHeight:                1,
+		SegwitHeight:             1,
+		TaprootHeight:            1,
+	}
+
+	SigNetConsensus = ConsensusParams{
+		PowLimit:               CompactToBig(0x1e0377ae),
+		PowLimitBits:           0x1e0377ae,
+		TargetTimespan:         14 * 24 * 60 * 60,
+		TargetSpacing:          10 * 60,
+		SubsidyHalvingInterval: 210000,
+		BIP16Height:            1,
+		BIP34Height:            1,
+		BIP65Height:            1,
+		BIP66Height:            1,
+		CSVHeight:              1,
+		SegwitHeight:           1,
+		TaprootHeight:          1,
+	}
+
+	RegTestConsensus = ConsensusParams{
+		PowLimit:                 CompactToBig(0x207fffff),
+		PowLimitBits:             0x207fffff,
+		TargetTimespan:           14 * 24 * 60 * 60,
+		TargetSpacing:            10 * 60,
+		AllowMinDifficultyBlocks: true,
+		NoRetargeting:            true,
+		SubsidyHalvingInterval:   150,
+		BIP16Height:              0,
+		BIP34Height:              1,
+		BIP65Height:              1,
+		BIP66Height:              1,
+		CSVHeight:                1,
+		SegwitHeight:             0,
+		TaprootHeight:            0,
+	}
+)
+
+// HeaderChain gives contextual validation access to the headers preceding the header being validated.
+type HeaderChain interface {
+	// Height returns the height of the most recent header in the chain, i.e. the parent of the header being validated.
+	Height() int32
+	// HeaderAt returns the header at the given height, which is between 0 and Height().
+	HeaderAt(height int32) *Header
+}
+
+// BigToCompact encodes a target in the compact representation used in the Bits field of a block header. It is the
+// inverse of CompactToBig, except for precision lost in the 23 bit mantissa.
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	abs := new(big.Int).Abs(n)
+	size := uint32((abs.BitLen() + 7) / 8)
+
+	var mantissa uint32
+	if size <= 3 {
+		mantissa = uint32(abs.Uint64()) << (8 * (3 - size))
+	} else {
+		mantissa = uint32(abs.Rsh(abs, uint(8*(size-3))).Uint64())
+	}
+
+	// the mantissa is signed, so it must not use the sign bit
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		size++
+	}
+
+	compact := size<<24 | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
+	return compact
+}
+
+// NextRequiredBits returns the difficulty bits that a block with the given timestamp extending chain must have.
+func NextRequiredBits(chain HeaderChain, timestamp uint32, params *ConsensusParams) uint32 {
+	last := chain.HeaderAt(chain.Height())
+	height := chain.Height() + 1
+	interval := params.DifficultyAdjustmentInterval()
+
+	if height%interval != 0 {
+		if !params.AllowMinDifficultyBlocks {
+			return last.Bits
+		}
+
+		if int64(timestamp) > int64(last.Timestamp)+2*params.TargetSpacing {
+			return params.PowLimitBits
+		}
+
+		// return the difficulty of the last block that didn't make use of the minimum difficulty exception
+		h := chain.Height()
+		header := last
+		for h > 0 && h%interval != 0 && header.Bits == params.PowLimitBits {
+			h--
+			header = chain.HeaderAt(h)
+		}
+		return header.Bits
+	}
+
+	if params.NoRetargeting {
+		return last.Bits
+	}
+
+	first := chain.HeaderAt(height - interval)
+	actualTimespan := int64(last.Timestamp) - int64(first.Timestamp)
+	actualTimespan = max(actualTimespan, params.TargetTimespan/4)
+	actualTimespan = min(actualTimespan, params.TargetTimespan*4)
+
+	bits := last.Bits
+	if params.EnforceBIP94 {
+		bits = first.Bits
+	}
+
+	target := CompactToBig(bits)
+	target.Mul(target, big.NewInt(actualTimespan))
+	target.Div(target, big.NewInt(params.TargetTimespan))
+
+	if target.Cmp(params.PowLimit) > 0 {
+		target.Set(params.PowLimit)
+	}
+
+	return BigToCompact(target)
+}
+
+// MedianTimePast returns the median of the timestamps of the last eleven headers in chain, or of all headers if the
+// chain is shorter.
+func MedianTimePast(chain HeaderChain) uint32 {
+	count := min(chain.Height()+1, medianTimeSpan)
+	timestamps := make([]uint32, count)
+
+	for i := int32(0); i < count; i++ {
+		timestamps[i] = chain.HeaderAt(chain.Height() - i).Timestamp
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[count/2]
+}
+
+// CheckHeaderContext validates the rules for a header that depend on the chain it extends: the difficulty adjustment,
+// the median time past as lower bound for the timestamp and the local time plus two hours as upper bound.
+func (h *Header) CheckHeaderContext(chain HeaderChain, params *ConsensusParams, now time.Time) error {
+	if h.Bits != NextRequiredBits(chain, h.Timestamp, params) {
+		return ErrUnexpectedDifficulty
+	}
+
+	if h.Timestamp <= MedianTimePast(chain) {
+		return ErrTimeTooOld
+	}
+
+	height := chain.Height() + 1
+	if params.EnforceBIP94 && height%params.DifficultyAdjustmentInterval() == 0 {
+		last := chain.HeaderAt(chain.Height())
+		if int64(h.Timestamp) < int64(last.Timestamp)-maxTimewarp {
+			return ErrTimewarpAttack
+		}
+	}
+
+	if int64(h.Timestamp) > now.Unix()+maxFutureBlockTime {
+		return ErrTimeTooNew
+	}
+
+	return nil
+}
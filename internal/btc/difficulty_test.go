@@ -0,0 +1,160 @@
+package btc
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// sparseChain is a HeaderChain that only contains the headers a test needs.
+type sparseChain struct {
+	height  int32
+	headers map[int32]*Header
+}
+
+func (c *sparseChain) Height() int32 {
+	return c.height
+}
+
+func (c *sparseChain) HeaderAt(height int32) *Header {
+	if h, ok := c.headers[height]; ok {
+		return h
+	}
+	return &Header{Bits: 0x1d00ffff}
+}
+
+// retargetChain returns a chain ending right before a difficulty adjustment, with the first block of the period at
+// firstTime and the last block at lastTime.
+func retargetChain(height int32, firstTime, lastTime, bits uint32) *sparseChain {
+	return &sparseChain{
+		height: height,
+		headers: map[int32]*Header{
+			height - 2015: {Timestamp: firstTime, Bits: bits},
+			height:        {Timestamp: lastTime, Bits: bits},
+		},
+	}
+}
+
+func TestBigToCompact(t *testing.T) {
+	for _, bits := range []uint32{0x1d00ffff, 0x1b0404cb, 0x207fffff, 0x03123456, 0x01120000} {
+		assert.Equal(t, bits, BigToCompact(CompactToBig(bits)))
+	}
+
+	assert.Equal(t, uint32(0x02008000), BigToCompact(big.NewInt(0x80)))
+	assert.Equal(t, uint32(0x04923456), BigToCompact(big.NewInt(-0x12345600)))
+	assert.Equal(t, uint32(0), BigToCompact(new(big.Int)))
+}
+
+func TestNextRequiredBits(t *testing.T) {
+	t.Run("retarget", func(t *testing.T) {
+		chain := retargetChain(32255, 1261130161, 1262152739, 0x1d00ffff)
+		assert.Equal(t, uint32(0x1d00d86a), NextRequiredBits(chain, 1262153464, &MainNetConsensus))
+	})
+
+	t.Run("target is limited by pow limit", func(t *testing.T) {
+		chain := retargetChain(2015, 1231006505, 1233061996, 0x1d00ffff)
+		assert.Equal(t, uint32(0x1d00ffff), NextRequiredBits(chain, 1233063531, &MainNetConsensus))
+	})
+
+	t.Run("adjustment is limited to a factor of four downwards", func(t *testing.T) {
+		chain := retargetChain(68543, 1279008237, 1279297671, 0x1c05a3f4)
+		assert.Equal(t, uint32(0x1c0168fd), NextRequiredBits(chain, 1279297779, &MainNetConsensus))
+	})
+
+	t.Run("adjustment is limited to a factor of four upwards", func(t *testing.T) {
+		chain := retargetChain(46367, 1263163443, 1269211443, 0x1c387f6f)
+		assert.Equal(t, uint32(0x1d00e1fd), NextRequiredBits(chain, 1269211443, &MainNetConsensus))
+	})
+
+	t.Run("no change between adjustments", func(t *testing.T) {
+		chain := retargetChain(32256, 1262152739, 1262153464, 0x1d00d86a)
+		assert.Equal(t, uint32(0x1d00d86a), NextRequiredBits(chain, 1262156000, &MainNetConsensus))
+	})
+
+	t.Run("minimum difficulty after twenty minutes on testnet", func(t *testing.T) {
+		chain := &sparseChain{height: 100, headers: map[int32]*Header{100: {Timestamp: 1000, Bits: 0x1c05a3f4}}}
+		assert.Equal(t, uint32(0x1d00ffff), NextRequiredBits(chain, 2201, &TestNet3Consensus))
+		assert.Equal(t, uint32(0x1c05a3f4), NextRequiredBits(chain, 2200, &TestNet3Consensus))
+		assert.Equal(t, uint32(0x1c05a3f4), NextRequiredBits(chain, 2201, &MainNetConsensus))
+	})
+
+	t.Run("minimum difficulty blocks don't reset the difficulty on testnet", func(t *testing.T) {
+		chain := &sparseChain{height: 100, headers: map[int32]*Header{
+			100: {Timestamp: 3000, Bits: 0x1d00ffff},
+			99:  {Timestamp: 2000, Bits: 0x1d00ffff},
+			98:  {Timestamp: 1000, Bits: 0x1c05a3f4},
+		}}
+		assert.Equal(t, uint32(0x1c05a3f4), NextRequiredBits(chain, 3001, &TestNet3Consensus))
+	})
+
+	t.Run("retarget is based on first block of the period with BIP94", func(t *testing.T) {
+		chain := retargetChain(32255, 1261130161, 1262152739, 0x1d00ffff)
+		chain.headers[32255].Bits = 0x1c05a3f4
+		assert.Equal(t, uint32(0x1d00d86a), NextRequiredBits(chain, 1262153464, &TestNet4Consensus))
+	})
+
+	t.Run("no retargeting on regtest", func(t *testing.T) {
+		chain := retargetChain(2015, 1296688602, 1296688603, 0x207fffff)
+		assert.Equal(t, uint32(0x207fffff), NextRequiredBits(chain, 1296688604, &RegTestConsensus))
+	})
+}
+
+func TestMedianTimePast(t *testing.T) {
+	t.Run("median of the last eleven blocks", func(t *testing.T) {
+		chain := &sparseChain{height: 20, headers: map[int32]*Header{}}
+		timestamps := []uint32{15, 11, 12, 19, 13, 30, 14, 17, 16, 18, 20}
+		for i, ts := range timestamps {
+			chain.headers[int32(20-i)] = &Header{Timestamp: ts}
+		}
+
+		assert.Equal(t, uint32(16), MedianTimePast(chain))
+	})
+
+	t.Run("shorter chain", func(t *testing.T) {
+		chain := &sparseChain{height: 1, headers: map[int32]*Header{0: {Timestamp: 5}, 1: {Timestamp: 3}}}
+		assert.Equal(t, uint32(5), MedianTimePast(chain))
+	})
+}
+
+func TestCheckHeaderContext(t *testing.T) {
+	chain := &sparseChain{height: 0, headers: map[int32]*Header{0: {Timestamp: 1000, Bits: 0x1d00ffff}}}
+	now := time.Unix(10000, 0)
+
+	t.Run("valid header", func(t *testing.T) {
+		header := &Header{Timestamp: 1600, Bits: 0x1d00ffff}
+		assert.NoError(t, header.CheckHeaderContext(chain, &MainNetConsensus, now))
+	})
+
+	t.Run("unexpected difficulty", func(t *testing.T) {
+		header := &Header{Timestamp: 1600, Bits: 0x1c00ffff}
+		assert.ErrorIs(t, header.CheckHeaderContext(chain, &MainNetConsensus, now), ErrUnexpectedDifficulty)
+	})
+
+	t.Run("timestamp not after median time past", func(t *testing.T) {
+		header := &Header{Timestamp: 1000, Bits: 0x1d00ffff}
+		assert.ErrorIs(t, header.CheckHeaderContext(chain, &MainNetConsensus, now), ErrTimeTooOld)
+	})
+
+	t.Run("timestamp more than two hours in the future", func(t *testing.T) {
+		header := &Header{Timestamp: 10000 + 7201, Bits: 0x1d00ffff}
+		assert.ErrorIs(t, header.CheckHeaderContext(chain, &MainNetConsensus, now), ErrTimeTooNew)
+
+		header.Timestamp = 10000 + 7200
+		assert.NoError(t, header.CheckHeaderContext(chain, &MainNetConsensus, now))
+	})
+
+	t.Run("timewarp attack with BIP94", func(t *testing.T) {
+		chain := retargetChain(2015, 100000, 2000000, 0x1d00ffff)
+		for h := int32(2005); h < 2015; h++ {
+			chain.headers[h] = &Header{Timestamp: 1000000, Bits: 0x1d00ffff}
+		}
+
+		header := &Header{Timestamp: 2000000 - 601, Bits: NextRequiredBits(chain, 2000000-601, &TestNet4Consensus)}
+		err := header.CheckHeaderContext(chain, &TestNet4Consensus, time.Unix(2000000, 0))
+		assert.ErrorIs(t, err, ErrTimewarpAttack)
+
+		header.Timestamp = 2000000 - 600
+		assert.NoError(t, header.CheckHeaderContext(chain, &TestNet4Consensus, time.Unix(2000000, 0)))
+	})
+}
@@ -0,0 +1,104 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+// The genesis blocks of the supported networks in their wire format. They are hard-coded instead of downloaded,
+// because no block points to them and their coinbase outputs can't be spent.
+const (
+	mainNetGenesisHex = "01000000" +
+		"0000000000000000000000000000000000000000000000000000000000000000" +
+		"3ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a" +
+		"29ab5f49ffff001d1dac2b7c" +
+		"01" +
+		genesisCoinbaseHex
+
+	testNet3GenesisHex = "01000000" +
+		"0000000000000000000000000000000000000000000000000000000000000000" +
+		"3ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a" +
+		"dae5494dffff001d1aa4ae18" +
+		"01" +
+		genesisCoinbaseHex
+
+	testNet4GenesisHex = "01000000" +
+		"0000000000000000000000000000000000000000000000000000000000000000" +
+		"4e7b2b9128fe0291db0693af2ae418b767e657cd407e80cb1434221eaea7a07a" +
+		"046f3566ffff001dbb0c7817" +
+		"01" +
+		"01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff5504ffff001d01044c4c3033" +
+		"2f4d61792f323032342030303030303030303030303030303030303030303165626435386332343439373062336161396437383362" +
+		"623030313031316662653865613865393865303065ffffffff0100f2052a0100000023210000000000000000000000000000000000" +
+		"00000000000000000000000000000000ac00000000"
+
+	sigNetGenesisHex = "01000000" +
+		"0000000000000000000000000000000000000000000000000000000000000000" +
+		"3ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a" +
+		"008f4d5fae77031e8ad22203" +
+		"01" +
+		genesisCoinbaseHex
+
+	regTestGenesisHex = "01000000" +
+		"0000000000000000000000000000000000000000000000000000000000000000" +
+		"3ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a" +
+		"dae5494dffff7f2002000000" +
+		"01" +
+		genesisCoinbaseHex
+
+	// genesisCoinbaseHex is the coinbase transaction of the main network genesis block, which commits to a newspaper
+	// headline and pays 50 bitcoin to a public key. All networks except testnet4 reuse it.
+	genesisCoinbaseHex = "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d01" +
+		"04455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64" +
+		"206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a8" +
+		"28e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+)
+
+var (
+	// MainNetGenesisBlock is the first block in the main Bitcoin network.
+	MainNetGenesisBlock = mustDecodeBlock(mainNetGenesisHex)
+	// TestNet3GenesisBlock is the first block in the third test network. It only differs from the main network genesis
+	// block in its timestamp and nonce.
+	TestNet3GenesisBlock = mustDecodeBlock(testNet3GenesisHex)
+	// TestNet4GenesisBlock is the first block in the fourth test network (BIP94).
+	TestNet4GenesisBlock = mustDecodeBlock(testNet4GenesisHex)
+	// SigNetGenesisBlock is the first block in the default signet.
+	SigNetGenesisBlock = mustDecodeBlock(sigNetGenesisHex)
+	// RegTestGenesisBlock is the first block in regression test networks.
+	RegTestGenesisBlock = mustDecodeBlock(regTestGenesisHex)
+)
+
+// MainNetGenesisHeader is the header of the first block in the main Bitcoin network.
+var MainNetGenesisHeader = MainNetGenesisBlock.Header
+
+// MainNetGenesisHash is the hash of MainNetGenesisHeader.
+var MainNetGenesisHash = mustParseBlockHash("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f")
+
+// mustDecodeBlock decodes a hard-coded block and panics if it is malformed.
+func mustDecodeBlock(s string) Block {
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+
+	buf := bytes.NewBuffer(raw)
+	block, err := DecodeBlock(buf)
+	if err != nil {
+		panic(err)
+	}
+
+	if buf.Len() != 0 {
+		panic(fmt.Sprintf("%d trailing bytes after hard-coded block", buf.Len()))
+	}
+	return *block
+}
+
+// mustParseBlockHash parses a hard-coded block hash and panics if it is malformed.
+func mustParseBlockHash(s string) BlockHash {
+	hash, err := ParseBlockHash(s)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
@@ -2,7 +2,6 @@ package btc
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
@@ -15,8 +14,33 @@ const BlockHashSize = 32
 
 type BlockHash [BlockHashSize]byte
 
+var ErrInvalidHeader = errors.New("invalid block header")
+var ErrInvalidBlockHash = errors.New("invalid block hash")
+
+// String returns the hash as hex string in the reversed byte order used by block explorers and Bitcoin Core.
 func (h BlockHash) String() string {
-	return hex.EncodeToString(h[:])
+	var reversed BlockHash
+	for i, b := range h {
+		reversed[BlockHashSize-1-i] = b
+	}
+	return hex.EncodeToString(reversed[:])
+}
+
+// ParseBlockHash parses a hash in the format returned by BlockHash.String.
+func ParseBlockHash(s string) (BlockHash, error) {
+	var hash BlockHash
+	if hex.DecodedLen(len(s)) != BlockHashSize {
+		return hash, ErrInvalidBlockHash
+	}
+
+	if _, err := hex.Decode(hash[:], []byte(s)); err != nil {
+		return hash, ErrInvalidBlockHash
+	}
+
+	for i := 0; i < BlockHashSize/2; i++ {
+		hash[i], hash[BlockHashSize-1-i] = hash[BlockHashSize-1-i], hash[i]
+	}
+	return hash, nil
 }
 
 type Header struct {
@@ -34,6 +58,10 @@ func (h *Header) Size() int {
 }
 
 func DecodeHeader(buf *bytes.Buffer) (*Header, error) {
+	if buf.Len() < staticHeaderSize {
+		return nil, ErrInvalidHeader
+	}
+
 	header := new(Header)
 	header.Version = int32(binary.LittleEndian.Uint32(buf.Next(4))) // TODO check overflow
 
@@ -93,6 +121,5 @@ func (h *Header) Hash() (BlockHash, error) {
 		return BlockHash{}, err
 	}
 
-	inner := sha256.Sum256(b[:staticHeaderSize])
-	return sha256.Sum256(inner[:]), nil
+	return doubleSHA256(b[:staticHeaderSize]), nil
 }
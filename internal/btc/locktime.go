@@ -0,0 +1,98 @@
+package btc
+
+import "errors"
+
+const (
+	// LockTimeThreshold is the lowest lock time that is interpreted as a unix timestamp instead of a block height.
+	LockTimeThreshold = 500000000
+
+	SequenceFinal = 0xffffffff
+	// SequenceLockTimeDisableFlag disables the relative lock time of an input (BIP68).
+	SequenceLockTimeDisableFlag = 1 << 31
+	// SequenceLockTimeTypeFlag makes the relative lock time of an input a time span in units of 512 seconds instead of
+	// a number of blocks.
+	SequenceLockTimeTypeFlag = 1 << 22
+	SequenceLockTimeMask     = 0x0000ffff
+	// sequenceLockTimeGranularity is the base 2 logarithm of the unit of relative lock times based on time.
+	sequenceLockTimeGranularity = 9
+)
+
+var (
+	ErrNonFinalTx    = errors.New("transaction is not final")
+	ErrSequenceLocks = errors.New("relative lock time of transaction is not satisfied")
+)
+
+// checkFinalTx returns an error if tx can't be included in a block at the given height because of its lock time.
+// lockTimeCutoff is the time that lock times based on time are compared to: the timestamp of the block before CSV
+// activation and the median time past of its parent afterwards (BIP113).
+func checkFinalTx(tx *Transaction, height int32, lockTimeCutoff int64) error {
+	if tx.LockTime == 0 {
+		return nil
+	}
+
+	threshold := int64(height)
+	if tx.LockTime >= LockTimeThreshold {
+		threshold = lockTimeCutoff
+	}
+	if int64(tx.LockTime) < threshold {
+		return nil
+	}
+
+	// a lock time in the future is ignored if all inputs opted out of it
+	for _, in := range tx.TxIn {
+		if in.Sequence != SequenceFinal {
+			return ruleError(ErrNonFinalTx, "lock time is %d at height %d and time %d", tx.LockTime, height, lockTimeCutoff)
+		}
+	}
+	return nil
+}
+
+// checkSequenceLocks returns an error if an input of tx spends an output that isn't old enough for the relative lock
+// time in its sequence number (BIP68). chain ends in the parent of the block at height that includes tx. The age of
+// outputs is measured in blocks or in the median time past of the block before the one that created the output.
+func checkSequenceLocks(tx *Transaction, view CoinView, height int32, chain HeaderChain) error {
+	if tx.Version < 2 {
+		return nil
+	}
+
+	minHeight := int32(-1)
+	minTime := int64(-1)
+
+	for i, in := range tx.TxIn {
+		if in.Sequence&SequenceLockTimeDisableFlag != 0 {
+			continue
+		}
+
+		coin, ok := view.Coin(in.PreviousOutput)
+		if !ok {
+			return ruleError(ErrMissingInputs, "input %d", i)
+		}
+
+		lockTime := int64(in.Sequence & SequenceLockTimeMask)
+		if in.Sequence&SequenceLockTimeTypeFlag == 0 {
+			minHeight = max(minHeight, coin.Height+int32(lockTime)-1)
+			continue
+		}
+
+		coinTime := int64(MedianTimePast(ancestorChain{chain, max(coin.Height-1, 0)}))
+		minTime = max(minTime, coinTime+lockTime<<sequenceLockTimeGranularity-1)
+	}
+
+	if minHeight >= height {
+		return ruleError(ErrSequenceLocks, "spendable at height %d", minHeight+1)
+	}
+	if mtp := int64(MedianTimePast(chain)); minTime >= mtp {
+		return ruleError(ErrSequenceLocks, "spendable after median time past %d, is %d", minTime, mtp)
+	}
+	return nil
+}
+
+// ancestorChain is the part of a HeaderChain up to the given height.
+type ancestorChain struct {
+	HeaderChain
+	height int32
+}
+
+func (c ancestorChain) Height() int32 {
+	return c.height
+}
@@ -0,0 +1,69 @@
+package btc
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// timedChain returns a chain for validating a block at the given height, in which every block is 1000 seconds after
+// its parent.
+func timedChain(height int32) *sparseChain {
+	chain := &sparseChain{height: height - 1, headers: make(map[int32]*Header)}
+	for h := int32(0); h < height; h++ {
+		chain.headers[h] = &Header{Timestamp: uint32(h) * 1000}
+	}
+	return chain
+}
+
+func TestCheckFinalTx(t *testing.T) {
+	tx := &Transaction{Version: 1, TxIn: []TxInput{{Sequence: 0}}}
+	assert.NoError(t, checkFinalTx(tx, 100, 0), "no lock time")
+
+	tx.LockTime = 100
+	assert.ErrorIs(t, checkFinalTx(tx, 100, 0), ErrNonFinalTx)
+	assert.NoError(t, checkFinalTx(tx, 101, 0))
+
+	tx.LockTime = LockTimeThreshold + 1000
+	assert.ErrorIs(t, checkFinalTx(tx, 100, LockTimeThreshold+1000), ErrNonFinalTx)
+	assert.NoError(t, checkFinalTx(tx, 100, LockTimeThreshold+1001))
+
+	tx.TxIn[0].Sequence = SequenceFinal
+	assert.NoError(t, checkFinalTx(tx, 100, 0), "all inputs are final")
+}
+
+func TestCheckSequenceLocks(t *testing.T) {
+	height := int32(100)
+	chain := timedChain(height)
+	outPoint := OutPoint{Hash: TxHash{1}}
+	view := coinMap{outPoint: {Output: TxOutput{Value: 1000, ScriptPubKey: []byte{0x51}}, Height: 90}}
+
+	spend := func(version, sequence uint32) *Transaction {
+		return &Transaction{Version: version, TxIn: []TxInput{{PreviousOutput: outPoint, Sequence: sequence}}}
+	}
+
+	t.Run("blocks", func(t *testing.T) {
+		assert.NoError(t, checkSequenceLocks(spend(2, 10), view, height, chain))
+		assert.ErrorIs(t, checkSequenceLocks(spend(2, 11), view, height, chain), ErrSequenceLocks)
+	})
+
+	t.Run("time", func(t *testing.T) {
+		// the median time past is 84000 before the block of the coin and 94000 before the new block
+		assert.NoError(t, checkSequenceLocks(spend(2, SequenceLockTimeTypeFlag|19), view, height, chain))
+		assert.ErrorIs(
+			t,
+			checkSequenceLocks(spend(2, SequenceLockTimeTypeFlag|20), view, height, chain),
+			ErrSequenceLocks,
+		)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		assert.NoError(t, checkSequenceLocks(spend(1, 11), view, height, chain), "before version 2")
+		assert.NoError(t, checkSequenceLocks(spend(2, SequenceLockTimeDisableFlag|11), view, height, chain))
+	})
+
+	t.Run("outputs of the same block", func(t *testing.T) {
+		view := coinMap{outPoint: {Output: TxOutput{Value: 1000, ScriptPubKey: []byte{0x51}}, Height: height}}
+		assert.NoError(t, checkSequenceLocks(spend(2, 0), view, height, chain))
+		assert.ErrorIs(t, checkSequenceLocks(spend(2, 1), view, height, chain), ErrSequenceLocks)
+	})
+}
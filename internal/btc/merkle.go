@@ -0,0 +1,96 @@
+package btc
+
+import (
+	"crypto/sha256"
+	"errors"
+)
+
+var (
+	ErrNoTransactions    = errors.New("block contains no transactions")
+	ErrBadMerkleRoot     = errors.New("merkle root doesn't match transactions")
+	ErrMutatedMerkleTree = errors.New("merkle tree contains duplicate transactions")
+)
+
+// MerkleRoot computes the root of the merkle tree over the given hashes. If a level of the tree has an odd number of
+// entries, the last one is paired with itself. Because of that, a list of transactions and the same list with
+// transactions repeated at the end can have the same merkle root (CVE-2012-2459). mutated is true if two identical
+// hashes are paired anywhere in the tree, which indicates such a duplication.
+func MerkleRoot(hashes [][32]byte) (root [32]byte, mutated bool) {
+	if len(hashes) == 0 {
+		return
+	}
+
+	level := make([][32]byte, len(hashes))
+	copy(level, hashes)
+
+	for len(level) > 1 {
+		for i := 0; i+1 < len(level); i += 2 {
+			if level[i] == level[i+1] {
+				mutated = true
+			}
+		}
+
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+
+		next := make([][32]byte, len(level)/2)
+		var pair [64]byte
+		for i := range next {
+			copy(pair[:32], level[2*i][:])
+			copy(pair[32:], level[2*i+1][:])
+			next[i] = doubleSHA256(pair[:])
+		}
+		level = next
+	}
+
+	return level[0], mutated
+}
+
+// CheckMerkleRoot verifies that the merkle root of the transactions in the block matches the one in the header and
+// that the transaction list hasn't been mutated by duplicating transactions.
+func (b *Block) CheckMerkleRoot() error {
+	if len(b.Transactions) == 0 {
+		return ErrNoTransactions
+	}
+
+	hashes := make([][32]byte, len(b.Transactions))
+	for i := range b.Transactions {
+		txid, err := b.Transactions[i].TxID()
+		if err != nil {
+			return err
+		}
+		hashes[i] = txid
+	}
+
+	root, mutated := MerkleRoot(hashes)
+	if root != b.Header.MerkleRoot {
+		return ErrBadMerkleRoot
+	}
+
+	if mutated {
+		return ErrMutatedMerkleTree
+	}
+	return nil
+}
+
+// WitnessMerkleRoot computes the merkle root over the witness ids of the transactions in the block, with the witness
+// id of the coinbase transaction replaced by zeros, as committed to in the coinbase (BIP141).
+func (b *Block) WitnessMerkleRoot() ([32]byte, error) {
+	hashes := make([][32]byte, len(b.Transactions))
+	for i := 1; i < len(b.Transactions); i++ {
+		wtxid, err := b.Transactions[i].WTxID()
+		if err != nil {
+			return [32]byte{}, err
+		}
+		hashes[i] = wtxid
+	}
+
+	root, _ := MerkleRoot(hashes)
+	return root, nil
+}
+
+func doubleSHA256(b []byte) [32]byte {
+	inner := sha256.Sum256(b)
+	return sha256.Sum256(inner[:])
+}
@@ -0,0 +1,98 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const genesisCoinbaseB64 = "AQAAAAEAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAP////9NBP//AB0BBEVUaGUgVGltZXMgMDMvSmFuLzIwMDkgQ2hhbmNlbGxvciBvbiBicmluayBvZiBzZWNvbmQgYmFpbG91dCBmb3IgYmFua3P/////AQDyBSoBAAAAQ0EEZ4r9sP5VSCcZZ/GmcTC3EFzWqCjgOQmmeWLg6h9h3rZJ9rw/TO84xPNVBOUewRLeXDhN97oLjVeKTHAra/EdX6wAAAAA"
+
+func decodeGenesisCoinbase(t *testing.T) Transaction {
+	raw, err := base64.StdEncoding.DecodeString(genesisCoinbaseB64)
+	require.NoError(t, err)
+
+	tx, err := DecodeTransaction(bytes.NewBuffer(raw))
+	require.NoError(t, err)
+	return *tx
+}
+
+// reversedHash parses a hash in the byte order used by block explorers.
+func reversedHash(t *testing.T, s string) [32]byte {
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	require.Len(t, b, 32)
+
+	var hash [32]byte
+	for i := range b {
+		hash[31-i] = b[i]
+	}
+	return hash
+}
+
+func TestMerkleRoot(t *testing.T) {
+	t.Run("block 100000", func(t *testing.T) {
+		hashes := [][32]byte{
+			reversedHash(t, "8c14f0db3df150123e6f3dbbf30f8b955a8249b62ac1d1ff16284aefa3d06d87"),
+			reversedHash(t, "fff2525b8931402dd09222c50775608f75787bd2b87e56995a7bdd30f79702c4"),
+			reversedHash(t, "6359f0868171b1d194cbee1af2f16ea598ae8fad666d9b012c8ed2b79a236ec4"),
+			reversedHash(t, "e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"),
+		}
+
+		root, mutated := MerkleRoot(hashes)
+		assert.Equal(t, reversedHash(t, "f3e94742aca4b5ef85488dc37c06c3282295ffec960994b2c0d5ac2a25a95766"), root)
+		assert.False(t, mutated)
+	})
+
+	t.Run("single hash is the root", func(t *testing.T) {
+		root, mutated := MerkleRoot([][32]byte{{1}})
+		assert.Equal(t, [32]byte{1}, root)
+		assert.False(t, mutated)
+	})
+
+	t.Run("duplicated last hash yields the same root but is detected", func(t *testing.T) {
+		odd, oddMutated := MerkleRoot([][32]byte{{1}, {2}, {3}})
+		even, evenMutated := MerkleRoot([][32]byte{{1}, {2}, {3}, {3}})
+
+		assert.Equal(t, odd, even)
+		assert.False(t, oddMutated)
+		assert.True(t, evenMutated)
+	})
+}
+
+func TestCheckMerkleRoot(t *testing.T) {
+	coinbase := decodeGenesisCoinbase(t)
+
+	t.Run("genesis block", func(t *testing.T) {
+		block := Block{Header: MainNetGenesisHeader, Transactions: []Transaction{coinbase}}
+		assert.NoError(t, block.CheckMerkleRoot())
+	})
+
+	t.Run("embedded genesis block", func(t *testing.T) {
+		assert.Equal(t, coinbase, MainNetGenesisBlock.Transactions[0])
+		assert.NoError(t, MainNetGenesisBlock.CheckMerkleRoot())
+	})
+
+	t.Run("transactions don't match header", func(t *testing.T) {
+		tampered := coinbase
+		tampered.LockTime = 1
+
+		block := Block{Header: MainNetGenesisHeader, Transactions: []Transaction{tampered}}
+		assert.ErrorIs(t, block.CheckMerkleRoot(), ErrBadMerkleRoot)
+	})
+
+	t.Run("duplicated transactions", func(t *testing.T) {
+		block := Block{Header: MainNetGenesisHeader, Transactions: []Transaction{coinbase, coinbase}}
+		block.Header.MerkleRoot, _ = MerkleRoot([][32]byte{MainNetGenesisHeader.MerkleRoot, MainNetGenesisHeader.MerkleRoot})
+
+		assert.ErrorIs(t, block.CheckMerkleRoot(), ErrMutatedMerkleTree)
+	})
+
+	t.Run("no transactions", func(t *testing.T) {
+		block := Block{Header: MainNetGenesisHeader}
+		assert.ErrorIs(t, block.CheckMerkleRoot(), ErrNoTransactions)
+	})
+}
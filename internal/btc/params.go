@@ -0,0 +1,151 @@
+package btc
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrUnknownNetwork = errors.New("unknown network")
+
+// ChainParams describes a Bitcoin network: how to find and talk to its nodes, where its chain starts, which consensus
+// rules apply and how addresses are encoded.
+type ChainParams struct {
+	// Name identifies the network, e.g. on the command line.
+	Name string
+	// Magic are the bytes every message on the network starts with.
+	Magic [4]byte
+	// DefaultPort is the port nodes of the network listen on unless configured otherwise.
+	DefaultPort uint16
+	// GenesisBlock is the first block of the chain.
+	GenesisBlock *Block
+	// DNSSeeds are host names that resolve to addresses of nodes on the network.
+	DNSSeeds  []string
+	Consensus *ConsensusParams
+	// PubKeyHashAddrID is the version byte of base58 encoded pay-to-pubkey-hash addresses.
+	PubKeyHashAddrID byte
+	// ScriptHashAddrID is the version byte of base58 encoded pay-to-script-hash addresses.
+	ScriptHashAddrID byte
+	// Bech32HRP is the human-readable part of bech32 encoded segwit addresses.
+	Bech32HRP string
+	// Checkpoints are blocks known to be part of the chain, in ascending order of height. Headers contradicting them
+	// are rejected, so that peers can't make us follow a long chain of low difficulty headers forking off early on.
+	Checkpoints []Checkpoint
+}
+
+// Checkpoint is the hash of the block at a given height in the best chain of a network.
+type Checkpoint struct {
+	Height int32
+	Hash   BlockHash
+}
+
+var (
+	MainNetParams = ChainParams{
+		Name:         "mainnet",
+		Magic:        [4]byte{0xf9, 0xbe, 0xb4, 0xd9},
+		DefaultPort:  8333,
+		GenesisBlock: &MainNetGenesisBlock,
+		DNSSeeds: []string{
+			"seed.bitcoin.sipa.be",
+			"dnsseed.bluematt.me",
+			"seed.bitcoinstats.com",
+			"seed.bitcoin.jonasschnelli.ch",
+			"seed.btc.petertodd.net",
+			"seed.bitcoin.sprovoost.nl",
+			"dnsseed.emzy.de",
+			"seed.bitcoin.wiz.biz",
+		},
+		Consensus:        &MainNetConsensus,
+		PubKeyHashAddrID: 0x00,
+		ScriptHashAddrID: 0x05,
+		Bech32HRP:        "bc",
+		Checkpoints: []Checkpoint{
+			{11111, mustParseBlockHash("0000000069e244f73d78e8fd29ba2fd2ed618bd6fa2ee92559f542fdb26e7c1d")},
+			{33333, mustParseBlockHash("000000002dd5588a74784eaa7ab0507a18ad16a236e7b1ce69f00d7ddfb5d0a6")},
+			{74000, mustParseBlockHash("0000000000573993a3c9e41ce34471c079dcf5f52a0e824a81e7f953b8661a20")},
+			{105000, mustParseBlockHash("00000000000291ce28027faea320c8d2b054b2e0fe44a773f3eefb151d6bdc97")},
+			{134444, mustParseBlockHash("00000000000005b12ffd4cd315cd34ffd4a594f430ac814c91184a0d42d2b0fe")},
+			{168000, mustParseBlockHash("000000000000099e61ea72015e79632f216fe6cb33d7899acb35b75c8303b763")},
+			{193000, mustParseBlockHash("000000000000059f452a5f7340de6682a977387c17010ff6e6c3bd83ca8b1317")},
+			{210000, mustParseBlockHash("000000000000048b95347e83192f69cf0366076336c639f9b7228e9ba171342e")},
+			{216116, mustParseBlockHash("00000000000001b4f4b433e81ee46494af945cf96014816a4e2370f11b23df4e")},
+			{225430, mustParseBlockHash("00000000000001c108384350f74090433e7fcf79a606b8e797f065b130575932")},
+			{250000, mustParseBlockHash("000000000000003887df1f29024b06fc2200b55f8af8f35453d7be294df2d214")},
+			{279000, mustParseBlockHash("0000000000000001ae8c72a0b0c301f67e3afca10e819efa9041e458e9bd7e40")},
+			{295000, mustParseBlockHash("00000000000000004d9b4ef50f0f9d686fd69db2e03af35a100370c64632a983")},
+		},
+	}
+
+	TestNet3Params = ChainParams{
+		Name:         "testnet3",
+		Magic:        [4]byte{0x0b, 0x11, 0x09, 0x07},
+		DefaultPort:  18333,
+		GenesisBlock: &TestNet3GenesisBlock,
+		DNSSeeds: []string{
+			"testnet-seed.bitcoin.jonasschnelli.ch",
+			"seed.tbtc.petertodd.net",
+			"seed.testnet.bitcoin.sprovoost.nl",
+			"testnet-seed.bluematt.me",
+		},
+		Consensus:        &TestNet3Consensus,
+		PubKeyHashAddrID: 0x6f,
+		ScriptHashAddrID: 0xc4,
+		Bech32HRP:        "tb",
+		Checkpoints: []Checkpoint{
+			{546, mustParseBlockHash("000000002a936ca763904c3c35fce2f3556c559c0214345d31b1bcebf76acb70")},
+		},
+	}
+
+	TestNet4Params = ChainParams{
+		Name:         "testnet4",
+		Magic:        [4]byte{0x1c, 0x16, 0x3f, 0x28},
+		DefaultPort:  48333,
+		GenesisBlock: &TestNet4GenesisBlock,
+		DNSSeeds: []string{
+			"seed.testnet4.bitcoin.sprovoost.nl",
+			"seed.testnet4.wiz.biz",
+		},
+		Consensus:        &TestNet4Consensus,
+		PubKeyHashAddrID: 0x6f,
+		ScriptHashAddrID: 0xc4,
+		Bech32HRP:        "tb",
+	}
+
+	// SigNetParams describe the default signet. Block signatures (BIP325) are not verified.
+	SigNetParams = ChainParams{
+		Name:         "signet",
+		Magic:        [4]byte{0x0a, 0x03, 0xcf, 0x40},
+		DefaultPort:  38333,
+		GenesisBlock: &SigNetGenesisBlock,
+		DNSSeeds: []string{
+			"seed.signet.bitcoin.sprovoost.nl",
+		},
+		Consensus:        &SigNetConsensus,
+		PubKeyHashAddrID: 0x6f,
+		ScriptHashAddrID: 0xc4,
+		Bech32HRP:        "tb",
+	}
+
+	RegTestParams = ChainParams{
+		Name:             "regtest",
+		Magic:            [4]byte{0xfa, 0xbf, 0xb5, 0xda},
+		DefaultPort:      18444,
+		GenesisBlock:     &RegTestGenesisBlock,
+		Consensus:        &RegTestConsensus,
+		PubKeyHashAddrID: 0x6f,
+		ScriptHashAddrID: 0xc4,
+		Bech32HRP:        "bcrt",
+	}
+)
+
+// Networks are the parameters of all supported networks.
+var Networks = []*ChainParams{&MainNetParams, &TestNet3Params, &TestNet4Params, &SigNetParams, &RegTestParams}
+
+// ParamsByName returns the parameters of the network with the given name.
+func ParamsByName(name string) (*ChainParams, error) {
+	for _, params := range Networks {
+		if params.Name == name {
+			return params, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownNetwork, name)
+}
@@ -0,0 +1,58 @@
+package btc
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"slices"
+	"testing"
+)
+
+func TestChainParams(t *testing.T) {
+	// the genesis block hashes in the byte order used by block explorers
+	genesisHashes := map[string]string{
+		"mainnet":  "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
+		"testnet3": "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943",
+		"testnet4": "00000000da84f2bafbbc53dee25a72ae507ff4914b867c565be350b0da8bf043",
+		"signet":   "00000008819873e925422c1ff0f99f7cc9bbb232af63a077a480a3633bee1ef6",
+		"regtest":  "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206",
+	}
+
+	for _, params := range Networks {
+		t.Run(params.Name, func(t *testing.T) {
+			genesis := params.GenesisBlock
+			assert.NoError(t, genesis.CheckMerkleRoot())
+			assert.NoError(t, genesis.Header.CheckProofOfWork(params.Consensus.PowLimit))
+			assert.Equal(t, params.Consensus.PowLimitBits, genesis.Header.Bits)
+
+			hash, err := genesis.Hash()
+			require.NoError(t, err)
+			assert.Equal(t, genesisHashes[params.Name], hash.String())
+
+			assert.True(t, slices.IsSortedFunc(params.Checkpoints, func(a, b Checkpoint) int {
+				return int(a.Height - b.Height)
+			}))
+
+			byName, err := ParamsByName(params.Name)
+			require.NoError(t, err)
+			assert.Same(t, params, byName)
+		})
+	}
+
+	_, err := ParamsByName("moonnet")
+	assert.ErrorIs(t, err, ErrUnknownNetwork)
+}
+
+func TestParseBlockHash(t *testing.T) {
+	hash, err := ParseBlockHash(MainNetGenesisHash.String())
+	require.NoError(t, err)
+	assert.Equal(t, MainNetGenesisHash, hash)
+
+	headerHash, err := MainNetGenesisHeader.Hash()
+	require.NoError(t, err)
+	assert.Equal(t, MainNetGenesisHash, headerHash)
+
+	_, err = ParseBlockHash("00")
+	assert.ErrorIs(t, err, ErrInvalidBlockHash)
+	_, err = ParseBlockHash("zz0000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f")
+	assert.ErrorIs(t, err, ErrInvalidBlockHash)
+}
@@ -0,0 +1,76 @@
+package btc
+
+import (
+	"errors"
+	"math/big"
+)
+
+var (
+	ErrBadDifficultyBits = errors.New("invalid difficulty bits")
+	ErrHighHash          = errors.New("block hash is above target")
+
+	// MainNetPowLimit is the highest target, i.e. the lowest difficulty, allowed on the main network.
+	MainNetPowLimit = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 224), big.NewInt(1))
+
+	oneLsh256 = new(big.Int).Lsh(big.NewInt(1), 256)
+)
+
+// CompactToBig decodes the compact representation of a target used in the Bits field of a block header. The compact
+// format is a base 256 floating point number with an 8 bit exponent and a 23 bit mantissa. Bit 24 is the sign bit.
+func CompactToBig(bits uint32) *big.Int {
+	exponent := uint(bits >> 24)
+	mantissa := int64(bits & 0x007fffff)
+
+	target := big.NewInt(mantissa)
+	if exponent <= 3 {
+		target.Rsh(target, 8*(3-exponent))
+	} else {
+		target.Lsh(target, 8*(exponent-3))
+	}
+
+	if bits&0x00800000 != 0 {
+		target.Neg(target)
+	}
+	return target
+}
+
+// HashToBig interprets a block hash as a 256 bit little endian number, so it can be compared to a target.
+func HashToBig(hash BlockHash) *big.Int {
+	var reversed [BlockHashSize]byte
+	for i, b := range hash {
+		reversed[BlockHashSize-1-i] = b
+	}
+
+	return new(big.Int).SetBytes(reversed[:])
+}
+
+// CalcWork returns the expected number of hashes required to find a block with the given difficulty bits, which is
+// 2^256 / (target + 1). Invalid targets are treated as having no work.
+func CalcWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 || target.BitLen() > 256 {
+		return new(big.Int)
+	}
+
+	return new(big.Int).Div(oneLsh256, target.Add(target, big.NewInt(1)))
+}
+
+// CheckProofOfWork verifies that the target encoded in the Bits field is within the range allowed by powLimit and
+// that the hash of the header does not exceed it. This only checks the header by itself. Whether the Bits field has
+// the value demanded by the difficulty adjustment depends on the chain the header is part of.
+func (h *Header) CheckProofOfWork(powLimit *big.Int) error {
+	target := CompactToBig(h.Bits)
+	if target.Sign() <= 0 || target.Cmp(powLimit) > 0 {
+		return ErrBadDifficultyBits
+	}
+
+	hash, err := h.Hash()
+	if err != nil {
+		return err
+	}
+
+	if HashToBig(hash).Cmp(target) > 0 {
+		return ErrHighHash
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package btc
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func TestCompactToBig(t *testing.T) {
+	t.Run("difficulty 1 target", func(t *testing.T) {
+		expected := new(big.Int).Lsh(big.NewInt(0xffff), 208)
+		assert.Equal(t, expected, CompactToBig(0x1d00ffff))
+	})
+
+	t.Run("small exponent shifts right", func(t *testing.T) {
+		assert.Equal(t, big.NewInt(0x12), CompactToBig(0x01123456))
+	})
+
+	t.Run("sign bit", func(t *testing.T) {
+		assert.Equal(t, big.NewInt(-0x12345600), CompactToBig(0x04923456))
+	})
+}
+
+func TestCalcWork(t *testing.T) {
+	t.Run("difficulty 1", func(t *testing.T) {
+		assert.Equal(t, big.NewInt(0x100010001), CalcWork(0x1d00ffff))
+	})
+
+	t.Run("negative target has no work", func(t *testing.T) {
+		assert.Equal(t, 0, CalcWork(0x04923456).Sign())
+	})
+}
+
+func TestCheckProofOfWork(t *testing.T) {
+	t.Run("genesis block", func(t *testing.T) {
+		header := MainNetGenesisHeader
+		assert.NoError(t, header.CheckProofOfWork(MainNetPowLimit))
+	})
+
+	t.Run("hash above target", func(t *testing.T) {
+		header := MainNetGenesisHeader
+		header.Nonce++
+		assert.ErrorIs(t, header.CheckProofOfWork(MainNetPowLimit), ErrHighHash)
+	})
+
+	t.Run("target above pow limit", func(t *testing.T) {
+		header := MainNetGenesisHeader
+		header.Bits = 0x1d01ffff
+		assert.ErrorIs(t, header.CheckProofOfWork(MainNetPowLimit), ErrBadDifficultyBits)
+	})
+
+	t.Run("negative target", func(t *testing.T) {
+		header := MainNetGenesisHeader
+		header.Bits = 0x1d80ffff
+		assert.ErrorIs(t, header.CheckProofOfWork(MainNetPowLimit), ErrBadDifficultyBits)
+	})
+}
@@ -0,0 +1,209 @@
+package btc
+
+import "encoding/binary"
+
+// MaxBlockSigOpsCost is the maximum total cost of the signature operations in a block (BIP141). Legacy and P2SH
+// signature operations cost WitnessScaleFactor each, those in witness scripts cost one.
+const MaxBlockSigOpsCost = 80000
+
+// the opcodes needed for counting signature operations. The script package can't be used here, because it depends
+// on this package.
+const (
+	op0                   = 0x00
+	opPushData1           = 0x4c
+	opPushData2           = 0x4d
+	opPushData4           = 0x4e
+	op1                   = 0x51
+	op16                  = 0x60
+	opCheckSig            = 0xac
+	opCheckSigVerify      = 0xad
+	opCheckMultiSig       = 0xae
+	opCheckMultiSigVerify = 0xaf
+	opHash160             = 0xa9
+	opEqual               = 0x87
+
+	// maxPubKeysPerMultiSig is the number of signature operations counted for OP_CHECKMULTISIG if the number of keys
+	// is not known.
+	maxPubKeysPerMultiSig = 20
+)
+
+// scriptOp is a single operation of a script. data is the pushed data for push operations.
+type scriptOp struct {
+	op   byte
+	data []byte
+}
+
+// parseScript splits a script into its operations. ok is false if the script ends in the middle of a push, in which
+// case the operations before it are returned.
+func parseScript(script []byte) (ops []scriptOp, ok bool) {
+	for pos := 0; pos < len(script); {
+		op := script[pos]
+		pos++
+
+		if op > opPushData4 {
+			ops = append(ops, scriptOp{op: op})
+			continue
+		}
+
+		size := int(op)
+		lenSize := 0
+		switch op {
+		case opPushData1:
+			lenSize = 1
+		case opPushData2:
+			lenSize = 2
+		case opPushData4:
+			lenSize = 4
+		}
+		if len(script)-pos < lenSize {
+			return ops, false
+		}
+
+		switch lenSize {
+		case 1:
+			size = int(script[pos])
+		case 2:
+			size = int(binary.LittleEndian.Uint16(script[pos:]))
+		case 4:
+			size = int(binary.LittleEndian.Uint32(script[pos:]))
+		}
+		pos += lenSize
+
+		if size < 0 || len(script)-pos < size {
+			return ops, false
+		}
+		ops = append(ops, scriptOp{op: op, data: script[pos : pos+size]})
+		pos += size
+	}
+	return ops, true
+}
+
+// countSigOps counts the signature operations in a script. If accurate is true, the number of keys preceding an
+// OP_CHECKMULTISIG is used, as in P2SH and witness scripts. Otherwise, it counts as the maximum number of keys.
+func countSigOps(script []byte, accurate bool) int {
+	ops, _ := parseScript(script)
+	count := 0
+	var lastOp byte = 0xff
+
+	for _, o := range ops {
+		switch o.op {
+		case opCheckSig, opCheckSigVerify:
+			count++
+		case opCheckMultiSig, opCheckMultiSigVerify:
+			if accurate && lastOp >= op1 && lastOp <= op16 {
+				count += int(lastOp-op1) + 1
+			} else {
+				count += maxPubKeysPerMultiSig
+			}
+		}
+		lastOp = o.op
+	}
+	return count
+}
+
+// LegacySigOpCount returns the number of signature operations in the signature and public key scripts of a
+// transaction, without looking at the outputs it spends.
+func (tx *Transaction) LegacySigOpCount() int {
+	count := 0
+	for _, in := range tx.TxIn {
+		count += countSigOps(in.SignatureScript, false)
+	}
+	for _, out := range tx.TxOut {
+		count += countSigOps(out.ScriptPubKey, false)
+	}
+	return count
+}
+
+// SigOpCost returns the total cost of the signature operations of a transaction in a block at height. Once active,
+// those in the P2SH redeem scripts (BIP16) and witness scripts (BIP141) of its inputs are included. view must contain
+// the outputs spent by the transaction.
+func (tx *Transaction) SigOpCost(view CoinView, height int32, params *ConsensusParams) int {
+	cost := tx.LegacySigOpCount() * WitnessScaleFactor
+	if tx.IsCoinbase() || height < params.BIP16Height {
+		return cost
+	}
+
+	for _, in := range tx.TxIn {
+		coin, ok := view.Coin(in.PreviousOutput)
+		if !ok {
+			continue
+		}
+
+		prevScript := coin.Output.ScriptPubKey
+		if isPayToScriptHash(prevScript) {
+			if redeemScript, ok := lastPush(in.SignatureScript); ok {
+				cost += countSigOps(redeemScript, true) * WitnessScaleFactor
+			}
+		}
+
+		if height >= params.SegwitHeight {
+			cost += witnessSigOps(in, prevScript)
+		}
+	}
+	return cost
+}
+
+// witnessSigOps counts the signature operations of a native or P2SH wrapped witness program spent by in.
+func witnessSigOps(in TxInput, prevScript []byte) int {
+	program := prevScript
+	if isPayToScriptHash(prevScript) {
+		redeemScript, ok := lastPush(in.SignatureScript)
+		if !ok {
+			return 0
+		}
+		program = redeemScript
+	}
+
+	version, program, ok := witnessProgram(program)
+	if !ok || version != 0 {
+		return 0
+	}
+
+	switch {
+	case len(program) == 20:
+		return 1
+	case len(program) == 32 && len(in.Witness) > 0:
+		return countSigOps(in.Witness[len(in.Witness)-1], true)
+	}
+	return 0
+}
+
+func isPayToScriptHash(script []byte) bool {
+	return len(script) == 23 && script[0] == opHash160 && script[1] == 0x14 && script[22] == opEqual
+}
+
+// lastPush returns the data pushed last by a signature script, if the script only consists of pushes.
+func lastPush(script []byte) ([]byte, bool) {
+	ops, ok := parseScript(script)
+	if !ok || len(ops) == 0 {
+		return nil, false
+	}
+
+	for _, o := range ops {
+		if o.op > op16 {
+			return nil, false
+		}
+	}
+	return ops[len(ops)-1].data, true
+}
+
+// witnessProgram returns the version and program of a witness program script (BIP141).
+func witnessProgram(script []byte) (int, []byte, bool) {
+	if len(script) < 4 || len(script) > 42 {
+		return 0, nil, false
+	}
+
+	if script[0] != op0 && (script[0] < op1 || script[0] > op16) {
+		return 0, nil, false
+	}
+
+	if int(script[1])+2 != len(script) {
+		return 0, nil, false
+	}
+
+	version := 0
+	if script[0] != op0 {
+		version = int(script[0]-op1) + 1
+	}
+	return version, script[2:], true
+}
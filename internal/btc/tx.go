@@ -13,19 +13,69 @@ var ErrInvalidTransaction = errors.New("invalid transaction")
 var ErrInvalidTxInput = errors.New("invalid tx input")
 var ErrInvalidTxOutput = errors.New("invalid tx output")
 var ErrInvalidTxWitnesses = errors.New("invalid tx witnesses")
+var ErrInvalidTxHash = errors.New("invalid tx hash")
+var ErrSuperfluousWitness = errors.New("tx has witness flag but no witness data")
+
+const (
+	// minTxInputSize is the size of an input with an empty signature script.
+	minTxInputSize = 41
+	// minTxOutputSize is the size of an output with an empty public key script.
+	minTxOutputSize = 9
+)
+
+const TxHashSize = 32
 
-type TxHash [32]byte
+// TxHash is a transaction id or witness transaction id. Like block hashes, it is stored in the byte order produced by
+// SHA-256, but conventionally displayed in reverse.
+type TxHash [TxHashSize]byte
 
+// String returns the hash as hex string in the reversed byte order used by block explorers and Bitcoin Core.
 func (h TxHash) String() string {
-	return hex.EncodeToString(h[:])
+	var reversed TxHash
+	for i, b := range h {
+		reversed[TxHashSize-1-i] = b
+	}
+	return hex.EncodeToString(reversed[:])
+}
+
+// ParseTxHash parses a hash in the format returned by TxHash.String.
+func ParseTxHash(s string) (TxHash, error) {
+	var hash TxHash
+	if hex.DecodedLen(len(s)) != TxHashSize {
+		return hash, ErrInvalidTxHash
+	}
+
+	if _, err := hex.Decode(hash[:], []byte(s)); err != nil {
+		return hash, ErrInvalidTxHash
+	}
+
+	for i := 0; i < TxHashSize/2; i++ {
+		hash[i], hash[TxHashSize-1-i] = hash[TxHashSize-1-i], hash[i]
+	}
+	return hash, nil
+}
+
+func (h TxHash) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+func (h *TxHash) UnmarshalText(text []byte) error {
+	hash, err := ParseTxHash(string(text))
+	if err != nil {
+		return err
+	}
+
+	*h = hash
+	return nil
 }
 
 type Transaction struct {
-	Version      uint32
+	Version uint32
+	// HasWitnesses determines whether the transaction is serialized in the segwit format defined in BIP144. It must
+	// only be set if at least one input has a witness.
 	HasWitnesses bool
 	TxIn         []TxInput
 	TxOut        []TxOutput
-	TxWitnesses  []TxWitness
 	LockTime     uint32
 }
 
@@ -33,6 +83,9 @@ type TxInput struct {
 	PreviousOutput  OutPoint
 	SignatureScript []byte
 	Sequence        uint32
+	// Witness is the witness stack of the input as defined in BIP141. It is empty for inputs that don't spend segwit
+	// outputs.
+	Witness TxWitness
 }
 
 type TxOutput struct {
@@ -45,22 +98,91 @@ type OutPoint struct {
 	Index uint32
 }
 
-type TxWitness struct {
-	ComponentData []byte
+// IsNull returns whether the outpoint is the one used by coinbase inputs, which doesn't refer to any output.
+func (o OutPoint) IsNull() bool {
+	return o.Hash == TxHash{} && o.Index == 0xffffffff
 }
 
-type TxWitnesses struct {
-	Witnesses []TxWitness
-	Size      uint64
+// TxWitness is a stack of byte vectors that is used to satisfy the script of a segwit output.
+type TxWitness [][]byte
+
+// IsCoinbase returns whether the transaction is a coinbase transaction, which creates new coins instead of spending
+// existing outputs. Its only input doesn't refer to a previous output.
+func (tx *Transaction) IsCoinbase() bool {
+	return len(tx.TxIn) == 1 && tx.TxIn[0].PreviousOutput.IsNull()
 }
 
+// TxID returns the id of the transaction, which is the hash of its serialization without witness data.
+func (tx *Transaction) TxID() (TxHash, error) {
+	encoded, err := tx.EncodeWithoutWitness()
+	if err != nil {
+		return TxHash{}, err
+	}
+
+	return doubleSHA256(encoded), nil
+}
+
+// WTxID returns the witness id of the transaction, which is the hash of its serialization including witness data as
+// defined in BIP144. For transactions without witness data, it is the same as the id.
+func (tx *Transaction) WTxID() (TxHash, error) {
+	encoded, err := tx.encode(tx.HasWitnesses)
+	if err != nil {
+		return TxHash{}, err
+	}
+
+	return doubleSHA256(encoded), nil
+}
+
+// Encode serializes the transaction in the format it is relayed in, which includes witness data if HasWitnesses is
+// set. DecodeTransaction returns an identical transaction for the result.
 func (tx *Transaction) Encode() ([]byte, error) {
+	return tx.encode(tx.HasWitnesses)
+}
+
+// EncodeWithoutWitness serializes the transaction in the legacy format, which is used for calculating the txid.
+func (tx *Transaction) EncodeWithoutWitness() ([]byte, error) {
+	return tx.encode(false)
+}
+
+// HasWitness returns whether any input of the transaction has witness data.
+func (tx *Transaction) HasWitness() bool {
+	for _, in := range tx.TxIn {
+		if len(in.Witness) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Weight returns the weight of the transaction as defined in BIP141, which counts bytes of the serialization without
+// witness data WitnessScaleFactor times and witness bytes once.
+func (tx *Transaction) Weight() (int, error) {
+	base, err := tx.EncodeWithoutWitness()
+	if err != nil {
+		return 0, err
+	}
+
+	total, err := tx.Encode()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(base)*(WitnessScaleFactor-1) + len(total), nil
+}
+
+func (tx *Transaction) encode(withWitnesses bool) ([]byte, error) {
 	buf := new(bytes.Buffer)
 
 	if err := binary.Write(buf, binary.LittleEndian, tx.Version); err != nil {
 		return nil, err
 	}
 
+	if withWitnesses {
+		if _, err := buf.Write([]byte{0x00, 0x01}); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := vartypes.WriteAsVarInt(buf, uint64(len(tx.TxIn))); err != nil {
 		return nil, err
 	}
@@ -99,6 +221,14 @@ func (tx *Transaction) Encode() ([]byte, error) {
 		}
 	}
 
+	if withWitnesses {
+		for _, input := range tx.TxIn {
+			if err := input.Witness.encode(buf); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	if err := binary.Write(buf, binary.LittleEndian, tx.LockTime); err != nil {
 		return nil, err
 	}
@@ -120,8 +250,12 @@ func DecodeTransaction(buf *bytes.Buffer) (*Transaction, error) {
 		return nil, err
 	}
 
+	if tx.HasWitnesses {
+		buf.Next(2)
+	}
+
 	numInputs, ok := vartypes.DecodeVarInt(buf)
-	if !ok {
+	if !ok || numInputs.Value > uint64(buf.Len()/minTxInputSize) {
 		return nil, ErrInvalidTransaction
 	}
 
@@ -134,7 +268,7 @@ func DecodeTransaction(buf *bytes.Buffer) (*Transaction, error) {
 	}
 
 	numOutputs, ok := vartypes.DecodeVarInt(buf)
-	if !ok {
+	if !ok || numOutputs.Value > uint64(buf.Len()/minTxOutputSize) {
 		return nil, ErrInvalidTransaction
 	}
 
@@ -147,7 +281,20 @@ func DecodeTransaction(buf *bytes.Buffer) (*Transaction, error) {
 	}
 
 	if tx.HasWitnesses {
-		tx.TxWitnesses, err = decodeTxWitnesses(buf)
+		hasWitnessData := false
+
+		for i := range tx.TxIn {
+			tx.TxIn[i].Witness, err = decodeTxWitness(buf)
+			if err != nil {
+				return nil, err
+			}
+			hasWitnessData = hasWitnessData || len(tx.TxIn[i].Witness) > 0
+		}
+
+		// the witness flag must only be used if there is witness data, otherwise the encoding would be ambiguous
+		if !hasWitnessData {
+			return nil, ErrSuperfluousWitness
+		}
 	}
 
 	if buf.Len() < 4 {
@@ -266,33 +413,65 @@ func (i TxOutput) Encode() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func decodeTxWitnesses(buf *bytes.Buffer) (w []TxWitness, err error) {
+func decodeTxWitness(buf *bytes.Buffer) (TxWitness, error) {
 	count, ok := vartypes.DecodeVarInt(buf)
-	if !ok {
-		return w, ErrInvalidTxWitnesses
+	if !ok || count.Value > uint64(buf.Len()) {
+		return nil, ErrInvalidTxWitnesses
 	}
 
-	witnesses := make([]TxWitness, count.Value)
+	witness := make(TxWitness, count.Value)
 
-	for i := uint64(0); i < count.Value; i++ {
+	for i := range witness {
 		l, ok := vartypes.DecodeVarInt(buf)
-		if !ok {
-			return w, ErrInvalidTxWitnesses
+		if !ok || l.Value > uint64(buf.Len()) {
+			return nil, ErrInvalidTxWitnesses
 		}
 
-		witnesses = append(witnesses, TxWitness{ComponentData: buf.Next(int(l.Value))})
+		witness[i] = buf.Next(int(l.Value))
 	}
-	return
+	return witness, nil
+}
+
+func (w TxWitness) encode(buf *bytes.Buffer) error {
+	if err := vartypes.WriteAsVarInt(buf, uint64(len(w))); err != nil {
+		return err
+	}
+
+	for _, item := range w {
+		if err := vartypes.WriteAsVarInt(buf, uint64(len(item))); err != nil {
+			return err
+		}
+
+		written, err := buf.Write(item)
+		if err != nil {
+			return err
+		}
+		if written != len(item) {
+			return io.ErrShortWrite
+		}
+	}
+	return nil
 }
 
+// decodeWitnessFlag checks whether the transaction in buf is in the segwit format. In that format, the version is
+// followed by a marker byte 0x00 and a flag byte 0x01 instead of the number of inputs.
 func decodeWitnessFlag(buf *bytes.Buffer) (bool, error) {
 	flag := buf.Bytes()
 	if len(flag) < 2 {
 		return false, ErrInvalidTxWitnesses
 	}
 
-	if flag[0] == 0 && flag[1] == 1 {
+	if flag[0] != 0 {
+		return false, nil
+	}
+
+	switch flag[1] {
+	case 0:
+		// a transaction without inputs in the legacy format
+		return false, nil
+	case 1:
 		return true, nil
+	default:
+		return false, ErrInvalidTxWitnesses
 	}
-	return false, nil
 }
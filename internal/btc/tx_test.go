@@ -3,6 +3,7 @@ package btc
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/hex"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -113,3 +114,200 @@ func TestTransaction(t *testing.T) {
 		assert.Equal(t, 38, len(tx.TxOut[2].ScriptPubKey))
 	})
 }
+
+func TestTxHash(t *testing.T) {
+	const genesisTxID = "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+
+	t.Run("string is in reversed byte order", func(t *testing.T) {
+		hash := TxHash(MainNetGenesisHeader.MerkleRoot)
+		assert.Equal(t, genesisTxID, hash.String())
+	})
+
+	t.Run("parse", func(t *testing.T) {
+		hash, err := ParseTxHash(genesisTxID)
+		assert.NoError(t, err)
+		assert.Equal(t, TxHash(MainNetGenesisHeader.MerkleRoot), hash)
+	})
+
+	t.Run("text round trip", func(t *testing.T) {
+		var hash TxHash
+		assert.NoError(t, hash.UnmarshalText([]byte(genesisTxID)))
+
+		text, err := hash.MarshalText()
+		assert.NoError(t, err)
+		assert.Equal(t, genesisTxID, string(text))
+	})
+
+	t.Run("rejects invalid strings", func(t *testing.T) {
+		for _, s := range []string{"", genesisTxID[2:], genesisTxID + "00", "zz" + genesisTxID[2:]} {
+			_, err := ParseTxHash(s)
+			assert.ErrorIs(t, err, ErrInvalidTxHash)
+		}
+	})
+}
+
+func TestTxID(t *testing.T) {
+	t.Run("genesis coinbase", func(t *testing.T) {
+		tx := decodeGenesisCoinbase(t)
+
+		txid, err := tx.TxID()
+		assert.NoError(t, err)
+		assert.Equal(t, "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b", txid.String())
+
+		wtxid, err := tx.WTxID()
+		assert.NoError(t, err)
+		assert.Equal(t, txid, wtxid)
+	})
+
+	t.Run("witness data only affects the wtxid", func(t *testing.T) {
+		tx := decodeGenesisCoinbase(t)
+		txid, err := tx.TxID()
+		assert.NoError(t, err)
+
+		tx.HasWitnesses = true
+		tx.TxIn[0].Witness = TxWitness{make([]byte, 32)}
+
+		segwitTxID, err := tx.TxID()
+		assert.NoError(t, err)
+		assert.Equal(t, txid, segwitTxID)
+
+		wtxid, err := tx.WTxID()
+		assert.NoError(t, err)
+		assert.NotEqual(t, txid, wtxid)
+
+		tx.TxIn[0].Witness[0][0] = 1
+		otherWTxID, err := tx.WTxID()
+		assert.NoError(t, err)
+		assert.NotEqual(t, wtxid, otherWTxID)
+	})
+}
+
+func TestTransactionRoundTrip(t *testing.T) {
+	t.Run("legacy transaction", func(t *testing.T) {
+		raw, err := base64.StdEncoding.DecodeString(genesisCoinbaseB64)
+		assert.NoError(t, err)
+
+		tx, err := DecodeTransaction(bytes.NewBuffer(raw))
+		assert.NoError(t, err)
+		assert.False(t, tx.HasWitnesses)
+
+		encoded, err := tx.Encode()
+		assert.NoError(t, err)
+		assert.Equal(t, raw, encoded)
+	})
+
+	// the signed native P2WPKH example from BIP143
+	segwitHex := "01000000000102fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f000000004948304502" +
+		"21008b9d1dc26ba6a9cb62127b02742fa9d754cd3bebf337f7a55d114c8e5cdd30be022040529b194ba3f9281a99f2b1c0a19c0489" +
+		"bc22ede944ccf4ecbab4cc618ef3ed01eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a01" +
+		"00000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976" +
+		"a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac000247304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d544" +
+		"7a12fb1366d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d43c212a8caed02de67eebee0121025476c2e83188" +
+		"368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee635711000000"
+	segwitRaw, err := hex.DecodeString(segwitHex)
+	assert.NoError(t, err)
+
+	t.Run("segwit transaction", func(t *testing.T) {
+		buf := bytes.NewBuffer(segwitRaw)
+		tx, err := DecodeTransaction(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, buf.Len())
+
+		assert.True(t, tx.HasWitnesses)
+		assert.Len(t, tx.TxIn, 2)
+		assert.Len(t, tx.TxOut, 2)
+		assert.Empty(t, tx.TxIn[0].Witness)
+		assert.Len(t, tx.TxIn[1].Witness, 2)
+		assert.Len(t, tx.TxIn[1].Witness[0], 71)
+		assert.Len(t, tx.TxIn[1].Witness[1], 33)
+		assert.Equal(t, uint32(0x11), tx.LockTime)
+
+		encoded, err := tx.Encode()
+		assert.NoError(t, err)
+		assert.Equal(t, segwitRaw, encoded)
+	})
+
+	t.Run("serialization without witness", func(t *testing.T) {
+		tx, err := DecodeTransaction(bytes.NewBuffer(segwitRaw))
+		assert.NoError(t, err)
+
+		stripped, err := tx.EncodeWithoutWitness()
+		assert.NoError(t, err)
+
+		legacy, err := DecodeTransaction(bytes.NewBuffer(stripped))
+		assert.NoError(t, err)
+		assert.False(t, legacy.HasWitnesses)
+
+		txid, err := tx.TxID()
+		assert.NoError(t, err)
+		legacyTxID, err := legacy.TxID()
+		assert.NoError(t, err)
+		assert.Equal(t, txid, legacyTxID)
+	})
+
+	t.Run("rejects witness flag without witness data", func(t *testing.T) {
+		tx, err := DecodeTransaction(bytes.NewBuffer(segwitRaw))
+		assert.NoError(t, err)
+
+		tx.TxIn[1].Witness = nil
+		encoded, err := tx.Encode()
+		assert.NoError(t, err)
+
+		_, err = DecodeTransaction(bytes.NewBuffer(encoded))
+		assert.ErrorIs(t, err, ErrSuperfluousWitness)
+	})
+
+	t.Run("rejects unknown flag", func(t *testing.T) {
+		raw := bytes.Clone(segwitRaw)
+		raw[5] = 2
+
+		_, err := DecodeTransaction(bytes.NewBuffer(raw))
+		assert.ErrorIs(t, err, ErrInvalidTxWitnesses)
+	})
+
+	t.Run("rejects truncated witness", func(t *testing.T) {
+		_, err := DecodeTransaction(bytes.NewBuffer(segwitRaw[:len(segwitRaw)-40]))
+		assert.Error(t, err)
+	})
+}
+
+func TestBlockRoundTrip(t *testing.T) {
+	block := Block{Header: MainNetGenesisHeader, Transactions: []Transaction{decodeGenesisCoinbase(t)}}
+
+	encoded, err := block.Encode()
+	assert.NoError(t, err)
+	assert.Len(t, encoded, 285)
+
+	decoded, err := DecodeBlock(bytes.NewBuffer(encoded))
+	assert.NoError(t, err)
+
+	reencoded, err := decoded.Encode()
+	assert.NoError(t, err)
+	assert.Equal(t, encoded, reencoded)
+}
+
+func TestBlockEncodeWithoutWitness(t *testing.T) {
+	coinbase := decodeGenesisCoinbase(t)
+	segwitTx := decodeGenesisCoinbase(t)
+	segwitTx.HasWitnesses = true
+	segwitTx.TxIn[0].Witness = TxWitness{make([]byte, 32)}
+
+	block := Block{Header: MainNetGenesisHeader, Transactions: []Transaction{coinbase, segwitTx}}
+
+	full, err := block.Encode()
+	assert.NoError(t, err)
+	stripped, err := block.EncodeWithoutWitness()
+	assert.NoError(t, err)
+	assert.Less(t, len(stripped), len(full))
+
+	decoded, err := DecodeBlock(bytes.NewBuffer(stripped))
+	assert.NoError(t, err)
+	assert.Len(t, decoded.Transactions, 2)
+	assert.False(t, decoded.Transactions[1].HasWitnesses)
+
+	txid, err := segwitTx.TxID()
+	assert.NoError(t, err)
+	decodedTxID, err := decoded.Transactions[1].TxID()
+	assert.NoError(t, err)
+	assert.Equal(t, txid, decodedTxID)
+}
@@ -0,0 +1,177 @@
+package btc
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// SatoshisPerBitcoin is the number of satoshis in a bitcoin. A satoshi is the unit of all values in transactions.
+	SatoshisPerBitcoin = 100_000_000
+	// MaxMoney is the total amount of bitcoin that will ever exist. No value in a transaction can be larger.
+	MaxMoney = 21_000_000 * SatoshisPerBitcoin
+	// CoinbaseMaturity is the number of blocks that must be added on top of a coinbase transaction before its outputs
+	// can be spent.
+	CoinbaseMaturity = 100
+	// MaxBlockWeight is the maximum weight of a block as defined in BIP141.
+	MaxBlockWeight = 4_000_000
+	// WitnessScaleFactor is the weight of a byte of non-witness data relative to a byte of witness data.
+	WitnessScaleFactor = 4
+
+	minCoinbaseScriptSize = 2
+	maxCoinbaseScriptSize = 100
+)
+
+var (
+	ErrNoInputs               = errors.New("transaction has no inputs")
+	ErrNoOutputs              = errors.New("transaction has no outputs")
+	ErrTxTooLarge             = errors.New("transaction is larger than the maximum block size")
+	ErrNegativeOutput         = errors.New("output value is negative")
+	ErrOutputTooLarge         = errors.New("output value is larger than the maximum amount of money")
+	ErrTotalOutputTooLarge    = errors.New("sum of output values is larger than the maximum amount of money")
+	ErrDuplicateInputs        = errors.New("transaction spends the same output more than once")
+	ErrBadCoinbaseLength      = errors.New("coinbase signature script has an invalid length")
+	ErrNullPrevOut            = errors.New("non-coinbase input doesn't refer to a previous output")
+	ErrMissingInputs          = errors.New("input spends a missing or already spent output")
+	ErrPrematureCoinbaseSpend = errors.New("input spends an immature coinbase output")
+	ErrInputValueOutOfRange   = errors.New("sum of input values is out of range")
+	ErrInputsBelowOutputs     = errors.New("sum of input values is less than sum of output values")
+)
+
+// RuleError is returned when a transaction or block violates a consensus rule.
+type RuleError struct {
+	// Rule is the sentinel error for the violated rule, e.g. ErrDuplicateInputs. errors.Is matches a RuleError with
+	// its rule.
+	Rule error
+	// Detail describes the violation, e.g. which input or output is affected.
+	Detail string
+}
+
+func (e *RuleError) Error() string {
+	if e.Detail == "" {
+		return e.Rule.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Rule, e.Detail)
+}
+
+func (e *RuleError) Unwrap() error {
+	return e.Rule
+}
+
+func ruleError(rule error, format string, args ...any) *RuleError {
+	return &RuleError{Rule: rule, Detail: fmt.Sprintf(format, args...)}
+}
+
+// MoneyRange returns whether value is a valid amount of satoshis.
+func MoneyRange(value int64) bool {
+	return value >= 0 && value <= MaxMoney
+}
+
+// CheckTransaction performs the checks of a transaction that don't depend on the chain it is included in.
+func CheckTransaction(tx *Transaction) error {
+	if len(tx.TxIn) == 0 {
+		return &RuleError{Rule: ErrNoInputs}
+	}
+
+	if len(tx.TxOut) == 0 {
+		return &RuleError{Rule: ErrNoOutputs}
+	}
+
+	encoded, err := tx.EncodeWithoutWitness()
+	if err != nil {
+		return err
+	}
+	if len(encoded)*WitnessScaleFactor > MaxBlockWeight {
+		return ruleError(ErrTxTooLarge, "%d bytes without witness data", len(encoded))
+	}
+
+	var total int64
+	for i, out := range tx.TxOut {
+		if out.Value < 0 {
+			return ruleError(ErrNegativeOutput, "output %d has value %d", i, out.Value)
+		}
+		if out.Value > MaxMoney {
+			return ruleError(ErrOutputTooLarge, "output %d has value %d", i, out.Value)
+		}
+
+		total += out.Value
+		if !MoneyRange(total) {
+			return ruleError(ErrTotalOutputTooLarge, "total is %d after output %d", total, i)
+		}
+	}
+
+	spent := make(map[OutPoint]bool, len(tx.TxIn))
+	for i, in := range tx.TxIn {
+		if spent[in.PreviousOutput] {
+			return ruleError(ErrDuplicateInputs, "input %d spends %s:%d", i, in.PreviousOutput.Hash, in.PreviousOutput.Index)
+		}
+		spent[in.PreviousOutput] = true
+	}
+
+	if tx.IsCoinbase() {
+		size := len(tx.TxIn[0].SignatureScript)
+		if size < minCoinbaseScriptSize || size > maxCoinbaseScriptSize {
+			return ruleError(ErrBadCoinbaseLength, "%d bytes", size)
+		}
+		return nil
+	}
+
+	for i, in := range tx.TxIn {
+		if in.PreviousOutput.IsNull() {
+			return ruleError(ErrNullPrevOut, "input %d", i)
+		}
+	}
+	return nil
+}
+
+// CheckTxInputs checks the inputs of a non-coinbase transaction against the outputs they spend, which are looked up
+// in view. spendHeight is the height of the block the transaction is included in. It returns the fee paid by the
+// transaction.
+func CheckTxInputs(tx *Transaction, view CoinView, spendHeight int32) (int64, error) {
+	var valueIn int64
+	for i, in := range tx.TxIn {
+		coin, ok := view.Coin(in.PreviousOutput)
+		if !ok {
+			return 0, ruleError(ErrMissingInputs, "input %d spends %s:%d", i, in.PreviousOutput.Hash, in.PreviousOutput.Index)
+		}
+
+		if coin.IsCoinbase && spendHeight-coin.Height < CoinbaseMaturity {
+			return 0, ruleError(
+				ErrPrematureCoinbaseSpend,
+				"input %d spends coinbase from height %d at height %d",
+				i,
+				coin.Height,
+				spendHeight,
+			)
+		}
+
+		valueIn += coin.Output.Value
+		if !MoneyRange(coin.Output.Value) || !MoneyRange(valueIn) {
+			return 0, ruleError(ErrInputValueOutOfRange, "total is %d after input %d", valueIn, i)
+		}
+	}
+
+	var valueOut int64
+	for _, out := range tx.TxOut {
+		valueOut += out.Value
+	}
+
+	if valueIn < valueOut {
+		return 0, ruleError(ErrInputsBelowOutputs, "inputs %d, outputs %d", valueIn, valueOut)
+	}
+	return valueIn - valueOut, nil
+}
+
+// ValidateTransaction checks that a transaction is valid when included in a block at spendHeight, with the outputs
+// it spends looked up in view. It returns the fee paid by the transaction, which is zero for coinbase transactions.
+// Violations of consensus rules are returned as *RuleError. Scripts are not verified.
+func ValidateTransaction(tx *Transaction, view CoinView, spendHeight int32) (int64, error) {
+	if err := CheckTransaction(tx); err != nil {
+		return 0, err
+	}
+
+	if tx.IsCoinbase() {
+		return 0, nil
+	}
+	return CheckTxInputs(tx, view, spendHeight)
+}
@@ -0,0 +1,139 @@
+package btc
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+type coinMap map[OutPoint]Coin
+
+func (m coinMap) Coin(outPoint OutPoint) (Coin, bool) {
+	coin, ok := m[outPoint]
+	return coin, ok
+}
+
+func validCoinbase() *Transaction {
+	return &Transaction{
+		Version: 1,
+		TxIn:    []TxInput{{PreviousOutput: OutPoint{Index: 0xffffffff}, SignatureScript: []byte{0x01, 0x02}}},
+		TxOut:   []TxOutput{{Value: 50 * SatoshisPerBitcoin, ScriptPubKey: []byte{0x51}}},
+	}
+}
+
+func TestCheckTransaction(t *testing.T) {
+	prevOut := OutPoint{Hash: TxHash{1}, Index: 0}
+	valid := func() *Transaction {
+		return &Transaction{
+			Version: 1,
+			TxIn:    []TxInput{{PreviousOutput: prevOut}},
+			TxOut:   []TxOutput{{Value: 10, ScriptPubKey: []byte{0x51}}},
+		}
+	}
+
+	assert.NoError(t, CheckTransaction(valid()))
+	assert.NoError(t, CheckTransaction(validCoinbase()))
+
+	tests := []struct {
+		name     string
+		modify   func(tx *Transaction)
+		expected error
+	}{
+		{"no inputs", func(tx *Transaction) { tx.TxIn = nil }, ErrNoInputs},
+		{"no outputs", func(tx *Transaction) { tx.TxOut = nil }, ErrNoOutputs},
+		{"negative output", func(tx *Transaction) { tx.TxOut[0].Value = -1 }, ErrNegativeOutput},
+		{"output too large", func(tx *Transaction) { tx.TxOut[0].Value = MaxMoney + 1 }, ErrOutputTooLarge},
+		{"total too large", func(tx *Transaction) {
+			tx.TxOut = []TxOutput{{Value: MaxMoney}, {Value: 1}}
+		}, ErrTotalOutputTooLarge},
+		{"duplicate inputs", func(tx *Transaction) { tx.TxIn = append(tx.TxIn, tx.TxIn[0]) }, ErrDuplicateInputs},
+		{"null prevout", func(tx *Transaction) {
+			tx.TxIn = append(tx.TxIn, TxInput{PreviousOutput: OutPoint{Index: 0xffffffff}})
+		}, ErrNullPrevOut},
+		{"too large", func(tx *Transaction) {
+			tx.TxOut[0].ScriptPubKey = []byte(strings.Repeat("x", MaxBlockWeight/WitnessScaleFactor))
+		}, ErrTxTooLarge},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := valid()
+			test.modify(tx)
+
+			err := CheckTransaction(tx)
+			assert.ErrorIs(t, err, test.expected)
+
+			var ruleErr *RuleError
+			assert.ErrorAs(t, err, &ruleErr)
+		})
+	}
+
+	t.Run("coinbase script length", func(t *testing.T) {
+		for _, size := range []int{1, 101} {
+			tx := validCoinbase()
+			tx.TxIn[0].SignatureScript = make([]byte, size)
+			assert.ErrorIs(t, CheckTransaction(tx), ErrBadCoinbaseLength)
+		}
+
+		tx := validCoinbase()
+		tx.TxIn[0].SignatureScript = make([]byte, 100)
+		assert.NoError(t, CheckTransaction(tx))
+	})
+}
+
+func TestValidateTransaction(t *testing.T) {
+	coinbaseOut := OutPoint{Hash: TxHash{1}, Index: 0}
+	regularOut := OutPoint{Hash: TxHash{2}, Index: 1}
+	view := coinMap{
+		coinbaseOut: {Output: TxOutput{Value: 50}, Height: 10, IsCoinbase: true},
+		regularOut:  {Output: TxOutput{Value: 30}, Height: 100},
+	}
+
+	tx := &Transaction{
+		Version: 1,
+		TxIn:    []TxInput{{PreviousOutput: coinbaseOut}, {PreviousOutput: regularOut}},
+		TxOut:   []TxOutput{{Value: 70, ScriptPubKey: []byte{0x51}}},
+	}
+
+	fee, err := ValidateTransaction(tx, view, 110)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), fee)
+
+	t.Run("immature coinbase", func(t *testing.T) {
+		_, err := ValidateTransaction(tx, view, 109)
+		assert.ErrorIs(t, err, ErrPrematureCoinbaseSpend)
+		assert.EqualError(t, err, "input spends an immature coinbase output: input 0 spends coinbase from height 10 at height 109")
+	})
+
+	t.Run("missing input", func(t *testing.T) {
+		_, err := ValidateTransaction(tx, coinMap{coinbaseOut: view[coinbaseOut]}, 110)
+		assert.ErrorIs(t, err, ErrMissingInputs)
+	})
+
+	t.Run("inputs below outputs", func(t *testing.T) {
+		tx := *tx
+		tx.TxOut = []TxOutput{{Value: 81}}
+		_, err := ValidateTransaction(&tx, view, 110)
+		assert.ErrorIs(t, err, ErrInputsBelowOutputs)
+	})
+
+	t.Run("input value out of range", func(t *testing.T) {
+		view := coinMap{coinbaseOut: view[coinbaseOut], regularOut: {Output: TxOutput{Value: MaxMoney}}}
+		_, err := ValidateTransaction(tx, view, 110)
+		assert.ErrorIs(t, err, ErrInputValueOutOfRange)
+	})
+
+	t.Run("context free checks come first", func(t *testing.T) {
+		tx := *tx
+		tx.TxIn = []TxInput{tx.TxIn[0], tx.TxIn[0]}
+		_, err := ValidateTransaction(&tx, coinMap{}, 110)
+		assert.ErrorIs(t, err, ErrDuplicateInputs)
+	})
+
+	t.Run("coinbase doesn't spend outputs", func(t *testing.T) {
+		fee, err := ValidateTransaction(validCoinbase(), coinMap{}, 1)
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), fee)
+	})
+}
@@ -0,0 +1,386 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"math/big"
+	"time"
+)
+
+// maxOrphans is the maximum number of headers kept aside while waiting for their parent to arrive.
+const maxOrphans = 10000
+
+var (
+	ErrInvalidGenesis       = errors.New("invalid genesis header")
+	ErrCheckpointMismatch   = errors.New("header contradicts checkpoint")
+	ErrForkBeforeCheckpoint = errors.New("header forks off before the last checkpoint")
+)
+
+// BlockNode is an entry in the BlockIndex. It links a header to its parent and tracks its position in the tree.
+type BlockNode struct {
+	Hash   btc.BlockHash
+	Header btc.Header
+	Parent *BlockNode
+	Height int32
+	// Work is the cumulative amount of work of the chain ending in this block, including the block itself.
+	Work *big.Int
+	skip *BlockNode
+	// invalid is set if the block or one of its ancestors violates a consensus rule
+	invalid bool
+}
+
+// IsInvalid returns whether the block or one of its ancestors has been marked as invalid.
+func (n *BlockNode) IsInvalid() bool {
+	return n.invalid
+}
+
+// Ancestor returns the ancestor of the node at the given height, or nil if height is negative or larger than the
+// height of the node.
+func (n *BlockNode) Ancestor(height int32) *BlockNode {
+	if height > n.Height || height < 0 {
+		return nil
+	}
+
+	walk := n
+	walkHeight := n.Height
+
+	for walkHeight > height {
+		skipHeight := getSkipHeight(walkHeight)
+		skipHeightPrev := getSkipHeight(walkHeight - 1)
+
+		// take the skip pointer only if it doesn't overshoot and following the parent doesn't lead to a better one
+		if walk.skip != nil && (skipHeight == height ||
+			(skipHeight > height && !(skipHeightPrev < skipHeight-2 && skipHeightPrev >= height))) {
+			walk = walk.skip
+			walkHeight = skipHeight
+		} else {
+			walk = walk.Parent
+			walkHeight--
+		}
+	}
+
+	return walk
+}
+
+// HeaderChain returns the chain of headers ending in the node, e.g. to compute the difficulty and minimum timestamp of
+// a child block.
+func (n *BlockNode) HeaderChain() btc.HeaderChain {
+	return headerChain{n}
+}
+
+// headerChain provides contextual header validation with access to the chain ending in a node.
+type headerChain struct {
+	tip *BlockNode
+}
+
+func (c headerChain) Height() int32 {
+	return c.tip.Height
+}
+
+func (c headerChain) HeaderAt(height int32) *btc.Header {
+	return &c.tip.Ancestor(height).Header
+}
+
+// BlockIndex is a tree of block headers rooted at the genesis block. Headers are linked to their parent via
+// btc.Header.PrevBlock. Headers whose parent is not known yet are kept aside as orphans and linked into the tree once
+// the parent arrives. The index tracks the tip with the most cumulative work as the best tip.
+type BlockIndex struct {
+	params       *btc.ConsensusParams
+	now          func() time.Time
+	nodes        map[btc.BlockHash]*BlockNode
+	orphans      map[btc.BlockHash][]*btc.Header
+	orphanHashes map[btc.BlockHash]bool
+	genesis      *BlockNode
+	best         *BlockNode
+
+	checkpoints map[int32]btc.BlockHash
+	// lastCheckpoint is the checkpoint with the greatest height that has been linked into the index
+	lastCheckpoint *BlockNode
+}
+
+// NewBlockIndex creates a BlockIndex containing only the given genesis header. Headers added to the index must satisfy
+// the proof of work and timestamp rules in params.
+func NewBlockIndex(genesis *btc.Header, params *btc.ConsensusParams) (*BlockIndex, error) {
+	if genesis.PrevBlock != (btc.BlockHash{}) {
+		return nil, ErrInvalidGenesis
+	}
+
+	hash, err := genesis.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	node := &BlockNode{
+		Hash:   hash,
+		Header: *genesis,
+		Parent: nil,
+		Height: 0,
+		Work:   btc.CalcWork(genesis.Bits),
+	}
+
+	return &BlockIndex{
+		params:       params,
+		now:          time.Now,
+		nodes:        map[btc.BlockHash]*BlockNode{hash: node},
+		orphans:      make(map[btc.BlockHash][]*btc.Header),
+		orphanHashes: make(map[btc.BlockHash]bool),
+		genesis:      node,
+		best:         node,
+	}, nil
+}
+
+// SetCheckpoints sets the blocks the index must agree with. Headers at the height of a checkpoint with a different hash
+// are rejected, as are headers forking off the chain before the last checkpoint in the index. Checkpoints should be set
+// before adding headers, since headers already in the index are not checked.
+func (i *BlockIndex) SetCheckpoints(checkpoints []btc.Checkpoint) {
+	i.checkpoints = make(map[int32]btc.BlockHash, len(checkpoints))
+	for _, checkpoint := range checkpoints {
+		i.checkpoints[checkpoint.Height] = checkpoint.Hash
+	}
+}
+
+// AddHeader adds a header to the index. If the parent of the header is not in the index, the header is kept as an
+// orphan and AddHeader returns no nodes. Otherwise, the header and all orphans descending from it are linked into the
+// tree and returned as nodes, parents before children. Headers already in the index are ignored. Headers without a
+// valid proof of work, or that violate the difficulty adjustment or timestamp rules when linked to their parent, are
+// rejected. Orphans violating these rules are dropped together with their descendants once their parent arrives.
+func (i *BlockIndex) AddHeader(header *btc.Header) ([]*BlockNode, error) {
+	hash, err := header.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	if i.Contains(hash) || i.orphanHashes[hash] {
+		return nil, nil
+	}
+
+	if err := header.CheckProofOfWork(i.params.PowLimit); err != nil {
+		return nil, err
+	}
+
+	parent, ok := i.nodes[header.PrevBlock]
+	if !ok {
+		i.addOrphan(hash, header)
+		return nil, nil
+	}
+
+	node, err := i.link(parent, hash, header)
+	if err != nil {
+		return nil, err
+	}
+	linked := []*BlockNode{node}
+
+	// link orphans whose ancestors have just arrived, breadth first
+	for j := 0; j < len(linked); j++ {
+		children := i.orphans[linked[j].Hash]
+		delete(i.orphans, linked[j].Hash)
+
+		for _, child := range children {
+			childHash, err := child.Hash()
+			if err != nil {
+				return linked, err
+			}
+
+			delete(i.orphanHashes, childHash)
+			node, err := i.link(linked[j], childHash, child)
+			if err != nil {
+				// the descendants of an invalid header can never be linked
+				i.dropOrphans(childHash)
+				continue
+			}
+			linked = append(linked, node)
+		}
+	}
+
+	return linked, nil
+}
+
+// Lookup returns the node for the block with the given hash, if it is in the index. Orphans are not returned.
+func (i *BlockIndex) Lookup(hash btc.BlockHash) (*BlockNode, bool) {
+	node, ok := i.nodes[hash]
+	return node, ok
+}
+
+// Contains returns whether the block with the given hash is linked into the index.
+func (i *BlockIndex) Contains(hash btc.BlockHash) bool {
+	_, ok := i.nodes[hash]
+	return ok
+}
+
+// IsOrphan returns whether the header with the given hash is waiting for its parent to arrive.
+func (i *BlockIndex) IsOrphan(hash btc.BlockHash) bool {
+	return i.orphanHashes[hash]
+}
+
+// Genesis returns the root of the index.
+func (i *BlockIndex) Genesis() *BlockNode {
+	return i.genesis
+}
+
+// Best returns the tip with the most cumulative work that isn't invalid. If several tips have the same amount of work,
+// the one that was added first is returned.
+func (i *BlockIndex) Best() *BlockNode {
+	return i.best
+}
+
+// Invalidate marks the block of node and all of its descendants as invalid, e.g. because the block turned out to
+// violate a consensus rule once its body was validated. Invalid blocks never become the best tip. If the best tip is
+// invalidated, the valid block with the most work becomes the new best tip. The genesis block can't be invalidated.
+func (i *BlockIndex) Invalidate(node *BlockNode) {
+	if node == i.genesis {
+		return
+	}
+
+	for _, n := range i.nodes {
+		if n.Height >= node.Height && n.Ancestor(node.Height) == node {
+			n.invalid = true
+		}
+	}
+
+	if !i.best.invalid {
+		return
+	}
+
+	i.best = i.genesis
+	for _, n := range i.nodes {
+		if !n.invalid && n.Work.Cmp(i.best.Work) > 0 {
+			i.best = n
+		}
+	}
+}
+
+// Size returns the number of blocks linked into the index.
+func (i *BlockIndex) Size() int {
+	return len(i.nodes)
+}
+
+// Locator returns a block locator for the chain ending in node. It contains the hashes of the ten most recent blocks,
+// followed by hashes of blocks exponentially further back and always ends with the genesis block. It allows a peer to
+// find the most recent block we have in common, even if we are on a fork.
+func (i *BlockIndex) Locator(node *BlockNode) []btc.BlockHash {
+	locator := make([]btc.BlockHash, 0, 32)
+	step := int32(1)
+
+	for node != nil {
+		locator = append(locator, node.Hash)
+		if node.Height == 0 {
+			break
+		}
+
+		if len(locator) >= 10 {
+			step *= 2
+		}
+
+		height := max(node.Height-step, 0)
+		node = node.Ancestor(height)
+	}
+
+	return locator
+}
+
+// FindFork returns the most recent common ancestor of a and b.
+func FindFork(a, b *BlockNode) *BlockNode {
+	if a.Height > b.Height {
+		a = a.Ancestor(b.Height)
+	} else if b.Height > a.Height {
+		b = b.Ancestor(a.Height)
+	}
+
+	for a != b && a != nil && b != nil {
+		a = a.Parent
+		b = b.Parent
+	}
+
+	return a
+}
+
+func (i *BlockIndex) link(parent *BlockNode, hash btc.BlockHash, header *btc.Header) (*BlockNode, error) {
+	height := parent.Height + 1
+	checkpoint, isCheckpoint := i.checkpoints[height]
+	if isCheckpoint && checkpoint != hash {
+		return nil, fmt.Errorf("%w: block %s at height %d, expected %s", ErrCheckpointMismatch, hash, height, checkpoint)
+	}
+
+	if i.lastCheckpoint != nil && height <= i.lastCheckpoint.Height {
+		return nil, fmt.Errorf("%w: block %s at height %d", ErrForkBeforeCheckpoint, hash, height)
+	}
+
+	if err := header.CheckHeaderContext(headerChain{parent}, i.params, i.now()); err != nil {
+		return nil, err
+	}
+
+	node := &BlockNode{
+		Hash:    hash,
+		Header:  *header,
+		Parent:  parent,
+		Height:  height,
+		Work:    new(big.Int).Add(parent.Work, btc.CalcWork(header.Bits)),
+		invalid: parent.invalid,
+	}
+	node.skip = parent.Ancestor(getSkipHeight(node.Height))
+
+	i.nodes[hash] = node
+	if isCheckpoint {
+		i.lastCheckpoint = node
+	}
+
+	if !node.invalid && node.Work.Cmp(i.best.Work) > 0 {
+		i.best = node
+	}
+
+	return node, nil
+}
+
+func (i *BlockIndex) addOrphan(hash btc.BlockHash, header *btc.Header) {
+	if len(i.orphanHashes) >= maxOrphans {
+		// make room by dropping an arbitrary group of orphans
+		for prev, headers := range i.orphans {
+			for _, h := range headers {
+				if orphanHash, err := h.Hash(); err == nil {
+					delete(i.orphanHashes, orphanHash)
+				}
+			}
+			delete(i.orphans, prev)
+			break
+		}
+	}
+
+	i.orphans[header.PrevBlock] = append(i.orphans[header.PrevBlock], header)
+	i.orphanHashes[hash] = true
+}
+
+// dropOrphans removes all orphans descending from the header with the given hash.
+func (i *BlockIndex) dropOrphans(hash btc.BlockHash) {
+	pending := []btc.BlockHash{hash}
+
+	for len(pending) > 0 {
+		prev := pending[0]
+		pending = pending[1:]
+
+		for _, h := range i.orphans[prev] {
+			if orphanHash, err := h.Hash(); err == nil {
+				delete(i.orphanHashes, orphanHash)
+				pending = append(pending, orphanHash)
+			}
+		}
+		delete(i.orphans, prev)
+	}
+}
+
+// getSkipHeight determines the height a node's skip pointer points to. Any height lower than the node's works, but
+// these choices allow Ancestor to find any ancestor in O(log n) steps.
+func getSkipHeight(height int32) int32 {
+	if height < 2 {
+		return 0
+	}
+
+	if height&1 == 1 {
+		return invertLowestOne(invertLowestOne(height-1)) + 1
+	}
+	return invertLowestOne(height)
+}
+
+func invertLowestOne(n int32) int32 {
+	return n & (n - 1)
+}
@@ -0,0 +1,325 @@
+package chain
+
+import (
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const easyBits = 0x207fffff
+
+var testGenesis = btc.Header{Version: 1, Timestamp: 1296688602, Bits: easyBits, Nonce: 2}
+
+// buildHeaders returns count headers extending parent, each with a valid proof of work. The nonce allows creating
+// distinct forks from the same parent.
+func buildHeaders(t *testing.T, parent *btc.Header, count int, bits uint32, nonce uint32) []*btc.Header {
+	headers := make([]*btc.Header, count)
+
+	for i := range headers {
+		prevHash, err := parent.Hash()
+		require.NoError(t, err)
+
+		headers[i] = &btc.Header{
+			Version:   1,
+			PrevBlock: prevHash,
+			Timestamp: parent.Timestamp + 600,
+			Bits:      bits,
+			Nonce:     nonce,
+		}
+
+		for headers[i].CheckProofOfWork(btc.RegTestConsensus.PowLimit) != nil {
+			headers[i].Nonce++
+		}
+		parent = headers[i]
+	}
+
+	return headers
+}
+
+func addAll(t *testing.T, index *BlockIndex, headers []*btc.Header) {
+	for _, h := range headers {
+		_, err := index.AddHeader(h)
+		require.NoError(t, err)
+	}
+}
+
+func TestBlockIndex(t *testing.T) {
+	t.Run("links headers to their parent", func(t *testing.T) {
+		index, err := NewBlockIndex(&testGenesis, &btc.RegTestConsensus)
+		require.NoError(t, err)
+
+		headers := buildHeaders(t, &testGenesis, 10, easyBits, 0)
+		addAll(t, index, headers)
+
+		best := index.Best()
+		assert.Equal(t, int32(10), best.Height)
+		assert.Equal(t, index.Genesis(), best.Ancestor(0))
+		assert.Equal(t, 11, index.Size())
+		assert.Equal(t, int64(22), best.Work.Int64())
+	})
+
+	t.Run("keeps orphans until their parent arrives", func(t *testing.T) {
+		index, err := NewBlockIndex(&testGenesis, &btc.RegTestConsensus)
+		require.NoError(t, err)
+
+		headers := buildHeaders(t, &testGenesis, 5, easyBits, 0)
+
+		for _, h := range headers[1:] {
+			linked, err := index.AddHeader(h)
+			require.NoError(t, err)
+			assert.Empty(t, linked)
+
+			hash, err := h.Hash()
+			require.NoError(t, err)
+			assert.True(t, index.IsOrphan(hash))
+		}
+		assert.Equal(t, int32(0), index.Best().Height)
+
+		linked, err := index.AddHeader(headers[0])
+		require.NoError(t, err)
+		assert.Len(t, linked, 5)
+		assert.Equal(t, int32(5), index.Best().Height)
+
+		for i, node := range linked {
+			assert.Equal(t, int32(i+1), node.Height)
+			assert.False(t, index.IsOrphan(node.Hash))
+		}
+	})
+
+	t.Run("ignores known headers", func(t *testing.T) {
+		index, err := NewBlockIndex(&testGenesis, &btc.RegTestConsensus)
+		require.NoError(t, err)
+
+		headers := buildHeaders(t, &testGenesis, 1, easyBits, 0)
+		addAll(t, index, headers)
+
+		linked, err := index.AddHeader(headers[0])
+		assert.NoError(t, err)
+		assert.Empty(t, linked)
+	})
+
+	t.Run("best tip has the most work", func(t *testing.T) {
+		index, err := NewBlockIndex(&testGenesis, &btc.RegTestConsensus)
+		require.NoError(t, err)
+
+		short := buildHeaders(t, &testGenesis, 10, easyBits, 0)
+		addAll(t, index, short)
+
+		long := buildHeaders(t, &testGenesis, 12, easyBits, 1000)
+		addAll(t, index, long[:10])
+
+		shortTip := mustHash(t, short[9])
+		assert.Equal(t, shortTip, index.Best().Hash, "first seen tip wins a tie")
+
+		addAll(t, index, long[10:])
+		assert.Equal(t, mustHash(t, long[11]), index.Best().Hash)
+
+		shortTipNode, ok := index.Lookup(shortTip)
+		require.True(t, ok)
+		assert.Equal(t, index.Genesis(), FindFork(shortTipNode, index.Best()))
+	})
+
+	t.Run("invalid blocks don't become the best tip", func(t *testing.T) {
+		index, err := NewBlockIndex(&testGenesis, &btc.RegTestConsensus)
+		require.NoError(t, err)
+
+		short := buildHeaders(t, &testGenesis, 10, easyBits, 0)
+		addAll(t, index, short)
+
+		long := buildHeaders(t, &testGenesis, 12, easyBits, 1000)
+		addAll(t, index, long)
+
+		invalid, ok := index.Lookup(mustHash(t, long[5]))
+		require.True(t, ok)
+		index.Invalidate(invalid)
+
+		assert.Equal(t, mustHash(t, short[9]), index.Best().Hash)
+		assert.True(t, invalid.IsInvalid())
+		assert.False(t, invalid.Parent.IsInvalid())
+
+		tip, ok := index.Lookup(mustHash(t, long[11]))
+		require.True(t, ok)
+		assert.True(t, tip.IsInvalid(), "descendants are invalid")
+
+		// headers extending an invalid block are invalid as well
+		extension := buildHeaders(t, long[11], 5, easyBits, 0)
+		addAll(t, index, extension)
+		assert.Equal(t, mustHash(t, short[9]), index.Best().Hash)
+
+		index.Invalidate(index.Genesis())
+		assert.False(t, index.Genesis().IsInvalid())
+	})
+
+	t.Run("rejects headers with unexpected difficulty", func(t *testing.T) {
+		index, err := NewBlockIndex(&testGenesis, &btc.RegTestConsensus)
+		require.NoError(t, err)
+
+		headers := buildHeaders(t, &testGenesis, 1, 0x1f7fffff, 0)
+
+		linked, err := index.AddHeader(headers[0])
+		assert.ErrorIs(t, err, btc.ErrUnexpectedDifficulty)
+		assert.Empty(t, linked)
+	})
+
+	t.Run("drops orphans violating timestamp rules once linked", func(t *testing.T) {
+		index, err := NewBlockIndex(&testGenesis, &btc.RegTestConsensus)
+		require.NoError(t, err)
+
+		headers := buildHeaders(t, &testGenesis, 1, easyBits, 0)
+		late := buildHeaders(t, headers[0], 1, easyBits, 0)
+		late[0].Timestamp = testGenesis.Timestamp
+		for late[0].CheckProofOfWork(btc.RegTestConsensus.PowLimit) != nil {
+			late[0].Nonce++
+		}
+
+		addAll(t, index, late)
+		linked, err := index.AddHeader(headers[0])
+		assert.NoError(t, err)
+		assert.Len(t, linked, 1)
+		assert.False(t, index.IsOrphan(mustHash(t, late[0])))
+		assert.False(t, index.Contains(mustHash(t, late[0])))
+	})
+
+	t.Run("drops the descendants of orphans failing to link", func(t *testing.T) {
+		index, err := NewBlockIndex(&testGenesis, &btc.RegTestConsensus)
+		require.NoError(t, err)
+
+		headers := buildHeaders(t, &testGenesis, 1, easyBits, 0)
+		late := buildHeaders(t, headers[0], 1, easyBits, 0)
+		late[0].Timestamp = testGenesis.Timestamp
+		for late[0].CheckProofOfWork(btc.RegTestConsensus.PowLimit) != nil {
+			late[0].Nonce++
+		}
+		descendants := buildHeaders(t, late[0], 2, easyBits, 0)
+
+		addAll(t, index, append(late, descendants...))
+		linked, err := index.AddHeader(headers[0])
+		assert.NoError(t, err)
+		assert.Len(t, linked, 1)
+
+		for _, h := range descendants {
+			assert.False(t, index.IsOrphan(mustHash(t, h)))
+		}
+		assert.Empty(t, index.orphans)
+		assert.Empty(t, index.orphanHashes)
+	})
+
+	t.Run("rejects headers without valid proof of work", func(t *testing.T) {
+		index, err := NewBlockIndex(&testGenesis, &btc.RegTestConsensus)
+		require.NoError(t, err)
+
+		headers := buildHeaders(t, &testGenesis, 1, easyBits, 0)
+		for headers[0].CheckProofOfWork(btc.RegTestConsensus.PowLimit) == nil {
+			headers[0].Nonce++
+		}
+
+		linked, err := index.AddHeader(headers[0])
+		assert.ErrorIs(t, err, btc.ErrHighHash)
+		assert.Empty(t, linked)
+		assert.Equal(t, 1, index.Size())
+	})
+
+	t.Run("rejects genesis with parent", func(t *testing.T) {
+		genesis := testGenesis
+		genesis.PrevBlock = btc.BlockHash{1}
+
+		_, err := NewBlockIndex(&genesis, &btc.RegTestConsensus)
+		assert.ErrorIs(t, err, ErrInvalidGenesis)
+	})
+}
+
+func TestCheckpoints(t *testing.T) {
+	headers := buildHeaders(t, &testGenesis, 6, easyBits, 0)
+	fork := buildHeaders(t, headers[1], 4, easyBits, 1000)
+	checkpoints := []btc.Checkpoint{{Height: 3, Hash: mustHash(t, headers[2])}}
+
+	t.Run("rejects headers contradicting a checkpoint", func(t *testing.T) {
+		index, err := NewBlockIndex(&testGenesis, &btc.RegTestConsensus)
+		require.NoError(t, err)
+		index.SetCheckpoints(checkpoints)
+
+		addAll(t, index, headers[:2])
+		linked, err := index.AddHeader(fork[0])
+		assert.ErrorIs(t, err, ErrCheckpointMismatch)
+		assert.Empty(t, linked)
+
+		addAll(t, index, headers)
+		assert.Equal(t, mustHash(t, headers[5]), index.Best().Hash)
+	})
+
+	t.Run("rejects forks before the last checkpoint", func(t *testing.T) {
+		index, err := NewBlockIndex(&testGenesis, &btc.RegTestConsensus)
+		require.NoError(t, err)
+		index.SetCheckpoints(checkpoints)
+		addAll(t, index, headers[:3])
+
+		_, err = index.AddHeader(buildHeaders(t, headers[0], 1, easyBits, 1000)[0])
+		assert.ErrorIs(t, err, ErrForkBeforeCheckpoint)
+
+		forkAfter := buildHeaders(t, headers[2], 1, easyBits, 1000)
+		_, err = index.AddHeader(forkAfter[0])
+		assert.NoError(t, err, "forks after the last checkpoint are fine")
+	})
+
+	t.Run("drops orphans contradicting a checkpoint once linked", func(t *testing.T) {
+		index, err := NewBlockIndex(&testGenesis, &btc.RegTestConsensus)
+		require.NoError(t, err)
+		index.SetCheckpoints(checkpoints)
+
+		addAll(t, index, fork)
+		linked, err := index.AddHeader(headers[0])
+		require.NoError(t, err)
+		assert.Len(t, linked, 1)
+		addAll(t, index, headers[1:2])
+		assert.False(t, index.Contains(mustHash(t, fork[0])))
+		assert.Equal(t, int32(2), index.Best().Height)
+	})
+}
+
+func TestAncestor(t *testing.T) {
+	index, err := NewBlockIndex(&testGenesis, &btc.RegTestConsensus)
+	require.NoError(t, err)
+
+	headers := buildHeaders(t, &testGenesis, 1000, easyBits, 0)
+	addAll(t, index, headers)
+
+	tip := index.Best()
+	for node := tip; node != nil; node = node.Parent {
+		assert.Equal(t, node, tip.Ancestor(node.Height))
+	}
+
+	assert.Nil(t, tip.Ancestor(-1))
+	assert.Nil(t, tip.Ancestor(tip.Height+1))
+}
+
+func TestLocator(t *testing.T) {
+	index, err := NewBlockIndex(&testGenesis, &btc.RegTestConsensus)
+	require.NoError(t, err)
+
+	t.Run("only genesis", func(t *testing.T) {
+		assert.Equal(t, []btc.BlockHash{index.Genesis().Hash}, index.Locator(index.Best()))
+	})
+
+	headers := buildHeaders(t, &testGenesis, 99, easyBits, 0)
+	addAll(t, index, headers)
+
+	t.Run("dense at the tip and sparse towards genesis", func(t *testing.T) {
+		locator := index.Locator(index.Best())
+		heights := make([]int32, len(locator))
+		for i, hash := range locator {
+			node, ok := index.Lookup(hash)
+			require.True(t, ok)
+			heights[i] = node.Height
+		}
+
+		expected := []int32{99, 98, 97, 96, 95, 94, 93, 92, 91, 90, 88, 84, 76, 60, 28, 0}
+		assert.Equal(t, expected, heights)
+	})
+}
+
+func mustHash(t *testing.T, header *btc.Header) btc.BlockHash {
+	hash, err := header.Hash()
+	require.NoError(t, err)
+	return hash
+}
@@ -0,0 +1,103 @@
+// Package mining generates blocks with a CPU miner. It is meant for regtest, where the minimum difficulty makes almost
+// every nonce a solution.
+package mining
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/chain"
+	"github.com/haikoschol/btc-node-challenge/internal/vartypes"
+	"math"
+	"time"
+)
+
+// blockVersion signals no soft fork deployments with the version bits of BIP9.
+const blockVersion = 0x20000000
+
+var ErrNoSolution = errors.New("no nonce satisfies the target")
+
+// NewBlock returns a block extending parent that only contains a coinbase paying the block subsidy to pkScript. The
+// header has the difficulty required by params and the current time as timestamp, or a later one if the median time
+// past demands it. The nonce still has to be found with Solve.
+func NewBlock(parent *chain.BlockNode, pkScript []byte, params *btc.ConsensusParams, now time.Time) (*btc.Block, error) {
+	height := parent.Height + 1
+	headers := parent.HeaderChain()
+	timestamp := max(uint32(now.Unix()), btc.MedianTimePast(headers)+1)
+
+	block := &btc.Block{
+		Header: btc.Header{
+			Version:   blockVersion,
+			PrevBlock: parent.Hash,
+			Timestamp: timestamp,
+			Bits:      btc.NextRequiredBits(headers, timestamp, params),
+			TxnCount:  vartypes.NewVarInt(1),
+		},
+		Transactions: []btc.Transaction{btc.NewCoinbase(height, btc.BlockSubsidy(height, params), pkScript, 0)},
+	}
+
+	if err := updateMerkleRoot(block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// Solve searches for a nonce that makes the hash of the block header meet the target in its Bits field. Once all
+// nonces have been tried, it increments the extra nonce of the coinbase, which must have been built by
+// btc.NewCoinbase, and starts over.
+func Solve(block *btc.Block) error {
+	target := btc.CompactToBig(block.Header.Bits)
+
+	for extraNonce := uint32(0); ; extraNonce++ {
+		if extraNonce > 0 {
+			if err := setExtraNonce(block, extraNonce); err != nil {
+				return err
+			}
+		}
+
+		for nonce := uint32(0); ; nonce++ {
+			block.Header.Nonce = nonce
+			hash, err := block.Header.Hash()
+			if err != nil {
+				return err
+			}
+
+			if btc.HashToBig(hash).Cmp(target) <= 0 {
+				return nil
+			}
+
+			if nonce == math.MaxUint32 {
+				break
+			}
+		}
+
+		if extraNonce == math.MaxUint32 {
+			return ErrNoSolution
+		}
+	}
+}
+
+// setExtraNonce replaces the extra nonce at the end of the signature script of the coinbase and updates the merkle
+// root accordingly.
+func setExtraNonce(block *btc.Block, extraNonce uint32) error {
+	coinbase := &block.Transactions[0]
+	sigScript := append([]byte{}, coinbase.TxIn[0].SignatureScript...)
+	binary.LittleEndian.PutUint32(sigScript[len(sigScript)-btc.ExtraNonceSize:], extraNonce)
+	coinbase.TxIn[0].SignatureScript = sigScript
+
+	return updateMerkleRoot(block)
+}
+
+func updateMerkleRoot(block *btc.Block) error {
+	hashes := make([][32]byte, len(block.Transactions))
+	for i := range block.Transactions {
+		txid, err := block.Transactions[i].TxID()
+		if err != nil {
+			return err
+		}
+		hashes[i] = txid
+	}
+
+	block.Header.MerkleRoot, _ = btc.MerkleRoot(hashes)
+	return nil
+}
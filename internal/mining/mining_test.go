@@ -0,0 +1,62 @@
+package mining
+
+import (
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/chain"
+	"github.com/haikoschol/btc-node-challenge/internal/script"
+	"github.com/haikoschol/btc-node-challenge/internal/utxo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMining(t *testing.T) {
+	params := &btc.RegTestConsensus
+	genesis := &btc.RegTestGenesisBlock
+	pkScript := []byte{0x51}
+
+	index, err := chain.NewBlockIndex(&genesis.Header, params)
+	require.NoError(t, err)
+
+	utxos := utxo.NewSet()
+	require.NoError(t, utxos.ConnectBlock(genesis))
+
+	now := time.Unix(int64(genesis.Header.Timestamp), 0)
+	for height := int32(1); height <= 3; height++ {
+		parent := index.Best()
+		block, err := NewBlock(parent, pkScript, params, now)
+		require.NoError(t, err)
+		require.NoError(t, Solve(block))
+
+		linked, err := index.AddHeader(&block.Header)
+		require.NoError(t, err)
+		require.Len(t, linked, 1)
+		assert.Equal(t, height, linked[0].Height)
+
+		flags := script.BlockVerifyFlags(height, params)
+		verify := func(tx *btc.Transaction, spentOutputs []btc.TxOutput) error {
+			return script.VerifyTransaction(tx, spentOutputs, flags)
+		}
+		require.NoError(t, btc.ValidateBlock(block, parent.HeaderChain(), utxos, params, verify))
+		require.NoError(t, utxos.ConnectBlock(block))
+
+		coinbase := block.Transactions[0]
+		assert.Equal(t, btc.BlockSubsidy(height, params), coinbase.TxOut[0].Value)
+		assert.Equal(t, pkScript, coinbase.TxOut[0].ScriptPubKey)
+	}
+
+	// the clock lagging behind the chain doesn't lead to timestamps before the median time past
+	assert.Greater(t, index.Best().Header.Timestamp, genesis.Header.Timestamp)
+
+	t.Run("extra nonce", func(t *testing.T) {
+		block, err := NewBlock(index.Best(), pkScript, params, now)
+		require.NoError(t, err)
+		root := block.Header.MerkleRoot
+
+		require.NoError(t, setExtraNonce(block, 1))
+		assert.NotEqual(t, root, block.Header.MerkleRoot)
+		assert.NoError(t, block.CheckMerkleRoot())
+		assert.NoError(t, btc.CheckBlock(block))
+	})
+}
@@ -0,0 +1,285 @@
+package network
+
+import (
+	"errors"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/chain"
+	"github.com/haikoschol/btc-node-challenge/internal/script"
+	"log"
+	"slices"
+)
+
+// reorgBufferSize is the number of reorg events that are kept until they are received. Further events are dropped.
+const reorgBufferSize = 16
+
+// ReorgEvent describes a switch of the UTXO set from one branch of the block tree to another one with more work.
+// Consumers that derive data from the connected blocks use it to roll back the data of the disconnected blocks.
+type ReorgEvent struct {
+	// Fork is the last block the old and the new branch have in common.
+	Fork       btc.BlockHash
+	ForkHeight int32
+	// Disconnected are the blocks of the old branch, starting with its tip.
+	Disconnected []*btc.Block
+	// Connected are the blocks of the new branch, starting with the child of Fork.
+	Connected []*btc.Block
+}
+
+// Reorgs returns a channel that receives an event whenever the UTXO set switches to a different branch. Events are
+// dropped if the channel isn't drained.
+func (p *NodePool) Reorgs() <-chan ReorgEvent {
+	return p.reorgCh
+}
+
+// connectBlocks moves the UTXO set to the tip of the best header chain, as far as its blocks have been downloaded. If
+// the best chain branches off below the tip of the UTXO set, the blocks back to the fork are disconnected first, once
+// the new branch has more work than the current one. Blocks that violate a consensus rule are marked as invalid in the
+// block index, which makes the chain with the next most work the best chain.
+func (p *NodePool) connectBlocks() {
+	for {
+		tip, ok := p.utxoTip()
+		if !ok {
+			return
+		}
+
+		target := p.connectTarget(tip)
+		if target == nil || (tip != nil && target.Work.Cmp(tip.Work) <= 0) {
+			return
+		}
+
+		if !p.connectChain(tip, target) {
+			return
+		}
+	}
+}
+
+// utxoTip returns the node for the tip of the UTXO set, which is nil if the set is empty.
+func (p *NodePool) utxoTip() (*chain.BlockNode, bool) {
+	hash, height := p.utxos.Tip()
+	if height < 0 {
+		return nil, true
+	}
+
+	node, ok := p.index.Lookup(hash)
+	if !ok {
+		log.Printf("tip %s of the UTXO set is not in the block index yet", hash)
+	}
+	return node, ok
+}
+
+// connectTarget returns the most recent block of the best header chain that can be connected, because it and all of
+// its ancestors above the fork with the UTXO tip have been downloaded.
+func (p *NodePool) connectTarget(tip *chain.BlockNode) *chain.BlockNode {
+	best := p.index.Best()
+
+	var target *chain.BlockNode
+	height := int32(0)
+	if tip != nil {
+		target = chain.FindFork(tip, best)
+		height = target.Height + 1
+	}
+
+	for ; height <= best.Height; height++ {
+		node := best.Ancestor(height)
+		if p.blocksByHash[node.Hash] == nil {
+			break
+		}
+		target = node
+	}
+	return target
+}
+
+// connectChain moves the UTXO set from tip to target, which must have more work than tip. If connecting a block of a
+// new branch fails and leaves the UTXO set without more work than before, the previous branch is restored. It returns
+// whether a block was marked as invalid, which changes the best chain.
+func (p *NodePool) connectChain(tip, target *chain.BlockNode) (invalidated bool) {
+	fork := tip
+	var disconnected []*btc.Block
+
+	if tip != nil {
+		fork = chain.FindFork(tip, target)
+		if fork != tip {
+			var ok bool
+			if disconnected, ok = p.disconnectTo(tip, fork); !ok {
+				return false
+			}
+		}
+	}
+
+	startHeight := int32(0)
+	if fork != nil {
+		startHeight = fork.Height + 1
+	}
+
+	newTip := fork
+	var connected []*btc.Block
+
+	for height := startHeight; height <= target.Height; height++ {
+		node := target.Ancestor(height)
+		block := p.blocksByHash[node.Hash]
+
+		if err := p.connectBlock(node, block); err != nil {
+			log.Printf("failed to connect block %s at height %d: %v", node.Hash, node.Height, err)
+			invalidated = p.rejectBlock(node, block, err)
+			break
+		}
+
+		newTip = node
+		connected = append(connected, block)
+	}
+
+	if len(disconnected) > 0 {
+		if newTip.Work.Cmp(tip.Work) <= 0 {
+			p.restoreChain(disconnected, connected)
+			return invalidated
+		}
+
+		p.publishReorg(ReorgEvent{
+			Fork:         fork.Hash,
+			ForkHeight:   fork.Height,
+			Disconnected: disconnected,
+			Connected:    connected,
+		})
+	}
+
+	p.updateTxPool(disconnected, connected)
+
+	if len(connected) > 0 {
+		_, height := p.utxos.Tip()
+		log.Printf("connected %d block(s). UTXO set height: %d, unspent outputs: %d", len(connected), height, p.utxos.Size())
+	}
+	return invalidated
+}
+
+// updateTxPool returns the transactions of disconnected blocks to the transaction pool and removes the transactions of
+// connected blocks from it.
+func (p *NodePool) updateTxPool(disconnected, connected []*btc.Block) {
+	for _, block := range disconnected {
+		if err := p.txPool.AddBlock(block); err != nil {
+			log.Printf("failed to return transactions of a disconnected block to the pool: %v", err)
+		}
+	}
+
+	for _, block := range connected {
+		if err := p.txPool.RemoveBlock(block); err != nil {
+			log.Printf("failed to remove transactions of a connected block from the pool: %v", err)
+		}
+	}
+}
+
+// connectBlock validates the block of node and connects it to the UTXO set. The genesis block isn't validated.
+func (p *NodePool) connectBlock(node *chain.BlockNode, block *btc.Block) error {
+	if node.Height > 0 {
+		flags := script.BlockVerifyFlags(node.Height, p.params.Consensus)
+		verify := func(tx *btc.Transaction, spentOutputs []btc.TxOutput) error {
+			return script.VerifyTransaction(tx, spentOutputs, flags)
+		}
+
+		if err := btc.ValidateBlock(block, node.Parent.HeaderChain(), p.utxos, p.params.Consensus, verify); err != nil {
+			return err
+		}
+	}
+	return p.utxos.ConnectBlock(block)
+}
+
+// disconnectTo disconnects the blocks from tip back to fork from the UTXO set and returns them, tip first. Nothing is
+// disconnected if the set doesn't have enough undo data to reach fork.
+func (p *NodePool) disconnectTo(tip, fork *chain.BlockNode) ([]*btc.Block, bool) {
+	depth := int(tip.Height - fork.Height)
+	if depth > p.utxos.UndoDepth() {
+		log.Printf("can't switch to a branch forking off %d blocks below the tip, undo data is only kept for %d", depth, p.utxos.UndoDepth())
+		return nil, false
+	}
+
+	blocks := make([]*btc.Block, 0, depth)
+	for node := tip; node != fork; node = node.Parent {
+		block := p.blocksByHash[node.Hash]
+		if block == nil {
+			log.Printf("can't disconnect block %s at height %d, because it isn't stored", node.Hash, node.Height)
+			return nil, false
+		}
+		blocks = append(blocks, block)
+	}
+
+	for i, block := range blocks {
+		if err := p.utxos.DisconnectBlock(block); err != nil {
+			log.Printf("failed to disconnect block at height %d: %v", tip.Height-int32(i), err)
+			p.restoreChain(blocks[:i], nil)
+			return nil, false
+		}
+	}
+
+	log.Printf("disconnected %d block(s) back to %s at height %d", len(blocks), fork.Hash, fork.Height)
+	return blocks, true
+}
+
+// restoreChain reverts a failed switch to another branch by disconnecting the blocks connected from it and
+// reconnecting the disconnected blocks of the previous branch, which have already been validated.
+func (p *NodePool) restoreChain(disconnected, connected []*btc.Block) {
+	for i := len(connected) - 1; i >= 0; i-- {
+		if err := p.utxos.DisconnectBlock(connected[i]); err != nil {
+			log.Printf("failed to restore the previous chain: %v", err)
+			return
+		}
+	}
+
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		if err := p.utxos.ConnectBlock(disconnected[i]); err != nil {
+			log.Printf("failed to restore the previous chain: %v", err)
+			return
+		}
+	}
+
+	_, height := p.utxos.Tip()
+	log.Printf("restored the previous chain at height %d", height)
+}
+
+// rejectBlock handles a block that failed to connect. If it violates a consensus rule, it is dropped and marked as
+// invalid in the block index, along with its descendants. If only its witness data is invalid, the block is dropped so
+// that another copy can be downloaded. It returns whether the block was marked as invalid.
+func (p *NodePool) rejectBlock(node *chain.BlockNode, block *btc.Block, err error) bool {
+	var ruleErr *btc.RuleError
+	if !errors.As(err, &ruleErr) {
+		return false
+	}
+
+	p.dropBlock(block, node.Hash)
+	if isWitnessMutation(err) {
+		return false
+	}
+
+	prevBest := p.index.Best()
+	p.index.Invalidate(node)
+
+	// the blocks of the next best chain might have to be downloaded
+	fork := chain.FindFork(prevBest, p.index.Best())
+	p.nextBlockHeight = min(p.nextBlockHeight, fork.Height+1)
+	return true
+}
+
+// isWitnessMutation returns whether a block was rejected because of its witness data, which isn't covered by the
+// proof of work. A peer can send a block with modified witness data, so this doesn't make the block itself invalid.
+func isWitnessMutation(err error) bool {
+	return errors.Is(err, btc.ErrBadWitnessNonce) ||
+		errors.Is(err, btc.ErrBadWitnessCommitment) ||
+		errors.Is(err, btc.ErrUnexpectedWitness)
+}
+
+// dropBlock removes an invalid block, so that it isn't connected or written to the state file.
+func (p *NodePool) dropBlock(block *btc.Block, hash btc.BlockHash) {
+	delete(p.blocksByHash, hash)
+	p.blocks = slices.DeleteFunc(p.blocks, func(b *btc.Block) bool { return b == block })
+}
+
+func (p *NodePool) publishReorg(event ReorgEvent) {
+	log.Printf(
+		"reorganized chain at height %d: disconnected %d block(s), connected %d block(s)",
+		event.ForkHeight,
+		len(event.Disconnected),
+		len(event.Connected),
+	)
+
+	select {
+	case p.reorgCh <- event:
+	default:
+	}
+}
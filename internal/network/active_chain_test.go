@@ -0,0 +1,257 @@
+package network
+
+import (
+	"encoding/hex"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/chain"
+	"github.com/haikoschol/btc-node-challenge/internal/utxo"
+	"github.com/haikoschol/btc-node-challenge/internal/vartypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// testParams are the regtest parameters with the BIP34 activation height and subsidy halving interval of mainnet, so
+// that the coinbases of test blocks don't need to commit to their height and can pay the same value on long chains.
+var testParams = func() *btc.ChainParams {
+	consensus := btc.RegTestConsensus
+	consensus.BIP34Height = btc.MainNetConsensus.BIP34Height
+	consensus.SubsidyHalvingInterval = btc.MainNetConsensus.SubsidyHalvingInterval
+
+	params := btc.RegTestParams
+	params.Consensus = &consensus
+	return &params
+}()
+
+// mineBlock returns a block with a coinbase paying value, which extends parent if it isn't nil. The tag makes the
+// coinbase unique, so that different branches don't share transactions.
+func mineBlock(t *testing.T, parent *btc.Block, tag byte, value int64) *btc.Block {
+	t.Helper()
+
+	coinbase := btc.Transaction{
+		Version: 1,
+		TxIn:    []btc.TxInput{{PreviousOutput: btc.OutPoint{Index: 0xffffffff}, SignatureScript: []byte{tag, 0x00}}},
+		TxOut:   []btc.TxOutput{{Value: value, ScriptPubKey: []byte{0x51}}},
+	}
+	txid, err := coinbase.TxID()
+	require.NoError(t, err)
+
+	block := &btc.Block{
+		Header: btc.Header{
+			Version:    1,
+			MerkleRoot: txid,
+			Timestamp:  1296688602,
+			Bits:       btc.RegTestConsensus.PowLimitBits,
+			TxnCount:   vartypes.NewVarInt(1),
+		},
+		Transactions: []btc.Transaction{coinbase},
+	}
+
+	if parent != nil {
+		block.Header.PrevBlock, err = parent.Hash()
+		require.NoError(t, err)
+		block.Header.Timestamp = parent.Header.Timestamp + 600
+	}
+
+	for block.Header.CheckProofOfWork(btc.RegTestConsensus.PowLimit) != nil {
+		block.Header.Nonce++
+	}
+	return block
+}
+
+// mineBlockWithTxs returns a block extending parent that contains txs after its coinbase.
+func mineBlockWithTxs(t *testing.T, parent *btc.Block, tag byte, txs ...btc.Transaction) *btc.Block {
+	t.Helper()
+
+	block := mineBlock(t, parent, tag, 50*btc.SatoshisPerBitcoin)
+	block.Transactions = append(block.Transactions, txs...)
+	block.Header.TxnCount = vartypes.NewVarInt(uint64(len(block.Transactions)))
+
+	hashes := make([][32]byte, len(block.Transactions))
+	for i := range block.Transactions {
+		txid, err := block.Transactions[i].TxID()
+		require.NoError(t, err)
+		hashes[i] = txid
+	}
+	block.Header.MerkleRoot, _ = btc.MerkleRoot(hashes)
+
+	for block.Header.CheckProofOfWork(btc.RegTestConsensus.PowLimit) != nil {
+		block.Header.Nonce++
+	}
+	return block
+}
+
+func mineChain(t *testing.T, parent *btc.Block, count int, tag byte) []*btc.Block {
+	t.Helper()
+
+	blocks := make([]*btc.Block, count)
+	for i := range blocks {
+		blocks[i] = mineBlock(t, parent, tag+byte(i), 50*btc.SatoshisPerBitcoin)
+		parent = blocks[i]
+	}
+	return blocks
+}
+
+func newTestPool(t *testing.T, genesis *btc.Block) *NodePool {
+	t.Helper()
+
+	index, err := chain.NewBlockIndex(&genesis.Header, &btc.RegTestConsensus)
+	require.NoError(t, err)
+
+	pool := &NodePool{
+		params:       testParams,
+		blocksByHash: make(map[btc.BlockHash]*btc.Block),
+		utxos:        utxo.NewSet(),
+		reorgCh:      make(chan ReorgEvent, reorgBufferSize),
+		index:        index,
+		downloads:    newDownloadScheduler(),
+		txPool:       NewTxPool(),
+	}
+	addBlocks(t, pool, genesis)
+	return pool
+}
+
+func addBlocks(t *testing.T, pool *NodePool, blocks ...*btc.Block) {
+	t.Helper()
+
+	for _, block := range blocks {
+		_, err := pool.index.AddHeader(&block.Header)
+		require.NoError(t, err)
+
+		hash, err := block.Hash()
+		require.NoError(t, err)
+		pool.blocksByHash[hash] = block
+		pool.blocks = append(pool.blocks, block)
+	}
+	pool.connectBlocks()
+}
+
+func assertUTXOTip(t *testing.T, pool *NodePool, block *btc.Block) {
+	t.Helper()
+
+	hash, err := block.Hash()
+	require.NoError(t, err)
+	tip, _ := pool.utxos.Tip()
+	assert.Equal(t, hash, tip)
+}
+
+func TestConnectBlocks(t *testing.T) {
+	genesis := mineBlock(t, nil, 0, 50*btc.SatoshisPerBitcoin)
+
+	t.Run("reorg to the branch with more work", func(t *testing.T) {
+		pool := newTestPool(t, genesis)
+		old := mineChain(t, genesis, 2, 10)
+		addBlocks(t, pool, old...)
+		assertUTXOTip(t, pool, old[1])
+
+		branch := mineChain(t, genesis, 3, 20)
+		addBlocks(t, pool, branch[:2]...)
+		assertUTXOTip(t, pool, old[1])
+		assert.Empty(t, pool.Reorgs(), "a branch with the same amount of work doesn't replace the tip")
+
+		addBlocks(t, pool, branch[2])
+		assertUTXOTip(t, pool, branch[2])
+
+		genesisHash, err := genesis.Hash()
+		require.NoError(t, err)
+
+		require.Len(t, pool.Reorgs(), 1)
+		event := <-pool.Reorgs()
+		assert.Equal(t, genesisHash, event.Fork)
+		assert.Equal(t, int32(0), event.ForkHeight)
+		assert.Equal(t, []*btc.Block{old[1], old[0]}, event.Disconnected)
+		assert.Equal(t, branch, event.Connected)
+
+		oldCoinbase, err := old[0].Transactions[0].TxID()
+		require.NoError(t, err)
+		_, ok := pool.utxos.Coin(btc.OutPoint{Hash: oldCoinbase})
+		assert.False(t, ok)
+	})
+
+	t.Run("waits for the blocks of the new branch", func(t *testing.T) {
+		pool := newTestPool(t, genesis)
+		old := mineChain(t, genesis, 2, 10)
+		addBlocks(t, pool, old...)
+
+		branch := mineChain(t, genesis, 3, 20)
+		for _, block := range branch {
+			_, err := pool.index.AddHeader(&block.Header)
+			require.NoError(t, err)
+		}
+		pool.connectBlocks()
+		assertUTXOTip(t, pool, old[1])
+		assert.Empty(t, pool.Reorgs())
+	})
+
+	t.Run("invalid branch restores the previous chain", func(t *testing.T) {
+		pool := newTestPool(t, genesis)
+		old := mineChain(t, genesis, 2, 10)
+		addBlocks(t, pool, old...)
+
+		branch := []*btc.Block{mineBlock(t, genesis, 20, 50*btc.SatoshisPerBitcoin)}
+		branch = append(branch, mineBlock(t, branch[0], 21, 50*btc.SatoshisPerBitcoin+1))
+		branch = append(branch, mineBlock(t, branch[1], 22, 50*btc.SatoshisPerBitcoin))
+		addBlocks(t, pool, branch...)
+
+		assertUTXOTip(t, pool, old[1])
+		assert.Empty(t, pool.Reorgs())
+
+		invalid, err := branch[1].Hash()
+		require.NoError(t, err)
+		assert.Nil(t, pool.blocksByHash[invalid])
+		assert.NotContains(t, pool.blocks, branch[1])
+
+		node, ok := pool.index.Lookup(invalid)
+		require.True(t, ok)
+		assert.True(t, node.IsInvalid())
+
+		oldTip, err := old[1].Hash()
+		require.NoError(t, err)
+		assert.Equal(t, oldTip, pool.index.Best().Hash)
+	})
+	t.Run("invalid signature", func(t *testing.T) {
+		pool := newTestPool(t, genesis)
+		blocks := mineChain(t, genesis, btc.CoinbaseMaturity+1, 1)
+		addBlocks(t, pool, blocks...)
+
+		coinbaseID, err := blocks[0].Transactions[0].TxID()
+		require.NoError(t, err)
+
+		// pays to the public key of the private key 1
+		pubKey, err := hex.DecodeString("0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798")
+		require.NoError(t, err)
+		p2pk := append(append([]byte{byte(len(pubKey))}, pubKey...), 0xac)
+
+		funding := btc.Transaction{
+			Version: 1,
+			TxIn:    []btc.TxInput{{PreviousOutput: btc.OutPoint{Hash: coinbaseID}, SignatureScript: []byte{}}},
+			TxOut:   []btc.TxOutput{{Value: 50 * btc.SatoshisPerBitcoin, ScriptPubKey: p2pk}},
+		}
+		fundingBlock := mineBlockWithTxs(t, blocks[len(blocks)-1], 200, funding)
+		addBlocks(t, pool, fundingBlock)
+		assertUTXOTip(t, pool, fundingBlock)
+
+		fundingID, err := funding.TxID()
+		require.NoError(t, err)
+
+		// a strict DER signature with r = s = 1 and SIGHASH_ALL
+		sig := []byte{0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x01, 0x01}
+		spend := btc.Transaction{
+			Version: 1,
+			TxIn: []btc.TxInput{{
+				PreviousOutput:  btc.OutPoint{Hash: fundingID},
+				SignatureScript: append([]byte{byte(len(sig))}, sig...),
+			}},
+			TxOut: []btc.TxOutput{{Value: 50 * btc.SatoshisPerBitcoin, ScriptPubKey: []byte{0x51}}},
+		}
+		invalid := mineBlockWithTxs(t, fundingBlock, 201, spend)
+		addBlocks(t, pool, invalid)
+		assertUTXOTip(t, pool, fundingBlock)
+
+		hash, err := invalid.Hash()
+		require.NoError(t, err)
+		node, ok := pool.index.Lookup(hash)
+		require.True(t, ok)
+		assert.True(t, node.IsInvalid())
+	})
+}
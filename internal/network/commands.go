@@ -14,6 +14,20 @@ var (
 	InvCmd     = Command{'i', 'n', 'v', 0, 0, 0, 0, 0, 0, 0, 0, 0}
 	GetdataCmd = Command{'g', 'e', 't', 'd', 'a', 't', 'a', 0, 0, 0, 0, 0}
 	BlockCmd   = Command{'b', 'l', 'o', 'c', 'k', 0, 0, 0, 0, 0, 0, 0}
+
+	GetheadersCmd = Command{'g', 'e', 't', 'h', 'e', 'a', 'd', 'e', 'r', 's', 0, 0}
+	HeadersCmd    = Command{'h', 'e', 'a', 'd', 'e', 'r', 's', 0, 0, 0, 0, 0}
+	GetblocksCmd  = Command{'g', 'e', 't', 'b', 'l', 'o', 'c', 'k', 's', 0, 0, 0}
+
+	NotfoundCmd = Command{'n', 'o', 't', 'f', 'o', 'u', 'n', 'd', 0, 0, 0, 0}
+
+	SendcmpctCmd   = Command{'s', 'e', 'n', 'd', 'c', 'm', 'p', 'c', 't', 0, 0, 0}
+	CmpctblockCmd  = Command{'c', 'm', 'p', 'c', 't', 'b', 'l', 'o', 'c', 'k', 0, 0}
+	GetblocktxnCmd = Command{'g', 'e', 't', 'b', 'l', 'o', 'c', 'k', 't', 'x', 'n', 0}
+	BlocktxnCmd    = Command{'b', 'l', 'o', 'c', 'k', 't', 'x', 'n', 0, 0, 0, 0}
+
+	Sendaddrv2Cmd = Command{'s', 'e', 'n', 'd', 'a', 'd', 'd', 'r', 'v', '2', 0, 0}
+	Addrv2Cmd     = Command{'a', 'd', 'd', 'r', 'v', '2', 0, 0, 0, 0, 0, 0}
 )
 
 func (c Command) String() string {
@@ -0,0 +1,621 @@
+package network
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/siphash"
+	"github.com/haikoschol/btc-node-challenge/internal/vartypes"
+	"io"
+	"log"
+	"sync/atomic"
+)
+
+const (
+	// compactBlocksVersion is the version of compact blocks (BIP152) we use. Version 2 computes short ids from witness
+	// transaction ids and transmits transactions including their witness data.
+	compactBlocksVersion = 2
+	// shortIDSize is the number of bytes of a short transaction id.
+	shortIDSize = 6
+	// compactHeaderSize is the size of a header in compact block messages, which omit the transaction count.
+	compactHeaderSize = blockHeaderSize - 1
+	// minTxSize is the size of the smallest possible serialized transaction. It limits the number of transactions a
+	// block can contain.
+	minTxSize = 10
+	// maxBlockTxs is the maximum number of transactions in a block.
+	maxBlockTxs = btc.MaxBlockWeight / (minTxSize * btc.WitnessScaleFactor)
+)
+
+var (
+	ErrInvalidCompactBlock  = errors.New("invalid compact block")
+	ErrInvalidBlockTxn      = errors.New("invalid block transactions")
+	ErrReconstructionFailed = errors.New("block reconstruction failed")
+)
+
+// SendCmpct is the payload of a 'sendcmpct' message. A peer sends it to signal that it supports compact blocks of the
+// given version. If Announce is set, the peer wants new blocks announced as 'cmpctblock' instead of 'inv' or 'headers'.
+type SendCmpct struct {
+	Announce bool
+	Version  uint64
+}
+
+// NewSendCmpctMessage creates a 'sendcmpct' message.
+func NewSendCmpctMessage(announce bool, version uint64) *Message {
+	payload := make(Payload, 9)
+	if announce {
+		payload[0] = 1
+	}
+	binary.LittleEndian.PutUint64(payload[1:], version)
+
+	return &Message{Header: NewHeader(SendcmpctCmd, payload), Payload: payload}
+}
+
+func decodeSendCmpctMessage(data []byte) (SendCmpct, error) {
+	if len(data) != 9 || data[0] > 1 {
+		return SendCmpct{}, fmt.Errorf("invalid sendcmpct message of %d bytes", len(data))
+	}
+
+	return SendCmpct{Announce: data[0] == 1, Version: binary.LittleEndian.Uint64(data[1:])}, nil
+}
+
+// PrefilledTx is a transaction that is sent in full as part of a compact block, because the receiver is unlikely to
+// have it, e.g. the coinbase transaction.
+type PrefilledTx struct {
+	// Index is the position of the transaction in the block.
+	Index int
+	Tx    *btc.Transaction
+}
+
+// CompactBlock is the payload of a 'cmpctblock' message (BIP152). It contains the header of a block and, for most of
+// its transactions, a short id that allows the receiver to look them up in its pool of unconfirmed transactions.
+type CompactBlock struct {
+	Header btc.Header
+	// Nonce is chosen by the sender and goes into the key short ids are computed with.
+	Nonce uint64
+	// ShortIDs are the short ids of the transactions that aren't prefilled, in the order of the block.
+	ShortIDs []uint64
+	// Prefilled are the transactions sent in full, ordered by index.
+	Prefilled []PrefilledTx
+}
+
+// NewCompactBlock creates the compact block for block. Only the coinbase transaction is prefilled.
+func NewCompactBlock(block *btc.Block, nonce uint64) (*CompactBlock, error) {
+	if len(block.Transactions) == 0 {
+		return nil, ErrInvalidCompactBlock
+	}
+
+	key, err := newShortIDKey(&block.Header, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	cmpct := &CompactBlock{
+		Header:    block.Header,
+		Nonce:     nonce,
+		ShortIDs:  make([]uint64, 0, len(block.Transactions)-1),
+		Prefilled: []PrefilledTx{{Index: 0, Tx: &block.Transactions[0]}},
+	}
+	// like in 'headers' messages, the transaction count isn't part of the header
+	cmpct.Header.TxnCount = vartypes.NewVarInt(0)
+
+	for i := 1; i < len(block.Transactions); i++ {
+		wtxid, err := block.Transactions[i].WTxID()
+		if err != nil {
+			return nil, err
+		}
+		cmpct.ShortIDs = append(cmpct.ShortIDs, key.shortID(wtxid))
+	}
+
+	return cmpct, nil
+}
+
+// TxCount returns the number of transactions in the block.
+func (c *CompactBlock) TxCount() int {
+	return len(c.ShortIDs) + len(c.Prefilled)
+}
+
+// Encode serializes the compact block as used in the payload of a 'cmpctblock' message.
+func (c *CompactBlock) Encode() (Payload, error) {
+	header, err := c.Header.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	buf.Write(header[:compactHeaderSize])
+
+	if err := binary.Write(buf, binary.LittleEndian, c.Nonce); err != nil {
+		return nil, err
+	}
+
+	if err := vartypes.WriteAsVarInt(buf, uint64(len(c.ShortIDs))); err != nil {
+		return nil, err
+	}
+
+	for _, id := range c.ShortIDs {
+		var encoded [8]byte
+		binary.LittleEndian.PutUint64(encoded[:], id)
+		buf.Write(encoded[:shortIDSize])
+	}
+
+	if err := vartypes.WriteAsVarInt(buf, uint64(len(c.Prefilled))); err != nil {
+		return nil, err
+	}
+
+	// indexes are encoded as the difference to the previous index minus one
+	prev := -1
+	for _, prefilled := range c.Prefilled {
+		if prefilled.Index <= prev {
+			return nil, ErrInvalidCompactBlock
+		}
+
+		if err := vartypes.WriteAsVarInt(buf, uint64(prefilled.Index-prev-1)); err != nil {
+			return nil, err
+		}
+		prev = prefilled.Index
+
+		encoded, err := prefilled.Tx.Encode()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encoded)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decodeCompactBlock(data []byte) (*CompactBlock, error) {
+	buf := bytes.NewBuffer(data)
+	header, err := decodeCompactHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	cmpct := &CompactBlock{Header: *header}
+	if buf.Len() < 8 {
+		return nil, ErrInvalidCompactBlock
+	}
+	cmpct.Nonce = binary.LittleEndian.Uint64(buf.Next(8))
+
+	idCount, ok := vartypes.DecodeVarInt(buf)
+	if !ok || idCount.Value > maxBlockTxs || idCount.Value*shortIDSize > uint64(buf.Len()) {
+		return nil, ErrInvalidCompactBlock
+	}
+
+	cmpct.ShortIDs = make([]uint64, idCount.Value)
+	for i := range cmpct.ShortIDs {
+		var id [8]byte
+		copy(id[:], buf.Next(shortIDSize))
+		cmpct.ShortIDs[i] = binary.LittleEndian.Uint64(id[:])
+	}
+
+	prefilledCount, ok := vartypes.DecodeVarInt(buf)
+	if !ok || prefilledCount.Value > uint64(buf.Len()) || idCount.Value+prefilledCount.Value > maxBlockTxs {
+		return nil, ErrInvalidCompactBlock
+	}
+
+	total := idCount.Value + prefilledCount.Value
+	cmpct.Prefilled = make([]PrefilledTx, prefilledCount.Value)
+	index := uint64(0)
+
+	for i := range cmpct.Prefilled {
+		diff, ok := vartypes.DecodeVarInt(buf)
+		if !ok || diff.Value >= total {
+			return nil, ErrInvalidCompactBlock
+		}
+
+		index += diff.Value
+		if i > 0 {
+			index++
+		}
+		if index >= total {
+			return nil, ErrInvalidCompactBlock
+		}
+
+		tx, err := btc.DecodeTransaction(buf)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidCompactBlock, err)
+		}
+		cmpct.Prefilled[i] = PrefilledTx{Index: int(index), Tx: tx}
+	}
+
+	if buf.Len() > 0 {
+		return nil, ErrInvalidCompactBlock
+	}
+	return cmpct, nil
+}
+
+func decodeCompactHeader(buf *bytes.Buffer) (*btc.Header, error) {
+	if buf.Len() < compactHeaderSize {
+		return nil, ErrInvalidCompactBlock
+	}
+
+	// btc.DecodeHeader expects a transaction count after the header
+	raw := make([]byte, 0, blockHeaderSize)
+	raw = append(raw, buf.Next(compactHeaderSize)...)
+	raw = append(raw, 0)
+	return btc.DecodeHeader(bytes.NewBuffer(raw))
+}
+
+// BlockTxnRequest is the payload of a 'getblocktxn' message, which requests the transactions of a block that couldn't
+// be found while reconstructing it from a compact block.
+type BlockTxnRequest struct {
+	BlockHash btc.BlockHash
+	// Indexes are the positions of the requested transactions in the block, in ascending order.
+	Indexes []int
+}
+
+// NewGetBlockTxnMessage creates a 'getblocktxn' message.
+func NewGetBlockTxnMessage(req BlockTxnRequest) (*Message, error) {
+	buf := new(bytes.Buffer)
+	buf.Write(req.BlockHash[:])
+
+	if err := vartypes.WriteAsVarInt(buf, uint64(len(req.Indexes))); err != nil {
+		return nil, err
+	}
+
+	prev := -1
+	for _, index := range req.Indexes {
+		if index <= prev {
+			return nil, ErrInvalidBlockTxn
+		}
+
+		if err := vartypes.WriteAsVarInt(buf, uint64(index-prev-1)); err != nil {
+			return nil, err
+		}
+		prev = index
+	}
+
+	payload := Payload(buf.Bytes())
+	return &Message{Header: NewHeader(GetblocktxnCmd, payload), Payload: payload}, nil
+}
+
+func decodeGetBlockTxnMessage(data []byte) (BlockTxnRequest, error) {
+	var req BlockTxnRequest
+	buf := bytes.NewBuffer(data)
+
+	if _, err := io.ReadFull(buf, req.BlockHash[:]); err != nil {
+		return req, ErrInvalidBlockTxn
+	}
+
+	count, ok := vartypes.DecodeVarInt(buf)
+	if !ok || count.Value > maxBlockTxs || count.Value > uint64(buf.Len()) {
+		return req, ErrInvalidBlockTxn
+	}
+
+	req.Indexes = make([]int, count.Value)
+	index := uint64(0)
+
+	for i := range req.Indexes {
+		diff, ok := vartypes.DecodeVarInt(buf)
+		if !ok || diff.Value >= maxBlockTxs {
+			return req, ErrInvalidBlockTxn
+		}
+
+		index += diff.Value
+		if i > 0 {
+			index++
+		}
+		if index >= maxBlockTxs {
+			return req, ErrInvalidBlockTxn
+		}
+		req.Indexes[i] = int(index)
+	}
+
+	if buf.Len() > 0 {
+		return req, ErrInvalidBlockTxn
+	}
+	return req, nil
+}
+
+// BlockTxn is the payload of a 'blocktxn' message, which responds to a 'getblocktxn' message with the requested
+// transactions in the order of the request.
+type BlockTxn struct {
+	BlockHash    btc.BlockHash
+	Transactions []*btc.Transaction
+}
+
+// NewBlockTxnMessage creates a 'blocktxn' message.
+func NewBlockTxnMessage(txn BlockTxn) (*Message, error) {
+	buf := new(bytes.Buffer)
+	buf.Write(txn.BlockHash[:])
+
+	if err := vartypes.WriteAsVarInt(buf, uint64(len(txn.Transactions))); err != nil {
+		return nil, err
+	}
+
+	for _, tx := range txn.Transactions {
+		encoded, err := tx.Encode()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encoded)
+	}
+
+	payload := Payload(buf.Bytes())
+	return &Message{Header: NewHeader(BlocktxnCmd, payload), Payload: payload}, nil
+}
+
+func decodeBlockTxnMessage(data []byte) (BlockTxn, error) {
+	var txn BlockTxn
+	buf := bytes.NewBuffer(data)
+
+	if _, err := io.ReadFull(buf, txn.BlockHash[:]); err != nil {
+		return txn, ErrInvalidBlockTxn
+	}
+
+	count, ok := vartypes.DecodeVarInt(buf)
+	if !ok || count.Value > maxBlockTxs || count.Value > uint64(buf.Len()) {
+		return txn, ErrInvalidBlockTxn
+	}
+
+	txn.Transactions = make([]*btc.Transaction, count.Value)
+	for i := range txn.Transactions {
+		tx, err := btc.DecodeTransaction(buf)
+		if err != nil {
+			return txn, fmt.Errorf("%w: %w", ErrInvalidBlockTxn, err)
+		}
+		txn.Transactions[i] = tx
+	}
+
+	if buf.Len() > 0 {
+		return txn, ErrInvalidBlockTxn
+	}
+	return txn, nil
+}
+
+// shortIDKey is the SipHash key short ids of the transactions in a compact block are computed with. It is derived from
+// the block header and the nonce of the compact block, so that collisions can't be precomputed for all blocks.
+type shortIDKey struct {
+	k0, k1 uint64
+}
+
+func newShortIDKey(header *btc.Header, nonce uint64) (shortIDKey, error) {
+	encoded, err := header.Encode()
+	if err != nil {
+		return shortIDKey{}, err
+	}
+
+	data := make([]byte, compactHeaderSize, compactHeaderSize+8)
+	copy(data, encoded)
+	data = binary.LittleEndian.AppendUint64(data, nonce)
+
+	sum := sha256.Sum256(data)
+	return shortIDKey{
+		k0: binary.LittleEndian.Uint64(sum[0:8]),
+		k1: binary.LittleEndian.Uint64(sum[8:16]),
+	}, nil
+}
+
+// shortID returns the short id of the transaction with the given witness id, which are the lower six bytes of its
+// SipHash.
+func (k shortIDKey) shortID(wtxid btc.TxHash) uint64 {
+	return siphash.Sum64(k.k0, k.k1, wtxid[:]) & 0xffffffffffff
+}
+
+// partialBlock is a block that is being reconstructed from a compact block.
+type partialBlock struct {
+	header btc.Header
+	// txs are the transactions of the block. Transactions that haven't been found in the pool of unconfirmed
+	// transactions are nil.
+	txs []*btc.Transaction
+	// size is the number of bytes received for the block so far.
+	size int
+}
+
+// newPartialBlock fills in the transactions of a compact block from the prefilled transactions and txPool, which may be
+// nil. If several transactions in the pool have the short id of a transaction in the block, the transaction is treated
+// as missing. It returns ErrReconstructionFailed if the compact block contains the same short id more than once.
+func newPartialBlock(cmpct *CompactBlock, txPool *TxPool) (*partialBlock, error) {
+	txs := make([]*btc.Transaction, cmpct.TxCount())
+	for _, prefilled := range cmpct.Prefilled {
+		if prefilled.Index >= len(txs) || txs[prefilled.Index] != nil {
+			return nil, ErrInvalidCompactBlock
+		}
+		txs[prefilled.Index] = prefilled.Tx
+	}
+
+	// map the short ids to the positions of the transactions that weren't prefilled
+	positions := make(map[uint64]int, len(cmpct.ShortIDs))
+	next := 0
+	for i := range txs {
+		if txs[i] != nil {
+			continue
+		}
+
+		id := cmpct.ShortIDs[next]
+		next++
+		if _, ok := positions[id]; ok {
+			return nil, fmt.Errorf("%w: duplicate short id %x", ErrReconstructionFailed, id)
+		}
+		positions[id] = i
+	}
+
+	if txPool != nil && len(positions) > 0 {
+		key, err := newShortIDKey(&cmpct.Header, cmpct.Nonce)
+		if err != nil {
+			return nil, err
+		}
+
+		collisions := make(map[int]bool)
+		txPool.each(func(wtxid btc.TxHash, tx *btc.Transaction) {
+			i, ok := positions[key.shortID(wtxid)]
+			if !ok {
+				return
+			}
+
+			if txs[i] != nil {
+				collisions[i] = true
+			}
+			txs[i] = tx
+		})
+
+		for i := range collisions {
+			txs[i] = nil
+		}
+	}
+
+	return &partialBlock{header: cmpct.Header, txs: txs}, nil
+}
+
+// missing returns the positions of the transactions that haven't been filled in yet.
+func (b *partialBlock) missing() []int {
+	var missing []int
+	for i, tx := range b.txs {
+		if tx == nil {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// fill completes the block with the missing transactions, which must be given in the order of the block. It returns
+// ErrReconstructionFailed if the transactions don't match the merkle root of the header, which happens if a
+// transaction from the pool has the short id of a different transaction in the block.
+func (b *partialBlock) fill(missing []*btc.Transaction) (*btc.Block, error) {
+	block := &btc.Block{Header: b.header, Transactions: make([]btc.Transaction, len(b.txs))}
+	block.Header.TxnCount = vartypes.NewVarInt(uint64(len(b.txs)))
+
+	next := 0
+	for i, tx := range b.txs {
+		if tx == nil {
+			if next == len(missing) {
+				return nil, fmt.Errorf("%w: got %d of the missing transactions", ErrInvalidBlockTxn, len(missing))
+			}
+			tx = missing[next]
+			next++
+		}
+		block.Transactions[i] = *tx
+	}
+
+	if next != len(missing) {
+		return nil, fmt.Errorf("%w: got %d transactions, expected %d", ErrInvalidBlockTxn, len(missing), next)
+	}
+
+	if err := block.CheckMerkleRoot(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReconstructionFailed, err)
+	}
+	return block, nil
+}
+
+// SetTxPool sets the pool of unconfirmed transactions compact blocks received from the host are reconstructed from.
+// Without a pool, all transactions of a compact block that weren't prefilled are requested from the host.
+func (n *Node) SetTxPool(txPool *TxPool) {
+	n.txPool = txPool
+}
+
+// supportsCompactBlocks returns whether the host announced support for the version of compact blocks we use.
+func (n *Node) supportsCompactBlocks() bool {
+	return atomic.LoadInt32(&n.compactBlocks) == 1
+}
+
+func (n *Node) handleSendCmpctMessage(msg *Message) {
+	sendCmpct, err := decodeSendCmpctMessage(msg.Payload)
+	if err != nil {
+		log.Println(n.peer(), err)
+		return
+	}
+
+	if sendCmpct.Version == compactBlocksVersion {
+		atomic.StoreInt32(&n.compactBlocks, 1)
+	}
+}
+
+// handleCmpctBlockMessage reconstructs a requested block from a compact block. Transactions that aren't in the pool of
+// unconfirmed transactions are requested from the host with 'getblocktxn'. If the compact block can't be reconstructed,
+// the full block is requested instead.
+func (n *Node) handleCmpctBlockMessage(msg *Message) {
+	cmpct, err := decodeCompactBlock(msg.Payload)
+	if err != nil {
+		n.misbehaving(maxMisbehavior, fmt.Sprintf("sent an invalid compact block: %v", err))
+		return
+	}
+
+	hash, err := cmpct.Header.Hash()
+	if err != nil {
+		log.Printf("received unhashable compact block from %s: %v", n.peer(), err)
+		return
+	}
+
+	if !n.takeRequest(hash) {
+		n.misbehaving(unsolicitedBlockPenalty, fmt.Sprintf("sent compact block %s without being asked", hash))
+		return
+	}
+
+	partial, err := newPartialBlock(cmpct, n.txPool)
+	if err != nil {
+		log.Printf("can't reconstruct compact block %s from %s: %v", hash, n.peer(), err)
+		n.requestFullBlock(hash)
+		return
+	}
+	partial.size = len(msg.Payload)
+
+	missing := partial.missing()
+	if len(missing) == 0 {
+		n.completeBlock(hash, partial, nil)
+		return
+	}
+
+	getBlockTxn, err := NewGetBlockTxnMessage(BlockTxnRequest{BlockHash: hash, Indexes: missing})
+	if err != nil {
+		log.Printf("requesting transactions of block %s from %s failed: %v", hash, n.peer(), err)
+		return
+	}
+
+	n.partialBlocks[hash] = partial
+	n.addRequests([]InvVec{{Type: MsgCmpctBlock, Hash: hash}})
+	n.write(getBlockTxn)
+}
+
+func (n *Node) handleBlockTxnMessage(msg *Message) {
+	txn, err := decodeBlockTxnMessage(msg.Payload)
+	if err != nil {
+		n.misbehaving(maxMisbehavior, fmt.Sprintf("sent invalid block transactions: %v", err))
+		return
+	}
+
+	partial, ok := n.partialBlocks[txn.BlockHash]
+	if !ok || !n.takeRequest(txn.BlockHash) {
+		n.misbehaving(unsolicitedBlockPenalty, fmt.Sprintf("sent transactions of block %s without being asked", txn.BlockHash))
+		return
+	}
+
+	delete(n.partialBlocks, txn.BlockHash)
+	partial.size += len(msg.Payload)
+	n.completeBlock(txn.BlockHash, partial, txn.Transactions)
+}
+
+// completeBlock fills in the missing transactions of a partial block and sends the block over the block channel.
+func (n *Node) completeBlock(hash btc.BlockHash, partial *partialBlock, missing []*btc.Transaction) {
+	block, err := partial.fill(missing)
+	if errors.Is(err, ErrReconstructionFailed) {
+		log.Printf("can't reconstruct compact block %s from %s: %v", hash, n.peer(), err)
+		n.requestFullBlock(hash)
+		return
+	}
+
+	if err != nil {
+		n.misbehaving(maxMisbehavior, fmt.Sprintf("sent invalid block transactions: %v", err))
+		return
+	}
+
+	if n.blockCh != nil {
+		n.blockCh <- BlockWithSource{Block: block, Size: partial.size, Node: n}
+	}
+}
+
+// requestFullBlock requests a block that couldn't be reconstructed from a compact block.
+func (n *Node) requestFullBlock(hash btc.BlockHash) {
+	inventory := []InvVec{{Type: MsgWitnessBlock, Hash: hash}}
+	payload, err := encodeInventory(inventory)
+	if err != nil {
+		log.Printf("requesting block %s from %s failed: %v", hash, n.peer(), err)
+		return
+	}
+
+	n.addRequests(inventory)
+	n.write(&Message{Header: NewHeader(GetdataCmd, payload), Payload: payload})
+}
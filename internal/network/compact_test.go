@@ -0,0 +1,296 @@
+package network
+
+import (
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/vartypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// blockWithTxs returns a block with a coinbase followed by count transactions, every other one with witness data. The
+// transactions aren't valid, but the merkle root of the block is.
+func blockWithTxs(t *testing.T, count int) *btc.Block {
+	t.Helper()
+
+	block := mineBlock(t, nil, 0, 50*btc.SatoshisPerBitcoin)
+	for i := 0; i < count; i++ {
+		tx := btc.Transaction{
+			Version: 2,
+			TxIn: []btc.TxInput{{
+				PreviousOutput:  btc.OutPoint{Hash: btc.TxHash{byte(i), 1}},
+				SignatureScript: []byte{},
+				Sequence:        0xffffffff,
+			}},
+			TxOut: []btc.TxOutput{{Value: int64(i), ScriptPubKey: []byte{0x51}}},
+		}
+		if i%2 == 1 {
+			tx.HasWitnesses = true
+			tx.TxIn[0].Witness = btc.TxWitness{{byte(i)}}
+		}
+		block.Transactions = append(block.Transactions, tx)
+	}
+
+	txids := make([][32]byte, len(block.Transactions))
+	for i := range block.Transactions {
+		txid, err := block.Transactions[i].TxID()
+		require.NoError(t, err)
+		txids[i] = txid
+	}
+	block.Header.MerkleRoot, _ = btc.MerkleRoot(txids)
+	block.Header.TxnCount = vartypes.NewVarInt(uint64(len(block.Transactions)))
+	return block
+}
+
+func cmpctBlockMessage(t *testing.T, block *btc.Block) *Message {
+	t.Helper()
+
+	cmpct, err := NewCompactBlock(block, 42)
+	require.NoError(t, err)
+	payload, err := cmpct.Encode()
+	require.NoError(t, err)
+	return &Message{Header: NewHeader(CmpctblockCmd, payload), Payload: payload}
+}
+
+func TestCompactBlockEncoding(t *testing.T) {
+	block := blockWithTxs(t, 5)
+
+	t.Run("cmpctblock", func(t *testing.T) {
+		cmpct, err := NewCompactBlock(block, 42)
+		require.NoError(t, err)
+		cmpct.Prefilled = append(cmpct.Prefilled, PrefilledTx{Index: 3, Tx: &block.Transactions[3]})
+		cmpct.ShortIDs = append(cmpct.ShortIDs[:2], cmpct.ShortIDs[3:]...)
+
+		payload, err := cmpct.Encode()
+		require.NoError(t, err)
+		decoded, err := decodeCompactBlock(payload)
+		require.NoError(t, err)
+		assert.Equal(t, cmpct, decoded)
+
+		_, err = decodeCompactBlock(payload[:len(payload)-1])
+		assert.ErrorIs(t, err, ErrInvalidCompactBlock)
+		_, err = decodeCompactBlock(append(payload, 0))
+		assert.ErrorIs(t, err, ErrInvalidCompactBlock)
+	})
+
+	t.Run("prefilled index beyond the block", func(t *testing.T) {
+		cmpct := &CompactBlock{Header: block.Header, Prefilled: []PrefilledTx{{Index: 1, Tx: &block.Transactions[0]}}}
+		payload, err := cmpct.Encode()
+		require.NoError(t, err)
+
+		_, err = decodeCompactBlock(payload)
+		assert.ErrorIs(t, err, ErrInvalidCompactBlock)
+	})
+
+	t.Run("getblocktxn", func(t *testing.T) {
+		req := BlockTxnRequest{BlockHash: btc.BlockHash{1, 2, 3}, Indexes: []int{1, 2, 5, 300}}
+		msg, err := NewGetBlockTxnMessage(req)
+		require.NoError(t, err)
+		assert.Equal(t, GetblocktxnCmd, msg.Header.Command)
+
+		decoded, err := decodeGetBlockTxnMessage(msg.Payload)
+		require.NoError(t, err)
+		assert.Equal(t, req, decoded)
+
+		_, err = NewGetBlockTxnMessage(BlockTxnRequest{Indexes: []int{2, 2}})
+		assert.ErrorIs(t, err, ErrInvalidBlockTxn)
+	})
+
+	t.Run("blocktxn", func(t *testing.T) {
+		txn := BlockTxn{BlockHash: btc.BlockHash{1}, Transactions: []*btc.Transaction{&block.Transactions[1], &block.Transactions[2]}}
+		msg, err := NewBlockTxnMessage(txn)
+		require.NoError(t, err)
+
+		decoded, err := decodeBlockTxnMessage(msg.Payload)
+		require.NoError(t, err)
+		assert.Equal(t, txn, decoded)
+	})
+
+	t.Run("sendcmpct", func(t *testing.T) {
+		msg := NewSendCmpctMessage(true, compactBlocksVersion)
+		decoded, err := decodeSendCmpctMessage(msg.Payload)
+		require.NoError(t, err)
+		assert.Equal(t, SendCmpct{Announce: true, Version: compactBlocksVersion}, decoded)
+	})
+}
+
+func TestShortIDs(t *testing.T) {
+	block := blockWithTxs(t, 1)
+	cmpct, err := NewCompactBlock(block, 42)
+	require.NoError(t, err)
+	require.Len(t, cmpct.ShortIDs, 1)
+
+	key, err := newShortIDKey(&block.Header, 42)
+	require.NoError(t, err)
+	wtxid, err := block.Transactions[1].WTxID()
+	require.NoError(t, err)
+	assert.Equal(t, key.shortID(wtxid), cmpct.ShortIDs[0])
+	assert.Less(t, cmpct.ShortIDs[0], uint64(1)<<48)
+
+	other, err := NewCompactBlock(block, 43)
+	require.NoError(t, err)
+	assert.NotEqual(t, cmpct.ShortIDs, other.ShortIDs, "the nonce changes the short ids")
+}
+
+func TestReconstructBlock(t *testing.T) {
+	block := blockWithTxs(t, 6)
+	hash, err := block.Hash()
+	require.NoError(t, err)
+
+	newTxPool := func(t *testing.T, indexes ...int) *TxPool {
+		pool := NewTxPool()
+		for _, i := range indexes {
+			require.NoError(t, pool.Add(&block.Transactions[i]))
+		}
+		return pool
+	}
+
+	t.Run("all transactions in the pool", func(t *testing.T) {
+		node, blockCh, disconnectErr := newTestNode(t)
+		node.SetTxPool(newTxPool(t, 1, 2, 3, 4, 5, 6))
+		node.addRequests([]InvVec{{Type: MsgCmpctBlock, Hash: hash}})
+
+		node.handleCmpctBlockMessage(cmpctBlockMessage(t, block))
+		require.Len(t, blockCh, 1)
+		assert.Equal(t, block, (<-blockCh).Block)
+		assert.NoError(t, *disconnectErr)
+	})
+
+	t.Run("missing transactions are requested", func(t *testing.T) {
+		node, blockCh, disconnectErr := newTestNode(t)
+		node.SetTxPool(newTxPool(t, 1, 3, 4))
+		node.addRequests([]InvVec{{Type: MsgCmpctBlock, Hash: hash}})
+
+		node.handleCmpctBlockMessage(cmpctBlockMessage(t, block))
+		assert.Empty(t, blockCh)
+		require.Len(t, node.msgWriteCh, 1)
+		getBlockTxn := <-node.msgWriteCh
+		assert.Equal(t, GetblocktxnCmd, getBlockTxn.Header.Command)
+		req, err := decodeGetBlockTxnMessage(getBlockTxn.Payload)
+		require.NoError(t, err)
+		assert.Equal(t, BlockTxnRequest{BlockHash: hash, Indexes: []int{2, 5, 6}}, req)
+
+		txn := BlockTxn{BlockHash: hash, Transactions: []*btc.Transaction{
+			&block.Transactions[2], &block.Transactions[5], &block.Transactions[6],
+		}}
+		msg, err := NewBlockTxnMessage(txn)
+		require.NoError(t, err)
+		node.handleBlockTxnMessage(msg)
+
+		require.Len(t, blockCh, 1)
+		assert.Equal(t, block, (<-blockCh).Block)
+		assert.Empty(t, node.partialBlocks)
+		assert.Empty(t, node.requests)
+		assert.NoError(t, *disconnectErr)
+	})
+
+	t.Run("wrong transactions lead to a request for the full block", func(t *testing.T) {
+		node, blockCh, _ := newTestNode(t)
+		node.addRequests([]InvVec{{Type: MsgCmpctBlock, Hash: hash}})
+
+		node.handleCmpctBlockMessage(cmpctBlockMessage(t, block))
+		<-node.msgWriteCh
+
+		wrong := make([]*btc.Transaction, 6)
+		for i := range wrong {
+			wrong[i] = &block.Transactions[6-i]
+		}
+		msg, err := NewBlockTxnMessage(BlockTxn{BlockHash: hash, Transactions: wrong})
+		require.NoError(t, err)
+		node.handleBlockTxnMessage(msg)
+
+		assert.Empty(t, blockCh)
+		require.Len(t, node.msgWriteCh, 1)
+		getData := <-node.msgWriteCh
+		assert.Equal(t, GetdataCmd, getData.Header.Command)
+		inv, err := decodeInvMessage(getData.Payload)
+		require.NoError(t, err)
+		assert.Equal(t, []InvVec{{Type: MsgWitnessBlock, Hash: hash}}, inv.Inventory)
+	})
+
+	t.Run("too few transactions", func(t *testing.T) {
+		node, blockCh, disconnectErr := newTestNode(t)
+		node.addRequests([]InvVec{{Type: MsgCmpctBlock, Hash: hash}})
+
+		node.handleCmpctBlockMessage(cmpctBlockMessage(t, block))
+		<-node.msgWriteCh
+
+		msg, err := NewBlockTxnMessage(BlockTxn{BlockHash: hash, Transactions: []*btc.Transaction{&block.Transactions[1]}})
+		require.NoError(t, err)
+		node.handleBlockTxnMessage(msg)
+
+		assert.Empty(t, blockCh)
+		assert.ErrorIs(t, *disconnectErr, ErrMisbehavior)
+	})
+
+	t.Run("unsolicited compact blocks", func(t *testing.T) {
+		node, blockCh, _ := newTestNode(t)
+
+		node.handleCmpctBlockMessage(cmpctBlockMessage(t, block))
+		assert.Empty(t, blockCh)
+		assert.Equal(t, int32(unsolicitedBlockPenalty), node.misbehavior)
+	})
+
+	t.Run("transactions are looked up by short id", func(t *testing.T) {
+		cmpct, err := NewCompactBlock(block, 42)
+		require.NoError(t, err)
+
+		partial, err := newPartialBlock(cmpct, newTxPool(t, 2, 5))
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 3, 4, 6}, partial.missing())
+		assert.Same(t, cmpct.Prefilled[0].Tx, partial.txs[0])
+
+		partial, err = newPartialBlock(cmpct, nil)
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 3, 4, 5, 6}, partial.missing())
+	})
+
+	t.Run("duplicate short ids", func(t *testing.T) {
+		cmpct, err := NewCompactBlock(block, 42)
+		require.NoError(t, err)
+		cmpct.ShortIDs[1] = cmpct.ShortIDs[0]
+
+		_, err = newPartialBlock(cmpct, nil)
+		assert.ErrorIs(t, err, ErrReconstructionFailed)
+	})
+}
+
+func TestTxPool(t *testing.T) {
+	block := blockWithTxs(t, 3)
+	pool := NewTxPool()
+
+	require.NoError(t, pool.AddBlock(block))
+	assert.Equal(t, 3, pool.Len(), "the coinbase isn't added")
+
+	require.NoError(t, pool.RemoveBlock(&btc.Block{Transactions: block.Transactions[:2]}))
+	assert.Equal(t, 2, pool.Len())
+}
+
+func TestRequestBlocksFrom(t *testing.T) {
+	genesis := mineBlock(t, nil, 0, 50*btc.SatoshisPerBitcoin)
+	pool := newTestPool(t, genesis)
+	node, _, _ := newTestNode(t)
+	node.compactBlocks = 1
+	hash := btc.BlockHash{1}
+
+	requestedType := func() ObjectType {
+		t.Helper()
+
+		pool.requestBlocksFrom(node, []btc.BlockHash{hash})
+		require.Len(t, node.msgWriteCh, 1)
+		inv, err := decodeInvMessage((<-node.msgWriteCh).Payload)
+		require.NoError(t, err)
+		require.Len(t, inv.Inventory, 1)
+		return inv.Inventory[0].Type
+	}
+
+	pool.initialBlockDownload = true
+	require.NoError(t, pool.txPool.AddBlock(blockWithTxs(t, 3)))
+	assert.Equal(t, MsgWitnessBlock, requestedType(), "during the initial block download")
+
+	pool.initialBlockDownload = false
+	assert.Equal(t, MsgCmpctBlock, requestedType())
+
+	pool.txPool = NewTxPool()
+	assert.Equal(t, MsgWitnessBlock, requestedType(), "with an empty transaction pool")
+}
@@ -0,0 +1,290 @@
+package network
+
+import (
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"log"
+	"slices"
+	"time"
+)
+
+const (
+	// maxBlocksInFlightPerPeer is the number of blocks that can be requested from a single peer at a time.
+	maxBlocksInFlightPerPeer = 16
+	// blockDownloadWindow is how far beyond the first missing block of the best chain blocks are requested. It limits
+	// the number of blocks that are kept in memory while waiting for a slow peer to deliver an earlier one.
+	blockDownloadWindow = 1024
+	// blockRequestTimeout is the time after which the blocks requested from a peer are requested from other peers, if
+	// the peer didn't deliver any of them.
+	blockRequestTimeout = time.Second * 20
+	// throughputSmoothing is the weight of a new sample in the moving average of the throughput of a peer.
+	throughputSmoothing = 0.3
+)
+
+// BlockWithSource is a block received from a peer.
+type BlockWithSource struct {
+	Block *btc.Block
+	// Size is the size of the serialized block in bytes.
+	Size int
+	Node *Node
+}
+
+type blockRequest struct {
+	node      *Node
+	requested time.Time
+}
+
+// peerDownload tracks the blocks requested from a peer and how fast it delivers them.
+type peerDownload struct {
+	inFlight int
+	// throughput is a moving average of the bytes per second at which the peer delivered blocks. It is zero until the
+	// peer delivers its first block and after a request to it timed out.
+	throughput   float64
+	lastDelivery time.Time
+}
+
+// downloadScheduler distributes block requests among peers. Each block is requested from one peer at a time, the
+// number of blocks requested from a peer is limited by maxBlocksInFlightPerPeer and peers with a higher throughput are
+// asked first. Requests to peers that stop delivering blocks time out, so that the blocks can be requested from other
+// peers.
+type downloadScheduler struct {
+	requests map[btc.BlockHash]blockRequest
+	peers    map[*Node]*peerDownload
+	now      func() time.Time
+}
+
+func newDownloadScheduler() *downloadScheduler {
+	return &downloadScheduler{
+		requests: make(map[btc.BlockHash]blockRequest),
+		peers:    make(map[*Node]*peerDownload),
+		now:      time.Now,
+	}
+}
+
+// isRequested returns whether the block with the given hash has been requested and not been delivered yet.
+func (s *downloadScheduler) isRequested(hash btc.BlockHash) bool {
+	_, ok := s.requests[hash]
+	return ok
+}
+
+// inFlight returns the number of blocks that have been requested and not been delivered yet.
+func (s *downloadScheduler) inFlight() int {
+	return len(s.requests)
+}
+
+// assign distributes hashes of blocks that haven't been requested yet among nodes and records the requests. The
+// fastest peer gets the first range of consecutive hashes that fits into its window, the next fastest the following
+// range and so on. Hashes that don't fit into the windows of any peer are left unassigned.
+func (s *downloadScheduler) assign(hashes []btc.BlockHash, nodes []*Node) map[*Node][]btc.BlockHash {
+	nodes = slices.Clone(nodes)
+	slices.SortStableFunc(nodes, func(a, b *Node) int {
+		return compareThroughput(s.peer(b).throughput, s.peer(a).throughput)
+	})
+
+	now := s.now()
+	assigned := make(map[*Node][]btc.BlockHash)
+
+	for _, node := range nodes {
+		if len(hashes) == 0 {
+			break
+		}
+
+		peer := s.peer(node)
+		count := min(maxBlocksInFlightPerPeer-peer.inFlight, len(hashes))
+		if count <= 0 {
+			continue
+		}
+
+		for _, hash := range hashes[:count] {
+			s.requests[hash] = blockRequest{node: node, requested: now}
+		}
+
+		peer.inFlight += count
+		assigned[node] = hashes[:count]
+		hashes = hashes[count:]
+	}
+
+	return assigned
+}
+
+// delivered records the arrival of a block of the given size in bytes from node. It returns whether the block had been
+// requested. A block requested from another peer is accepted as well, but only counts towards the throughput of the
+// peer it was requested from.
+func (s *downloadScheduler) delivered(hash btc.BlockHash, node *Node, size int) bool {
+	req, ok := s.requests[hash]
+	if !ok {
+		return false
+	}
+
+	delete(s.requests, hash)
+	peer := s.peer(req.node)
+	peer.inFlight--
+
+	if req.node != node {
+		return true
+	}
+
+	now := s.now()
+	start := req.requested
+	if peer.lastDelivery.After(start) {
+		start = peer.lastDelivery
+	}
+
+	if elapsed := now.Sub(start).Seconds(); elapsed > 0 {
+		sample := float64(size) / elapsed
+		if peer.throughput == 0 {
+			peer.throughput = sample
+		} else {
+			peer.throughput += throughputSmoothing * (sample - peer.throughput)
+		}
+	}
+
+	peer.lastDelivery = now
+	return true
+}
+
+// expire removes the requests to peers that haven't delivered any block within blockRequestTimeout of a request, so
+// that the blocks can be assigned to other peers. The throughput of these peers is reset, which puts them last in
+// line for new requests. It returns the peers whose requests expired.
+func (s *downloadScheduler) expire() []*Node {
+	now := s.now()
+	var stalled []*Node
+
+	for hash, req := range s.requests {
+		peer := s.peer(req.node)
+		start := req.requested
+		if peer.lastDelivery.After(start) {
+			start = peer.lastDelivery
+		}
+
+		if now.Sub(start) <= blockRequestTimeout {
+			continue
+		}
+
+		delete(s.requests, hash)
+		peer.inFlight--
+		peer.throughput = 0
+
+		if !slices.Contains(stalled, req.node) {
+			stalled = append(stalled, req.node)
+		}
+	}
+
+	return stalled
+}
+
+// release removes the request for the block with the given hash from node, because it doesn't have the block. Like
+// with an expired request, the throughput of the peer is reset, so that the block is requested from other peers first.
+// It returns whether the block had been requested from node.
+func (s *downloadScheduler) release(hash btc.BlockHash, node *Node) bool {
+	req, ok := s.requests[hash]
+	if !ok || req.node != node {
+		return false
+	}
+
+	delete(s.requests, hash)
+	peer := s.peer(node)
+	peer.inFlight--
+	peer.throughput = 0
+	return true
+}
+
+// removePeer forgets node and its outstanding requests, so that the blocks can be assigned to other peers.
+func (s *downloadScheduler) removePeer(node *Node) {
+	for hash, req := range s.requests {
+		if req.node == node {
+			delete(s.requests, hash)
+		}
+	}
+	delete(s.peers, node)
+}
+
+func (s *downloadScheduler) peer(node *Node) *peerDownload {
+	peer, ok := s.peers[node]
+	if !ok {
+		peer = &peerDownload{}
+		s.peers[node] = peer
+	}
+	return peer
+}
+
+func compareThroughput(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// requestNextBlocks requests the missing blocks within the download window of the best header chain from the connected
+// peers, spreading them according to the download scheduler.
+func (p *NodePool) requestNextBlocks() {
+	best := p.index.Best()
+	p.nextBlockHeight = max(p.nextBlockHeight, p.syncCursor()+1)
+
+	for p.nextBlockHeight <= best.Height && p.blocksByHash[best.Ancestor(p.nextBlockHeight).Hash] != nil {
+		p.nextBlockHeight++
+	}
+
+	if p.nextBlockHeight > best.Height {
+		if p.syncState == syncBlocks {
+			log.Printf("block download complete at height %d", best.Height)
+			p.syncState = syncDone
+		}
+		return
+	}
+
+	var missing []btc.BlockHash
+	end := min(best.Height, p.nextBlockHeight+blockDownloadWindow-1)
+
+	for node := best.Ancestor(end); node != nil && node.Height >= p.nextBlockHeight; node = node.Parent {
+		if p.blocksByHash[node.Hash] == nil && !p.downloads.isRequested(node.Hash) {
+			missing = append(missing, node.Hash)
+		}
+	}
+	slices.Reverse(missing)
+
+	for node, hashes := range p.downloads.assign(missing, p.blockSources()) {
+		p.requestBlocksFrom(node, hashes)
+	}
+}
+
+// checkBlockDownload releases the requests to disconnected and stalling peers and assigns the blocks to other peers.
+func (p *NodePool) checkBlockDownload() {
+	for node := range p.downloads.peers {
+		if !p.nodes.Contains(node) {
+			p.downloads.removePeer(node)
+		}
+	}
+
+	for _, node := range p.downloads.expire() {
+		log.Printf("block download from %s stalled. requesting its blocks from other peers", node.peer())
+	}
+
+	p.requestNextBlocks()
+}
+
+// handleNotFound releases the requests for blocks a peer doesn't have, so that they are requested from other peers.
+func (p *NodePool) handleNotFound(msg InvWithSource) {
+	released := 0
+	for _, item := range msg.Inventory {
+		if p.downloads.release(item.Hash, msg.Node) {
+			released++
+		}
+	}
+
+	if released > 0 {
+		log.Printf("%s doesn't have %d requested block(s)", msg.Node.peer(), released)
+	}
+}
+
+// checkRequests disconnects peers that left requests unanswered for too long.
+func (p *NodePool) checkRequests() {
+	// need to copy the set to avoid a deadlock when nodes remove themselves in OnError during iteration
+	p.nodes.Clone().Each(func(n *Node) bool {
+		n.checkRequests()
+		return false
+	})
+}
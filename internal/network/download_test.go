@@ -0,0 +1,147 @@
+package network
+
+import (
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func testHashes(count int) []btc.BlockHash {
+	hashes := make([]btc.BlockHash, count)
+	for i := range hashes {
+		hashes[i] = btc.BlockHash{byte(i), byte(i >> 8)}
+	}
+	return hashes
+}
+
+func newTestScheduler() (*downloadScheduler, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	s := newDownloadScheduler()
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func TestDownloadScheduler(t *testing.T) {
+	t.Run("assigns distinct ranges up to the window of each peer", func(t *testing.T) {
+		s, _ := newTestScheduler()
+		a, b := &Node{}, &Node{}
+		hashes := testHashes(maxBlocksInFlightPerPeer*2 + 5)
+
+		assigned := s.assign(hashes, []*Node{a, b})
+		require.Len(t, assigned, 2)
+		assert.Len(t, assigned[a], maxBlocksInFlightPerPeer)
+		assert.Len(t, assigned[b], maxBlocksInFlightPerPeer)
+		assert.NotEqual(t, assigned[a][0], assigned[b][0])
+		assert.Equal(t, maxBlocksInFlightPerPeer*2, s.inFlight())
+		assert.False(t, s.isRequested(hashes[len(hashes)-1]), "hashes beyond the windows are left unassigned")
+
+		assert.Empty(t, s.assign(hashes[maxBlocksInFlightPerPeer*2:], []*Node{a, b}), "the windows are full")
+
+		require.True(t, s.delivered(assigned[a][0], a, 1000))
+		assigned = s.assign(hashes[maxBlocksInFlightPerPeer*2:], []*Node{a, b})
+		assert.Equal(t, map[*Node][]btc.BlockHash{a: {hashes[maxBlocksInFlightPerPeer*2]}}, assigned)
+	})
+
+	t.Run("prefers peers with higher throughput", func(t *testing.T) {
+		s, now := newTestScheduler()
+		slow, fast := &Node{}, &Node{}
+		hashes := testHashes(4)
+
+		s.assign(hashes[:1], []*Node{slow})
+		s.assign(hashes[1:2], []*Node{fast})
+		*now = now.Add(time.Second)
+		require.True(t, s.delivered(hashes[0], slow, 1000))
+		require.True(t, s.delivered(hashes[1], fast, 100000))
+		assert.Greater(t, s.peers[fast].throughput, s.peers[slow].throughput)
+
+		assigned := s.assign(hashes[2:3], []*Node{slow, fast})
+		assert.Equal(t, map[*Node][]btc.BlockHash{fast: {hashes[2]}}, assigned)
+	})
+
+	t.Run("delivery by another peer", func(t *testing.T) {
+		s, _ := newTestScheduler()
+		a, b := &Node{}, &Node{}
+		hashes := testHashes(1)
+
+		s.assign(hashes, []*Node{a})
+		assert.True(t, s.delivered(hashes[0], b, 1000))
+		assert.Zero(t, s.peers[a].inFlight)
+		assert.Zero(t, s.peers[a].throughput)
+		assert.False(t, s.delivered(hashes[0], a, 1000), "the block is no longer requested")
+	})
+
+	t.Run("requests to stalling peers expire", func(t *testing.T) {
+		s, now := newTestScheduler()
+		stalling, busy := &Node{}, &Node{}
+		hashes := testHashes(4)
+
+		s.assign(hashes[:2], []*Node{stalling})
+		s.assign(hashes[2:], []*Node{busy})
+		s.peers[stalling].throughput = 1000
+
+		*now = now.Add(blockRequestTimeout)
+		require.True(t, s.delivered(hashes[2], busy, 1000))
+		assert.Empty(t, s.expire())
+
+		*now = now.Add(time.Second)
+		assert.Equal(t, []*Node{stalling}, s.expire(), "a recent delivery keeps the other requests alive")
+		assert.False(t, s.isRequested(hashes[0]))
+		assert.False(t, s.isRequested(hashes[1]))
+		assert.True(t, s.isRequested(hashes[3]))
+		assert.Zero(t, s.peers[stalling].inFlight)
+		assert.Zero(t, s.peers[stalling].throughput)
+	})
+
+	t.Run("removing a peer releases its requests", func(t *testing.T) {
+		s, _ := newTestScheduler()
+		node := &Node{}
+		hashes := testHashes(3)
+
+		s.assign(hashes, []*Node{node})
+		s.removePeer(node)
+		assert.Zero(t, s.inFlight())
+		assert.NotContains(t, s.peers, node)
+	})
+}
+
+func TestHandleInvalidBlock(t *testing.T) {
+	genesis := mineBlock(t, nil, 0, 50*btc.SatoshisPerBitcoin)
+	pool := newTestPool(t, genesis)
+	block := mineBlock(t, genesis, 1, 50*btc.SatoshisPerBitcoin)
+	hash := blockHash(t, block)
+	_, err := pool.index.AddHeader(&block.Header)
+	require.NoError(t, err)
+
+	sender, _, _ := newTestNode(t)
+	other, _, _ := newTestNode(t)
+	for _, node := range []*Node{sender, other} {
+		node.services = requiredServices
+		pool.setCallbacks(node)
+	}
+
+	pool.nodes = mapset.NewSet(sender)
+	pool.requestNextBlocks()
+	require.Len(t, sender.msgWriteCh, 1)
+	<-sender.msgWriteCh
+	pool.nodes.Add(other)
+
+	// the header is unchanged, but the merkle root doesn't match the transactions anymore
+	mutated := *block
+	mutated.Transactions = []btc.Transaction{block.Transactions[0]}
+	mutated.Transactions[0].TxOut = []btc.TxOutput{{Value: 1, ScriptPubKey: []byte{0x51}}}
+	pool.handleBlock(BlockWithSource{Block: &mutated, Size: 100, Node: sender})
+
+	assert.Nil(t, pool.blocksByHash[hash])
+	assert.Equal(t, int32(maxMisbehavior), sender.misbehavior)
+	assert.False(t, pool.nodes.Contains(sender))
+
+	require.Len(t, other.msgWriteCh, 1, "the block is requested from another peer")
+	inv, err := decodeInvMessage((<-other.msgWriteCh).Payload)
+	require.NoError(t, err)
+	assert.Equal(t, []InvVec{{Type: MsgWitnessBlock, Hash: hash}}, inv.Inventory)
+	assert.Same(t, other, pool.downloads.requests[hash].node)
+	assert.Zero(t, pool.downloads.peer(sender).throughput)
+}
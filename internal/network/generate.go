@@ -0,0 +1,92 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"github.com/haikoschol/btc-node-challenge/internal/address"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/mining"
+	"log"
+	"time"
+)
+
+var ErrNotRegtest = errors.New("blocks can only be generated on regtest")
+
+type generateRequest struct {
+	count    int
+	pkScript []byte
+	resultCh chan generateResult
+}
+
+type generateResult struct {
+	hashes []btc.BlockHash
+	err    error
+}
+
+// GenerateToAddress mines count blocks on top of the tip of the UTXO set, which pay their subsidy to addr, and returns
+// their hashes. The blocks are connected like downloaded ones. Generating blocks is only supported on regtest.
+func (p *NodePool) GenerateToAddress(count int, addr string) ([]btc.BlockHash, error) {
+	if p.params.Name != btc.RegTestParams.Name {
+		return nil, ErrNotRegtest
+	}
+
+	pkScript, err := address.ToScript(addr, p.params)
+	if err != nil {
+		return nil, err
+	}
+
+	req := generateRequest{count: count, pkScript: pkScript, resultCh: make(chan generateResult, 1)}
+	p.generateCh <- req
+	result := <-req.resultCh
+	return result.hashes, result.err
+}
+
+func (p *NodePool) handleGenerate(req generateRequest) {
+	hashes, err := p.generateBlocks(req.count, req.pkScript)
+	req.resultCh <- generateResult{hashes: hashes, err: err}
+}
+
+// generateBlocks mines and connects count blocks paying to pkScript. It stops at the first block that can't be
+// connected, e.g. because the best header chain has more work than the chain the block extends.
+func (p *NodePool) generateBlocks(count int, pkScript []byte) ([]btc.BlockHash, error) {
+	var hashes []btc.BlockHash
+
+	for i := 0; i < count; i++ {
+		tip, ok := p.utxoTip()
+		if !ok || tip == nil {
+			return hashes, errors.New("the tip of the UTXO set is unknown")
+		}
+
+		block, err := mining.NewBlock(tip, pkScript, p.params.Consensus, time.Now())
+		if err != nil {
+			return hashes, err
+		}
+
+		if err := mining.Solve(block); err != nil {
+			return hashes, err
+		}
+
+		hash, err := block.Hash()
+		if err != nil {
+			return hashes, err
+		}
+
+		if _, err := p.index.AddHeader(&block.Header); err != nil {
+			return hashes, err
+		}
+
+		p.blocksByHash[hash] = block
+		p.blocks = append(p.blocks, block)
+		p.connectBlocks()
+
+		if utxoTip, _ := p.utxos.Tip(); utxoTip != hash {
+			return hashes, fmt.Errorf("generated block %s was not connected", hash)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	if len(hashes) > 0 {
+		log.Printf("generated %d block(s)", len(hashes))
+	}
+	return hashes, nil
+}
@@ -0,0 +1,29 @@
+package network
+
+import (
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestGenerateBlocks(t *testing.T) {
+	pool := newTestPool(t, &btc.RegTestGenesisBlock)
+	pkScript := []byte{0x51}
+
+	hashes, err := pool.generateBlocks(3, pkScript)
+	require.NoError(t, err)
+	require.Len(t, hashes, 3)
+
+	tip, height := pool.utxos.Tip()
+	assert.Equal(t, hashes[2], tip)
+	assert.Equal(t, int32(3), height)
+	assert.Equal(t, hashes[2], pool.index.Best().Hash)
+	assert.Len(t, pool.blocks, 4)
+
+	t.Run("only on regtest", func(t *testing.T) {
+		pool.params = &btc.MainNetParams
+		_, err := pool.GenerateToAddress(1, "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2")
+		assert.ErrorIs(t, err, ErrNotRegtest)
+	})
+}
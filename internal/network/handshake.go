@@ -1,61 +1,124 @@
 package network
 
-import (
-	"net"
-	"net/netip"
-)
+import "net/netip"
 
-const protocolVersion = 70012
+// protocolVersion 70016 is the first version in which peers negotiate 'addrv2' messages (BIP155) during the handshake.
+const protocolVersion = 70016
 
-func handshake(conn net.Conn, peerAddr netip.Addr, peerPort uint16, connServices Services) (*Message, error) {
-	//peer := fmt.Sprintf("%s:%d", peerAddr.String(), peerPort)
+// handshake performs the initiator side of the handshake over the transport t, advertising localServices, and returns
+// the version message of the peer. The second return value reports whether the peer sent a 'sendaddrv2' message, i.e.
+// wants to receive addresses in 'addrv2' messages.
+func handshake(
+	t transport,
+	peerAddr netip.Addr,
+	peerPort uint16,
+	localServices Services,
+) (*Message, bool, error) {
+	if err := sendVersion(t, peerAddr, peerPort, localServices); err != nil {
+		return nil, false, err
+	}
+
+	peerVersionMsg, err := readVersion(t)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// BIP155 requires 'sendaddrv2' to be sent between the version message and verack
+	if err := t.writeMessage(Sendaddrv2Message); err != nil {
+		return nil, false, err
+	}
+
+	addrV2, err := readVerack(t)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := t.writeMessage(VerackMessage); err != nil {
+		return nil, false, err
+	}
 
+	return peerVersionMsg, addrV2, nil
+}
+
+// acceptHandshake performs the responder side of the handshake on an inbound connection, where the peer sends its
+// version message first. The return values are the same as those of handshake.
+func acceptHandshake(
+	t transport,
+	peerAddr netip.Addr,
+	peerPort uint16,
+	localServices Services,
+) (*Message, bool, error) {
+	peerVersionMsg, err := readVersion(t)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := sendVersion(t, peerAddr, peerPort, localServices); err != nil {
+		return nil, false, err
+	}
+
+	if err := t.writeMessage(Sendaddrv2Message); err != nil {
+		return nil, false, err
+	}
+
+	if err := t.writeMessage(VerackMessage); err != nil {
+		return nil, false, err
+	}
+
+	addrV2, err := readVerack(t)
+	if err != nil {
+		return nil, false, err
+	}
+	return peerVersionMsg, addrV2, nil
+}
+
+func sendVersion(t transport, peerAddr netip.Addr, peerPort uint16, services Services) error {
 	versionMessage, err := NewVersionMessage(
 		int32(protocolVersion),
-		connServices,
+		services,
 		peerAddr,
 		peerPort,
-		connServices,
+		services,
 		int32(0),
 		false,
 	)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	err = versionMessage.Write(conn)
-	if err != nil {
-		return nil, err
-	}
-	//log.Printf("sent [%s] to %s", versionMessage.Header.String(), peer)
+	return t.writeMessage(versionMessage)
+}
 
-	message, err := ReadMessage(conn)
+func readVersion(t transport) (*Message, error) {
+	message, err := t.readMessage()
 	if err != nil {
 		return nil, err
 	}
 
-	//log.Printf("received [%s] from %s", message.Header.String(), peer)
 	if message.Header.Command != VersionCmd {
 		return nil, ErrUnexpectedMessage
 	}
+	return message, nil
+}
 
-	peerVersionMsg := message
-
-	message, err = ReadMessage(conn)
-	if err != nil {
-		return nil, err
-	}
+// readVerack reads messages until the peer acknowledges our version message and reports whether it sent 'sendaddrv2'
+// in the meantime. The peer may negotiate other features (e.g. 'wtxidrelay') before sending verack as well.
+func readVerack(t transport) (addrV2 bool, err error) {
+	for {
+		message, err := t.readMessage()
+		if err != nil {
+			return false, err
+		}
 
-	//log.Printf("received [%s] from %s", message.Header.String(), peer)
-	if !message.Equal(VerackMessage) {
-		return nil, ErrUnexpectedMessage
-	}
+		if message.Equal(VerackMessage) {
+			return addrV2, nil
+		}
 
-	err = VerackMessage.Write(conn)
-	if err != nil {
-		return nil, err
+		switch message.Header.Command {
+		case Sendaddrv2Cmd:
+			addrV2 = true
+		case VersionCmd, VerackCmd:
+			return false, ErrUnexpectedMessage
+		}
 	}
-	//log.Printf("sent [%s] to %s", VerackMessage.Header.String(), peer)
-
-	return peerVersionMsg, nil
 }
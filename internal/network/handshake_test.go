@@ -2,6 +2,7 @@ package network
 
 import (
 	"encoding/binary"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"net"
@@ -14,6 +15,7 @@ func TestHandshake(t *testing.T) {
 	// the address of the peer from the perspective of the handshake function
 	// (i.e. the peer simulated by the tests below)
 	peerAddr := netip.MustParseAddr("127.0.0.1")
+	magic := btc.MainNetParams.Magic
 
 	t.Run("initiates handshake by sending a version message", func(t *testing.T) {
 		var wg sync.WaitGroup
@@ -22,7 +24,7 @@ func TestHandshake(t *testing.T) {
 
 		go func() {
 			defer wg.Done()
-			peerVersionMessage, err := handshake(local, peerAddr, 8333, Network)
+			peerVersionMessage, _, err := handshake(newV1Transport(local, local, magic), peerAddr, 8333, Network)
 			assert.Error(t, err) // caused by the peer closing the connection
 			assert.Nil(t, peerVersionMessage)
 		}()
@@ -44,7 +46,7 @@ func TestHandshake(t *testing.T) {
 
 		go func() {
 			defer wg.Done()
-			peerVersionMessage, err := handshake(local, peerAddr, 8333, Network)
+			peerVersionMessage, _, err := handshake(newV1Transport(local, local, magic), peerAddr, 8333, Network)
 			assert.ErrorIs(t, err, ErrUnexpectedMessage)
 			assert.Nil(t, peerVersionMessage)
 			local.Close() // simulate the caller of handshake() handling the error by closing the connection
@@ -53,7 +55,7 @@ func TestHandshake(t *testing.T) {
 		_, err := readMsg(peer) // read their version message
 		assert.NoError(t, err)
 
-		_ = VerackMessage.Write(peer) // ignore the error caused by the connection being closed in the goroutine above
+		_ = VerackMessage.Write(peer, magic) // ignore the error caused by the connection being closed in the goroutine above
 		wg.Wait()
 	})
 
@@ -75,7 +77,7 @@ func TestHandshake(t *testing.T) {
 
 		go func() {
 			defer wg.Done()
-			peerVersionMessage, err := handshake(local, peerAddr, 8333, Network)
+			peerVersionMessage, _, err := handshake(newV1Transport(local, local, magic), peerAddr, 8333, Network)
 			assert.ErrorIs(t, err, ErrUnexpectedMessage)
 			assert.Nil(t, peerVersionMessage)
 			local.Close() // simulate the caller of handshake() handling the error by closing the connection
@@ -85,21 +87,28 @@ func TestHandshake(t *testing.T) {
 		_, err = readMsg(peer)
 		assert.NoError(t, err)
 
-		err = versionMessage.Write(peer)
+		err = versionMessage.Write(peer, magic)
 		assert.NoError(t, err)
 
-		_ = versionMessage.Write(peer) // ignore the error caused by the connection being closed in the goroutine above
+		// read their sendaddrv2 message
+		_, err = readMsg(peer)
+		assert.NoError(t, err)
+
+		_ = versionMessage.Write(peer, magic) // ignore the error caused by the connection being closed in the goroutine above
 		wg.Wait()
 	})
 
-	t.Run("successful handshake", func(t *testing.T) {
+	successfulHandshake := func(t *testing.T, beforeVerack ...*Message) bool {
 		var wg sync.WaitGroup
 		wg.Add(1)
 		local, peer := net.Pipe()
 
+		var addrV2 bool
 		go func() {
 			defer wg.Done()
-			peerVersionMessage, err := handshake(local, peerAddr, 8333, Network)
+			var peerVersionMessage *Message
+			var err error
+			peerVersionMessage, addrV2, err = handshake(newV1Transport(local, local, magic), peerAddr, 8333, Network)
 			assert.NoError(t, err)
 			assert.True(t, peerVersionMessage.Equal(versionMessage))
 		}()
@@ -110,10 +119,20 @@ func TestHandshake(t *testing.T) {
 		command := msg[magicSize : magicSize+commandSize]
 		assert.Equal(t, VersionCmd[:], command)
 
-		err = versionMessage.Write(peer)
+		err = versionMessage.Write(peer, magic)
 		assert.NoError(t, err)
 
-		err = VerackMessage.Write(peer)
+		msg, err = readMsg(peer)
+		assert.NoError(t, err)
+
+		command = msg[magicSize : magicSize+commandSize]
+		assert.Equal(t, Sendaddrv2Cmd[:], command)
+
+		for _, m := range beforeVerack {
+			assert.NoError(t, m.Write(peer, magic))
+		}
+
+		err = VerackMessage.Write(peer, magic)
 		assert.NoError(t, err)
 
 		msg, err = readMsg(peer)
@@ -125,6 +144,16 @@ func TestHandshake(t *testing.T) {
 		checksum := msg[len(msg)-checksumSize:]
 		assert.Equal(t, VerackMessage.Header.Checksum[:], checksum)
 		wg.Wait()
+		return addrV2
+	}
+
+	t.Run("successful handshake", func(t *testing.T) {
+		assert.False(t, successfulHandshake(t))
+	})
+
+	t.Run("peer negotiates addrv2 and other features before verack", func(t *testing.T) {
+		wtxidRelay := &Message{Header: NewHeader(Command{'w', 't', 'x', 'i', 'd', 'r', 'e', 'l', 'a', 'y'}, Payload{}), Payload: Payload{}}
+		assert.True(t, successfulHandshake(t, wtxidRelay, Sendaddrv2Message))
 	})
 }
 
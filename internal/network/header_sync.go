@@ -0,0 +1,169 @@
+package network
+
+import (
+	"errors"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/chain"
+	"log"
+	"time"
+)
+
+// syncStallTimeout is the time after which the sync node is replaced if it didn't deliver any headers.
+const syncStallTimeout = time.Second * 30
+
+// syncState describes the phase of the headers-first sync the NodePool is in. The header chain is downloaded from the
+// genesis block to the tip of the sync node first. Afterwards, the block bodies along the best header chain are
+// downloaded from all connected peers in parallel. Once the
+// pool has all blocks, it keeps following the chain by requesting headers for blocks announced by its peers.
+type syncState int
+
+const (
+	syncHeaders syncState = iota
+	syncBlocks
+	syncDone
+)
+
+func (s syncState) String() string {
+	switch s {
+	case syncHeaders:
+		return "headers"
+	case syncBlocks:
+		return "blocks"
+	case syncDone:
+		return "done"
+	default:
+		return "unknown"
+	}
+}
+
+func (p *NodePool) startHeaderSync(node *Node) {
+	p.syncState = syncHeaders
+	p.syncNode = node
+	p.lastSyncProgress = time.Now()
+	log.Printf("starting header sync with %s", node.peer())
+	p.requestHeaders(node)
+}
+
+func (p *NodePool) requestHeaders(node *Node) {
+	locator := p.index.Locator(p.index.Best())
+
+	err := node.GetHeaders(locator, btc.BlockHash{}, p.headersCh)
+	if err != nil {
+		log.Printf("requesting headers from %s failed: %v", node.peer(), err)
+	}
+}
+
+func (p *NodePool) handleHeaders(msg HeadersWithSource) {
+	added := p.addHeaders(msg.Headers, msg.Node)
+
+	if p.syncState == syncHeaders {
+		if msg.Node != p.syncNode {
+			return
+		}
+
+		if added > 0 && len(msg.Headers) == maxHeadersResults {
+			p.requestHeaders(msg.Node)
+			return
+		}
+
+		log.Printf("header sync complete at height %d. downloading blocks", p.index.Best().Height)
+		p.syncState = syncBlocks
+	} else if added > 0 && len(msg.Headers) == maxHeadersResults {
+		// a peer announced a block further ahead than one headers message reaches
+		p.requestHeaders(msg.Node)
+	}
+
+	if added > 0 {
+		p.resumeBlockDownload()
+	}
+}
+
+// addHeaders adds headers to the block index and returns the number of headers that were linked into it. If a header
+// doesn't connect to the index, the headers leading up to it are requested from source.
+func (p *NodePool) addHeaders(headers []*btc.Header, source *Node) int {
+	prevBest := p.index.Best()
+	added := 0
+	orphaned := false
+
+	for _, header := range headers {
+		if !p.index.Contains(header.PrevBlock) {
+			orphaned = true
+		}
+
+		linked, err := p.index.AddHeader(header)
+		if err != nil {
+			log.Printf("rejecting header: %v", err)
+			if source != nil && (errors.Is(err, chain.ErrCheckpointMismatch) || errors.Is(err, chain.ErrForkBeforeCheckpoint)) {
+				source.misbehaving(maxMisbehavior, "sent headers contradicting a checkpoint")
+			}
+			break
+		}
+		added += len(linked)
+	}
+
+	best := p.index.Best()
+
+	if added > 0 {
+		p.lastSyncProgress = time.Now()
+		log.Printf("added %d header(s) to the block index. best header height: %d", added, best.Height)
+	}
+
+	if fork := chain.FindFork(prevBest, best); fork != prevBest {
+		log.Printf(
+			"best header chain switched from %s to %s at height %d",
+			prevBest.Hash,
+			best.Hash,
+			fork.Height,
+		)
+		p.nextBlockHeight = min(p.nextBlockHeight, fork.Height+1)
+	}
+
+	if orphaned && source != nil {
+		p.requestHeaders(source)
+	}
+
+	return added
+}
+
+// resumeBlockDownload continues downloading block bodies after new headers have been added to the block index.
+func (p *NodePool) resumeBlockDownload() {
+	if p.syncState == syncDone {
+		p.syncState = syncBlocks
+	}
+
+	if p.syncState == syncBlocks {
+		p.requestNextBlocks()
+	}
+}
+
+// checkSyncProgress replaces the sync node if it disconnected or stopped delivering headers and repeats the request for
+// headers with the new sync node. While downloading blocks, it hands the blocks requested from disconnected and
+// stalling peers to other peers.
+func (p *NodePool) checkSyncProgress() {
+	switch p.syncState {
+	case syncHeaders:
+		p.checkHeaderSync()
+	case syncBlocks:
+		p.checkBlockDownload()
+	}
+}
+
+func (p *NodePool) checkHeaderSync() {
+	stalled := time.Since(p.lastSyncProgress) > syncStallTimeout
+	if p.syncNode != nil && p.nodes.Contains(p.syncNode) && !stalled {
+		return
+	}
+
+	node, ok := p.pickNode(p.syncNode)
+	if !ok {
+		return
+	}
+
+	if p.syncNode != nil {
+		log.Printf("sync with %s stalled. switching to %s", p.syncNode.peer(), node.peer())
+	}
+
+	p.syncNode = node
+	p.lastSyncProgress = time.Now()
+	p.requestHeaders(node)
+}
@@ -0,0 +1,143 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/vartypes"
+	"io"
+)
+
+// maxHeadersResults is the maximum number of headers a peer sends in response to a single getheaders message.
+const maxHeadersResults = 2000
+
+// blockHeaderSize is the size of a header in a 'headers' message. It includes the transaction count, which is always zero.
+const blockHeaderSize = 81
+
+// maxLocatorSize is the maximum number of hashes accepted in the locator of a 'getheaders' or 'getblocks' message.
+const maxLocatorSize = 101
+
+var (
+	ErrInvalidHeadersMessage = errors.New("invalid headers message")
+	ErrInvalidLocatorMessage = errors.New("invalid getheaders or getblocks message")
+)
+
+type HeadersWithSource struct {
+	Headers []*btc.Header
+	Node    *Node
+}
+
+// LocatorRequest is a 'getheaders' or 'getblocks' message received from a peer. Command tells them apart.
+type LocatorRequest struct {
+	Command  Command
+	Locator  []btc.BlockHash
+	HashStop btc.BlockHash
+	Node     *Node
+}
+
+// NewGetHeadersMessage creates a getheaders message asking for the headers following the first hash in locator that
+// the peer has on its main chain. The response stops at hashStop, or after maxHeadersResults headers if hashStop is
+// the zero hash.
+func NewGetHeadersMessage(locator []btc.BlockHash, hashStop btc.BlockHash) (*Message, error) {
+	buf := new(bytes.Buffer)
+
+	err := binary.Write(buf, binary.LittleEndian, uint32(protocolVersion))
+	if err != nil {
+		return nil, err
+	}
+
+	err = vartypes.WriteAsVarInt(buf, uint64(len(locator)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hash := range locator {
+		written, err := buf.Write(hash[:])
+		if err != nil {
+			return nil, err
+		}
+		if written != len(hash) {
+			return nil, io.ErrShortWrite
+		}
+	}
+
+	written, err := buf.Write(hashStop[:])
+	if err != nil {
+		return nil, err
+	}
+	if written != len(hashStop) {
+		return nil, io.ErrShortWrite
+	}
+
+	payload := Payload(buf.Bytes())
+	return &Message{
+		Header:  NewHeader(GetheadersCmd, payload),
+		Payload: payload,
+	}, nil
+}
+
+// NewHeadersMessage creates a 'headers' message containing headers. The transaction counts of the headers are replaced
+// with zero.
+func NewHeadersMessage(headers []*btc.Header) (*Message, error) {
+	buf := new(bytes.Buffer)
+	if err := vartypes.WriteAsVarInt(buf, uint64(len(headers))); err != nil {
+		return nil, err
+	}
+
+	for _, header := range headers {
+		encoded, err := header.Encode()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encoded[:blockHeaderSize-1])
+		buf.WriteByte(0)
+	}
+
+	payload := Payload(buf.Bytes())
+	return &Message{Header: NewHeader(HeadersCmd, payload), Payload: payload}, nil
+}
+
+// decodeLocatorMessage decodes the payload of a 'getheaders' or 'getblocks' message, which share the same format.
+func decodeLocatorMessage(data []byte) ([]btc.BlockHash, btc.BlockHash, error) {
+	buf := bytes.NewBuffer(data)
+	if buf.Len() < 4 {
+		return nil, btc.BlockHash{}, ErrInvalidLocatorMessage
+	}
+	buf.Next(4) // protocol version
+
+	count, ok := vartypes.DecodeVarInt(buf)
+	if !ok || count.Value > maxLocatorSize || uint64(buf.Len()) != (count.Value+1)*btc.BlockHashSize {
+		return nil, btc.BlockHash{}, ErrInvalidLocatorMessage
+	}
+
+	locator := make([]btc.BlockHash, count.Value)
+	for i := range locator {
+		locator[i] = btc.BlockHash(buf.Next(btc.BlockHashSize))
+	}
+
+	return locator, btc.BlockHash(buf.Next(btc.BlockHashSize)), nil
+}
+
+func decodeHeadersMessage(data []byte) ([]*btc.Header, error) {
+	buf := bytes.NewBuffer(data)
+	count, ok := vartypes.DecodeVarInt(buf)
+	if !ok || count.Value > maxHeadersResults || uint64(buf.Len()) != count.Value*blockHeaderSize {
+		return nil, ErrInvalidHeadersMessage
+	}
+
+	headers := make([]*btc.Header, count.Value)
+	for i := range headers {
+		header, err := btc.DecodeHeader(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		if header.TxnCount.Value != 0 {
+			return nil, ErrInvalidHeadersMessage
+		}
+		headers[i] = header
+	}
+
+	return headers, nil
+}
@@ -0,0 +1,80 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/vartypes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGetHeadersMessage(t *testing.T) {
+	locator := []btc.BlockHash{{0x01}, {0x02}}
+	hashStop := btc.BlockHash{0x03}
+
+	msg, err := NewGetHeadersMessage(locator, hashStop)
+	assert.NoError(t, err)
+
+	t.Run("command", func(t *testing.T) {
+		assert.Equal(t, GetheadersCmd, msg.Header.Command)
+	})
+
+	t.Run("payload size", func(t *testing.T) {
+		assert.Equal(t, uint32(4+1+3*btc.BlockHashSize), msg.Header.Size)
+	})
+
+	t.Run("protocol version", func(t *testing.T) {
+		assert.Equal(t, uint32(protocolVersion), binary.LittleEndian.Uint32(msg.Payload[:4]))
+	})
+
+	t.Run("locator hashes", func(t *testing.T) {
+		assert.Equal(t, byte(2), msg.Payload[4])
+		assert.Equal(t, locator[0][:], []byte(msg.Payload[5:37]))
+		assert.Equal(t, locator[1][:], []byte(msg.Payload[37:69]))
+	})
+
+	t.Run("hash stop", func(t *testing.T) {
+		assert.Equal(t, hashStop[:], []byte(msg.Payload[69:]))
+	})
+}
+
+func TestDecodeHeadersMessage(t *testing.T) {
+	genesis := btc.Header{
+		Version:   1,
+		Timestamp: 1231006505,
+		Bits:      0x1d00ffff,
+		Nonce:     2083236893,
+		TxnCount:  vartypes.NewVarInt(0),
+	}
+	encoded, err := genesis.Encode()
+	assert.NoError(t, err)
+
+	t.Run("decodes headers", func(t *testing.T) {
+		payload := append([]byte{2}, encoded...)
+		payload = append(payload, encoded...)
+
+		headers, err := decodeHeadersMessage(payload)
+		assert.NoError(t, err)
+		assert.Len(t, headers, 2)
+		assert.Equal(t, genesis.Nonce, headers[1].Nonce)
+	})
+
+	t.Run("rejects truncated message", func(t *testing.T) {
+		payload := append([]byte{2}, encoded...)
+
+		headers, err := decodeHeadersMessage(payload)
+		assert.Nil(t, headers)
+		assert.ErrorIs(t, err, ErrInvalidHeadersMessage)
+	})
+
+	t.Run("rejects non-zero transaction count", func(t *testing.T) {
+		withTxns := bytes.Clone(encoded)
+		withTxns[len(withTxns)-1] = 1
+		payload := append([]byte{1}, withTxns...)
+
+		headers, err := decodeHeadersMessage(payload)
+		assert.Nil(t, headers)
+		assert.ErrorIs(t, err, ErrInvalidHeadersMessage)
+	})
+}
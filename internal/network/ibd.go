@@ -0,0 +1,109 @@
+package network
+
+import (
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/utxo"
+	"log"
+	"slices"
+	"time"
+)
+
+const (
+	// flushInterval is how often the stored blocks and the UTXO set are written to disk, so that a restart after a crash
+	// doesn't have to download and validate everything since the last graceful shutdown again.
+	flushInterval = time.Minute * 5
+	// maxTipAge is how old the timestamp of the last connected block may be for the initial block download to be
+	// considered complete.
+	maxTipAge = time.Hour * 24
+)
+
+// checkInitialBlockDownload logs the progress of the initial block download (IBD) and ends it once the UTXO set has
+// caught up with the network. The NodePool starts in IBD mode, downloading the header chain and then the blocks from the
+// embedded genesis block to the tip of the best header chain. IBD ends once all blocks have been downloaded and
+// connected and the last one is recent.
+func (p *NodePool) checkInitialBlockDownload() {
+	if !p.initialBlockDownload {
+		return
+	}
+
+	hash, height := p.utxos.Tip()
+	best := p.index.Best()
+
+	if p.syncState == syncBlocks {
+		log.Printf("initial block download at height %d of %d", height, best.Height)
+	}
+
+	tip, ok := p.index.Lookup(hash)
+	if !ok || tip != best || p.syncState != syncDone {
+		return
+	}
+
+	if time.Since(time.Unix(int64(tip.Header.Timestamp), 0)) > maxTipAge {
+		return
+	}
+
+	log.Printf("initial block download complete at height %d", height)
+	p.initialBlockDownload = false
+	p.flushState()
+}
+
+// syncCursor returns the height after which block download continues, which is the height of the tip of the UTXO set.
+// It is written to disk periodically along with the UTXO set, so that block download resumes there after a restart.
+// It is -1 if the tip isn't part of the best header chain, e.g. while the header chain is downloaded after a restart.
+func (p *NodePool) syncCursor() int32 {
+	hash, height := p.utxos.Tip()
+	tip, ok := p.index.Lookup(hash)
+	if !ok || p.index.Best().Ancestor(tip.Height) != tip {
+		return -1
+	}
+	return height
+}
+
+// flushState writes the stored blocks and the UTXO set to disk, after removing blocks that are no longer needed.
+func (p *NodePool) flushState() {
+	p.pruneBlocks()
+
+	if err := p.writeState(); err != nil {
+		log.Printf("failed writing state to %s: %v", p.statePath, err)
+	}
+
+	if err := p.utxos.Save(p.utxoPath); err != nil {
+		log.Printf("failed writing UTXO set to %s: %v", p.utxoPath, err)
+	}
+
+	p.lastFlush = time.Now()
+}
+
+// pruneBlocks removes blocks that are further below the tip of the UTXO set than its undo data reaches. Blocks of the
+// best chain that deep can't be disconnected anymore and blocks of other branches can't be connected anymore.
+func (p *NodePool) pruneBlocks() {
+	_, height := p.utxos.Tip()
+	pruneHeight := height - utxo.MaxUndoDepth
+	if pruneHeight < 0 {
+		return
+	}
+
+	pruned := 0
+	p.blocks = slices.DeleteFunc(p.blocks, func(block *btc.Block) bool {
+		hash, err := block.Hash()
+		if err != nil {
+			return false
+		}
+
+		node, ok := p.index.Lookup(hash)
+		if !ok || node.Height > pruneHeight {
+			return false
+		}
+
+		delete(p.blocksByHash, hash)
+		pruned++
+		return true
+	})
+
+	// the genesis block isn't in p.blocks
+	delete(p.blocksByHash, p.index.Genesis().Hash)
+
+	if pruned > 0 {
+		log.Printf("pruned %d block(s) below height %d", pruned, pruneHeight+1)
+	}
+}
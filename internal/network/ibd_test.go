@@ -0,0 +1,75 @@
+package network
+
+import (
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/chain"
+	"github.com/haikoschol/btc-node-challenge/internal/utxo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncCursor(t *testing.T) {
+	genesis := mineBlock(t, nil, 0, 50*btc.SatoshisPerBitcoin)
+	pool := newTestPool(t, genesis)
+	assert.Equal(t, int32(0), pool.syncCursor())
+
+	blocks := mineChain(t, genesis, 3, 10)
+	addBlocks(t, pool, blocks...)
+	assert.Equal(t, int32(3), pool.syncCursor())
+
+	t.Run("restart", func(t *testing.T) {
+		index, err := chain.NewBlockIndex(&genesis.Header, &btc.RegTestConsensus)
+		require.NoError(t, err)
+
+		restarted := &NodePool{blocksByHash: make(map[btc.BlockHash]*btc.Block), utxos: pool.utxos, index: index}
+		assert.Equal(t, int32(-1), restarted.syncCursor(), "the header chain doesn't reach the cursor yet")
+
+		for _, block := range blocks {
+			_, err := index.AddHeader(&block.Header)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, int32(3), restarted.syncCursor())
+	})
+
+	t.Run("cursor on a stale branch", func(t *testing.T) {
+		addBlocks(t, pool, mineChain(t, genesis, 4, 20)...)
+		assert.Equal(t, int32(4), pool.syncCursor())
+
+		// headers of a branch with more work whose blocks haven't been downloaded
+		for _, block := range mineChain(t, blocks[0], 5, 30) {
+			_, err := pool.index.AddHeader(&block.Header)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, int32(-1), pool.syncCursor())
+	})
+}
+
+func TestFlushState(t *testing.T) {
+	genesis := mineBlock(t, nil, 0, 50*btc.SatoshisPerBitcoin)
+	pool := newTestPool(t, genesis)
+	pool.statePath = filepath.Join(t.TempDir(), "state.bin")
+	pool.utxoPath = filepath.Join(t.TempDir(), "utxo.bin")
+
+	blocks := mineChain(t, genesis, utxo.MaxUndoDepth+2, 1)
+	addBlocks(t, pool, blocks...)
+	pool.flushState()
+
+	// the undo data reaches back to the block at height 2, so that blocks of a branch forking off there can be connected
+	require.Len(t, pool.blocks, utxo.MaxUndoDepth, "blocks deeper than the undo data are pruned")
+	assert.Same(t, blocks[2], pool.blocks[0])
+	assert.Equal(t, utxo.MaxUndoDepth, len(pool.blocksByHash))
+
+	stored, _, err := loadState(pool.statePath)
+	require.NoError(t, err)
+	assert.Equal(t, utxo.MaxUndoDepth, len(stored))
+
+	utxos, err := utxo.Load(pool.utxoPath)
+	require.NoError(t, err)
+	tip, height := utxos.Tip()
+	expectedTip, err := blocks[len(blocks)-1].Hash()
+	require.NoError(t, err)
+	assert.Equal(t, expectedTip, tip)
+	assert.Equal(t, int32(len(blocks)), height)
+}
@@ -0,0 +1,74 @@
+package network
+
+import (
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAcceptHandshake(t *testing.T) {
+	initiatorConn, responderConn := tcpPipe(t)
+	magic := testParams.Magic
+	localhost := netip.MustParseAddr("127.0.0.1")
+
+	done := make(chan *Message, 1)
+	go func() {
+		responder, err := acceptTransport(responderConn, magic)
+		assert.NoError(t, err)
+		assert.IsType(t, &v1Transport{}, responder)
+
+		version, addrV2, err := acceptHandshake(responder, localhost, 1234, localServices)
+		assert.NoError(t, err)
+		assert.True(t, addrV2)
+		done <- version
+	}()
+
+	version, addrV2, err := handshake(newV1Transport(initiatorConn, initiatorConn, magic), localhost, 8333, Network)
+	require.NoError(t, err)
+	assert.True(t, addrV2)
+	assert.Equal(t, VersionCmd, version.Header.Command)
+
+	peerVersion := <-done
+	require.NotNil(t, peerVersion)
+	assert.Equal(t, VersionCmd, peerVersion.Header.Command)
+}
+
+func TestListen(t *testing.T) {
+	pool := newTestPool(t, mineBlock(t, nil, 0, 50))
+	pool.nodes = mapset.NewSet[*Node]()
+
+	require.NoError(t, pool.Listen("127.0.0.1:0", 1))
+	t.Cleanup(func() { pool.listener.Close() })
+
+	listenAddr := pool.listener.Addr().(*net.TCPAddr).AddrPort()
+	addr, port := listenAddr.Addr(), listenAddr.Port()
+
+	node, err := Connect(testParams, addr, port, None, None)
+	require.NoError(t, err)
+	assert.IsType(t, &v2Transport{}, node.transport)
+	assert.Equal(t, localServices, node.services)
+
+	assert.Eventually(t, func() bool { return pool.Size() == 1 }, time.Second, 10*time.Millisecond)
+	inbound := pool.nodes.ToSlice()[0]
+	assert.True(t, inbound.inbound)
+	assert.False(t, inbound.servesBlocks(), "we didn't advertise any services")
+	assert.Empty(t, pool.blockSources())
+
+	_, err = Connect(testParams, addr, port, None, None)
+	assert.Error(t, err, "all inbound slots are taken")
+
+	node.Disconnect()
+	assert.Eventually(t, func() bool { return pool.Size() == 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&pool.inbound))
+
+	node, err = Connect(testParams, addr, port, localServices, None)
+	require.NoError(t, err, "the slot is free again")
+	assert.Eventually(t, func() bool { return pool.Size() == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, localServices, pool.nodes.ToSlice()[0].services, "outbound connections advertise our services")
+	node.Disconnect()
+}
@@ -3,11 +3,11 @@ package network
 import (
 	"bytes"
 	"encoding/binary"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/haikoschol/btc-node-challenge/internal/btc"
 	"github.com/haikoschol/btc-node-challenge/internal/vartypes"
+	"io"
 )
 
 type ObjectType uint32
@@ -56,7 +56,7 @@ type InvVec struct {
 }
 
 func (v *InvVec) String() string {
-	return fmt.Sprintf("type=%s hash=%s", v.Type, hex.EncodeToString(v.Hash[:]))
+	return fmt.Sprintf("type=%s hash=%s", v.Type, v.Hash)
 }
 
 type InvWithSource struct {
@@ -69,6 +69,33 @@ type InvMessage struct {
 	Inventory []InvVec
 }
 
+// encodeInventory serializes an inventory vector as used in the payload of 'inv', 'getdata' and 'notfound' messages.
+func encodeInventory(inventory []InvVec) (Payload, error) {
+	buf := new(bytes.Buffer)
+
+	err := vartypes.WriteAsVarInt(buf, uint64(len(inventory)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range inventory {
+		err = binary.Write(buf, binary.LittleEndian, item.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		written, err := buf.Write(item.Hash[:])
+		if err != nil {
+			return nil, err
+		}
+		if written != len(item.Hash) {
+			return nil, io.ErrShortWrite
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
 func decodeInvMessage(data []byte) (*InvMessage, error) {
 	buf := bytes.NewBuffer(data)
 	count, ok := vartypes.DecodeVarInt(buf)
@@ -20,6 +20,11 @@ var (
 		Header:  NewHeader(GetaddrCmd, Payload{}),
 		Payload: Payload{},
 	}
+
+	Sendaddrv2Message = &Message{
+		Header:  NewHeader(Sendaddrv2Cmd, Payload{}),
+		Payload: Payload{},
+	}
 )
 
 const (
@@ -31,7 +36,6 @@ type Checksum [checksumSize]byte
 type Payload []byte
 
 var (
-	Magic     = [magicSize]byte{0xF9, 0xBE, 0xB4, 0xD9}
 	UserAgent = append([]byte{0x11}, []byte("/Santitham:0.0.1/")...)
 
 	ErrInvalidHeader       = errors.New("invalid header")
@@ -39,6 +43,7 @@ var (
 	ErrUnexpectedMessage   = errors.New("received unexpected message")
 	ErrInvalidPeerVersion  = errors.New("invalid peer version")
 	ErrServicesUnavailable = errors.New("requested services unavailable")
+	ErrDisconnected        = errors.New("connection closed")
 )
 
 func (p Payload) Checksum() Checksum {
@@ -62,23 +67,25 @@ func (h Header) String() string {
 	return fmt.Sprintf("command=%s size=%d checksum=%s", h.Command.String(), h.Size, hex.EncodeToString(h.Checksum[:]))
 }
 
+// NewHeader returns the header for a message with the given command and payload. The magic bytes identifying the
+// network are filled in when the message is written.
 func NewHeader(command Command, payload Payload) Header {
 	return Header{
-		Magic:    Magic,
 		Command:  command,
 		Size:     payload.Size(),
 		Checksum: payload.Checksum(),
 	}
 }
 
-func ReadHeader(r io.Reader) (Header, error) {
+// ReadHeader reads a message header from r and checks that it belongs to the network identified by magic.
+func ReadHeader(r io.Reader, magic [magicSize]byte) (Header, error) {
 	header := Header{}
 	err := binary.Read(r, binary.LittleEndian, &header)
 	if err != nil {
 		return Header{}, err
 	}
 
-	if header.Magic != Magic {
+	if header.Magic != magic {
 		return Header{}, ErrInvalidHeader
 	}
 
@@ -94,12 +101,19 @@ func (m *Message) Command() string {
 	return m.Header.Command.String()
 }
 
+// Equal reports whether both messages have the same command and payload, regardless of the network they belong to.
 func (m *Message) Equal(other *Message) bool {
-	return m.Header == other.Header && bytes.Equal(m.Payload, other.Payload)
+	header, otherHeader := m.Header, other.Header
+	header.Magic, otherHeader.Magic = [magicSize]byte{}, [magicSize]byte{}
+	return header == otherHeader && bytes.Equal(m.Payload, other.Payload)
 }
 
-func (m *Message) Write(w io.Writer) error {
-	err := binary.Write(w, binary.LittleEndian, m.Header)
+// Write sends the message to w with the magic bytes of the network it is meant for.
+func (m *Message) Write(w io.Writer, magic [magicSize]byte) error {
+	header := m.Header
+	header.Magic = magic
+
+	err := binary.Write(w, binary.LittleEndian, header)
 	if err != nil {
 		return err
 	}
@@ -117,8 +131,9 @@ func (m *Message) Write(w io.Writer) error {
 	return nil
 }
 
-func ReadMessage(r io.Reader) (*Message, error) {
-	header, err := ReadHeader(r)
+// ReadMessage reads a message of the network identified by magic from r.
+func ReadMessage(r io.Reader, magic [magicSize]byte) (*Message, error) {
+	header, err := ReadHeader(r, magic)
 	if err != nil {
 		return nil, err
 	}
@@ -2,6 +2,7 @@ package network
 
 import (
 	"bytes"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"testing"
@@ -31,7 +32,7 @@ func TestReadMessage(t *testing.T) {
 			0x5D, 0xF6, 0xE0, 0xE2,
 		})
 
-		message, err := ReadMessage(buf)
+		message, err := ReadMessage(buf, btc.MainNetParams.Magic)
 
 		assert.NoError(t, err)
 		assert.True(t, message.Equal(VerackMessage))
@@ -45,7 +46,21 @@ func TestReadMessage(t *testing.T) {
 			0x5D, 0xF6, 0xE0, 0xE2,
 		})
 
-		message, err := ReadMessage(buf)
+		message, err := ReadMessage(buf, btc.MainNetParams.Magic)
+
+		assert.Nil(t, message)
+		assert.ErrorIs(t, err, ErrInvalidHeader)
+	})
+
+	t.Run("rejects messages of other networks", func(t *testing.T) {
+		buf := bytes.NewBuffer([]byte{
+			0xF9, 0xBE, 0xB4, 0xD9,
+			'v', 'e', 'r', 'a', 'c', 'k', 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 0,
+			0x5D, 0xF6, 0xE0, 0xE2,
+		})
+
+		message, err := ReadMessage(buf, btc.TestNet4Params.Magic)
 
 		assert.Nil(t, message)
 		assert.ErrorIs(t, err, ErrInvalidHeader)
@@ -60,7 +75,7 @@ func TestReadMessage(t *testing.T) {
 			0xBA, 0xDC, 0x0F, 0xFE, 0xE0,
 		})
 
-		message, err := ReadMessage(buf)
+		message, err := ReadMessage(buf, btc.MainNetParams.Magic)
 
 		assert.Nil(t, message)
 		assert.ErrorIs(t, err, ErrInvalidChecksum)
@@ -75,9 +90,15 @@ func TestReadMessage(t *testing.T) {
 			0xBA, 0xDC, 0x0F, 0xFE, 0xE0, 0xDE, 0xCA, 0xF0,
 		})
 
-		message, err := ReadMessage(buf)
+		message, err := ReadMessage(buf, btc.MainNetParams.Magic)
 
 		assert.Nil(t, message)
 		assert.ErrorIs(t, io.ErrUnexpectedEOF, err)
 	})
 }
+
+func TestInvVecString(t *testing.T) {
+	vec := InvVec{Type: MsgBlock, Hash: btc.MainNetGenesisHash}
+	assert.Equal(t, "type=MSG_BLOCK hash="+btc.MainNetGenesisHash.String(), vec.String())
+	assert.Contains(t, vec.String(), "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f")
+}
@@ -2,23 +2,171 @@ package network
 
 import (
 	"bytes"
+	"encoding/base32"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"github.com/haikoschol/btc-node-challenge/internal/vartypes"
+	"golang.org/x/crypto/sha3"
 	"net/netip"
+	"strings"
 )
 
 const netAddrSize = 30
 
+// maxAddrV2Size is the maximum size of an address in an 'addrv2' message, including addresses of unknown networks.
+const maxAddrV2Size = 512
+
+// torV3Version is the version byte of v3 onion addresses.
+const torV3Version = 3
+
+var (
+	ErrInvalidAddr     = errors.New("invalid address")
+	ErrInvalidAddrV2   = errors.New("invalid addrv2 message")
+	ErrUnreachableAddr = errors.New("address is not reachable")
+)
+
+// NetworkID identifies the network of an address in 'addrv2' messages (BIP155).
+type NetworkID uint8
+
+const (
+	IPv4 NetworkID = 1
+	IPv6 NetworkID = 2
+	// TorV2 addresses are no longer supported by the Tor network. They are ignored.
+	TorV2 NetworkID = 3
+	TorV3 NetworkID = 4
+	I2P   NetworkID = 5
+	CJDNS NetworkID = 6
+)
+
+func (id NetworkID) String() string {
+	switch id {
+	case IPv4:
+		return "IPv4"
+	case IPv6:
+		return "IPv6"
+	case TorV2:
+		return "TorV2"
+	case TorV3:
+		return "TorV3"
+	case I2P:
+		return "I2P"
+	case CJDNS:
+		return "CJDNS"
+	default:
+		return fmt.Sprintf("unknown network %d", uint8(id))
+	}
+}
+
+// addrSize returns the size of addresses on the network, or 0 if the network isn't supported.
+func (id NetworkID) addrSize() int {
+	switch id {
+	case IPv4:
+		return 4
+	case IPv6, CJDNS:
+		return 16
+	case TorV3, I2P:
+		return 32
+	default:
+		return 0
+	}
+}
+
+// Addr is the address of a node on one of the networks of BIP155, without the port. It is comparable, so that it can be
+// used as map key.
+type Addr struct {
+	Network NetworkID
+	data    [32]byte
+}
+
+// NewAddr creates the address of a node on network from its raw bytes: an IP address for IPv4, IPv6 and CJDNS, the
+// ed25519 public key for TorV3 and the SHA256 hash of the destination for I2P.
+func NewAddr(network NetworkID, raw []byte) (Addr, error) {
+	size := network.addrSize()
+	if size == 0 {
+		return Addr{}, fmt.Errorf("%w: %s", ErrInvalidAddr, network)
+	}
+
+	if len(raw) != size {
+		return Addr{}, fmt.Errorf("%w: %s address of %d bytes", ErrInvalidAddr, network, len(raw))
+	}
+
+	// CJDNS addresses are IPv6 addresses in fc00::/8
+	if network == CJDNS && raw[0] != 0xfc {
+		return Addr{}, fmt.Errorf("%w: CJDNS address outside of fc00::/8", ErrInvalidAddr)
+	}
+
+	addr := Addr{Network: network}
+	copy(addr.data[:], raw)
+	return addr, nil
+}
+
+// AddrFromIP returns the address of ip, which is an IPv4 address for IPv4-mapped IPv6 addresses.
+func AddrFromIP(ip netip.Addr) Addr {
+	ip = ip.Unmap()
+	if ip.Is4() {
+		raw := ip.As4()
+		addr, _ := NewAddr(IPv4, raw[:])
+		return addr
+	}
+
+	raw := ip.As16()
+	addr, _ := NewAddr(IPv6, raw[:])
+	return addr
+}
+
+// Bytes returns the raw bytes of the address.
+func (a Addr) Bytes() []byte {
+	return bytes.Clone(a.data[:a.Network.addrSize()])
+}
+
+// IP returns the IP address we can connect to the node at. It returns false for addresses on overlay networks, which
+// aren't supported yet.
+func (a Addr) IP() (netip.Addr, bool) {
+	switch a.Network {
+	case IPv4:
+		return netip.AddrFrom4([4]byte(a.data[:4])), true
+	case IPv6:
+		return netip.AddrFrom16([16]byte(a.data[:16])), true
+	default:
+		return netip.Addr{}, false
+	}
+}
+
+func (a Addr) String() string {
+	switch a.Network {
+	case IPv4, IPv6:
+		ip, _ := a.IP()
+		return ip.String()
+	case CJDNS:
+		return netip.AddrFrom16([16]byte(a.data[:16])).String()
+	case TorV3:
+		// the onion address encodes the public key, a checksum and the version
+		pubKey := a.data[:32]
+		checksum := sha3.Sum256(append(append([]byte(".onion checksum"), pubKey...), torV3Version))
+		raw := append(append(bytes.Clone(pubKey), checksum[:2]...), torV3Version)
+		return encodeBase32(raw) + ".onion"
+	case I2P:
+		return encodeBase32(a.data[:32]) + ".b32.i2p"
+	default:
+		return a.Network.String()
+	}
+}
+
+func encodeBase32(raw []byte) string {
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw))
+}
+
+// NetAddr is the address of a node as advertised in 'addr' and 'addrv2' messages.
 type NetAddr struct {
 	Time     uint32
 	Services Services
-	IPAddr   [16]byte
+	Addr     Addr
 	Port     uint16
 }
 
 func (na *NetAddr) String() string {
-	addr := netip.AddrFrom16(na.IPAddr).String()
-	return fmt.Sprintf("address=%s port=%d timestamp=%d services=%d", addr, na.Port, na.Time, na.Services)
+	return fmt.Sprintf("address=%s port=%d timestamp=%d services=%d", na.Addr, na.Port, na.Time, na.Services)
 }
 
 func decodeNetAddr(buf *bytes.Buffer) NetAddr {
@@ -33,7 +181,91 @@ func decodeNetAddr(buf *bytes.Buffer) NetAddr {
 	return NetAddr{
 		Time:     timestamp,
 		Services: services,
-		IPAddr:   ipAddr,
+		Addr:     AddrFromIP(netip.AddrFrom16(ipAddr)),
 		Port:     port,
 	}
 }
+
+// NewAddrV2Message creates an 'addrv2' message advertising addrs.
+func NewAddrV2Message(addrs []NetAddr) (*Message, error) {
+	buf := new(bytes.Buffer)
+	if err := vartypes.WriteAsVarInt(buf, uint64(len(addrs))); err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		if err := binary.Write(buf, binary.LittleEndian, addr.Time); err != nil {
+			return nil, err
+		}
+
+		if err := vartypes.WriteAsVarInt(buf, uint64(addr.Services)); err != nil {
+			return nil, err
+		}
+
+		raw := addr.Addr.Bytes()
+		buf.WriteByte(byte(addr.Addr.Network))
+		if err := vartypes.WriteAsVarInt(buf, uint64(len(raw))); err != nil {
+			return nil, err
+		}
+		buf.Write(raw)
+
+		if err := binary.Write(buf, binary.BigEndian, addr.Port); err != nil {
+			return nil, err
+		}
+	}
+
+	payload := Payload(buf.Bytes())
+	return &Message{Header: NewHeader(Addrv2Cmd, payload), Payload: payload}, nil
+}
+
+// decodeAddrV2Message decodes the payload of an 'addrv2' message. Addresses of networks we don't know and invalid
+// addresses are skipped. Errors are only returned for malformed messages.
+func decodeAddrV2Message(data []byte) ([]NetAddr, error) {
+	buf := bytes.NewBuffer(data)
+	count, ok := vartypes.DecodeVarInt(buf)
+	if !ok || count.Value > maxPeerCount {
+		return nil, ErrInvalidAddrV2
+	}
+
+	addrs := make([]NetAddr, 0, count.Value)
+	for i := uint64(0); i < count.Value; i++ {
+		if buf.Len() < 4 {
+			return nil, ErrInvalidAddrV2
+		}
+		timestamp := binary.LittleEndian.Uint32(buf.Next(4))
+
+		services, ok := vartypes.DecodeVarInt(buf)
+		if !ok || buf.Len() < 1 {
+			return nil, ErrInvalidAddrV2
+		}
+		network := NetworkID(buf.Next(1)[0])
+
+		size, ok := vartypes.DecodeVarInt(buf)
+		if !ok || size.Value > maxAddrV2Size || size.Value+2 > uint64(buf.Len()) {
+			return nil, ErrInvalidAddrV2
+		}
+		raw := buf.Next(int(size.Value))
+		port := binary.BigEndian.Uint16(buf.Next(2))
+
+		if network.addrSize() == 0 {
+			continue
+		}
+
+		if len(raw) != network.addrSize() {
+			return nil, fmt.Errorf("%w: %s address of %d bytes", ErrInvalidAddrV2, network, len(raw))
+		}
+
+		// like Bitcoin Core, skip addresses that are well-formed but invalid, e.g. CJDNS addresses outside of fc00::/8
+		addr, err := NewAddr(network, raw)
+		if err != nil {
+			continue
+		}
+
+		addrs = append(addrs, NetAddr{Time: timestamp, Services: Services(services.Value), Addr: addr, Port: port})
+	}
+
+	if buf.Len() > 0 {
+		return nil, ErrInvalidAddrV2
+	}
+	return addrs, nil
+}
@@ -0,0 +1,184 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func TestAddr(t *testing.T) {
+	tests := []struct {
+		name     string
+		network  NetworkID
+		raw      string
+		expected string
+		dialable bool
+	}{
+		{"IPv4", IPv4, "01020304", "1.2.3.4", true},
+		{"IPv6", IPv6, "20010db8000000000000000000000001", "2001:db8::1", true},
+		{
+			"TorV3",
+			TorV3,
+			"79bcc625184b05194975c28b66b66b0469f7f6556fb1ac3189a79b40dda32f1f",
+			"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion",
+			false,
+		},
+		{
+			"I2P",
+			I2P,
+			"a2894dabaec08c0051a481a6dac88b64f98232ae42d4b6fd2fa81952dfe36a87",
+			"ukeu3k5oycgaauneqgtnvselmt4yemvoilkln7jpvamvfx7dnkdq.b32.i2p",
+			false,
+		},
+		{"CJDNS", CJDNS, "fc000001000200030004000500060007", "fc00:1:2:3:4:5:6:7", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := mustDecodeHex(t, tt.raw)
+			addr, err := NewAddr(tt.network, raw)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expected, addr.String())
+			assert.Equal(t, raw, addr.Bytes())
+
+			_, dialable := addr.IP()
+			assert.Equal(t, tt.dialable, dialable)
+
+			_, err = NewAddr(tt.network, raw[1:])
+			assert.ErrorIs(t, err, ErrInvalidAddr)
+		})
+	}
+
+	t.Run("invalid addresses", func(t *testing.T) {
+		_, err := NewAddr(CJDNS, mustDecodeHex(t, "20010db8000000000000000000000001"))
+		assert.ErrorIs(t, err, ErrInvalidAddr)
+
+		_, err = NewAddr(TorV2, make([]byte, 10))
+		assert.ErrorIs(t, err, ErrInvalidAddr)
+	})
+
+	t.Run("IPv4-mapped IPv6 addresses", func(t *testing.T) {
+		addr := AddrFromIP(netip.MustParseAddr("::ffff:1.2.3.4"))
+		assert.Equal(t, IPv4, addr.Network)
+
+		ip, ok := addr.IP()
+		require.True(t, ok)
+		assert.Equal(t, netip.MustParseAddr("1.2.3.4"), ip)
+	})
+}
+
+func TestAddrV2Message(t *testing.T) {
+	torV3, err := NewAddr(TorV3, mustDecodeHex(t, "79bcc625184b05194975c28b66b66b0469f7f6556fb1ac3189a79b40dda32f1f"))
+	require.NoError(t, err)
+
+	addrs := []NetAddr{
+		{Time: 1700000000, Services: Network | Witness, Addr: AddrFromIP(netip.MustParseAddr("1.2.3.4")), Port: 8333},
+		{Time: 1700000001, Services: Network, Addr: torV3, Port: 8333},
+	}
+
+	t.Run("roundtrip", func(t *testing.T) {
+		msg, err := NewAddrV2Message(addrs)
+		require.NoError(t, err)
+		assert.Equal(t, Addrv2Cmd, msg.Header.Command)
+
+		decoded, err := decodeAddrV2Message(msg.Payload)
+		require.NoError(t, err)
+		assert.Equal(t, addrs, decoded)
+
+		_, err = decodeAddrV2Message(msg.Payload[:len(msg.Payload)-1])
+		assert.ErrorIs(t, err, ErrInvalidAddrV2)
+	})
+
+	t.Run("addresses of unknown networks are skipped", func(t *testing.T) {
+		payload := []byte{3}
+		payload = appendAddrV2(payload, TorV2, make([]byte, 10))
+		payload = appendAddrV2(payload, NetworkID(42), make([]byte, 7))
+		payload = appendAddrV2(payload, IPv4, []byte{1, 2, 3, 4})
+
+		decoded, err := decodeAddrV2Message(payload)
+		require.NoError(t, err)
+		require.Len(t, decoded, 1)
+		assert.Equal(t, "1.2.3.4", decoded[0].Addr.String())
+	})
+
+	t.Run("invalid CJDNS addresses are skipped", func(t *testing.T) {
+		cjdns := mustDecodeHex(t, "fc000000000000000000000000000001")
+		outside := mustDecodeHex(t, "fd000000000000000000000000000001")
+
+		payload := []byte{3}
+		payload = appendAddrV2(payload, IPv4, []byte{1, 2, 3, 4})
+		payload = appendAddrV2(payload, CJDNS, outside)
+		payload = appendAddrV2(payload, CJDNS, cjdns)
+
+		decoded, err := decodeAddrV2Message(payload)
+		require.NoError(t, err)
+		require.Len(t, decoded, 2)
+		assert.Equal(t, "1.2.3.4", decoded[0].Addr.String())
+		assert.Equal(t, "fc00::1", decoded[1].Addr.String())
+	})
+
+	t.Run("wrong address size", func(t *testing.T) {
+		payload := appendAddrV2([]byte{1}, IPv4, []byte{1, 2, 3, 4, 5})
+		_, err := decodeAddrV2Message(payload)
+		assert.ErrorIs(t, err, ErrInvalidAddrV2)
+	})
+
+	t.Run("handled by the node", func(t *testing.T) {
+		node, _, _ := newTestNode(t)
+		peersCh := make(chan []NetAddr, 1)
+		node.setPeersCh(peersCh)
+
+		msg, err := NewAddrV2Message(addrs)
+		require.NoError(t, err)
+		node.handleAddrV2Message(msg)
+
+		require.Len(t, peersCh, 1)
+		assert.Equal(t, addrs, <-peersCh)
+		assert.False(t, node.hasPeersCh())
+	})
+}
+
+func TestGetPeerBatch(t *testing.T) {
+	i2p, err := NewAddr(I2P, make([]byte, 32))
+	require.NoError(t, err)
+
+	now := uint32(time.Now().Unix())
+	dialable := NetAddr{Time: now, Addr: AddrFromIP(netip.MustParseAddr("1.2.3.4")), Port: 8333}
+	stale := NetAddr{Time: 1, Addr: AddrFromIP(netip.MustParseAddr("5.6.7.8")), Port: 8333}
+	undialable := NetAddr{Time: now, Addr: i2p, Port: 0}
+
+	pool := &NodePool{minConnections: 1, peerAddrs: mapset.NewSet[NetAddr]()}
+	pool.addPeerAddrs([]NetAddr{dialable, stale, undialable})
+	assert.Equal(t, 2, pool.dialablePeerAddrs())
+
+	assert.Equal(t, []NetAddr{dialable}, pool.getPeerBatch())
+	assert.Equal(t, []NetAddr{undialable}, pool.peerAddrs.ToSlice(), "addresses we can't dial yet are kept")
+	assert.Empty(t, pool.getPeerBatch())
+
+	_, err = pool.connect(undialable)
+	assert.ErrorIs(t, err, ErrUnreachableAddr)
+}
+
+// appendAddrV2 appends an address in the format of 'addrv2' messages with services=0, time=0 and port=8333 to payload.
+func appendAddrV2(payload []byte, network NetworkID, raw []byte) []byte {
+	buf := bytes.NewBuffer(payload)
+	buf.Write([]byte{0, 0, 0, 0, 0, byte(network), byte(len(raw))})
+	buf.Write(raw)
+	_ = binary.Write(buf, binary.BigEndian, uint16(8333))
+	return buf.Bytes()
+}
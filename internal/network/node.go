@@ -1,16 +1,17 @@
 package network
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"fmt"
 	"github.com/haikoschol/btc-node-challenge/internal/btc"
 	"github.com/haikoschol/btc-node-challenge/internal/vartypes"
-	"io"
 	"log"
 	"math"
 	"net"
 	"net/netip"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -18,6 +19,13 @@ import (
 
 const maxPeerCount = 1000
 
+// v2HandshakeTimeout is the time the host has to complete the key exchange of the v2 transport before we fall back to
+// v1.
+const v2HandshakeTimeout = 10 * time.Second
+
+// inboundHandshakeTimeout is the time a peer connecting to us has to complete the handshake.
+const inboundHandshakeTimeout = 30 * time.Second
+
 // Node represents a node in the Bitcoin network.
 // Instances should be created with Connect.
 type Node struct {
@@ -28,71 +36,218 @@ type Node struct {
 	addr         netip.Addr
 	port         uint16
 	conn         net.Conn
+	transport    transport
 	protoVersion int32
 	services     Services
 	lock         sync.Mutex
 	peersCh      chan []NetAddr
 	invCh        chan InvWithSource
-	blockCh      chan *btc.Block
-	stopWritesCh chan bool
+	blockCh      chan BlockWithSource
+	headersCh    chan HeadersWithSource
+	notFoundCh   chan InvWithSource
+	getDataCh    chan InvWithSource
+	locatorCh    chan LocatorRequest
+	// stopWritesCh is closed on disconnect to stop the goroutines writing to the host
+	stopWritesCh chan struct{}
 	msgWriteCh   chan *Message
 	shuttingDown int32
+	misbehavior  int32
+	// requests maps the hashes of items requested with 'getdata' to the time of the request.
+	requests map[btc.BlockHash]time.Time
+
+	// compactBlocks is set to 1 once the host announced support for compactBlocksVersion
+	compactBlocks int32
+	txPool        *TxPool
+	// partialBlocks are the compact blocks waiting for the response to a 'getblocktxn' message
+	partialBlocks map[btc.BlockHash]*partialBlock
+
+	// addrV2 is set if the host sent 'sendaddrv2' during the handshake and thus advertises addresses with 'addrv2'
+	addrV2 bool
+	// inbound is set if the host initiated the connection
+	inbound bool
+
+	// responses are the answers to requests of the host that haven't been sent yet. serving is set while a goroutine
+	// sends them.
+	serveLock sync.Mutex
+	responses []func() error
+	serving   bool
 }
 
-// Connect establishes a TCP connection with the host at addr:port and performs a Bitcoin protocol handshake. The
-// requestedServices are passed to the host in the version message. If the version message response from the host does
-// not contain these services, the connection is aborted and the function returns ErrServicesUnavailable.
-func Connect(addr netip.Addr, port uint16, requestedServices Services) (*Node, error) {
-	peer := fmt.Sprintf("%s:%d", addr.String(), port)
-	network := "tcp"
+// Connect establishes a TCP connection with the host at addr:port and performs a Bitcoin protocol handshake on the
+// network described by params. The connection is encrypted with the v2 transport of BIP324 if the host supports it. The
+// localServices are passed to the host in our version message. If the version message response from the host does not
+// contain the requiredServices, the connection is aborted and the function returns ErrServicesUnavailable.
+func Connect(
+	params *btc.ChainParams,
+	addr netip.Addr,
+	port uint16,
+	localServices Services,
+	requiredServices Services,
+) (*Node, error) {
+	conn, t, err := dialTransport(params.Magic, addr, port)
+	if err != nil {
+		return nil, err
+	}
 
-	if addr.Is6() {
-		peer = fmt.Sprintf("[%s]:%d", addr.String(), port)
-		network = "tcp6"
+	versionMsg, addrV2, err := handshake(t, addr, port, localServices)
+	if err != nil {
+		conn.Close()
+		return nil, err
 	}
 
-	var dialer net.Dialer
-	dialer.Timeout = time.Second * 15
-	conn, err := dialer.Dial(network, peer)
+	node, err := newNode(conn, t, addr, port, versionMsg, addrV2)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if node.services&requiredServices != requiredServices {
+		conn.Close()
+		return nil, ErrServicesUnavailable
+	}
+	return node, nil
+}
+
+// Accept performs the responder side of the handshake on the inbound connection conn on the network described by
+// params. The peer decides whether the v1 or the v2 transport is used. localServices are passed to the peer in our
+// version message. conn is closed if the handshake fails.
+func Accept(params *btc.ChainParams, conn net.Conn, localServices Services) (*Node, error) {
+	remote, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	addr, port := remote.Addr().Unmap(), remote.Port()
+
+	if err := conn.SetDeadline(time.Now().Add(inboundHandshakeTimeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	t, err := acceptTransport(conn, params.Magic)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	versionMsg, addrV2, err := acceptHandshake(t, addr, port, localServices)
 	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
 		return nil, err
 	}
 
-	versionMsg, err := handshake(conn, addr, port, requestedServices)
+	node, err := newNode(conn, t, addr, port, versionMsg, addrV2)
 	if err != nil {
 		conn.Close()
 		return nil, err
 	}
+	node.inbound = true
+	return node, nil
+}
+
+func newNode(conn net.Conn, t transport, addr netip.Addr, port uint16, versionMsg *Message, addrV2 bool) (*Node, error) {
+	if len(versionMsg.Payload) < 12 {
+		return nil, ErrInvalidPeerVersion
+	}
 
 	version := binary.LittleEndian.Uint32(versionMsg.Payload[:4])
 	if version > math.MaxInt32 {
-		conn.Close()
 		return nil, ErrInvalidPeerVersion
 	}
 	protoVersion := int32(version)
 
 	services := Services(binary.LittleEndian.Uint64(versionMsg.Payload[4:12]))
-	if services&requestedServices != requestedServices {
-		conn.Close()
-		return nil, ErrServicesUnavailable
-	}
 
 	return &Node{
 		addr:         addr,
 		port:         port,
 		conn:         conn,
+		transport:    t,
 		protoVersion: protoVersion,
 		services:     services,
 		lock:         sync.Mutex{},
 		peersCh:      nil,
 		invCh:        nil,
 		blockCh:      nil,
-		stopWritesCh: make(chan bool, 1),
+		headersCh:    nil,
+		notFoundCh:   nil,
+		stopWritesCh: make(chan struct{}),
 		msgWriteCh:   make(chan *Message, 5),
 		shuttingDown: 0,
+		misbehavior:  0,
+		requests:     make(map[btc.BlockHash]time.Time),
+
+		partialBlocks: make(map[btc.BlockHash]*partialBlock),
+
+		addrV2: addrV2,
 	}, nil
 }
 
+// dialTransport connects to the host at addr:port and tries to establish the encrypted v2 transport. If that fails, it
+// reconnects and falls back to the plaintext v1 transport. Hosts that only support v1 close the connection right away,
+// because our public key doesn't start with the magic bytes of the network.
+func dialTransport(magic [magicSize]byte, addr netip.Addr, port uint16) (net.Conn, transport, error) {
+	conn, err := dial(addr, port)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(v2HandshakeTimeout)); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	t, err := newV2Transport(conn, conn, magic, true)
+	if err == nil {
+		if err := conn.SetDeadline(time.Time{}); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		return conn, t, nil
+	}
+	conn.Close()
+
+	conn, err = dial(addr, port)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, newV1Transport(conn, conn, magic), nil
+}
+
+// acceptTransport detects the transport the peer on an inbound connection uses. v1 peers start with the header of
+// their version message, whereas v2 peers send an ElligatorSwift encoded public key, which is indistinguishable from
+// random data.
+func acceptTransport(conn net.Conn, magic [magicSize]byte) (transport, error) {
+	r := bufio.NewReader(conn)
+	prefix, err := r.Peek(magicSize + commandSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(prefix[:magicSize], magic[:]) && bytes.Equal(prefix[magicSize:], VersionCmd[:]) {
+		return newV1Transport(r, conn, magic), nil
+	}
+	return newV2Transport(r, conn, magic, false)
+}
+
+func dial(addr netip.Addr, port uint16) (net.Conn, error) {
+	peer := net.JoinHostPort(addr.String(), strconv.Itoa(int(port)))
+	network := "tcp"
+
+	if addr.Is6() {
+		network = "tcp6"
+	}
+
+	var dialer net.Dialer
+	dialer.Timeout = time.Second * 15
+	return dialer.Dial(network, peer)
+}
+
 // Disconnect closes the connection to the host and runs the OnDisconnect handler, if it has been set.
 // The Node instance should be discarded after calling Disconnect.
 func (n *Node) Disconnect() {
@@ -104,8 +259,11 @@ func (n *Node) Disconnect() {
 func (n *Node) Run() {
 	go n.processWrites()
 
+	// compact blocks are only requested explicitly, so the host shouldn't announce new blocks with them
+	n.write(NewSendCmpctMessage(false, compactBlocksVersion))
+
 	for {
-		msg, err := ReadMessage(n.conn)
+		msg, err := n.transport.readMessage()
 		if err != nil {
 			n.disconnect(fmt.Errorf("closing connection to %s. reading message failed: %w", n.peer(), err))
 			return
@@ -118,10 +276,26 @@ func (n *Node) Run() {
 			n.write(msg)
 		case AddrCmd:
 			n.handleAddrMessage(msg)
+		case Addrv2Cmd:
+			n.handleAddrV2Message(msg)
 		case InvCmd:
 			n.handleInvMessage(msg)
 		case BlockCmd:
 			n.handleBlockMessage(msg)
+		case HeadersCmd:
+			n.handleHeadersMessage(msg)
+		case GetdataCmd:
+			n.handleGetDataMessage(msg)
+		case GetheadersCmd, GetblocksCmd:
+			n.handleLocatorMessage(msg)
+		case NotfoundCmd:
+			n.handleNotFoundMessage(msg)
+		case SendcmpctCmd:
+			n.handleSendCmpctMessage(msg)
+		case CmpctblockCmd:
+			n.handleCmpctBlockMessage(msg)
+		case BlocktxnCmd:
+			n.handleBlockTxnMessage(msg)
 		}
 	}
 }
@@ -139,46 +313,44 @@ func (n *Node) GetInventory(invCh chan InvWithSource) {
 	n.invCh = invCh
 }
 
-// GetBlocks requests the blocks with the hashes in the given inventory vector from the connected host. All blocks
-// received by the node, not just those requested in the call, will be sent over the given channel.
-func (n *Node) GetBlocks(inventory []InvVec, ch chan *btc.Block) error {
+// GetBlocks requests the blocks with the hashes in the given inventory vector from the connected host. All requested
+// blocks received by the node, not just those requested in the call, will be sent over the given channel. Blocks that
+// weren't requested are dropped and count towards the misbehavior score of the host.
+func (n *Node) GetBlocks(inventory []InvVec, ch chan BlockWithSource) error {
 	n.blockCh = ch
-	buf := new(bytes.Buffer)
 
-	err := vartypes.WriteAsVarInt(buf, uint64(len(inventory)))
+	payload, err := encodeInventory(inventory)
 	if err != nil {
 		return err
 	}
 
-	for _, item := range inventory {
-		err = binary.Write(buf, binary.LittleEndian, item.Type)
-		if err != nil {
-			return err
-		}
-
-		written, err := buf.Write(item.Hash[:])
-		if err != nil {
-			return err
-		}
-		if written != len(item.Hash) {
-			return io.ErrShortWrite
-		}
-	}
-
-	payload := Payload(buf.Bytes())
 	msg := &Message{
 		Header:  NewHeader(GetdataCmd, payload),
 		Payload: payload,
 	}
-	n.write(msg)
-	return nil
+	n.addRequests(inventory)
+	return n.write(msg)
+}
+
+// GetHeaders requests the headers of the blocks following the most recent block in locator that the host has on its
+// main chain, up to hashStop. All headers received by the node, not just those requested in the call, will be sent over
+// the given channel.
+func (n *Node) GetHeaders(locator []btc.BlockHash, hashStop btc.BlockHash, ch chan HeadersWithSource) error {
+	n.headersCh = ch
+
+	msg, err := NewGetHeadersMessage(locator, hashStop)
+	if err != nil {
+		return err
+	}
+
+	return n.write(msg)
 }
 
 func (n *Node) processWrites() {
 	for {
 		select {
 		case msg := <-n.msgWriteCh:
-			if err := msg.Write(n.conn); err != nil {
+			if err := n.transport.writeMessage(msg); err != nil {
 				n.disconnect(
 					fmt.Errorf(
 						"closing connection to %s. sending '%s' message failed: %w",
@@ -196,8 +368,15 @@ func (n *Node) processWrites() {
 	}
 }
 
-func (n *Node) write(msg *Message) {
-	n.msgWriteCh <- msg
+// write queues msg for sending to the host. It returns ErrDisconnected instead of blocking if the connection has been
+// closed.
+func (n *Node) write(msg *Message) error {
+	select {
+	case n.msgWriteCh <- msg:
+		return nil
+	case <-n.stopWritesCh:
+		return ErrDisconnected
+	}
 }
 
 func (n *Node) handleAddrMessage(msg *Message) {
@@ -225,6 +404,21 @@ func (n *Node) handleAddrMessage(msg *Message) {
 	return
 }
 
+func (n *Node) handleAddrV2Message(msg *Message) {
+	if !n.hasPeersCh() {
+		return
+	}
+
+	peers, err := decodeAddrV2Message(msg.Payload)
+	if err != nil {
+		log.Printf("received corrupt '%s' payload from %s: %v. ignoring message", msg.Command(), n.peer(), err)
+		return
+	}
+
+	n.peersCh <- peers
+	n.setPeersCh(nil)
+}
+
 func (n *Node) handleInvMessage(msg *Message) {
 	inv, err := decodeInvMessage(msg.Payload)
 	if err != nil {
@@ -238,26 +432,54 @@ func (n *Node) handleInvMessage(msg *Message) {
 }
 
 func (n *Node) handleBlockMessage(msg *Message) {
-	if n.blockCh == nil {
+	buf := bytes.NewBuffer(msg.Payload)
+	block, err := btc.DecodeBlock(buf)
+	if err != nil {
+		n.misbehaving(maxMisbehavior, fmt.Sprintf("sent an invalid block: %v", err))
+		return
+	}
+
+	if buf.Len() > 0 {
+		n.misbehaving(maxMisbehavior, fmt.Sprintf("sent a block followed by %d unexpected byte(s)", buf.Len()))
+		return
+	}
+
+	hash, err := block.Hash()
+	if err != nil {
+		log.Printf("received unhashable block from %s: %v", n.peer(), err)
+		return
+	}
+
+	if !n.takeRequest(hash) {
+		n.misbehaving(unsolicitedBlockPenalty, fmt.Sprintf("sent block %s without being asked", hash))
 		return
 	}
 
-	block, err := btc.DecodeBlock(bytes.NewBuffer(msg.Payload))
+	if n.blockCh != nil {
+		n.blockCh <- BlockWithSource{Block: block, Size: len(msg.Payload), Node: n}
+	}
+}
+
+func (n *Node) handleHeadersMessage(msg *Message) {
+	if n.headersCh == nil {
+		return
+	}
+
+	headers, err := decodeHeadersMessage(msg.Payload)
 	if err != nil {
-		log.Printf("received invalid block from %s: %v", n.peer(), err)
+		log.Printf("received invalid headers from %s: %v", n.peer(), err)
 		return
 	}
 
-	n.blockCh <- block
+	n.headersCh <- HeadersWithSource{Headers: headers, Node: n}
 }
 
 func (n *Node) disconnect(err error) {
-	if n.isShuttingDown() {
+	if !atomic.CompareAndSwapInt32(&n.shuttingDown, 0, 1) {
 		return
 	}
 
-	atomic.AddInt32(&n.shuttingDown, 1)
-	n.stopWritesCh <- true
+	close(n.stopWritesCh)
 	n.conn.Close()
 	n.setPeersCh(nil)
 
@@ -280,10 +502,16 @@ func (n *Node) hasPeersCh() bool {
 	return n.peersCh != nil
 }
 
+// servesBlocks returns true if the host offers the services needed to download blocks from it. That is always the case
+// for outbound connections, which are only kept to such hosts.
+func (n *Node) servesBlocks() bool {
+	return n.services&requiredServices == requiredServices
+}
+
 func (n *Node) peer() string {
 	return fmt.Sprintf("%s:%d", n.addr.String(), n.port)
 }
 
 func (n *Node) isShuttingDown() bool {
-	return n.shuttingDown > 0
+	return atomic.LoadInt32(&n.shuttingDown) > 0
 }
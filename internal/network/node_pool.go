@@ -6,41 +6,103 @@ import (
 	"fmt"
 	mapset "github.com/deckarep/golang-set/v2"
 	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/chain"
+	"github.com/haikoschol/btc-node-challenge/internal/utxo"
 	"github.com/haikoschol/btc-node-challenge/internal/vartypes"
 	"io"
 	"log"
+	"net"
 	"net/netip"
 	"os"
 	"path/filepath"
-	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const maxPeerAge = time.Hour * 24 * 10
 
+// requiredServices are the services a node must offer to be added to the pool. Witness is needed to receive blocks
+// including the witness data of their transactions.
+const requiredServices = Network | Witness
+
+// localServices are the services we offer to peers. The blocks needed to disconnect the most recent utxo.MaxUndoDepth
+// blocks are kept, which is what NetworkLimited promises.
+const localServices = NetworkLimited | Witness | P2PV2
+
 type NodePool struct {
+	params         *btc.ChainParams
 	minConnections int
 	statePath      string
+	utxoPath       string
 	addrsCh        chan []NetAddr
 	invCh          chan InvWithSource
-	blockCh        chan *btc.Block
+	blockCh        chan BlockWithSource
+	headersCh      chan HeadersWithSource
+	notFoundCh     chan InvWithSource
+	getDataCh      chan InvWithSource
+	locatorCh      chan LocatorRequest
+	generateCh     chan generateRequest
 	getAddrPending bool
 	peerAddrs      mapset.Set[NetAddr]
 	nodes          mapset.Set[*Node]
-	blockHashes    mapset.Set[btc.BlockHash]
+	blocksByHash   map[btc.BlockHash]*btc.Block
 	blocks         []*btc.Block
+	utxos          *utxo.Set
 	shutdownCh     chan bool
 	errorCh        chan error
+	reorgCh        chan ReorgEvent
+
+	syncState        syncState
+	syncNode         *Node
+	lastSyncProgress time.Time
+	index            *chain.BlockIndex
+	nextBlockHeight  int32
+	downloads        *downloadScheduler
+	txPool           *TxPool
+
+	initialBlockDownload bool
+	lastFlush            time.Time
+
+	listener net.Listener
+	// inbound is the number of inbound connections, including those still performing the handshake
+	inbound int32
 }
 
-func NewNodePool(addr netip.Addr, port uint16, minConnections int, statePath string) (*NodePool, error) {
-	blocks, blockHashes, err := loadState(statePath)
+func NewNodePool(
+	params *btc.ChainParams,
+	addr netip.Addr,
+	port uint16,
+	minConnections int,
+	statePath, utxoPath string,
+) (*NodePool, error) {
+	blocks, blocksByHash, err := loadState(statePath)
 	if err != nil {
 		return nil, err
 	}
 
-	node, err := Connect(addr, port, Network)
+	utxos, err := utxo.Load(utxoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	genesis := params.GenesisBlock
+	index, err := chain.NewBlockIndex(&genesis.Header, params.Consensus)
+	if err != nil {
+		return nil, err
+	}
+	index.SetCheckpoints(params.Checkpoints)
+
+	// the genesis block is connected to the UTXO set without being downloaded. it isn't written to the state file.
+	blocksByHash[index.Genesis().Hash] = genesis
+
+	for _, block := range blocks {
+		if _, err := index.AddHeader(&block.Header); err != nil {
+			return nil, err
+		}
+	}
+
+	node, err := Connect(params, addr, port, localServices, requiredServices)
 	if err != nil {
 		return nil, err
 	}
@@ -49,32 +111,46 @@ func NewNodePool(addr netip.Addr, port uint16, minConnections int, statePath str
 	nodes.Add(node)
 
 	pool := &NodePool{
+		params:         params,
 		minConnections: minConnections,
 		statePath:      statePath,
+		utxoPath:       utxoPath,
 		addrsCh:        make(chan []NetAddr, 1),
 		invCh:          make(chan InvWithSource, minConnections), // TODO figure out what the size should be
-		blockCh:        make(chan *btc.Block, 100),               // TODO figure out what the size should be
+		blockCh:        make(chan BlockWithSource, 100),          // TODO figure out what the size should be
+		headersCh:      make(chan HeadersWithSource, minConnections),
+		notFoundCh:     make(chan InvWithSource, minConnections),
+		getDataCh:      make(chan InvWithSource, minConnections),
+		locatorCh:      make(chan LocatorRequest, minConnections),
+		generateCh:     make(chan generateRequest),
 		getAddrPending: false,
 		peerAddrs:      mapset.NewSet[NetAddr](),
 		nodes:          nodes,
-		blockHashes:    blockHashes,
+		blocksByHash:   blocksByHash,
 		blocks:         blocks,
+		utxos:          utxos,
 		shutdownCh:     make(chan bool, 1),
 		errorCh:        make(chan error, 1),
-	}
+		reorgCh:        make(chan ReorgEvent, reorgBufferSize),
+		index:          index,
+		downloads:      newDownloadScheduler(),
+		txPool:         NewTxPool(),
 
-	node.OnDisconnect = func() {
-		pool.nodes.Remove(node)
-	}
-	node.OnError = func(err error) {
-		log.Println(err)
-		pool.nodes.Remove(node)
+		initialBlockDownload: true,
+		lastFlush:            time.Now(),
 	}
 
+	pool.setCallbacks(node)
+
+	// the genesis block on the first start. blocks stored after the UTXO set was last saved can only be connected once
+	// the header chain has been downloaded again.
+	pool.connectBlocks()
+
 	go node.Run()
+	pool.startHeaderSync(node)
 	go pool.run()
 	node.FindPeers(pool.addrsCh)
-	node.GetInventory(pool.invCh)
+	pool.subscribe(node)
 	pool.getAddrPending = true
 	return pool, nil
 }
@@ -83,9 +159,26 @@ func (p *NodePool) Size() int {
 	return p.nodes.Cardinality()
 }
 
+// Listen accepts inbound connections on address (host:port) until the pool shuts down. Connections beyond maxInbound
+// are closed right away. Listen must not be called concurrently with Shutdown.
+func (p *NodePool) Listen(address string, maxInbound int) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	p.listener = listener
+	go p.acceptInbound(listener, maxInbound)
+	return nil
+}
+
 func (p *NodePool) Shutdown() {
 	p.shutdownCh <- true
 
+	if p.listener != nil {
+		p.listener.Close()
+	}
+
 	// need to copy the set to avoid a deadlock when nodes remove themselves in OnDisconnect during iteration
 	s := p.nodes.Clone()
 
@@ -97,6 +190,10 @@ func (p *NodePool) Shutdown() {
 	if err := p.writeState(); err != nil {
 		log.Printf("failed writing state to %s: %v", p.statePath, err)
 	}
+
+	if err := p.utxos.Save(p.utxoPath); err != nil {
+		log.Printf("failed writing UTXO set to %s: %v", p.utxoPath, err)
+	}
 }
 
 func (p *NodePool) Error() chan error {
@@ -118,6 +215,16 @@ func (p *NodePool) run() {
 			p.handleInventory(inv)
 		case block := <-p.blockCh:
 			p.handleBlock(block)
+		case headers := <-p.headersCh:
+			p.handleHeaders(headers)
+		case notFound := <-p.notFoundCh:
+			p.handleNotFound(notFound)
+		case req := <-p.getDataCh:
+			p.handleGetData(req)
+		case req := <-p.locatorCh:
+			p.handleLocatorRequest(req)
+		case req := <-p.generateCh:
+			p.handleGenerate(req)
 		case <-p.shutdownCh:
 			ticker.Stop()
 			return
@@ -133,15 +240,18 @@ func (p *NodePool) handleTick(ticker *time.Ticker) {
 		return
 	}
 
-	lowOnPeerAddrs := p.peerAddrs.Cardinality() <= p.minConnections
-	lowOnConnections := p.Size() < p.minConnections
+	dialable := p.dialablePeerAddrs()
+	lowOnPeerAddrs := dialable <= p.minConnections
+	// inbound connections don't count, so that peers connecting to us can't keep us from choosing our own peers
+	outbound := p.Size() - int(atomic.LoadInt32(&p.inbound))
+	lowOnConnections := outbound < p.minConnections
 
 	if lowOnConnections && !lowOnPeerAddrs {
 		log.Printf(
 			"trying to connect to more nodes. current: %d target: %d peer addresses left to try: %d",
-			p.Size(),
+			outbound,
 			p.minConnections,
-			p.peerAddrs.Cardinality(),
+			dialable,
 		)
 
 		added := p.addConnections()
@@ -156,117 +266,108 @@ func (p *NodePool) handleTick(ticker *time.Ticker) {
 		log.Println("running low on peer addresses. requesting more...")
 		p.getAddrPending = p.requestPeerAddrs()
 	}
+
+	p.checkRequests()
+	p.checkSyncProgress()
+	p.checkInitialBlockDownload()
+
+	if time.Since(p.lastFlush) > flushInterval {
+		p.flushState()
+	}
 }
 
+// handleInventory requests the headers leading up to newly announced blocks. The blocks themselves are requested once
+// their headers connect to the header chain. Announcements are ignored until the initial header and block download is
+// complete.
 func (p *NodePool) handleInventory(inv InvWithSource) {
-	request := make([]InvVec, 0)
+	if p.syncState != syncDone {
+		return
+	}
 
 	for _, item := range inv.Inventory {
 		isBlock := item.Type == MsgBlock || item.Type == MsgWitnessBlock
+		known := p.index.Contains(item.Hash) || p.index.IsOrphan(item.Hash)
 
-		if isBlock && !p.blockHashes.Contains(item.Hash) {
-			log.Printf("requesting block %s from %s", item.Hash.String(), inv.Node.peer())
-			request = append(request, item)
+		if isBlock && !known && p.blocksByHash[item.Hash] == nil {
+			log.Printf("requesting headers for block %s from %s", item.Hash.String(), inv.Node.peer())
+			p.requestHeaders(inv.Node)
+			return
 		}
 	}
-
-	if len(request) == 0 {
-		return
-	}
-
-	err := inv.Node.GetBlocks(request, p.blockCh)
-	if err != nil {
-		log.Printf("failed requesting blocks from %s", inv.Node.peer())
-	}
 }
 
-func (p *NodePool) handleBlock(block *btc.Block) {
+func (p *NodePool) handleBlock(msg BlockWithSource) {
+	block := msg.Block
 	hash, err := block.Hash()
 	if err != nil {
 		log.Println("unhashable block is unhashable", err)
 		return
 	}
 
-	if p.blockHashes.Contains(hash) {
+	if err := p.checkBlock(block); err != nil {
+		// the peer might have mutated the transactions of a valid block, so the block is requested from other peers
+		p.downloads.release(hash, msg.Node)
+		msg.Node.misbehaving(maxMisbehavior, fmt.Sprintf("sent invalid block %s: %v", hash, err))
+		p.requestNextBlocks()
 		return
 	}
 
-	log.Println("received block", hash.String())
-	p.blockHashes.Add(hash)
-	p.blocks = append(p.blocks, block)
+	requested := p.downloads.delivered(hash, msg.Node, msg.Size)
 
-	// TODO requesting n blocks as soon as one block is received is way too aggressive
-	missing := p.checkChain()
-	if len(missing) > 0 {
-		missing = missing[:1]
+	if p.blocksByHash[hash] != nil {
+		return
 	}
-	log.Printf("requesting %d missing block(s)", len(missing))
-	p.requestBlocks(missing)
-	log.Printf("got %d blocks in total so far", len(p.blocks))
-}
 
-func (p *NodePool) checkChain() (missing []btc.BlockHash) {
-	// oldest block first
-	sort.Sort(btc.BlocksByTimestamp(p.blocks))
-	//zeroHash := btc.BlockHash{}
+	if !p.initialBlockDownload {
+		log.Println("received block", hash.String())
+	}
+	p.blocksByHash[hash] = block
+	p.blocks = append(p.blocks, block)
+	p.connectBlocks()
 
-	for i := len(p.blocks) - 1; i > 0; i-- {
-		current := p.blocks[i]
-		prev := p.blocks[i-1]
-		prevHash, err := prev.Hash()
-		if err != nil {
-			log.Println("unhashable header is unhashable", err)
-			return
-		}
+	if requested {
+		p.requestNextBlocks()
+		return
+	}
 
-		currentHash, err := current.Hash()
-		if err != nil {
-			log.Println("unhashable header is unhashable", err)
-			return
-		}
-		if current.Header.PrevBlock != prevHash {
-			log.Printf(
-				"gap between blocks %s (timestamp: %d) and %s (timestamp: %d)",
-				prevHash,
-				prev.Header.Timestamp,
-				currentHash,
-				current.Header.Timestamp,
-			)
-			missing = append(missing, current.Header.PrevBlock)
-		} else {
-			log.Printf(
-				"consecutive blocks found. parent: %s (timestamp: %d) child: %s (timestamp: %d)",
-				prevHash,
-				prev.Header.Timestamp,
-				currentHash,
-				current.Header.Timestamp,
-			)
-		}
+	// a block that arrived after its request expired. its header might extend the header chain or be an orphan, in which
+	// case we need the headers leading up to it.
+	if p.addHeaders([]*btc.Header{&block.Header}, msg.Node) > 0 {
+		p.resumeBlockDownload()
+	}
+	log.Printf("got %d blocks in total so far", len(p.blocks))
+}
 
-		//if i == 1 && prev.Header.PrevBlock != zeroHash && !p.blockHashes.Contains(prev.Header.PrevBlock) {
-		//	missing = append(missing, prev.Header.PrevBlock)
-		//}
+// checkBlock performs the checks of a block that don't depend on its position in the chain.
+func (p *NodePool) checkBlock(block *btc.Block) error {
+	if err := block.Header.CheckProofOfWork(p.params.Consensus.PowLimit); err != nil {
+		return err
 	}
-	return
+	return btc.CheckBlock(block)
 }
 
-func (p *NodePool) requestBlocks(hashes []btc.BlockHash) {
+// requestBlocksFrom requests blocks from node. Once the initial block download is complete, new blocks are requested as
+// compact blocks from nodes that support them, but only while the transaction pool holds transactions. The pool is only
+// filled with the transactions of blocks disconnected in a reorg, so otherwise nearly every transaction of a compact
+// block would have to be requested with 'getblocktxn', which takes longer than downloading the full block.
+func (p *NodePool) requestBlocksFrom(node *Node, hashes []btc.BlockHash) {
+	objectType := MsgWitnessBlock
+	if !p.initialBlockDownload && node.supportsCompactBlocks() && p.txPool.Len() > 0 {
+		objectType = MsgCmpctBlock
+	}
+
 	invs := make([]InvVec, len(hashes))
 	for i, hash := range hashes {
 		invs[i] = InvVec{
-			Type: MsgBlock,
+			Type: objectType,
 			Hash: hash,
 		}
 	}
 
-	p.nodes.Each(func(n *Node) bool {
-		err := n.GetBlocks(invs, p.blockCh)
-		if err != nil {
-			log.Printf("requesting %d block(s) from %s failed: %v", len(invs), n.peer(), err)
-			return false
-		}
-		return true
-	})
+	err := node.GetBlocks(invs, p.blockCh)
+	if err != nil {
+		log.Printf("requesting %d block(s) from %s failed: %v", len(invs), node.peer(), err)
+	}
 }
 
 func (p *NodePool) addPeerAddrs(addrs []NetAddr) {
@@ -302,42 +403,128 @@ func (p *NodePool) addConnections() int {
 }
 
 func (p *NodePool) connect(peer NetAddr) (*Node, error) {
-	addr := netip.AddrFrom16(peer.IPAddr).Unmap()
-	n, err := Connect(addr, peer.Port, Network)
+	addr, ok := peer.Addr.IP()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnreachableAddr, peer.Addr)
+	}
+
+	n, err := Connect(p.params, addr, peer.Port, localServices, requiredServices)
 	if err != nil {
 		return nil, err
 	}
 
-	n.OnDisconnect = func() {
+	p.setCallbacks(n)
+	p.nodes.Add(n)
+	p.subscribe(n)
+	return n, nil
+}
+
+// acceptInbound adds the nodes connecting through listener to the pool as long as there are free inbound slots.
+func (p *NodePool) acceptInbound(listener net.Listener, maxInbound int) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("accepting inbound connections failed: %v", err)
+			}
+			return
+		}
+
+		if int(atomic.AddInt32(&p.inbound, 1)) > maxInbound {
+			atomic.AddInt32(&p.inbound, -1)
+			conn.Close()
+			continue
+		}
+
+		go func() {
+			n, err := Accept(p.params, conn, localServices)
+			if err != nil {
+				atomic.AddInt32(&p.inbound, -1)
+				return
+			}
+
+			log.Printf("accepted inbound connection from %s", n.peer())
+			p.setCallbacks(n)
+			p.nodes.Add(n)
+			p.subscribe(n)
+			go n.Run()
+		}()
+	}
+}
+
+// setCallbacks makes n remove itself from the pool when it disconnects.
+func (p *NodePool) setCallbacks(n *Node) {
+	remove := func() {
 		p.nodes.Remove(n)
+		if n.inbound {
+			atomic.AddInt32(&p.inbound, -1)
+		}
 	}
+
+	n.OnDisconnect = remove
 	n.OnError = func(err error) {
 		log.Println(err)
-		p.nodes.Remove(n)
+		remove()
 	}
+}
 
-	p.nodes.Add(n)
+// subscribe makes n deliver the messages the pool handles.
+func (p *NodePool) subscribe(n *Node) {
 	n.GetInventory(p.invCh)
-	return n, nil
+	n.GetNotFound(p.notFoundCh)
+	n.ServeRequests(p.getDataCh, p.locatorCh)
+	n.SetTxPool(p.txPool)
+}
+
+// blockSources returns the connected nodes we can download blocks from.
+func (p *NodePool) blockSources() []*Node {
+	var sources []*Node
+	p.nodes.Each(func(n *Node) bool {
+		if n.servesBlocks() {
+			sources = append(sources, n)
+		}
+		return false
+	})
+	return sources
 }
 
+// getPeerBatch removes addresses we can connect to from the set of peer addresses and returns them. Addresses on
+// networks we can't reach yet (Tor, I2P, CJDNS) stay in the set. Stale addresses are dropped.
 func (p *NodePool) getPeerBatch() (batch []NetAddr) {
 	batchSize := p.minConnections * 4
 
-	for len(batch) < batchSize && p.peerAddrs.Cardinality() > 0 {
-		peer, ok := p.peerAddrs.Pop()
-		if !ok {
+	for _, peer := range p.peerAddrs.ToSlice() {
+		if len(batch) >= batchSize {
 			return
 		}
 
 		if time.Since(time.Unix(int64(peer.Time), 0)) > maxPeerAge {
+			p.peerAddrs.Remove(peer)
+			continue
+		}
+
+		if _, ok := peer.Addr.IP(); !ok {
 			continue
 		}
+
+		p.peerAddrs.Remove(peer)
 		batch = append(batch, peer)
 	}
 	return
 }
 
+// dialablePeerAddrs returns the number of peer addresses we can connect to.
+func (p *NodePool) dialablePeerAddrs() int {
+	count := 0
+	p.peerAddrs.Each(func(peer NetAddr) bool {
+		if _, ok := peer.Addr.IP(); ok {
+			count++
+		}
+		return false
+	})
+	return count
+}
+
 func (p *NodePool) requestPeerAddrs() bool {
 	node, ok := p.nodes.Pop()
 	if !ok {
@@ -349,6 +536,22 @@ func (p *NodePool) requestPeerAddrs() bool {
 	return true
 }
 
+// pickNode returns a connected node we can download blocks from other than exclude, if there is one. Otherwise it
+// returns exclude, if it is still connected.
+func (p *NodePool) pickNode(exclude *Node) (*Node, bool) {
+	var picked *Node
+
+	p.nodes.Each(func(n *Node) bool {
+		if !n.servesBlocks() {
+			return false
+		}
+		picked = n
+		return n != exclude
+	})
+
+	return picked, picked != nil
+}
+
 func (p *NodePool) isShuttingDown() bool {
 	select {
 	case <-p.shutdownCh:
@@ -359,10 +562,6 @@ func (p *NodePool) isShuttingDown() bool {
 }
 
 func (p *NodePool) writeState() error {
-	if len(p.blocks) == 0 {
-		return nil
-	}
-
 	tmpFile, err := os.CreateTemp(filepath.Dir(p.statePath), "state.*.bin")
 	if err != nil {
 		return err
@@ -400,12 +599,12 @@ func (p *NodePool) writeState() error {
 	return os.Rename(tmpFile.Name(), p.statePath)
 }
 
-func loadState(statePath string) ([]*btc.Block, mapset.Set[btc.BlockHash], error) {
-	hashes := mapset.NewSet[btc.BlockHash]()
+func loadState(statePath string) ([]*btc.Block, map[btc.BlockHash]*btc.Block, error) {
+	blocksByHash := make(map[btc.BlockHash]*btc.Block)
 
 	file, err := os.Open(statePath)
 	if os.IsNotExist(err) {
-		return []*btc.Block{}, hashes, nil
+		return []*btc.Block{}, blocksByHash, nil
 	} else if err != nil {
 		return nil, nil, err
 	}
@@ -434,8 +633,8 @@ func loadState(statePath string) ([]*btc.Block, mapset.Set[btc.BlockHash], error
 		if err != nil {
 			return nil, nil, fmt.Errorf("corrupt block data in state file at %s", statePath)
 		}
-		hashes.Add(hash)
+		blocksByHash[hash] = block
 	}
 
-	return blocks, hashes, nil
+	return blocks, blocksByHash, nil
 }
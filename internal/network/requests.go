@@ -0,0 +1,105 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxMisbehavior is the misbehavior score at which the connection to a peer is closed.
+	maxMisbehavior = 100
+	// unsolicitedBlockPenalty is added to the misbehavior score of a peer for every block it sends without being asked.
+	unsolicitedBlockPenalty = 20
+	// getdataTimeout is the time after which a peer is disconnected if it neither delivered a requested block nor
+	// responded with 'notfound'. It is much longer than blockRequestTimeout, so that the download scheduler has
+	// already asked other peers for the blocks.
+	getdataTimeout = time.Minute * 2
+)
+
+var (
+	ErrMisbehavior    = errors.New("peer misbehaved")
+	ErrGetdataTimeout = errors.New("getdata request timed out")
+)
+
+// GetNotFound sets the channel on which the items of 'notfound' messages are sent. Only items that have been requested
+// from the host and not been received are sent.
+func (n *Node) GetNotFound(ch chan InvWithSource) {
+	n.notFoundCh = ch
+}
+
+// addRequests records the items of a 'getdata' message, so that the responses can be matched against them.
+func (n *Node) addRequests(inventory []InvVec) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	now := time.Now()
+	for _, item := range inventory {
+		n.requests[item.Hash] = now
+	}
+}
+
+// takeRequest removes the request for the item with the given hash and returns whether there was one.
+func (n *Node) takeRequest(hash btc.BlockHash) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if _, ok := n.requests[hash]; !ok {
+		return false
+	}
+
+	delete(n.requests, hash)
+	return true
+}
+
+// checkRequests closes the connection if the host didn't deliver a requested item within getdataTimeout of its request.
+// Each item expires on its own, so answering other requests doesn't keep a request the host ignores alive. Expired
+// requests are removed.
+func (n *Node) checkRequests() {
+	n.lock.Lock()
+	expired := 0
+	for hash, requested := range n.requests {
+		if time.Since(requested) > getdataTimeout {
+			delete(n.requests, hash)
+			expired++
+		}
+	}
+	n.lock.Unlock()
+
+	if expired > 0 {
+		n.disconnect(fmt.Errorf("closing connection to %s. %d item(s) not delivered: %w", n.peer(), expired, ErrGetdataTimeout))
+	}
+}
+
+// misbehaving adds penalty to the misbehavior score of the host and closes the connection once it reaches
+// maxMisbehavior.
+func (n *Node) misbehaving(penalty int32, reason string) {
+	score := atomic.AddInt32(&n.misbehavior, penalty)
+	log.Printf("%s %s. misbehavior score: %d", n.peer(), reason, score)
+
+	if score >= maxMisbehavior {
+		n.disconnect(fmt.Errorf("closing connection to %s: %w", n.peer(), ErrMisbehavior))
+	}
+}
+
+func (n *Node) handleNotFoundMessage(msg *Message) {
+	inv, err := decodeInvMessage(msg.Payload)
+	if err != nil {
+		log.Println(n.peer(), err)
+		return
+	}
+
+	var missing []InvVec
+	for _, item := range inv.Inventory {
+		if n.takeRequest(item.Hash) {
+			missing = append(missing, item)
+		}
+	}
+
+	if len(missing) > 0 && n.notFoundCh != nil {
+		n.notFoundCh <- InvWithSource{Inventory: missing, Node: n}
+	}
+}
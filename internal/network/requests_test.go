@@ -0,0 +1,143 @@
+package network
+
+import (
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestNode(t *testing.T) (*Node, chan BlockWithSource, *error) {
+	t.Helper()
+
+	local, peer := net.Pipe()
+	t.Cleanup(func() { peer.Close() })
+
+	var disconnectErr error
+	node := &Node{
+		conn:         local,
+		blockCh:      make(chan BlockWithSource, 10),
+		stopWritesCh: make(chan struct{}),
+		msgWriteCh:   make(chan *Message, 5),
+		requests:     make(map[btc.BlockHash]time.Time),
+		OnError:      func(err error) { disconnectErr = err },
+
+		partialBlocks: make(map[btc.BlockHash]*partialBlock),
+	}
+	return node, node.blockCh, &disconnectErr
+}
+
+func blockMessage(t *testing.T, block *btc.Block) *Message {
+	t.Helper()
+
+	encoded, err := block.Encode()
+	require.NoError(t, err)
+
+	payload := Payload(encoded)
+	return &Message{Header: NewHeader(BlockCmd, payload), Payload: payload}
+}
+
+func TestRequestTracking(t *testing.T) {
+	block := mineBlock(t, nil, 0, 50*btc.SatoshisPerBitcoin)
+	hash, err := block.Hash()
+	require.NoError(t, err)
+
+	t.Run("requested blocks are forwarded once", func(t *testing.T) {
+		node, blockCh, disconnectErr := newTestNode(t)
+		node.addRequests([]InvVec{{Type: MsgWitnessBlock, Hash: hash}})
+
+		node.handleBlockMessage(blockMessage(t, block))
+		require.Len(t, blockCh, 1)
+		received := <-blockCh
+		assert.Same(t, node, received.Node)
+		receivedHash, err := received.Block.Hash()
+		require.NoError(t, err)
+		assert.Equal(t, hash, receivedHash)
+
+		node.handleBlockMessage(blockMessage(t, block))
+		assert.Empty(t, blockCh, "the second copy wasn't requested")
+		assert.Equal(t, int32(unsolicitedBlockPenalty), node.misbehavior)
+		assert.NoError(t, *disconnectErr)
+	})
+
+	t.Run("unsolicited blocks lead to a disconnect", func(t *testing.T) {
+		node, blockCh, disconnectErr := newTestNode(t)
+
+		for i := 0; i < maxMisbehavior/unsolicitedBlockPenalty; i++ {
+			node.handleBlockMessage(blockMessage(t, block))
+		}
+		assert.Empty(t, blockCh)
+		assert.ErrorIs(t, *disconnectErr, ErrMisbehavior)
+	})
+
+	t.Run("trailing bytes", func(t *testing.T) {
+		node, blockCh, disconnectErr := newTestNode(t)
+		node.addRequests([]InvVec{{Type: MsgWitnessBlock, Hash: hash}})
+
+		msg := blockMessage(t, block)
+		msg.Payload = append(msg.Payload, 0x00)
+		node.handleBlockMessage(msg)
+		assert.Empty(t, blockCh)
+		assert.ErrorIs(t, *disconnectErr, ErrMisbehavior)
+	})
+
+	t.Run("notfound", func(t *testing.T) {
+		node, _, _ := newTestNode(t)
+		notFoundCh := make(chan InvWithSource, 1)
+		node.GetNotFound(notFoundCh)
+		node.addRequests([]InvVec{{Type: MsgWitnessBlock, Hash: hash}})
+
+		items := []InvVec{{Type: MsgWitnessBlock, Hash: hash}, {Type: MsgWitnessBlock, Hash: btc.BlockHash{1}}}
+		payload, err := encodeInventory(items)
+		require.NoError(t, err)
+		node.handleNotFoundMessage(&Message{Header: NewHeader(NotfoundCmd, payload), Payload: payload})
+
+		require.Len(t, notFoundCh, 1)
+		assert.Equal(t, items[:1], (<-notFoundCh).Inventory, "items that weren't requested are ignored")
+		assert.Empty(t, node.requests)
+	})
+
+	t.Run("requests time out", func(t *testing.T) {
+		node, _, disconnectErr := newTestNode(t)
+		node.addRequests([]InvVec{{Type: MsgWitnessBlock, Hash: hash}})
+
+		node.checkRequests()
+		assert.NoError(t, *disconnectErr)
+
+		node.requests[hash] = time.Now().Add(-getdataTimeout - time.Second)
+		node.checkRequests()
+		assert.ErrorIs(t, *disconnectErr, ErrGetdataTimeout)
+	})
+
+	t.Run("responses to other requests don't extend the deadline", func(t *testing.T) {
+		node, _, disconnectErr := newTestNode(t)
+		other := btc.BlockHash{1}
+		node.addRequests([]InvVec{{Type: MsgWitnessBlock, Hash: hash}, {Type: MsgWitnessBlock, Hash: other}})
+		node.requests[hash] = time.Now().Add(-getdataTimeout - time.Second)
+
+		assert.True(t, node.takeRequest(other))
+		node.checkRequests()
+		assert.ErrorIs(t, *disconnectErr, ErrGetdataTimeout)
+		assert.Empty(t, node.requests, "expired requests are removed")
+	})
+}
+
+func TestCheckpointViolation(t *testing.T) {
+	pool := newTestPool(t, &btc.RegTestGenesisBlock)
+	blocks := mineChain(t, &btc.RegTestGenesisBlock, 2, 0)
+	fork := mineChain(t, &btc.RegTestGenesisBlock, 2, 100)
+
+	checkpoint, err := blocks[1].Hash()
+	require.NoError(t, err)
+	pool.index.SetCheckpoints([]btc.Checkpoint{{Height: 2, Hash: checkpoint}})
+
+	node, _, disconnectErr := newTestNode(t)
+	added := pool.addHeaders([]*btc.Header{&fork[0].Header, &fork[1].Header}, node)
+	assert.Equal(t, 1, added)
+	assert.ErrorIs(t, *disconnectErr, ErrMisbehavior)
+
+	assert.Equal(t, 2, pool.addHeaders([]*btc.Header{&blocks[0].Header, &blocks[1].Header}, nil))
+	assert.Equal(t, checkpoint, pool.index.Best().Hash)
+}
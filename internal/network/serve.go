@@ -0,0 +1,260 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/chain"
+	"log"
+)
+
+const (
+	// maxGetDataSize is the maximum number of items a peer may request in a single 'getdata' message.
+	maxGetDataSize = 50000
+	// maxBlocksResults is the maximum number of block hashes sent in response to a 'getblocks' message.
+	maxBlocksResults = 500
+	// maxQueuedResponses is the number of requests of a peer that can wait for an answer. Further requests are dropped.
+	maxQueuedResponses = 100
+)
+
+// servedBlock is a block requested by a peer, together with the serialization it asked for.
+type servedBlock struct {
+	block   *btc.Block
+	witness bool
+}
+
+// ServeRequests sets the channels on which the items of 'getdata' messages and the locators of 'getheaders' and
+// 'getblocks' messages received from the host are sent.
+func (n *Node) ServeRequests(getDataCh chan InvWithSource, locatorCh chan LocatorRequest) {
+	n.getDataCh = getDataCh
+	n.locatorCh = locatorCh
+}
+
+func (n *Node) handleGetDataMessage(msg *Message) {
+	if n.getDataCh == nil {
+		return
+	}
+
+	inv, err := decodeInvMessage(msg.Payload)
+	if err != nil {
+		log.Printf("received invalid '%s' message from %s: %v", msg.Command(), n.peer(), err)
+		return
+	}
+
+	if len(inv.Inventory) > maxGetDataSize {
+		n.misbehaving(maxMisbehavior, fmt.Sprintf("requested %d items in one 'getdata' message", len(inv.Inventory)))
+		return
+	}
+
+	n.getDataCh <- InvWithSource{Inventory: inv.Inventory, Node: n}
+}
+
+func (n *Node) handleLocatorMessage(msg *Message) {
+	if n.locatorCh == nil {
+		return
+	}
+
+	locator, hashStop, err := decodeLocatorMessage(msg.Payload)
+	if err != nil {
+		log.Printf("received invalid '%s' message from %s: %v", msg.Command(), n.peer(), err)
+		return
+	}
+
+	n.locatorCh <- LocatorRequest{Command: msg.Header.Command, Locator: locator, HashStop: hashStop, Node: n}
+}
+
+// serve queues the response to a request of the host. The responses are sent in order by a single goroutine per node,
+// so that serializing blocks doesn't hold up the pool. The goroutine exits once the queue is empty or the connection
+// has been closed.
+func (n *Node) serve(respond func() error) {
+	n.serveLock.Lock()
+	defer n.serveLock.Unlock()
+
+	if len(n.responses) >= maxQueuedResponses {
+		log.Printf("%s has too many unanswered requests. dropping request", n.peer())
+		return
+	}
+
+	n.responses = append(n.responses, respond)
+	if !n.serving {
+		n.serving = true
+		go n.processResponses()
+	}
+}
+
+func (n *Node) processResponses() {
+	for {
+		n.serveLock.Lock()
+		if len(n.responses) == 0 || n.isShuttingDown() {
+			n.responses = nil
+			n.serving = false
+			n.serveLock.Unlock()
+			return
+		}
+
+		respond := n.responses[0]
+		n.responses = n.responses[1:]
+		n.serveLock.Unlock()
+
+		if err := respond(); err != nil && !errors.Is(err, ErrDisconnected) {
+			log.Printf("failed answering request of %s: %v", n.peer(), err)
+		}
+	}
+}
+
+// serveBlocks sends the blocks to the host, followed by a 'notfound' message for the items in notFound, if there are
+// any.
+func (n *Node) serveBlocks(blocks []servedBlock, notFound []InvVec) error {
+	for _, served := range blocks {
+		encode := served.block.Encode
+		if !served.witness {
+			encode = served.block.EncodeWithoutWitness
+		}
+
+		payload, err := encode()
+		if err != nil {
+			log.Printf("failed serializing block for %s: %v", n.peer(), err)
+			continue
+		}
+		if err := n.write(&Message{Header: NewHeader(BlockCmd, payload), Payload: payload}); err != nil {
+			return err
+		}
+	}
+
+	if len(notFound) == 0 {
+		return nil
+	}
+
+	payload, err := encodeInventory(notFound)
+	if err != nil {
+		return err
+	}
+	return n.write(&Message{Header: NewHeader(NotfoundCmd, payload), Payload: payload})
+}
+
+// handleGetData answers a 'getdata' message with the requested blocks of the active chain. MsgBlock asks for blocks
+// without witness data. Compact blocks are answered with full blocks, which BIP152 allows for blocks that aren't
+// recent. Blocks we don't have, including those already pruned, and all other types of items are answered with
+// 'notfound'.
+func (p *NodePool) handleGetData(req InvWithSource) {
+	tip, _ := p.utxoTip()
+
+	var blocks []servedBlock
+	var notFound []InvVec
+
+	for _, item := range req.Inventory {
+		isBlock := item.Type == MsgBlock || item.Type == MsgWitnessBlock || item.Type == MsgCmpctBlock
+		block := p.activeBlock(tip, item.Hash)
+
+		if !isBlock || block == nil {
+			notFound = append(notFound, item)
+			continue
+		}
+		blocks = append(blocks, servedBlock{block: block, witness: item.Type != MsgBlock})
+	}
+
+	req.Node.serve(func() error { return req.Node.serveBlocks(blocks, notFound) })
+}
+
+// handleLocatorRequest answers 'getheaders' with the headers and 'getblocks' with an 'inv' of the blocks of the active
+// chain following the fork with the chain of the peer described by the locator.
+func (p *NodePool) handleLocatorRequest(req LocatorRequest) {
+	// like Bitcoin Core, don't hand out headers while catching up, so that peers don't sync to an outdated tip
+	if req.Command == GetheadersCmd && p.initialBlockDownload {
+		return
+	}
+
+	tip, ok := p.utxoTip()
+	if !ok || tip == nil {
+		return
+	}
+
+	var msg *Message
+	var err error
+
+	switch req.Command {
+	case GetheadersCmd:
+		var headers []*btc.Header
+		for _, node := range p.chainAfter(tip, req.Locator, req.HashStop, maxHeadersResults) {
+			headers = append(headers, &node.Header)
+		}
+		msg, err = NewHeadersMessage(headers)
+	case GetblocksCmd:
+		var inventory []InvVec
+		for _, node := range p.chainAfter(tip, req.Locator, req.HashStop, maxBlocksResults) {
+			// the peer would have to ask someone else for the block and the ones after it
+			if node.Hash == req.HashStop || p.blocksByHash[node.Hash] == nil {
+				break
+			}
+			inventory = append(inventory, InvVec{Type: MsgBlock, Hash: node.Hash})
+		}
+
+		if len(inventory) == 0 {
+			return
+		}
+
+		var payload Payload
+		payload, err = encodeInventory(inventory)
+		msg = &Message{Header: NewHeader(InvCmd, payload), Payload: payload}
+	default:
+		return
+	}
+
+	if err != nil {
+		log.Printf("failed answering '%s' message from %s: %v", req.Command, req.Node.peer(), err)
+		return
+	}
+	req.Node.serve(func() error { return req.Node.write(msg) })
+}
+
+// activeBlock returns the block with the given hash if it is part of the active chain ending in tip and hasn't been
+// pruned. It returns nil otherwise.
+func (p *NodePool) activeBlock(tip *chain.BlockNode, hash btc.BlockHash) *btc.Block {
+	node, ok := p.index.Lookup(hash)
+	if !ok || !onChain(tip, node) {
+		return nil
+	}
+	return p.blocksByHash[hash]
+}
+
+// chainAfter returns up to limit nodes of the active chain ending in tip that follow the most recent block of locator
+// on it, or the genesis block if there is none. The result ends early with hashStop. An empty locator asks for the
+// block hashStop only.
+func (p *NodePool) chainAfter(
+	tip *chain.BlockNode,
+	locator []btc.BlockHash,
+	hashStop btc.BlockHash,
+	limit int,
+) []*chain.BlockNode {
+	if len(locator) == 0 {
+		node, ok := p.index.Lookup(hashStop)
+		if !ok || !onChain(tip, node) {
+			return nil
+		}
+		return []*chain.BlockNode{node}
+	}
+
+	fork := p.index.Genesis()
+	for _, hash := range locator {
+		if node, ok := p.index.Lookup(hash); ok && onChain(tip, node) {
+			fork = node
+			break
+		}
+	}
+
+	var nodes []*chain.BlockNode
+	for height := fork.Height + 1; height <= tip.Height && len(nodes) < limit; height++ {
+		node := tip.Ancestor(height)
+		nodes = append(nodes, node)
+
+		if node.Hash == hashStop {
+			break
+		}
+	}
+	return nodes
+}
+
+// onChain returns whether node is part of the chain ending in tip.
+func onChain(tip, node *chain.BlockNode) bool {
+	return tip != nil && tip.Ancestor(node.Height) == node
+}
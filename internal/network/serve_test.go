@@ -0,0 +1,271 @@
+package network
+
+import (
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// receive returns the next message the node writes to the host. Responses are written by the serving goroutine of the
+// node.
+func receive(t *testing.T, node *Node) *Message {
+	t.Helper()
+
+	select {
+	case msg := <-node.msgWriteCh:
+		return msg
+	case <-time.After(time.Second):
+		require.FailNow(t, "no message written")
+		return nil
+	}
+}
+
+func blockHash(t *testing.T, block *btc.Block) btc.BlockHash {
+	t.Helper()
+
+	hash, err := block.Hash()
+	require.NoError(t, err)
+	return hash
+}
+
+func TestHandleGetData(t *testing.T) {
+	genesis := mineBlock(t, nil, 0, 50*btc.SatoshisPerBitcoin)
+	pool := newTestPool(t, genesis)
+	blocks := mineChain(t, genesis, 3, 10)
+	addBlocks(t, pool, blocks...)
+	branch := mineBlock(t, genesis, 20, 50*btc.SatoshisPerBitcoin)
+	addBlocks(t, pool, branch)
+	assertUTXOTip(t, pool, blocks[2])
+
+	node, _, _ := newTestNode(t)
+	notFound := []InvVec{
+		{Type: MsgBlock, Hash: blockHash(t, branch)},
+		{Type: MsgBlock, Hash: btc.BlockHash{0x42}},
+		{Type: MsgWitnessTx, Hash: btc.BlockHash{0x43}},
+	}
+	pool.handleGetData(InvWithSource{
+		Inventory: append([]InvVec{
+			{Type: MsgWitnessBlock, Hash: blockHash(t, blocks[0])},
+			{Type: MsgBlock, Hash: blockHash(t, blocks[1])},
+			{Type: MsgCmpctBlock, Hash: blockHash(t, blocks[2])},
+		}, notFound...),
+		Node: node,
+	})
+
+	withWitness, err := blocks[0].Encode()
+	require.NoError(t, err)
+	msg := receive(t, node)
+	assert.Equal(t, BlockCmd, msg.Header.Command)
+	assert.Equal(t, Payload(withWitness), msg.Payload)
+
+	withoutWitness, err := blocks[1].EncodeWithoutWitness()
+	require.NoError(t, err)
+	msg = receive(t, node)
+	assert.Equal(t, BlockCmd, msg.Header.Command)
+	assert.Equal(t, Payload(withoutWitness), msg.Payload)
+
+	full, err := blocks[2].Encode()
+	require.NoError(t, err)
+	msg = receive(t, node)
+	assert.Equal(t, BlockCmd, msg.Header.Command)
+	assert.Equal(t, Payload(full), msg.Payload, "compact blocks are answered with full blocks")
+
+	msg = receive(t, node)
+	assert.Equal(t, NotfoundCmd, msg.Header.Command)
+	inv, err := decodeInvMessage(msg.Payload)
+	require.NoError(t, err)
+	assert.Equal(t, notFound, inv.Inventory)
+}
+
+func TestHandleLocatorRequest(t *testing.T) {
+	genesis := mineBlock(t, nil, 0, 50*btc.SatoshisPerBitcoin)
+	pool := newTestPool(t, genesis)
+	blocks := mineChain(t, genesis, 5, 10)
+	addBlocks(t, pool, blocks...)
+	branch := mineBlock(t, genesis, 20, 50*btc.SatoshisPerBitcoin)
+	addBlocks(t, pool, branch)
+
+	hashes := func(blocks ...*btc.Block) []btc.BlockHash {
+		var result []btc.BlockHash
+		for _, block := range blocks {
+			result = append(result, blockHash(t, block))
+		}
+		return result
+	}
+
+	getHeaders := func(t *testing.T, locator []btc.BlockHash, hashStop btc.BlockHash) []btc.BlockHash {
+		t.Helper()
+
+		node, _, _ := newTestNode(t)
+		pool.handleLocatorRequest(LocatorRequest{Command: GetheadersCmd, Locator: locator, HashStop: hashStop, Node: node})
+
+		msg := receive(t, node)
+		require.Equal(t, HeadersCmd, msg.Header.Command)
+		headers, err := decodeHeadersMessage(msg.Payload)
+		require.NoError(t, err)
+
+		var result []btc.BlockHash
+		for _, header := range headers {
+			hash, err := header.Hash()
+			require.NoError(t, err)
+			result = append(result, hash)
+		}
+		return result
+	}
+
+	getBlocks := func(t *testing.T, locator []btc.BlockHash, hashStop btc.BlockHash) []InvVec {
+		t.Helper()
+
+		node, _, _ := newTestNode(t)
+		pool.handleLocatorRequest(LocatorRequest{Command: GetblocksCmd, Locator: locator, HashStop: hashStop, Node: node})
+
+		msg := receive(t, node)
+		require.Equal(t, InvCmd, msg.Header.Command)
+		inv, err := decodeInvMessage(msg.Payload)
+		require.NoError(t, err)
+		return inv.Inventory
+	}
+
+	t.Run("headers after the fork", func(t *testing.T) {
+		locator := hashes(blocks[1], blocks[0], genesis)
+		assert.Equal(t, hashes(blocks[2:]...), getHeaders(t, locator, btc.BlockHash{}))
+	})
+
+	t.Run("locator without blocks of the active chain", func(t *testing.T) {
+		locator := append(hashes(branch), btc.BlockHash{0x42})
+		assert.Equal(t, hashes(blocks...), getHeaders(t, locator, btc.BlockHash{}))
+	})
+
+	t.Run("headers up to hash stop", func(t *testing.T) {
+		locator := hashes(genesis)
+		assert.Equal(t, hashes(blocks[:3]...), getHeaders(t, locator, blockHash(t, blocks[2])))
+	})
+
+	t.Run("empty locator asks for hash stop", func(t *testing.T) {
+		assert.Equal(t, hashes(blocks[3]), getHeaders(t, nil, blockHash(t, blocks[3])))
+	})
+
+	t.Run("no headers during initial block download", func(t *testing.T) {
+		pool.initialBlockDownload = true
+		defer func() { pool.initialBlockDownload = false }()
+
+		node, _, _ := newTestNode(t)
+		pool.handleLocatorRequest(LocatorRequest{Command: GetheadersCmd, Locator: hashes(genesis), Node: node})
+		assert.Empty(t, node.msgWriteCh)
+	})
+
+	t.Run("block inventory", func(t *testing.T) {
+		inventory := getBlocks(t, hashes(blocks[0], genesis), btc.BlockHash{})
+
+		var expected []InvVec
+		for _, hash := range hashes(blocks[1:]...) {
+			expected = append(expected, InvVec{Type: MsgBlock, Hash: hash})
+		}
+		assert.Equal(t, expected, inventory)
+	})
+
+	t.Run("block inventory stops before hash stop", func(t *testing.T) {
+		inventory := getBlocks(t, hashes(genesis), blockHash(t, blocks[2]))
+		assert.Equal(t, []InvVec{
+			{Type: MsgBlock, Hash: blockHash(t, blocks[0])},
+			{Type: MsgBlock, Hash: blockHash(t, blocks[1])},
+		}, inventory)
+	})
+}
+
+func TestHandleLocatorMessage(t *testing.T) {
+	node, _, _ := newTestNode(t)
+	locatorCh := make(chan LocatorRequest, 1)
+	node.ServeRequests(make(chan InvWithSource, 1), locatorCh)
+
+	locator := []btc.BlockHash{{0x01}, {0x02}}
+	hashStop := btc.BlockHash{0x03}
+
+	msg, err := NewGetHeadersMessage(locator, hashStop)
+	require.NoError(t, err)
+	// 'getblocks' has the same payload as 'getheaders'
+	msg.Header.Command = GetblocksCmd
+	node.handleLocatorMessage(msg)
+
+	require.Len(t, locatorCh, 1)
+	req := <-locatorCh
+	assert.Equal(t, GetblocksCmd, req.Command)
+	assert.Equal(t, locator, req.Locator)
+	assert.Equal(t, hashStop, req.HashStop)
+	assert.Equal(t, node, req.Node)
+
+	t.Run("invalid payload", func(t *testing.T) {
+		msg.Payload = msg.Payload[:len(msg.Payload)-1]
+		node.handleLocatorMessage(msg)
+		assert.Empty(t, locatorCh)
+	})
+}
+
+func TestServe(t *testing.T) {
+	genesis := mineBlock(t, nil, 0, 50*btc.SatoshisPerBitcoin)
+	pool := newTestPool(t, genesis)
+	blocks := mineChain(t, genesis, 3, 10)
+	addBlocks(t, pool, blocks...)
+
+	isServing := func(node *Node) bool {
+		node.serveLock.Lock()
+		defer node.serveLock.Unlock()
+		return node.serving
+	}
+
+	t.Run("responses are sent in order by one goroutine", func(t *testing.T) {
+		node, _, _ := newTestNode(t)
+		for _, block := range blocks {
+			inventory := []InvVec{{Type: MsgWitnessBlock, Hash: blockHash(t, block)}}
+			pool.handleGetData(InvWithSource{Inventory: inventory, Node: node})
+		}
+
+		for _, block := range blocks {
+			encoded, err := block.Encode()
+			require.NoError(t, err)
+			msg := receive(t, node)
+			assert.Equal(t, Payload(encoded), msg.Payload)
+		}
+		assert.Eventually(t, func() bool { return !isServing(node) }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("writes fail after disconnect", func(t *testing.T) {
+		node, _, _ := newTestNode(t)
+		for len(node.msgWriteCh) < cap(node.msgWriteCh) {
+			require.NoError(t, node.write(GetaddrMessage))
+		}
+
+		node.Disconnect()
+		assert.ErrorIs(t, node.write(GetaddrMessage), ErrDisconnected)
+	})
+
+	t.Run("serving stops on disconnect", func(t *testing.T) {
+		node, _, _ := newTestNode(t)
+		// nothing reads the queued messages, so the serving goroutine blocks once msgWriteCh is full
+		inventory := []InvVec{{Type: MsgBlock, Hash: blockHash(t, blocks[0])}}
+		for i := 0; i < 10; i++ {
+			pool.handleGetData(InvWithSource{Inventory: inventory, Node: node})
+		}
+		assert.Eventually(t, func() bool { return len(node.msgWriteCh) == cap(node.msgWriteCh) }, time.Second, 10*time.Millisecond)
+		assert.True(t, isServing(node))
+
+		node.Disconnect()
+		assert.Eventually(t, func() bool { return !isServing(node) }, time.Second, 10*time.Millisecond)
+		assert.Empty(t, node.responses)
+	})
+
+	t.Run("requests beyond the queue limit are dropped", func(t *testing.T) {
+		node, _, _ := newTestNode(t)
+		t.Cleanup(node.Disconnect)
+
+		for i := 0; i < maxQueuedResponses+cap(node.msgWriteCh)+10; i++ {
+			node.serve(func() error { return node.write(GetaddrMessage) })
+		}
+
+		node.serveLock.Lock()
+		defer node.serveLock.Unlock()
+		assert.LessOrEqual(t, len(node.responses), maxQueuedResponses)
+	})
+}
@@ -12,4 +12,6 @@ const (
 	Xthin          Service = 16
 	CompactFilters Service = 32
 	NetworkLimited Service = 64
+	// P2PV2 signals support for the encrypted v2 transport (BIP324).
+	P2PV2 Service = 2048
 )
@@ -0,0 +1,32 @@
+package network
+
+import (
+	"bufio"
+	"io"
+)
+
+// transport frames the messages exchanged with a peer. v1Transport is the plaintext framing of the original protocol,
+// v2Transport the encrypted one of BIP324. A transport may be read from and written to concurrently, but reads and
+// writes must not be concurrent among themselves.
+type transport interface {
+	readMessage() (*Message, error)
+	writeMessage(msg *Message) error
+}
+
+type v1Transport struct {
+	r     io.Reader
+	w     io.Writer
+	magic [magicSize]byte
+}
+
+func newV1Transport(r io.Reader, w io.Writer, magic [magicSize]byte) *v1Transport {
+	return &v1Transport{r: bufio.NewReader(r), w: w, magic: magic}
+}
+
+func (t *v1Transport) readMessage() (*Message, error) {
+	return ReadMessage(t.r, t.magic)
+}
+
+func (t *v1Transport) writeMessage(msg *Message) error {
+	return msg.Write(t.w, t.magic)
+}
@@ -0,0 +1,81 @@
+package network
+
+import (
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"sync"
+)
+
+// TxPool holds unconfirmed transactions, keyed by their witness id. Compact blocks are reconstructed from them, so that
+// only the transactions missing from the pool have to be downloaded. It is safe for concurrent use.
+type TxPool struct {
+	lock sync.RWMutex
+	txs  map[btc.TxHash]*btc.Transaction
+}
+
+func NewTxPool() *TxPool {
+	return &TxPool{txs: make(map[btc.TxHash]*btc.Transaction)}
+}
+
+// Add adds tx to the pool. Coinbase transactions are ignored, because they are only valid in their block.
+func (p *TxPool) Add(tx *btc.Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	wtxid, err := tx.WTxID()
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.txs[wtxid] = tx
+	return nil
+}
+
+// AddBlock adds the transactions of a block that has been disconnected from the chain, since they are likely to be
+// included in a block of the new chain.
+func (p *TxPool) AddBlock(block *btc.Block) error {
+	for i := range block.Transactions {
+		if err := p.Add(&block.Transactions[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveBlock removes the transactions of a block that has been connected to the chain.
+func (p *TxPool) RemoveBlock(block *btc.Block) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if len(p.txs) == 0 {
+		return nil
+	}
+
+	for i := range block.Transactions {
+		wtxid, err := block.Transactions[i].WTxID()
+		if err != nil {
+			return err
+		}
+		delete(p.txs, wtxid)
+	}
+	return nil
+}
+
+// Len returns the number of transactions in the pool.
+func (p *TxPool) Len() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return len(p.txs)
+}
+
+// each calls fn for every transaction in the pool. fn must not modify the pool.
+func (p *TxPool) each(fn func(wtxid btc.TxHash, tx *btc.Transaction)) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	for wtxid, tx := range p.txs {
+		fn(wtxid, tx)
+	}
+}
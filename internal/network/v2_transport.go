@@ -0,0 +1,362 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/haikoschol/btc-node-challenge/internal/secp256k1"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	mathrand "math/rand"
+)
+
+const (
+	// rekeyInterval is the number of packets after which the ciphers of the v2 transport switch to a new key.
+	rekeyInterval = 224
+
+	lengthFieldSize       = 3
+	packetHeaderSize      = 1
+	garbageTerminatorSize = 16
+	maxGarbageSize        = 4095
+	// maxPayloadSize is the largest message payload accepted on the v2 transport.
+	maxPayloadSize = 4 * 1000 * 1000
+
+	// ignoreFlag marks decoy packets in the packet header.
+	ignoreFlag = 0x80
+)
+
+var (
+	ErrV2Handshake   = errors.New("v2 handshake failed")
+	ErrInvalidPacket = errors.New("invalid v2 packet")
+)
+
+// v2MessageTypes are the message types BIP324 assigns one byte ids to. The id of a type is its index. All other
+// message types are sent with their full command.
+var v2MessageTypes = []string{
+	"", "addr", "block", "blocktxn", "cmpctblock", "feefilter", "filteradd", "filterclear", "filterload", "getblocks",
+	"getblocktxn", "getdata", "getheaders", "headers", "inv", "mempool", "merkleblock", "notfound", "ping", "pong",
+	"sendcmpct", "tx", "getcfilters", "cfilter", "getcfheaders", "cfheaders", "getcfcheckpt", "cfcheckpt", "addrv2",
+}
+
+var v2ShortIDs = func() map[Command]byte {
+	ids := make(map[Command]byte)
+	for id, name := range v2MessageTypes[1:] {
+		var cmd Command
+		copy(cmd[:], name)
+		ids[cmd] = byte(id + 1)
+	}
+	return ids
+}()
+
+// v2Transport encrypts messages as described in BIP324. Every packet consists of the encrypted length of its contents
+// followed by the encrypted and authenticated header and contents.
+type v2Transport struct {
+	r     io.Reader
+	w     io.Writer
+	magic [magicSize]byte
+
+	sendL *fsChaCha20
+	sendP *fsChaCha20Poly1305
+	recvL *fsChaCha20
+	recvP *fsChaCha20Poly1305
+
+	// sendAAD and recvAAD are the garbage sent and received during the handshake, which the first packet in each
+	// direction authenticates
+	sendAAD []byte
+	recvAAD []byte
+
+	// sessionID is derived from the shared secret like the keys. Both sides of a connection have the same one, which
+	// allows them to verify out of band that there is no man in the middle.
+	sessionID [32]byte
+}
+
+// newV2Transport performs the key exchange of BIP324 on rw. The initiator of the connection sends its public key first.
+// The version packets of both sides are exchanged before returning, so a successful return means that the peer
+// supports the v2 transport and knows the keys.
+func newV2Transport(r io.Reader, w io.Writer, magic [magicSize]byte, initiator bool) (*v2Transport, error) {
+	t := &v2Transport{r: bufio.NewReader(r), w: w, magic: magic}
+
+	priv, ours, err := secp256k1.EllSwiftCreate(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	garbage := make([]byte, mathrand.Intn(maxGarbageSize+1))
+	if _, err := rand.Read(garbage); err != nil {
+		return nil, err
+	}
+
+	var theirs [secp256k1.EllSwiftPubKeySize]byte
+	if initiator {
+		if _, err := w.Write(append(ours[:], garbage...)); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(t.r, theirs[:]); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrV2Handshake, err)
+		}
+	} else {
+		if _, err := io.ReadFull(t.r, theirs[:]); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrV2Handshake, err)
+		}
+		if _, err := w.Write(append(ours[:], garbage...)); err != nil {
+			return nil, err
+		}
+	}
+
+	secret, err := secp256k1.EllSwiftECDH(priv, ours, theirs, initiator)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrV2Handshake, err)
+	}
+
+	sendTerminator, recvTerminator := t.initCiphers(secret, initiator)
+	t.sendAAD = garbage
+
+	// the version packet has no contents yet. it is meant for negotiating future extensions of the transport
+	packet := t.encryptPacket(nil, false)
+	if _, err := w.Write(append(sendTerminator, packet...)); err != nil {
+		return nil, err
+	}
+
+	if err := t.skipGarbage(recvTerminator); err != nil {
+		return nil, err
+	}
+
+	// the contents of the version packet are ignored, as required for forward compatibility
+	for {
+		_, ignore, err := t.readPacket()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrV2Handshake, err)
+		}
+		if !ignore {
+			return t, nil
+		}
+	}
+}
+
+// initCiphers derives the keys of both directions from the ECDH secret and returns the garbage terminators to send and
+// to expect.
+func (t *v2Transport) initCiphers(secret [32]byte, initiator bool) (sendTerminator, recvTerminator []byte) {
+	prk := hkdf.Extract(sha256.New, secret[:], append([]byte("bitcoin_v2_shared_secret"), t.magic[:]...))
+	expand := func(info string) [32]byte {
+		var key [32]byte
+		// reading 32 bytes from HKDF-SHA256 can't fail
+		_, _ = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte(info)), key[:])
+		return key
+	}
+
+	initiatorL, initiatorP := expand("initiator_L"), expand("initiator_P")
+	responderL, responderP := expand("responder_L"), expand("responder_P")
+	terminators := expand("garbage_terminators")
+	t.sessionID = expand("session_id")
+
+	if initiator {
+		t.sendL, t.sendP = &fsChaCha20{key: initiatorL}, &fsChaCha20Poly1305{key: initiatorP}
+		t.recvL, t.recvP = &fsChaCha20{key: responderL}, &fsChaCha20Poly1305{key: responderP}
+		return terminators[:garbageTerminatorSize], terminators[garbageTerminatorSize:]
+	}
+
+	t.sendL, t.sendP = &fsChaCha20{key: responderL}, &fsChaCha20Poly1305{key: responderP}
+	t.recvL, t.recvP = &fsChaCha20{key: initiatorL}, &fsChaCha20Poly1305{key: initiatorP}
+	return terminators[garbageTerminatorSize:], terminators[:garbageTerminatorSize]
+}
+
+// skipGarbage reads the garbage the peer sent after its public key up to and including terminator.
+func (t *v2Transport) skipGarbage(terminator []byte) error {
+	received := make([]byte, 0, maxGarbageSize+garbageTerminatorSize)
+	buf := make([]byte, 1)
+
+	for !bytes.HasSuffix(received, terminator) {
+		if len(received) == cap(received) {
+			return fmt.Errorf("%w: garbage terminator not found", ErrV2Handshake)
+		}
+
+		if _, err := io.ReadFull(t.r, buf); err != nil {
+			return fmt.Errorf("%w: %w", ErrV2Handshake, err)
+		}
+		received = append(received, buf[0])
+	}
+
+	t.recvAAD = received[:len(received)-garbageTerminatorSize]
+	return nil
+}
+
+func (t *v2Transport) readMessage() (*Message, error) {
+	for {
+		contents, ignore, err := t.readPacket()
+		if err != nil {
+			return nil, err
+		}
+
+		if ignore {
+			continue
+		}
+
+		if len(contents) == 0 {
+			return nil, fmt.Errorf("%w: no message type", ErrInvalidPacket)
+		}
+
+		var cmd Command
+		if contents[0] == 0 {
+			if len(contents) < 1+commandSize {
+				return nil, fmt.Errorf("%w: truncated message type", ErrInvalidPacket)
+			}
+			copy(cmd[:], contents[1:1+commandSize])
+			contents = contents[1+commandSize:]
+		} else if int(contents[0]) < len(v2MessageTypes) {
+			copy(cmd[:], v2MessageTypes[contents[0]])
+			contents = contents[1:]
+		} else {
+			// unknown short ids may be assigned by future extensions and are ignored
+			continue
+		}
+
+		payload := Payload(contents)
+		header := NewHeader(cmd, payload)
+		header.Magic = t.magic
+		return &Message{Header: header, Payload: payload}, nil
+	}
+}
+
+func (t *v2Transport) writeMessage(msg *Message) error {
+	var contents []byte
+	if id, ok := v2ShortIDs[msg.Header.Command]; ok {
+		contents = append([]byte{id}, msg.Payload...)
+	} else {
+		contents = append(append([]byte{0}, msg.Header.Command[:]...), msg.Payload...)
+	}
+
+	packet := t.encryptPacket(contents, false)
+	if written, err := t.w.Write(packet); err != nil || written != len(packet) {
+		if err == nil {
+			err = io.ErrShortWrite
+		}
+		return err
+	}
+	return nil
+}
+
+// readPacket reads and decrypts the next packet. ignore is true for decoy packets.
+func (t *v2Transport) readPacket() (contents []byte, ignore bool, err error) {
+	var length [lengthFieldSize]byte
+	if _, err := io.ReadFull(t.r, length[:]); err != nil {
+		return nil, false, err
+	}
+
+	t.recvL.crypt(length[:])
+	size := int(length[0]) | int(length[1])<<8 | int(length[2])<<16
+	if size > 1+commandSize+maxPayloadSize {
+		return nil, false, fmt.Errorf("%w: contents of %d bytes", ErrInvalidPacket, size)
+	}
+
+	ciphertext := make([]byte, packetHeaderSize+size+chacha20poly1305.Overhead)
+	if _, err := io.ReadFull(t.r, ciphertext); err != nil {
+		return nil, false, err
+	}
+
+	plaintext, err := t.recvP.open(ciphertext, t.recvAAD)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %w", ErrInvalidPacket, err)
+	}
+	t.recvAAD = nil
+
+	return plaintext[packetHeaderSize:], plaintext[0]&ignoreFlag != 0, nil
+}
+
+func (t *v2Transport) encryptPacket(contents []byte, ignore bool) []byte {
+	header := byte(0)
+	if ignore {
+		header = ignoreFlag
+	}
+
+	var length [lengthFieldSize]byte
+	length[0], length[1], length[2] = byte(len(contents)), byte(len(contents)>>8), byte(len(contents)>>16)
+	t.sendL.crypt(length[:])
+
+	ciphertext := t.sendP.seal(append([]byte{header}, contents...), t.sendAAD)
+	t.sendAAD = nil
+	return append(length[:], ciphertext...)
+}
+
+// fsChaCha20 is the cipher encrypting the packet lengths. Its keystream continues across packets and it switches to a
+// new key, taken from the keystream, every rekeyInterval packets.
+type fsChaCha20 struct {
+	key    [chacha20.KeySize]byte
+	chunks uint64
+	stream *chacha20.Cipher
+}
+
+// crypt encrypts or decrypts chunk in place.
+func (c *fsChaCha20) crypt(chunk []byte) {
+	if c.stream == nil {
+		var nonce [chacha20.NonceSize]byte
+		binary.LittleEndian.PutUint64(nonce[4:], c.chunks/rekeyInterval)
+		// the key and nonce have the required sizes
+		c.stream, _ = chacha20.NewUnauthenticatedCipher(c.key[:], nonce[:])
+	}
+	c.stream.XORKeyStream(chunk, chunk)
+
+	// the new key continues the keystream of the current one
+	if (c.chunks+1)%rekeyInterval == 0 {
+		var key [chacha20.KeySize]byte
+		c.stream.XORKeyStream(key[:], key[:])
+		c.key = key
+		c.stream = nil
+	}
+	c.chunks++
+}
+
+// fsChaCha20Poly1305 is the AEAD encrypting the packets. The nonce is derived from the packet counter, and every
+// rekeyInterval packets it switches to a new key, derived from the current one.
+type fsChaCha20Poly1305 struct {
+	key     [chacha20poly1305.KeySize]byte
+	packets uint64
+	aead    cipher.AEAD
+}
+
+func (c *fsChaCha20Poly1305) seal(plaintext, aad []byte) []byte {
+	ciphertext := c.current().Seal(nil, c.nonce(), plaintext, aad)
+	c.advance()
+	return ciphertext
+}
+
+func (c *fsChaCha20Poly1305) open(ciphertext, aad []byte) ([]byte, error) {
+	plaintext, err := c.current().Open(nil, c.nonce(), ciphertext, aad)
+	if err != nil {
+		return nil, err
+	}
+	c.advance()
+	return plaintext, nil
+}
+
+func (c *fsChaCha20Poly1305) current() cipher.AEAD {
+	if c.aead == nil {
+		// the key has the required size
+		c.aead, _ = chacha20poly1305.New(c.key[:])
+	}
+	return c.aead
+}
+
+func (c *fsChaCha20Poly1305) nonce() []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint32(nonce[:4], uint32(c.packets%rekeyInterval))
+	binary.LittleEndian.PutUint64(nonce[4:], c.packets/rekeyInterval)
+	return nonce
+}
+
+func (c *fsChaCha20Poly1305) advance() {
+	if (c.packets+1)%rekeyInterval == 0 {
+		// the new key is the encryption of 32 zero bytes with a nonce that packets never use
+		nonce := c.nonce()
+		binary.LittleEndian.PutUint32(nonce[:4], 0xffffffff)
+		c.key = [chacha20poly1305.KeySize]byte(c.current().Seal(nil, nonce, make([]byte, chacha20poly1305.KeySize), nil))
+		c.aead = nil
+	}
+	c.packets++
+}
@@ -0,0 +1,308 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/secp256k1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+	"io"
+	"math/big"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+// tcpPipe returns both ends of a TCP connection on the loopback interface. Unlike net.Pipe, writes are buffered, which
+// the v2 handshake relies on.
+func tcpPipe(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+
+	initiator, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	responder := <-accepted
+	require.NotNil(t, responder)
+
+	t.Cleanup(func() {
+		initiator.Close()
+		responder.Close()
+	})
+	return initiator, responder
+}
+
+func v2Pair(t *testing.T) (*v2Transport, *v2Transport, net.Conn) {
+	t.Helper()
+
+	initiatorConn, responderConn := tcpPipe(t)
+	magic := btc.MainNetParams.Magic
+
+	type result struct {
+		t   *v2Transport
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		responder, err := newV2Transport(responderConn, responderConn, magic, false)
+		ch <- result{responder, err}
+	}()
+
+	initiator, err := newV2Transport(initiatorConn, initiatorConn, magic, true)
+	require.NoError(t, err)
+	res := <-ch
+	require.NoError(t, res.err)
+	return initiator, res.t, initiatorConn
+}
+
+func TestV2Transport(t *testing.T) {
+	ping := &Message{Header: NewHeader(PingCmd, Payload{1, 2, 3, 4, 5, 6, 7, 8}), Payload: Payload{1, 2, 3, 4, 5, 6, 7, 8}}
+
+	t.Run("messages in both directions", func(t *testing.T) {
+		initiator, responder, _ := v2Pair(t)
+
+		// the ciphers switch to new keys after rekeyInterval packets
+		for i := 0; i < rekeyInterval+10; i++ {
+			payload := Payload(bytes.Repeat([]byte{byte(i)}, i))
+			msg := &Message{Header: NewHeader(InvCmd, payload), Payload: payload}
+
+			require.NoError(t, initiator.writeMessage(msg))
+			received, err := responder.readMessage()
+			require.NoError(t, err)
+			assert.True(t, msg.Equal(received))
+
+			require.NoError(t, responder.writeMessage(ping))
+			received, err = initiator.readMessage()
+			require.NoError(t, err)
+			assert.True(t, ping.Equal(received))
+		}
+	})
+
+	t.Run("commands without short id", func(t *testing.T) {
+		initiator, responder, _ := v2Pair(t)
+		assert.NotContains(t, v2ShortIDs, VerackCmd)
+
+		go func() {
+			_ = initiator.writeMessage(VerackMessage)
+		}()
+
+		received, err := responder.readMessage()
+		require.NoError(t, err)
+		assert.True(t, VerackMessage.Equal(received))
+	})
+
+	t.Run("decoy packets and unknown short ids are skipped", func(t *testing.T) {
+		initiator, responder, conn := v2Pair(t)
+
+		_, err := conn.Write(initiator.encryptPacket([]byte{0xff, 1, 2, 3}, true))
+		require.NoError(t, err)
+		_, err = conn.Write(initiator.encryptPacket([]byte{0xfe, 1, 2, 3}, false))
+		require.NoError(t, err)
+		require.NoError(t, initiator.writeMessage(ping))
+
+		received, err := responder.readMessage()
+		require.NoError(t, err)
+		assert.True(t, ping.Equal(received))
+	})
+
+	t.Run("tampered packets are rejected", func(t *testing.T) {
+		initiator, responder, conn := v2Pair(t)
+
+		packet := initiator.encryptPacket([]byte{v2ShortIDs[PingCmd], 1, 2, 3, 4, 5, 6, 7, 8}, false)
+		packet[len(packet)-1] ^= 1
+		_, err := conn.Write(packet)
+		require.NoError(t, err)
+
+		_, err = responder.readMessage()
+		assert.ErrorIs(t, err, ErrInvalidPacket)
+	})
+}
+
+// TestV2TransportVectors checks the key exchange and packet encryption against packet_encoding_test_vectors.csv of
+// BIP324. Packets before idx are encrypted with empty contents.
+func TestV2TransportVectors(t *testing.T) {
+	vectors := []struct {
+		idx            int
+		priv           string
+		ours           string
+		theirs         string
+		initiator      bool
+		contents       string
+		sharedSecret   string
+		sendTerminator string
+		recvTerminator string
+		sessionID      string
+		ciphertext     string
+	}{
+		{
+			idx:            1,
+			priv:           "61062ea5071d800bbfd59e2e8b53d47d194b095ae5a4df04936b49772ef0d4d7",
+			ours:           "ec0adff257bbfe500c188c80b4fdd640f6b45a482bbc15fc7cef5931deff0aa186f6eb9bba7b85dc4dcc28b28722de1e3d9108b985e2967045668f66098e475b",
+			theirs:         "a4a94dfce69b4a2a0a099313d10f9f7e7d649d60501c9e1d274c300e0d89aafaffffffffffffffffffffffffffffffffffffffffffffffffffffffff8faf88d5",
+			initiator:      true,
+			contents:       "8e",
+			sharedSecret:   "c6992a117f5edbea70c3f511d32d26b9798be4b81a62eaee1a5acaa8459a3592",
+			sendTerminator: "faef555dfcdb936425d84aba524758f3",
+			recvTerminator: "02cb8ff24307a6e27de3b4e7ea3fa65b",
+			sessionID:      "ce72dffb015da62b0d0f5474cab8bc72605225b0cee3f62312ec680ec5f41ba5",
+			ciphertext:     "7530d2a18720162ac09c25329a60d75adf36eda3c3",
+		},
+	}
+
+	decode := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		require.NoError(t, err)
+		return b
+	}
+
+	for i, vector := range vectors {
+		priv := new(big.Int).SetBytes(decode(vector.priv))
+		ours := [secp256k1.EllSwiftPubKeySize]byte(decode(vector.ours))
+		theirs := [secp256k1.EllSwiftPubKeySize]byte(decode(vector.theirs))
+
+		secret, err := secp256k1.EllSwiftECDH(priv, ours, theirs, vector.initiator)
+		require.NoError(t, err)
+		assert.Equal(t, vector.sharedSecret, hex.EncodeToString(secret[:]), "vector %d", i)
+
+		transport := &v2Transport{magic: btc.MainNetParams.Magic}
+		sendTerminator, recvTerminator := transport.initCiphers(secret, vector.initiator)
+		assert.Equal(t, vector.sendTerminator, hex.EncodeToString(sendTerminator), "vector %d", i)
+		assert.Equal(t, vector.recvTerminator, hex.EncodeToString(recvTerminator), "vector %d", i)
+		assert.Equal(t, vector.sessionID, hex.EncodeToString(transport.sessionID[:]), "vector %d", i)
+
+		for j := 0; j < vector.idx; j++ {
+			transport.encryptPacket(nil, false)
+		}
+		ciphertext := transport.encryptPacket(decode(vector.contents), false)
+		assert.Equal(t, vector.ciphertext, hex.EncodeToString(ciphertext), "vector %d", i)
+	}
+}
+
+// TestV2TransportRekey compares the packets around the first rekeys of both ciphers with the construction of BIP324
+// written out with plain ChaCha20 and ChaCha20-Poly1305: the length cipher takes its new key from the keystream of the
+// current one, whose nonce is the number of rekeys so far, and the packet cipher encrypts 32 zero bytes with the nonce
+// 0xffffffff followed by the number of rekeys.
+func TestV2TransportRekey(t *testing.T) {
+	secret := [32]byte{1, 2, 3}
+	transport := &v2Transport{magic: btc.MainNetParams.Magic}
+	transport.initCiphers(secret, true)
+	keyL, keyP := transport.sendL.key, transport.sendP.key
+
+	nonce := func(low uint32, high uint64) []byte {
+		nonce := make([]byte, chacha20poly1305.NonceSize)
+		binary.LittleEndian.PutUint32(nonce[:4], low)
+		binary.LittleEndian.PutUint64(nonce[4:], high)
+		return nonce
+	}
+
+	var lengthKeystream []byte
+	for i := 0; i <= 4*rekeyInterval+128; i++ {
+		epoch := uint64(i / rekeyInterval)
+		if i%rekeyInterval == 0 {
+			// all length fields of an epoch followed by the next key
+			keystream := make([]byte, rekeyInterval*lengthFieldSize+chacha20.KeySize)
+			stream, err := chacha20.NewUnauthenticatedCipher(keyL[:], nonce(0, epoch))
+			require.NoError(t, err)
+			stream.XORKeyStream(keystream, keystream)
+			lengthKeystream = keystream[:rekeyInterval*lengthFieldSize]
+			keyL = [32]byte(keystream[rekeyInterval*lengthFieldSize:])
+		}
+
+		contents := bytes.Repeat([]byte{byte(i)}, i%300)
+		length := []byte{byte(len(contents)), byte(len(contents) >> 8), 0}
+		for j := range length {
+			length[j] ^= lengthKeystream[(i%rekeyInterval)*lengthFieldSize+j]
+		}
+
+		aead, err := chacha20poly1305.New(keyP[:])
+		require.NoError(t, err)
+		plaintext := append([]byte{0}, contents...)
+		expected := append(length, aead.Seal(nil, nonce(uint32(i%rekeyInterval), epoch), plaintext, nil)...)
+		if (i+1)%rekeyInterval == 0 {
+			keyP = [32]byte(aead.Seal(nil, nonce(0xffffffff, epoch), make([]byte, 32), nil))
+		}
+
+		actual := transport.encryptPacket(contents, false)
+		require.Equal(t, hex.EncodeToString(expected), hex.EncodeToString(actual), "packet %d", i)
+	}
+}
+
+func TestDialTransport(t *testing.T) {
+	magic := btc.MainNetParams.Magic
+
+	listen := func(t *testing.T, handle func(conn net.Conn)) (netip.Addr, uint16) {
+		t.Helper()
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { listener.Close() })
+
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				handle(conn)
+			}
+		}()
+
+		addr := listener.Addr().(*net.TCPAddr).AddrPort()
+		return addr.Addr(), addr.Port()
+	}
+
+	t.Run("v2", func(t *testing.T) {
+		addr, port := listen(t, func(conn net.Conn) {
+			go func() {
+				responder, err := newV2Transport(conn, conn, magic, false)
+				if err == nil {
+					_ = responder.writeMessage(VerackMessage)
+				}
+			}()
+		})
+
+		conn, tr, err := dialTransport(magic, addr, port)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.IsType(t, &v2Transport{}, tr)
+
+		msg, err := tr.readMessage()
+		require.NoError(t, err)
+		assert.True(t, VerackMessage.Equal(msg))
+	})
+
+	t.Run("fallback to v1", func(t *testing.T) {
+		connections := 0
+		addr, port := listen(t, func(conn net.Conn) {
+			connections++
+			if connections == 1 {
+				// like a v1 node, close the connection when the first bytes don't match the magic bytes
+				_, _ = io.ReadFull(conn, make([]byte, headerSize))
+				conn.Close()
+				return
+			}
+			_ = VerackMessage.Write(conn, magic)
+		})
+
+		conn, tr, err := dialTransport(magic, addr, port)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.IsType(t, &v1Transport{}, tr)
+
+		msg, err := tr.readMessage()
+		require.NoError(t, err)
+		assert.True(t, VerackMessage.Equal(msg))
+	})
+}
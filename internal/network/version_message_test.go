@@ -3,6 +3,7 @@ package network
 import (
 	"bytes"
 	"encoding/binary"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
 	"github.com/stretchr/testify/assert"
 	"net/netip"
 	"testing"
@@ -24,12 +25,12 @@ func TestVersionMessage(t *testing.T) {
 	assert.NoError(t, err)
 
 	buf := new(bytes.Buffer)
-	err = versionMessage.Write(buf)
+	err = versionMessage.Write(buf, btc.MainNetParams.Magic)
 	assert.NoError(t, err)
 	encodedMessage := buf.Bytes()
 
 	t.Run("encoded message starts with magic bytes", func(t *testing.T) {
-		assert.Equal(t, Magic[:], encodedMessage[0:magicSize])
+		assert.Equal(t, btc.MainNetParams.Magic[:], encodedMessage[0:magicSize])
 	})
 
 	t.Run("encoded message has correct size", func(t *testing.T) {
@@ -0,0 +1,44 @@
+package ripemd160
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestSum(t *testing.T) {
+	// test vectors from the RIPEMD-160 specification
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", "9c1185a5c5e9fc54612808977ee8f548b2258d31"},
+		{"a", "0bdc9d2d256b3ee9daae347be6f4dc835a467ffe"},
+		{"abc", "8eb208f7e05d987a9b044a8e98c6b087f15a0bfc"},
+		{"message digest", "5d0689ef49d2fae572b881b123a85ffa21595f36"},
+		{"abcdbcdecdefdefgefghfghighijhijkijkljklmklmnlmnomnopnopq", "12a053384a9c0c88e405a06c27dcf49ada62eb2b"},
+		{strings.Repeat("1234567890", 8), "9b752e45573d4b39f4dbd3323cab82bf63326bfb"},
+		{strings.Repeat("a", 1000000), "52783243c1697bdbe16d37f97f68f08325dc1528"},
+	}
+
+	for _, test := range tests {
+		sum := Sum([]byte(test.input))
+		assert.Equal(t, test.expected, hex.EncodeToString(sum[:]))
+	}
+}
+
+func TestIncrementalWrites(t *testing.T) {
+	input := []byte(strings.Repeat("abcdefghij", 20))
+	expected := Sum(input)
+
+	h := New()
+	for i := 0; i < len(input); i += 7 {
+		end := min(i+7, len(input))
+		_, _ = h.Write(input[i:end])
+	}
+
+	assert.Equal(t, expected[:], h.Sum(nil))
+	// Sum doesn't change the state
+	assert.Equal(t, expected[:], h.Sum(nil))
+}
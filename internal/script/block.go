@@ -0,0 +1,48 @@
+package script
+
+import (
+	"fmt"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+)
+
+// BlockVerifyFlags returns the flags for the soft forks that are active in a block at height.
+func BlockVerifyFlags(height int32, params *btc.ConsensusParams) VerifyFlags {
+	var flags VerifyFlags
+	if height >= params.BIP16Height {
+		flags |= VerifyP2SH
+	}
+	if height >= params.BIP66Height {
+		flags |= VerifyDERSig
+	}
+	if height >= params.BIP65Height {
+		flags |= VerifyCheckLockTimeVerify
+	}
+	if height >= params.CSVHeight {
+		flags |= VerifyCheckSequenceVerify
+	}
+	// BIP147 was deployed together with segwit
+	if height >= params.SegwitHeight {
+		flags |= VerifyWitness | VerifyNullDummy
+	}
+	if height >= params.TaprootHeight {
+		flags |= VerifyTaproot
+	}
+	return flags
+}
+
+// VerifyTransaction verifies the scripts of all inputs of tx with flags. spentOutputs are the outputs spent by the
+// inputs, in the same order.
+func VerifyTransaction(tx *btc.Transaction, spentOutputs []btc.TxOutput, flags VerifyFlags) error {
+	txData, err := NewPrecomputedTxData(tx, spentOutputs)
+	if err != nil {
+		return err
+	}
+
+	for i, in := range tx.TxIn {
+		checker := NewTxSigChecker(tx, i, txData)
+		if err := VerifyScript(in.SignatureScript, spentOutputs[i].ScriptPubKey, in.Witness, flags, checker); err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+	return nil
+}
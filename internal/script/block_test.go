@@ -0,0 +1,35 @@
+package script
+
+import (
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBlockVerifyFlags(t *testing.T) {
+	params := &btc.MainNetConsensus
+
+	assert.Equal(t, VerifyFlags(0), BlockVerifyFlags(params.BIP16Height-1, params))
+	assert.Equal(t, VerifyP2SH, BlockVerifyFlags(params.BIP16Height, params))
+	assert.Equal(t, VerifyP2SH|VerifyDERSig, BlockVerifyFlags(params.BIP66Height, params))
+	assert.Equal(t, MandatoryVerifyFlags&^VerifyTaproot, BlockVerifyFlags(params.SegwitHeight, params))
+	assert.Equal(t, MandatoryVerifyFlags, BlockVerifyFlags(params.TaprootHeight, params))
+}
+
+func TestVerifyTransaction(t *testing.T) {
+	// the signed native P2WPKH example from BIP143, with a P2PK input and a P2WPKH input
+	tx := decodeTx(t, "01000000000102fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f00000000494830450221008b9d1dc26ba6a9cb62127b02742fa9d754cd3bebf337f7a55d114c8e5cdd30be022040529b194ba3f9281a99f2b1c0a19c0489bc22ede944ccf4ecbab4cc618ef3ed01eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac000247304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d5447a12fb1366d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d43c212a8caed02de67eebee0121025476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee635711000000")
+	spent := []btc.TxOutput{
+		{Value: 625000000, ScriptPubKey: mustDecodeHex(t, "2103c9f4836b9a4f77fc0d81f7bcb01b7f1b35916864b9476c241ce9fc198bd25432ac")},
+		{Value: 600000000, ScriptPubKey: mustDecodeHex(t, "00141d0f172a0ecb48aee1be1f2687d2963ae33f71a1")},
+	}
+
+	assert.NoError(t, VerifyTransaction(tx, spent, MandatoryVerifyFlags))
+
+	wrongAmount := []btc.TxOutput{spent[0], {Value: spent[1].Value + 1, ScriptPubKey: spent[1].ScriptPubKey}}
+	err := VerifyTransaction(tx, wrongAmount, MandatoryVerifyFlags)
+	assert.ErrorIs(t, err, ErrEvalFalse)
+	assert.ErrorContains(t, err, "input 1")
+
+	assert.ErrorIs(t, VerifyTransaction(tx, spent[:1], MandatoryVerifyFlags), ErrPrevOutCount)
+}
@@ -0,0 +1,132 @@
+package script
+
+import (
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/secp256k1"
+)
+
+// SigChecker provides the checks that depend on the transaction being validated to the script engine.
+type SigChecker interface {
+	// CheckECDSASignature verifies a signature of the transaction with the given public key. The last byte of sig is
+	// the signature hash type.
+	CheckECDSASignature(sig, pubKey, scriptCode []byte, sigVersion SigVersion) bool
+	// CheckSchnorrSignature verifies a BIP340 signature of the transaction with the given 32 byte public key. A 65 byte
+	// signature ends with the signature hash type.
+	CheckSchnorrSignature(sig, pubKey []byte, sigVersion SigVersion, execData *ExecData) error
+	// CheckLockTime returns true if the transaction satisfies the absolute lock time required by
+	// OP_CHECKLOCKTIMEVERIFY.
+	CheckLockTime(lockTime int64) bool
+	// CheckSequence returns true if the input satisfies the relative lock time required by OP_CHECKSEQUENCEVERIFY.
+	CheckSequence(sequence int64) bool
+}
+
+// TxSigChecker checks signatures and lock times against an input of a transaction.
+type TxSigChecker struct {
+	Tx         *btc.Transaction
+	InputIndex int
+	TxData     *PrecomputedTxData
+}
+
+func NewTxSigChecker(tx *btc.Transaction, inputIndex int, txData *PrecomputedTxData) *TxSigChecker {
+	return &TxSigChecker{
+		Tx:         tx,
+		InputIndex: inputIndex,
+		TxData:     txData,
+	}
+}
+
+func (c *TxSigChecker) CheckECDSASignature(sig, pubKey, scriptCode []byte, sigVersion SigVersion) bool {
+	if len(sig) == 0 {
+		return false
+	}
+
+	key, err := secp256k1.ParsePubKey(pubKey)
+	if err != nil {
+		return false
+	}
+
+	hashType := uint32(sig[len(sig)-1])
+	signature, err := secp256k1.ParseDERSignature(sig[:len(sig)-1])
+	if err != nil {
+		return false
+	}
+
+	hash, err := c.signatureHash(scriptCode, hashType, sigVersion)
+	if err != nil {
+		return false
+	}
+	return signature.Verify(hash[:], key)
+}
+
+func (c *TxSigChecker) signatureHash(scriptCode []byte, hashType uint32, sigVersion SigVersion) ([32]byte, error) {
+	if sigVersion == SigVersionWitnessV0 {
+		return WitnessV0SignatureHash(c.Tx, c.InputIndex, scriptCode, hashType, c.TxData)
+	}
+	return LegacySignatureHash(c.Tx, c.InputIndex, scriptCode, hashType)
+}
+
+func (c *TxSigChecker) CheckSchnorrSignature(sig, pubKey []byte, sigVersion SigVersion, execData *ExecData) error {
+	if len(sig) != secp256k1.SchnorrSigSize && len(sig) != secp256k1.SchnorrSigSize+1 {
+		return ErrSchnorrSigSize
+	}
+
+	hashType := byte(SigHashDefault)
+	if len(sig) == secp256k1.SchnorrSigSize+1 {
+		hashType = sig[secp256k1.SchnorrSigSize]
+		// the default hash type must not be given explicitly, so that there is only one encoding of the signature
+		if hashType == SigHashDefault {
+			return ErrSchnorrSigHashType
+		}
+		sig = sig[:secp256k1.SchnorrSigSize]
+	}
+
+	hash, err := TaprootSignatureHash(c.Tx, c.InputIndex, hashType, sigVersion, execData, c.TxData)
+	if err != nil {
+		return ErrSchnorrSigHashType
+	}
+
+	key, err := secp256k1.ParseXOnlyPubKey(pubKey)
+	if err != nil || !secp256k1.VerifySchnorr(sig, hash[:], key) {
+		return ErrSchnorrSig
+	}
+	return nil
+}
+
+func (c *TxSigChecker) CheckLockTime(lockTime int64) bool {
+	txLockTime := int64(c.Tx.LockTime)
+
+	// the lock time must be of the same type as the one of the transaction, either a block height or a timestamp
+	if (txLockTime < btc.LockTimeThreshold) != (lockTime < btc.LockTimeThreshold) {
+		return false
+	}
+
+	if lockTime > txLockTime {
+		return false
+	}
+
+	// the lock time of the transaction is ignored if the input is final, so it has to be non-final for the check to
+	// mean anything
+	return c.Tx.TxIn[c.InputIndex].Sequence != btc.SequenceFinal
+}
+
+func (c *TxSigChecker) CheckSequence(sequence int64) bool {
+	txSequence := int64(c.Tx.TxIn[c.InputIndex].Sequence)
+
+	// relative lock times are only enforced for version 2 transactions (BIP68)
+	if c.Tx.Version < 2 {
+		return false
+	}
+
+	if txSequence&btc.SequenceLockTimeDisableFlag != 0 {
+		return false
+	}
+
+	mask := int64(btc.SequenceLockTimeTypeFlag | btc.SequenceLockTimeMask)
+	txSequence &= mask
+	sequence &= mask
+
+	if (txSequence < btc.SequenceLockTimeTypeFlag) != (sequence < btc.SequenceLockTimeTypeFlag) {
+		return false
+	}
+	return sequence <= txSequence
+}
@@ -0,0 +1,111 @@
+package script
+
+import "math/big"
+
+const (
+	// SigHashDefault is the hash type of 64 byte Schnorr signatures, which sign the same data as SigHashAll.
+	SigHashDefault      = 0x00
+	SigHashAll          = 0x01
+	SigHashNone         = 0x02
+	SigHashSingle       = 0x03
+	SigHashAnyoneCanPay = 0x80
+)
+
+// halfOrder is half the order of the secp256k1 group, the largest S value allowed by VerifyLowS.
+var halfOrder, _ = new(big.Int).SetString("7fffffffffffffffffffffffffffffff5d576e7357a4501ddfe92f46681b20a0", 16)
+
+// checkSignatureEncoding checks the encoding of a signature including its hash type byte according to the flags. An
+// empty signature is always allowed, as it can be used to make a signature check fail on purpose.
+func checkSignatureEncoding(sig []byte, flags VerifyFlags) error {
+	if len(sig) == 0 {
+		return nil
+	}
+
+	if flags&(VerifyDERSig|VerifyLowS|VerifyStrictEnc) != 0 && !isValidSignatureEncoding(sig) {
+		return ErrSigDER
+	}
+
+	if flags&VerifyLowS != 0 && !isLowDERSignature(sig) {
+		return ErrSigHighS
+	}
+
+	if flags&VerifyStrictEnc != 0 && !isDefinedHashType(sig) {
+		return ErrSigHashType
+	}
+	return nil
+}
+
+// isValidSignatureEncoding returns true if sig is a strict DER encoded signature followed by a hash type byte (BIP66).
+//
+// The format is 0x30 [total-length] 0x02 [R-length] [R] 0x02 [S-length] [S] [sighash], where R and S are non-negative
+// integers without unnecessary leading zero bytes.
+func isValidSignatureEncoding(sig []byte) bool {
+	if len(sig) < 9 || len(sig) > 73 {
+		return false
+	}
+
+	if sig[0] != 0x30 || int(sig[1]) != len(sig)-3 {
+		return false
+	}
+
+	lenR := int(sig[3])
+	if 5+lenR >= len(sig) {
+		return false
+	}
+
+	lenS := int(sig[5+lenR])
+	if lenR+lenS+7 != len(sig) {
+		return false
+	}
+
+	if sig[2] != 0x02 || lenR == 0 || sig[4]&0x80 != 0 {
+		return false
+	}
+	if lenR > 1 && sig[4] == 0x00 && sig[5]&0x80 == 0 {
+		return false
+	}
+
+	if sig[lenR+4] != 0x02 || lenS == 0 || sig[lenR+6]&0x80 != 0 {
+		return false
+	}
+	if lenS > 1 && sig[lenR+6] == 0x00 && sig[lenR+7]&0x80 == 0 {
+		return false
+	}
+	return true
+}
+
+// isLowDERSignature returns true if the S value of a strictly encoded signature is at most half the curve order.
+func isLowDERSignature(sig []byte) bool {
+	lenR := int(sig[3])
+	lenS := int(sig[5+lenR])
+	s := new(big.Int).SetBytes(sig[6+lenR : 6+lenR+lenS])
+	return s.Cmp(halfOrder) <= 0
+}
+
+func isDefinedHashType(sig []byte) bool {
+	hashType := sig[len(sig)-1] &^ SigHashAnyoneCanPay
+	return hashType >= SigHashAll && hashType <= SigHashSingle
+}
+
+// checkPubKeyEncoding checks the encoding of a public key according to the flags.
+func checkPubKeyEncoding(pubKey []byte, flags VerifyFlags, sigVersion SigVersion) error {
+	if flags&VerifyStrictEnc != 0 && !isCompressedOrUncompressedPubKey(pubKey) {
+		return ErrPubKeyType
+	}
+
+	if flags&VerifyWitnessPubKeyType != 0 && sigVersion == SigVersionWitnessV0 && !isCompressedPubKey(pubKey) {
+		return ErrWitnessPubKeyType
+	}
+	return nil
+}
+
+func isCompressedOrUncompressedPubKey(pubKey []byte) bool {
+	if len(pubKey) == 65 {
+		return pubKey[0] == 0x04
+	}
+	return isCompressedPubKey(pubKey)
+}
+
+func isCompressedPubKey(pubKey []byte) bool {
+	return len(pubKey) == 33 && (pubKey[0] == 0x02 || pubKey[0] == 0x03)
+}
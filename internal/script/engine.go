@@ -0,0 +1,958 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/ripemd160"
+)
+
+const (
+	MaxScriptSize         = 10000
+	MaxScriptElementSize  = 520
+	MaxOpsPerScript       = 201
+	MaxPubKeysPerMultiSig = 20
+	// MaxStackSize is the maximum number of elements on the main and alt stack combined.
+	MaxStackSize = 1000
+)
+
+// VerifyScript checks whether scriptSig and witness satisfy the conditions of scriptPubKey. It returns nil if they do
+// and an error describing the failed rule if they don't.
+func VerifyScript(scriptSig, scriptPubKey []byte, witness btc.TxWitness, flags VerifyFlags, checker SigChecker) error {
+	if flags&VerifySigPushOnly != 0 && !IsPushOnly(scriptSig) {
+		return ErrSigPushOnly
+	}
+
+	var st stack
+	if err := evalScript(&st, scriptSig, flags, checker, SigVersionBase, &ExecData{}); err != nil {
+		return err
+	}
+
+	var p2shStack stack
+	if flags&VerifyP2SH != 0 {
+		p2shStack = st.copyStack()
+	}
+
+	if err := evalScript(&st, scriptPubKey, flags, checker, SigVersionBase, &ExecData{}); err != nil {
+		return err
+	}
+	if len(st) == 0 || !castToBool(st.top(-1)) {
+		return ErrEvalFalse
+	}
+
+	hadWitness := false
+	if flags&VerifyWitness != 0 {
+		if version, program, ok := IsWitnessProgram(scriptPubKey); ok {
+			hadWitness = true
+			if len(scriptSig) != 0 {
+				return ErrWitnessMalleated
+			}
+
+			if err := verifyWitnessProgram(witness, version, program, flags, checker, false); err != nil {
+				return err
+			}
+			// the witness program leaves exactly one element on the stack, which satisfies the clean stack rule
+			st = st[:1]
+		}
+	}
+
+	if flags&VerifyP2SH != 0 && IsPayToScriptHash(scriptPubKey) {
+		// only pushes are allowed in the signature script, otherwise the redeem script could be computed
+		if !IsPushOnly(scriptSig) {
+			return ErrSigPushOnly
+		}
+
+		// the signature script isn't empty, otherwise the scriptPubKey would have failed above
+		st = p2shStack
+		redeemScript := st.pop()
+
+		if err := evalScript(&st, redeemScript, flags, checker, SigVersionBase, &ExecData{}); err != nil {
+			return err
+		}
+		if len(st) == 0 || !castToBool(st.top(-1)) {
+			return ErrEvalFalse
+		}
+
+		if flags&VerifyWitness != 0 {
+			if version, program, ok := IsWitnessProgram(redeemScript); ok {
+				hadWitness = true
+				// the signature script must be exactly a push of the redeem script to prevent malleability
+				if !bytes.Equal(scriptSig, AppendPushData(nil, redeemScript)) {
+					return ErrWitnessMalleatedP2SH
+				}
+
+				if err := verifyWitnessProgram(witness, version, program, flags, checker, true); err != nil {
+					return err
+				}
+				st = st[:1]
+			}
+		}
+	}
+
+	if flags&VerifyCleanStack != 0 && len(st) != 1 {
+		return ErrCleanStack
+	}
+
+	if flags&VerifyWitness != 0 && !hadWitness && len(witness) > 0 {
+		return ErrWitnessUnexpected
+	}
+	return nil
+}
+
+func verifyWitnessProgram(witness btc.TxWitness, version int, program []byte, flags VerifyFlags, checker SigChecker, isP2SH bool) error {
+	if version == 1 && len(program) == taprootProgramSize && !isP2SH {
+		if flags&VerifyTaproot == 0 {
+			return nil
+		}
+		return verifyTaproot(witness, program, flags, checker)
+	}
+
+	if version != 0 {
+		if flags&VerifyDiscourageUpgradableWitnessProgram != 0 {
+			return ErrDiscourageUpgradableWitnessProgram
+		}
+		// witness programs of unknown versions are anyone-can-spend, so that they can be assigned meaning later
+		return nil
+	}
+
+	switch len(program) {
+	case 32:
+		// P2WSH: the last witness element is the script, whose hash must match the program
+		if len(witness) == 0 {
+			return ErrWitnessProgramWitnessEmpty
+		}
+
+		witnessScript := witness[len(witness)-1]
+		hash := sha256.Sum256(witnessScript)
+		if !bytes.Equal(hash[:], program) {
+			return ErrWitnessProgramMismatch
+		}
+
+		st := stack(witness[:len(witness)-1]).copyStack()
+		return executeWitnessScript(st, witnessScript, flags, checker, SigVersionWitnessV0, &ExecData{})
+	case 20:
+		// P2WPKH: the witness is a signature and a public key, which are checked like a P2PKH output
+		if len(witness) != 2 {
+			return ErrWitnessProgramMismatch
+		}
+
+		script := []byte{byte(OpDup), byte(OpHash160)}
+		script = AppendPushData(script, program)
+		script = append(script, byte(OpEqualVerify), byte(OpCheckSig))
+
+		return executeWitnessScript(stack(witness).copyStack(), script, flags, checker, SigVersionWitnessV0, &ExecData{})
+	default:
+		return ErrWitnessProgramWrongLength
+	}
+}
+
+func executeWitnessScript(st stack, script []byte, flags VerifyFlags, checker SigChecker, sigVersion SigVersion, execData *ExecData) error {
+	if sigVersion == SigVersionTapscript {
+		// OP_SUCCESSx makes the script succeed without executing it, overriding all other rules
+		t := newTokenizer(script)
+		for t.next() {
+			if isOpSuccess(t.instr.op) {
+				if flags&VerifyDiscourageOpSuccess != 0 {
+					return ErrDiscourageOpSuccess
+				}
+				return nil
+			}
+		}
+		if t.err != nil {
+			return t.err
+		}
+
+		if len(st) > MaxStackSize {
+			return ErrStackSize
+		}
+	}
+
+	for _, element := range st {
+		if len(element) > MaxScriptElementSize {
+			return ErrPushSize
+		}
+	}
+
+	if err := evalScript(&st, script, flags, checker, sigVersion, execData); err != nil {
+		return err
+	}
+
+	// witness scripts implicitly require a clean stack
+	if len(st) != 1 {
+		return ErrCleanStack
+	}
+	if !castToBool(st.top(-1)) {
+		return ErrEvalFalse
+	}
+	return nil
+}
+
+// condStack keeps track of nested OP_IF branches. Only the size and the position of the first false entry are needed
+// to know whether the current branch is executed, which keeps all operations constant time.
+type condStack struct {
+	size       int
+	firstFalse int
+}
+
+const noFalse = -1
+
+func (c *condStack) empty() bool {
+	return c.size == 0
+}
+
+func (c *condStack) allTrue() bool {
+	return c.firstFalse == noFalse
+}
+
+func (c *condStack) push(v bool) {
+	if c.firstFalse == noFalse && !v {
+		c.firstFalse = c.size
+	}
+	c.size++
+}
+
+func (c *condStack) pop() {
+	c.size--
+	if c.firstFalse == c.size {
+		c.firstFalse = noFalse
+	}
+}
+
+func (c *condStack) toggleTop() {
+	if c.firstFalse == noFalse {
+		c.firstFalse = c.size - 1
+	} else if c.firstFalse == c.size-1 {
+		c.firstFalse = noFalse
+	}
+}
+
+// scriptContext holds the parameters of a script execution that are shared by all operations.
+type scriptContext struct {
+	flags      VerifyFlags
+	checker    SigChecker
+	sigVersion SigVersion
+	execData   *ExecData
+}
+
+// evalScript executes a script on the given stack.
+func evalScript(st *stack, script []byte, flags VerifyFlags, checker SigChecker, sigVersion SigVersion, execData *ExecData) error {
+	// tapscript has no limits on the script size and number of operations
+	isLegacyOrV0 := sigVersion == SigVersionBase || sigVersion == SigVersionWitnessV0
+	if isLegacyOrV0 && len(script) > MaxScriptSize {
+		return ErrScriptSize
+	}
+
+	ctx := &scriptContext{flags: flags, checker: checker, sigVersion: sigVersion, execData: execData}
+	var altStack stack
+	cond := condStack{firstFalse: noFalse}
+	opCount := 0
+	requireMinimal := flags&VerifyMinimalData != 0
+	// signatures commit to the script after the last executed OP_CODESEPARATOR
+	codeHashStart := 0
+	// tapscript signatures commit to the position of the last executed OP_CODESEPARATOR instead
+	opcodePos := uint32(0)
+	execData.codeSeparatorPos = noCodeSeparator
+
+	t := newTokenizer(script)
+	for t.next() {
+		op := t.instr.op
+		data := t.instr.data
+		exec := cond.allTrue()
+
+		if len(data) > MaxScriptElementSize {
+			return ErrPushSize
+		}
+
+		if isLegacyOrV0 && op > Op16 {
+			opCount++
+			if opCount > MaxOpsPerScript {
+				return ErrOpCount
+			}
+		}
+
+		if isDisabled(op) {
+			return ErrDisabledOpcode
+		}
+
+		if op == OpCodeSeparator && sigVersion == SigVersionBase && flags&VerifyConstScriptCode != 0 {
+			return ErrOpCodeSeparator
+		}
+
+		if exec && op <= OpPushData4 {
+			if requireMinimal && !checkMinimalPush(data, op) {
+				return ErrMinimalData
+			}
+			st.push(data)
+		} else if exec || (op >= OpIf && op <= OpEndIf) {
+			if err := execOpcode(st, &altStack, &cond, op, exec, ctx, script[codeHashStart:], &opCount); err != nil {
+				return err
+			}
+			if op == OpCodeSeparator {
+				codeHashStart = t.pos
+				execData.codeSeparatorPos = opcodePos
+			}
+		}
+
+		if len(*st)+len(altStack) > MaxStackSize {
+			return ErrStackSize
+		}
+		opcodePos++
+	}
+
+	if t.err != nil {
+		return t.err
+	}
+
+	if !cond.empty() {
+		return ErrUnbalancedConditional
+	}
+	return nil
+}
+
+// execOpcode executes a single non-push operation. Control flow operations are also passed in if the current branch
+// is not executed, in which case exec is false.
+func execOpcode(st *stack, altStack *stack, cond *condStack, op Opcode, exec bool, ctx *scriptContext, scriptCode []byte, opCount *int) error {
+	flags, checker, sigVersion := ctx.flags, ctx.checker, ctx.sigVersion
+	requireMinimal := flags&VerifyMinimalData != 0
+
+	switch op {
+	case Op1Negate, Op1, Op2, Op3, Op4, Op5, Op6, Op7, Op8, Op9, Op10, Op11, Op12, Op13, Op14, Op15, Op16:
+		st.pushNum(scriptNum(int(op) - int(Op1-1)))
+
+	case OpNop:
+
+	case OpCheckLockTimeVerify:
+		if flags&VerifyCheckLockTimeVerify == 0 {
+			// treated as OP_NOP2 before BIP65
+			if flags&VerifyDiscourageUpgradableNops != 0 {
+				return ErrDiscourageUpgradableNops
+			}
+			break
+		}
+
+		if len(*st) < 1 {
+			return ErrInvalidStackOperation
+		}
+
+		// lock times can be larger than the 4 bytes of regular numbers
+		lockTime, err := makeScriptNum(st.top(-1), requireMinimal, 5)
+		if err != nil {
+			return err
+		}
+		if lockTime < 0 {
+			return ErrNegativeLockTime
+		}
+		if !checker.CheckLockTime(int64(lockTime)) {
+			return ErrUnsatisfiedLockTime
+		}
+
+	case OpCheckSequenceVerify:
+		if flags&VerifyCheckSequenceVerify == 0 {
+			// treated as OP_NOP3 before BIP112
+			if flags&VerifyDiscourageUpgradableNops != 0 {
+				return ErrDiscourageUpgradableNops
+			}
+			break
+		}
+
+		if len(*st) < 1 {
+			return ErrInvalidStackOperation
+		}
+
+		sequence, err := makeScriptNum(st.top(-1), requireMinimal, 5)
+		if err != nil {
+			return err
+		}
+		if sequence < 0 {
+			return ErrNegativeLockTime
+		}
+		// the disable flag makes the operation a NOP, so that it can be given meaning later
+		if sequence&btc.SequenceLockTimeDisableFlag != 0 {
+			break
+		}
+		if !checker.CheckSequence(int64(sequence)) {
+			return ErrUnsatisfiedLockTime
+		}
+
+	case OpNop1, OpNop4, OpNop5, OpNop6, OpNop7, OpNop8, OpNop9, OpNop10:
+		if flags&VerifyDiscourageUpgradableNops != 0 {
+			return ErrDiscourageUpgradableNops
+		}
+
+	case OpIf, OpNotIf:
+		value := false
+		if exec {
+			if len(*st) < 1 {
+				return ErrUnbalancedConditional
+			}
+
+			top := st.top(-1)
+			isMinimal := len(top) == 0 || (len(top) == 1 && top[0] == 1)
+			if sigVersion == SigVersionTapscript && !isMinimal {
+				return ErrTapscriptMinimalIf
+			}
+			if sigVersion == SigVersionWitnessV0 && flags&VerifyMinimalIf != 0 && !isMinimal {
+				return ErrMinimalIf
+			}
+
+			value = castToBool(top)
+			if op == OpNotIf {
+				value = !value
+			}
+			st.pop()
+		}
+		cond.push(value)
+
+	case OpElse:
+		if cond.empty() {
+			return ErrUnbalancedConditional
+		}
+		cond.toggleTop()
+
+	case OpEndIf:
+		if cond.empty() {
+			return ErrUnbalancedConditional
+		}
+		cond.pop()
+
+	case OpVerify:
+		if len(*st) < 1 {
+			return ErrInvalidStackOperation
+		}
+		if !castToBool(st.top(-1)) {
+			return ErrVerify
+		}
+		st.pop()
+
+	case OpReturn:
+		return ErrOpReturn
+
+	case OpToAltStack:
+		if len(*st) < 1 {
+			return ErrInvalidStackOperation
+		}
+		altStack.push(st.pop())
+
+	case OpFromAltStack:
+		if len(*altStack) < 1 {
+			return ErrInvalidAltStackOperation
+		}
+		st.push(altStack.pop())
+
+	case Op2Drop:
+		if len(*st) < 2 {
+			return ErrInvalidStackOperation
+		}
+		st.pop()
+		st.pop()
+
+	case Op2Dup:
+		if len(*st) < 2 {
+			return ErrInvalidStackOperation
+		}
+		a, b := st.top(-2), st.top(-1)
+		st.push(a)
+		st.push(b)
+
+	case Op3Dup:
+		if len(*st) < 3 {
+			return ErrInvalidStackOperation
+		}
+		a, b, c := st.top(-3), st.top(-2), st.top(-1)
+		st.push(a)
+		st.push(b)
+		st.push(c)
+
+	case Op2Over:
+		if len(*st) < 4 {
+			return ErrInvalidStackOperation
+		}
+		a, b := st.top(-4), st.top(-3)
+		st.push(a)
+		st.push(b)
+
+	case Op2Rot:
+		if len(*st) < 6 {
+			return ErrInvalidStackOperation
+		}
+		a, b := st.top(-6), st.top(-5)
+		st.remove(-6)
+		st.remove(-5)
+		st.push(a)
+		st.push(b)
+
+	case Op2Swap:
+		if len(*st) < 4 {
+			return ErrInvalidStackOperation
+		}
+		st.swap(-4, -2)
+		st.swap(-3, -1)
+
+	case OpIfDup:
+		if len(*st) < 1 {
+			return ErrInvalidStackOperation
+		}
+		if top := st.top(-1); castToBool(top) {
+			st.push(top)
+		}
+
+	case OpDepth:
+		st.pushNum(scriptNum(len(*st)))
+
+	case OpDrop:
+		if len(*st) < 1 {
+			return ErrInvalidStackOperation
+		}
+		st.pop()
+
+	case OpDup:
+		if len(*st) < 1 {
+			return ErrInvalidStackOperation
+		}
+		st.push(st.top(-1))
+
+	case OpNip:
+		if len(*st) < 2 {
+			return ErrInvalidStackOperation
+		}
+		st.remove(-2)
+
+	case OpOver:
+		if len(*st) < 2 {
+			return ErrInvalidStackOperation
+		}
+		st.push(st.top(-2))
+
+	case OpPick, OpRoll:
+		if len(*st) < 2 {
+			return ErrInvalidStackOperation
+		}
+
+		num, err := makeScriptNum(st.top(-1), requireMinimal, defaultNumSize)
+		if err != nil {
+			return err
+		}
+		st.pop()
+
+		n := int(num.Int32())
+		if n < 0 || n >= len(*st) {
+			return ErrInvalidStackOperation
+		}
+
+		element := st.top(-n - 1)
+		if op == OpRoll {
+			st.remove(-n - 1)
+		}
+		st.push(element)
+
+	case OpRot:
+		if len(*st) < 3 {
+			return ErrInvalidStackOperation
+		}
+		st.swap(-3, -2)
+		st.swap(-2, -1)
+
+	case OpSwap:
+		if len(*st) < 2 {
+			return ErrInvalidStackOperation
+		}
+		st.swap(-2, -1)
+
+	case OpTuck:
+		if len(*st) < 2 {
+			return ErrInvalidStackOperation
+		}
+		top := st.top(-1)
+		second := st.top(-2)
+		st.pop()
+		st.pop()
+		st.push(top)
+		st.push(second)
+		st.push(top)
+
+	case OpSize:
+		if len(*st) < 1 {
+			return ErrInvalidStackOperation
+		}
+		st.pushNum(scriptNum(len(st.top(-1))))
+
+	case OpEqual, OpEqualVerify:
+		if len(*st) < 2 {
+			return ErrInvalidStackOperation
+		}
+
+		equal := bytes.Equal(st.pop(), st.pop())
+		st.pushBool(equal)
+
+		if op == OpEqualVerify {
+			if !equal {
+				return ErrEqualVerify
+			}
+			st.pop()
+		}
+
+	case Op1Add, Op1Sub, OpNegate, OpAbs, OpNot, Op0NotEqual:
+		if len(*st) < 1 {
+			return ErrInvalidStackOperation
+		}
+
+		n, err := makeScriptNum(st.top(-1), requireMinimal, defaultNumSize)
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case Op1Add:
+			n++
+		case Op1Sub:
+			n--
+		case OpNegate:
+			n = -n
+		case OpAbs:
+			if n < 0 {
+				n = -n
+			}
+		case OpNot:
+			n = boolNum(n == 0)
+		case Op0NotEqual:
+			n = boolNum(n != 0)
+		}
+
+		st.pop()
+		st.pushNum(n)
+
+	case OpAdd, OpSub, OpBoolAnd, OpBoolOr, OpNumEqual, OpNumEqualVerify, OpNumNotEqual, OpLessThan, OpGreaterThan,
+		OpLessThanOrEqual, OpGreaterThanOrEqual, OpMin, OpMax:
+		if len(*st) < 2 {
+			return ErrInvalidStackOperation
+		}
+
+		a, err := makeScriptNum(st.top(-2), requireMinimal, defaultNumSize)
+		if err != nil {
+			return err
+		}
+		b, err := makeScriptNum(st.top(-1), requireMinimal, defaultNumSize)
+		if err != nil {
+			return err
+		}
+
+		var result scriptNum
+		switch op {
+		case OpAdd:
+			result = a + b
+		case OpSub:
+			result = a - b
+		case OpBoolAnd:
+			result = boolNum(a != 0 && b != 0)
+		case OpBoolOr:
+			result = boolNum(a != 0 || b != 0)
+		case OpNumEqual, OpNumEqualVerify:
+			result = boolNum(a == b)
+		case OpNumNotEqual:
+			result = boolNum(a != b)
+		case OpLessThan:
+			result = boolNum(a < b)
+		case OpGreaterThan:
+			result = boolNum(a > b)
+		case OpLessThanOrEqual:
+			result = boolNum(a <= b)
+		case OpGreaterThanOrEqual:
+			result = boolNum(a >= b)
+		case OpMin:
+			result = min(a, b)
+		case OpMax:
+			result = max(a, b)
+		}
+
+		st.pop()
+		st.pop()
+		st.pushNum(result)
+
+		if op == OpNumEqualVerify {
+			if !castToBool(st.top(-1)) {
+				return ErrNumEqualVerify
+			}
+			st.pop()
+		}
+
+	case OpWithin:
+		if len(*st) < 3 {
+			return ErrInvalidStackOperation
+		}
+
+		x, err := makeScriptNum(st.top(-3), requireMinimal, defaultNumSize)
+		if err != nil {
+			return err
+		}
+		lower, err := makeScriptNum(st.top(-2), requireMinimal, defaultNumSize)
+		if err != nil {
+			return err
+		}
+		upper, err := makeScriptNum(st.top(-1), requireMinimal, defaultNumSize)
+		if err != nil {
+			return err
+		}
+
+		st.pop()
+		st.pop()
+		st.pop()
+		st.pushBool(lower <= x && x < upper)
+
+	case OpRipemd160, OpSha1, OpSha256, OpHash160, OpHash256:
+		if len(*st) < 1 {
+			return ErrInvalidStackOperation
+		}
+
+		data := st.pop()
+		var hash []byte
+		switch op {
+		case OpRipemd160:
+			h := ripemd160.Sum(data)
+			hash = h[:]
+		case OpSha1:
+			h := sha1.Sum(data)
+			hash = h[:]
+		case OpSha256:
+			h := sha256.Sum256(data)
+			hash = h[:]
+		case OpHash160:
+			hash = Hash160(data)
+		case OpHash256:
+			h := hash256(data)
+			hash = h[:]
+		}
+		st.push(hash)
+
+	case OpCodeSeparator:
+		// the caller moves the start of the script code
+
+	case OpCheckSig, OpCheckSigVerify:
+		if len(*st) < 2 {
+			return ErrInvalidStackOperation
+		}
+
+		sig, pubKey := st.top(-2), st.top(-1)
+		success, err := evalCheckSig(sig, pubKey, scriptCode, ctx)
+		if err != nil {
+			return err
+		}
+
+		st.pop()
+		st.pop()
+		st.pushBool(success)
+
+		if op == OpCheckSigVerify {
+			if !success {
+				return ErrCheckSigVerify
+			}
+			st.pop()
+		}
+
+	case OpCheckSigAdd:
+		// replaces OP_CHECKMULTISIG in tapscript, which allows batch verification of the signatures
+		if sigVersion == SigVersionBase || sigVersion == SigVersionWitnessV0 {
+			return ErrBadOpcode
+		}
+
+		if len(*st) < 3 {
+			return ErrInvalidStackOperation
+		}
+
+		sig, pubKey := st.top(-3), st.top(-1)
+		n, err := makeScriptNum(st.top(-2), requireMinimal, defaultNumSize)
+		if err != nil {
+			return err
+		}
+
+		success, err := evalCheckSig(sig, pubKey, scriptCode, ctx)
+		if err != nil {
+			return err
+		}
+
+		st.pop()
+		st.pop()
+		st.pop()
+		if success {
+			n++
+		}
+		st.pushNum(n)
+
+	case OpCheckMultiSig, OpCheckMultiSigVerify:
+		if sigVersion == SigVersionTapscript {
+			return ErrTapscriptCheckMultiSig
+		}
+
+		success, err := evalCheckMultiSig(st, ctx, scriptCode, opCount)
+		if err != nil {
+			return err
+		}
+
+		st.pushBool(success)
+
+		if op == OpCheckMultiSigVerify {
+			if !success {
+				return ErrCheckMultiSigVerify
+			}
+			st.pop()
+		}
+
+	default:
+		return ErrBadOpcode
+	}
+
+	return nil
+}
+
+func boolNum(v bool) scriptNum {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// Hash160 returns RIPEMD160(SHA256(data)), which is used for public key and script hashes in output scripts.
+func Hash160(data []byte) []byte {
+	sha := sha256.Sum256(data)
+	hash := ripemd160.Sum(sha[:])
+	return hash[:]
+}
+
+func evalCheckSig(sig, pubKey, scriptCode []byte, ctx *scriptContext) (bool, error) {
+	if ctx.sigVersion == SigVersionTapscript {
+		return evalCheckSigTapscript(sig, pubKey, ctx)
+	}
+
+	flags, checker, sigVersion := ctx.flags, ctx.checker, ctx.sigVersion
+
+	// a signature can't sign itself, so it is removed from the script code of legacy scripts
+	if sigVersion == SigVersionBase {
+		var found int
+		scriptCode, found = findAndDelete(scriptCode, AppendPushData(nil, sig))
+		if found > 0 && flags&VerifyConstScriptCode != 0 {
+			return false, ErrSigFindAndDelete
+		}
+	}
+
+	if err := checkSignatureEncoding(sig, flags); err != nil {
+		return false, err
+	}
+	if err := checkPubKeyEncoding(pubKey, flags, sigVersion); err != nil {
+		return false, err
+	}
+
+	success := checker.CheckECDSASignature(sig, pubKey, scriptCode, sigVersion)
+	if !success && flags&VerifyNullFail != 0 && len(sig) > 0 {
+		return false, ErrSigNullFail
+	}
+	return success, nil
+}
+
+// evalCheckMultiSig executes OP_CHECKMULTISIG and removes its arguments from the stack. The stack layout is
+// <dummy> <sig 1> ... <sig m> <m> <pubkey 1> ... <pubkey n> <n>, where the extra dummy element is consumed because of
+// an off-by-one error in the original implementation.
+func evalCheckMultiSig(st *stack, ctx *scriptContext, scriptCode []byte, opCount *int) (bool, error) {
+	flags, checker, sigVersion := ctx.flags, ctx.checker, ctx.sigVersion
+	requireMinimal := flags&VerifyMinimalData != 0
+
+	i := 1
+	if len(*st) < i {
+		return false, ErrInvalidStackOperation
+	}
+
+	num, err := makeScriptNum(st.top(-i), requireMinimal, defaultNumSize)
+	if err != nil {
+		return false, err
+	}
+	keyCount := int(num.Int32())
+	if keyCount < 0 || keyCount > MaxPubKeysPerMultiSig {
+		return false, ErrPubKeyCount
+	}
+
+	*opCount += keyCount
+	if *opCount > MaxOpsPerScript {
+		return false, ErrOpCount
+	}
+
+	i++
+	keyIndex := i
+	// the number of stack elements from the first key on that must be empty if NULLFAIL is set
+	keysToCheck := keyCount + 2
+	i += keyCount
+	if len(*st) < i {
+		return false, ErrInvalidStackOperation
+	}
+
+	num, err = makeScriptNum(st.top(-i), requireMinimal, defaultNumSize)
+	if err != nil {
+		return false, err
+	}
+	sigCount := int(num.Int32())
+	if sigCount < 0 || sigCount > keyCount {
+		return false, ErrSigCount
+	}
+
+	i++
+	sigIndex := i
+	i += sigCount
+	if len(*st) < i {
+		return false, ErrInvalidStackOperation
+	}
+
+	if sigVersion == SigVersionBase {
+		for k := 0; k < sigCount; k++ {
+			var found int
+			scriptCode, found = findAndDelete(scriptCode, AppendPushData(nil, st.top(-sigIndex-k)))
+			if found > 0 && flags&VerifyConstScriptCode != 0 {
+				return false, ErrSigFindAndDelete
+			}
+		}
+	}
+
+	// signatures have to be in the same order as their public keys
+	success := true
+	for success && sigCount > 0 {
+		sig := st.top(-sigIndex)
+		pubKey := st.top(-keyIndex)
+
+		if err := checkSignatureEncoding(sig, flags); err != nil {
+			return false, err
+		}
+		if err := checkPubKeyEncoding(pubKey, flags, sigVersion); err != nil {
+			return false, err
+		}
+
+		if checker.CheckECDSASignature(sig, pubKey, scriptCode, sigVersion) {
+			sigIndex++
+			sigCount--
+		}
+		keyIndex++
+		keyCount--
+
+		// there are more signatures left than public keys to check them against
+		if sigCount > keyCount {
+			success = false
+		}
+	}
+
+	// remove all arguments except the dummy element
+	for ; i > 1; i-- {
+		if !success && flags&VerifyNullFail != 0 && keysToCheck == 0 && len(st.top(-1)) > 0 {
+			return false, ErrSigNullFail
+		}
+		if keysToCheck > 0 {
+			keysToCheck--
+		}
+		st.pop()
+	}
+
+	if len(*st) < 1 {
+		return false, ErrInvalidStackOperation
+	}
+	if flags&VerifyNullDummy != 0 && len(st.top(-1)) > 0 {
+		return false, ErrSigNullDummy
+	}
+	st.pop()
+
+	return success, nil
+}
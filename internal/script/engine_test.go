@@ -0,0 +1,309 @@
+package script
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+// fakeChecker accepts signatures that equal the public key with "sig" prepended, followed by a SIGHASH_ALL byte for
+// ECDSA signatures.
+type fakeChecker struct {
+	lockTime int64
+	sequence int64
+}
+
+func (c *fakeChecker) CheckECDSASignature(sig, pubKey, scriptCode []byte, sigVersion SigVersion) bool {
+	return string(sig) == "sig"+string(pubKey)+"\x01"
+}
+
+func (c *fakeChecker) CheckSchnorrSignature(sig, pubKey []byte, sigVersion SigVersion, execData *ExecData) error {
+	if string(sig) != "sig"+string(pubKey) {
+		return ErrSchnorrSig
+	}
+	return nil
+}
+
+func (c *fakeChecker) CheckLockTime(lockTime int64) bool {
+	return lockTime <= c.lockTime
+}
+
+func (c *fakeChecker) CheckSequence(sequence int64) bool {
+	return sequence <= c.sequence
+}
+
+type scriptTest struct {
+	name         string
+	scriptSig    string
+	scriptPubKey string
+	flags        VerifyFlags
+	expected     error
+}
+
+func runScriptTests(t *testing.T, tests []scriptTest) {
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifyScript(asm(t, test.scriptSig), asm(t, test.scriptPubKey), nil, test.flags, &fakeChecker{})
+			if test.expected == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, test.expected)
+			}
+		})
+	}
+}
+
+func TestEvalScript(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{"equal", "1 2", "2 EQUALVERIFY 1 EQUAL", VerifyP2SH, nil},
+		{"arithmetic", "2 3", "ADD 5 NUMEQUAL", 0, nil},
+		{"subtraction is ordered", "2 3", "SUB -1 EQUAL", 0, nil},
+		{"result can exceed four bytes", "0x04 0xffffff7f", "DUP ADD 0x05 0xfeffffff00 EQUAL", 0, nil},
+		{"inputs can't exceed four bytes", "0x05 0x0000000001", "1ADD", 0, ErrScriptNumOverflow},
+		{"within", "0 0 1", "WITHIN", 0, nil},
+		{"within upper bound is exclusive", "1 0 1", "WITHIN NOT", 0, nil},
+		{"min max", "3 7", "2DUP MIN 3 EQUALVERIFY MAX 7 EQUAL", 0, nil},
+		{"false result", "0", "", 0, ErrEvalFalse},
+		{"empty scripts", "", "", 0, ErrEvalFalse},
+		{"negative zero is false", "0x01 0x80", "", 0, ErrEvalFalse},
+		{"verify", "1", "VERIFY 1", 0, nil},
+		{"failed verify", "0", "VERIFY 1", 0, ErrVerify},
+		{"op_return", "1", "RETURN", 0, ErrOpReturn},
+		{"hash", "'abc'", "SHA256 0x20 0xba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad EQUAL", 0, nil},
+		{"hash160", "'abc'", "HASH160 0x14 0xbb1be98c142444d7a56aa3981c3942a978e4dc33 EQUAL", 0, nil},
+		{"ripemd160", "'abc'", "RIPEMD160 0x14 0x8eb208f7e05d987a9b044a8e98c6b087f15a0bfc EQUAL", 0, nil},
+		{"sha1", "'abc'", "SHA1 0x14 0xa9993e364706816aba3e25717850c26c9cd0d89d EQUAL", 0, nil},
+		{"size", "'abc'", "SIZE 3 EQUALVERIFY 'abc' EQUAL", 0, nil},
+		{"altstack", "1 2", "TOALTSTACK DROP FROMALTSTACK 2 EQUAL", 0, nil},
+		{"empty altstack", "1", "FROMALTSTACK", 0, ErrInvalidAltStackOperation},
+		{"pick", "1 2 3", "2 PICK 1 EQUALVERIFY DEPTH 3 EQUAL", 0, nil},
+		{"roll", "1 2 3", "2 ROLL 1 EQUALVERIFY DEPTH 2 EQUAL", 0, nil},
+		{"pick out of range", "1 2", "2 PICK", 0, ErrInvalidStackOperation},
+		{"rot", "1 2 3", "ROT 1 EQUALVERIFY 3 EQUALVERIFY 2 EQUAL", 0, nil},
+		{"2rot", "1 2 3 4 5 6", "2ROT 2 EQUALVERIFY 1 EQUALVERIFY 6 EQUALVERIFY 5 EQUALVERIFY 4 EQUALVERIFY 3 EQUAL", 0, nil},
+		{"2swap", "1 2 3 4", "2SWAP 2 EQUALVERIFY 1 EQUALVERIFY 4 EQUALVERIFY 3 EQUAL", 0, nil},
+		{"tuck", "1 2", "TUCK 2 EQUALVERIFY 1 EQUALVERIFY 2 EQUAL", 0, nil},
+		{"ifdup", "0", "IFDUP DEPTH 1 EQUALVERIFY NOT", 0, nil},
+		{"stack underflow", "", "DROP 1", 0, ErrInvalidStackOperation},
+
+		{"if", "1", "IF 1 ELSE 0 ENDIF", 0, nil},
+		{"else", "0", "IF 0 ELSE 1 ENDIF", 0, nil},
+		{"notif", "0", "NOTIF 1 ELSE 0 ENDIF", 0, nil},
+		{"nested if", "0 1", "IF IF 0 ELSE 1 ENDIF ELSE 0 ENDIF", 0, nil},
+		{"multiple else", "1", "IF 0 ELSE 1 ELSE 0 ENDIF", 0, ErrEvalFalse},
+		{"unbalanced if", "1", "IF 1", 0, ErrUnbalancedConditional},
+		{"unbalanced endif", "1", "ENDIF", 0, ErrUnbalancedConditional},
+		{"if without argument", "", "IF 1 ENDIF", 0, ErrUnbalancedConditional},
+		{"unexecuted bad opcode", "0", "IF RESERVED ENDIF 1", 0, nil},
+		{"unexecuted verif", "0", "IF VERIF ENDIF 1", 0, ErrBadOpcode},
+		{"unexecuted disabled opcode", "0", "IF CAT ENDIF 1", 0, ErrDisabledOpcode},
+		{"unexecuted return", "0", "IF RETURN ENDIF 1", 0, nil},
+		{"bad opcode", "1", "0xba", 0, ErrBadOpcode},
+		{"unknown opcode", "1", "0xc0", 0, ErrBadOpcode},
+		{"malformed push", "1", "0x4c", 0, ErrBadOpcode},
+
+		{"push size", "", "0x4d0902 0x" + strings.Repeat("00", 521), 0, ErrPushSize},
+		{"minimal data", "0x4c01 0x07", "7 EQUAL", VerifyMinimalData, ErrMinimalData},
+		{"non-minimal data without flag", "0x4c01 0x07", "7 EQUAL", 0, nil},
+		{"minimal small int", "0x01 0x07", "7 EQUAL", VerifyMinimalData, ErrMinimalData},
+		{"minimal number", "0x02 0x0100", "1ADD 2 EQUAL", VerifyMinimalData, ErrMinimalData},
+		{"op count", "1", strings.Repeat("NOP ", 201), 0, nil},
+		{"op count exceeded", "1", strings.Repeat("NOP ", 202), 0, ErrOpCount},
+		{"stack size", "", strings.Repeat("1 ", 1000), 0, nil},
+		{"stack size exceeded", "", strings.Repeat("1 ", 1001), 0, ErrStackSize},
+		{"script size", "1", "0x" + strings.Repeat("61", 10001), 0, ErrScriptSize},
+
+		{"upgradable nop", "1", "NOP1", 0, nil},
+		{"discouraged upgradable nop", "1", "NOP10", VerifyDiscourageUpgradableNops, ErrDiscourageUpgradableNops},
+		{"cltv is nop without flag", "0", "CHECKLOCKTIMEVERIFY 1", 0, nil},
+		{"cltv satisfied", "0", "CHECKLOCKTIMEVERIFY", VerifyCheckLockTimeVerify, ErrEvalFalse},
+		{"cltv unsatisfied", "1", "CHECKLOCKTIMEVERIFY", VerifyCheckLockTimeVerify, ErrUnsatisfiedLockTime},
+		{"cltv negative", "-1", "CHECKLOCKTIMEVERIFY", VerifyCheckLockTimeVerify, ErrNegativeLockTime},
+		{"cltv empty stack", "", "CHECKLOCKTIMEVERIFY", VerifyCheckLockTimeVerify, ErrInvalidStackOperation},
+		{"csv unsatisfied", "1", "CHECKSEQUENCEVERIFY", VerifyCheckSequenceVerify, ErrUnsatisfiedLockTime},
+		{"csv disabled", "0x05 0x0000008000", "CHECKSEQUENCEVERIFY", VerifyCheckSequenceVerify, nil},
+
+		{"sigpushonly", "NOP 1", "", VerifySigPushOnly, ErrSigPushOnly},
+		{"clean stack", "1 1", "", VerifyP2SH | VerifyWitness | VerifyCleanStack, ErrCleanStack},
+	})
+}
+
+func TestCheckSig(t *testing.T) {
+	runScriptTests(t, []scriptTest{
+		{"valid signature", "'sigkey\x01'", "'key' CHECKSIG", 0, nil},
+		{"invalid signature", "'sigxxx\x01'", "'key' CHECKSIG", 0, ErrEvalFalse},
+		{"checksigverify", "'sigxxx\x01'", "'key' CHECKSIGVERIFY 1", 0, ErrCheckSigVerify},
+		{"failed signature must be empty", "'sigxxx\x01'", "'key' CHECKSIG NOT", VerifyNullFail, ErrSigNullFail},
+		{"empty failed signature", "0", "'key' CHECKSIG NOT", VerifyNullFail, nil},
+		{"strict DER", "'sigkey\x01'", "'key' CHECKSIG", VerifyDERSig, ErrSigDER},
+		{"strict pubkey encoding", "0", "'key' CHECKSIG NOT", VerifyStrictEnc, ErrPubKeyType},
+
+		{"multisig", "0 'sigk1\x01' 'sigk3\x01'", "2 'k1' 'k2' 'k3' 3 CHECKMULTISIG", 0, nil},
+		{"multisig out of order", "0 'sigk3\x01' 'sigk1\x01'", "2 'k1' 'k2' 'k3' 3 CHECKMULTISIG", 0, ErrEvalFalse},
+		{"multisig zero of zero", "0", "0 0 CHECKMULTISIG", 0, nil},
+		{"multisig missing dummy", "'sigk1\x01'", "1 'k1' 1 CHECKMULTISIG", 0, ErrInvalidStackOperation},
+		{"multisig non-null dummy", "1 'sigk1\x01'", "1 'k1' 1 CHECKMULTISIG", 0, nil},
+		{"multisig null dummy", "1 'sigk1\x01'", "1 'k1' 1 CHECKMULTISIG", VerifyNullDummy, ErrSigNullDummy},
+		{"multisig too many signatures", "0 'sigk1\x01' 'sigk1\x01'", "2 'k1' 1 CHECKMULTISIG", 0, ErrSigCount},
+		{"multisig too many keys", "0 0", "0 " + strings.Repeat("'k' ", 21) + "21 CHECKMULTISIG", 0, ErrPubKeyCount},
+		{"multisig failed signature must be empty", "0 'sigxx\x01'", "1 'k1' 1 CHECKMULTISIG NOT", VerifyNullFail, ErrSigNullFail},
+		{"multisig verify", "0 'sigk2\x01'", "1 'k1' 1 CHECKMULTISIGVERIFY 1", 0, ErrCheckMultiSigVerify},
+		{"multisig keys count towards op limit", "0", "0 " + strings.Repeat("'k' ", 20) + "20 CHECKMULTISIG " + strings.Repeat("NOP ", 180), 0, nil},
+		{"multisig keys exceed op limit", "0", "0 " + strings.Repeat("'k' ", 20) + "20 CHECKMULTISIG " + strings.Repeat("NOP ", 181), 0, ErrOpCount},
+	})
+}
+
+func TestCheckSignatureEncoding(t *testing.T) {
+	// a valid DER signature with SIGHASH_ALL, taken from the spend in block 170
+	valid := asm(t, "0x304402204e45e16932b8af514961a1d3a1a25fdf3f4f7732e9d624c6c61548ab5fb8cd410220181522ec8eca07de4860a4acdd12909d831cc56cbbac4622082221a8768d1d0901")
+	assert.NoError(t, checkSignatureEncoding(valid, VerifyDERSig|VerifyLowS|VerifyStrictEnc))
+
+	undefinedHashType := append([]byte{}, valid...)
+	undefinedHashType[len(undefinedHashType)-1] = 0x04
+	assert.NoError(t, checkSignatureEncoding(undefinedHashType, VerifyDERSig))
+	assert.ErrorIs(t, checkSignatureEncoding(undefinedHashType, VerifyStrictEnc), ErrSigHashType)
+
+	// the same signature with S replaced by n - S
+	highS := asm(t, "0x304502204e45e16932b8af514961a1d3a1a25fdf3f4f7732e9d624c6c61548ab5fb8cd41022100e7eadd137135f821b79f5b5322ed6f6137921779f39c5a19b7b03ce459a9243801")
+	assert.NoError(t, checkSignatureEncoding(highS, VerifyDERSig))
+	assert.ErrorIs(t, checkSignatureEncoding(highS, VerifyLowS), ErrSigHighS)
+
+	// R with an unnecessary leading zero
+	paddedR := asm(t, "0x30450221004e45e16932b8af514961a1d3a1a25fdf3f4f7732e9d624c6c61548ab5fb8cd410220181522ec8eca07de4860a4acdd12909d831cc56cbbac4622082221a8768d1d0901")
+	assert.ErrorIs(t, checkSignatureEncoding(paddedR, VerifyDERSig), ErrSigDER)
+	assert.NoError(t, checkSignatureEncoding(paddedR, 0))
+}
+
+func TestPayToScriptHash(t *testing.T) {
+	redeemScript := asm(t, "2 EQUAL")
+	p2sh := "HASH160 0x14 0x" + hex.EncodeToString(Hash160(redeemScript)) + " EQUAL"
+	push := "0x" + hex.EncodeToString(AppendPushData(nil, redeemScript))
+
+	runScriptTests(t, []scriptTest{
+		{"redeem script is evaluated", "2 " + push, p2sh, VerifyP2SH, nil},
+		{"redeem script fails", "3 " + push, p2sh, VerifyP2SH, ErrEvalFalse},
+		{"redeem script isn't evaluated without flag", "3 " + push, p2sh, 0, nil},
+		{"signature script must be push only", "2 NOP " + push, p2sh, VerifyP2SH, ErrSigPushOnly},
+	})
+}
+
+func TestWitness(t *testing.T) {
+	witnessScript := asm(t, "2 EQUAL")
+	scriptHash := sha256.Sum256(witnessScript)
+	p2wsh := asm(t, "0 0x20 0x"+hex.EncodeToString(scriptHash[:]))
+	flags := VerifyP2SH | VerifyWitness
+
+	t.Run("p2wsh", func(t *testing.T) {
+		witness := btc.TxWitness{{2}, witnessScript}
+		assert.NoError(t, VerifyScript(nil, p2wsh, witness, flags, &fakeChecker{}))
+
+		witness = btc.TxWitness{{3}, witnessScript}
+		assert.ErrorIs(t, VerifyScript(nil, p2wsh, witness, flags, &fakeChecker{}), ErrEvalFalse)
+	})
+
+	t.Run("p2wsh requires clean stack", func(t *testing.T) {
+		witness := btc.TxWitness{{1}, {2}, witnessScript}
+		assert.ErrorIs(t, VerifyScript(nil, p2wsh, witness, flags, &fakeChecker{}), ErrCleanStack)
+	})
+
+	t.Run("p2wsh script mismatch", func(t *testing.T) {
+		witness := btc.TxWitness{{2}, asm(t, "2 EQUALVERIFY 1")}
+		assert.ErrorIs(t, VerifyScript(nil, p2wsh, witness, flags, &fakeChecker{}), ErrWitnessProgramMismatch)
+	})
+
+	t.Run("p2wsh empty witness", func(t *testing.T) {
+		assert.ErrorIs(t, VerifyScript(nil, p2wsh, nil, flags, &fakeChecker{}), ErrWitnessProgramWitnessEmpty)
+	})
+
+	t.Run("witness requires empty signature script", func(t *testing.T) {
+		witness := btc.TxWitness{{2}, witnessScript}
+		assert.ErrorIs(t, VerifyScript(asm(t, "1"), p2wsh, witness, flags, &fakeChecker{}), ErrWitnessMalleated)
+	})
+
+	t.Run("p2wpkh", func(t *testing.T) {
+		pubKey := []byte("key")
+		p2wpkh := asm(t, "0 0x14 0x"+hex.EncodeToString(Hash160(pubKey)))
+
+		witness := btc.TxWitness{[]byte("sigkey\x01"), pubKey}
+		assert.NoError(t, VerifyScript(nil, p2wpkh, witness, flags, &fakeChecker{}))
+
+		witness = btc.TxWitness{[]byte("sigkey\x01"), pubKey, {}}
+		assert.ErrorIs(t, VerifyScript(nil, p2wpkh, witness, flags, &fakeChecker{}), ErrWitnessProgramMismatch)
+	})
+
+	t.Run("p2sh wrapped p2wsh", func(t *testing.T) {
+		p2sh := asm(t, "HASH160 0x14 0x"+hex.EncodeToString(Hash160(p2wsh))+" EQUAL")
+		scriptSig := AppendPushData(nil, p2wsh)
+		witness := btc.TxWitness{{2}, witnessScript}
+		assert.NoError(t, VerifyScript(scriptSig, p2sh, witness, flags, &fakeChecker{}))
+
+		scriptSig = append(asm(t, "0"), scriptSig...)
+		assert.ErrorIs(t, VerifyScript(scriptSig, p2sh, witness, flags, &fakeChecker{}), ErrWitnessMalleatedP2SH)
+	})
+
+	t.Run("witness ignored without flag", func(t *testing.T) {
+		witness := btc.TxWitness{{3}, witnessScript}
+		assert.NoError(t, VerifyScript(nil, p2wsh, witness, VerifyP2SH, &fakeChecker{}))
+	})
+
+	t.Run("unexpected witness", func(t *testing.T) {
+		witness := btc.TxWitness{{1}}
+		assert.ErrorIs(t, VerifyScript(nil, asm(t, "1"), witness, flags, &fakeChecker{}), ErrWitnessUnexpected)
+	})
+
+	t.Run("wrong program length", func(t *testing.T) {
+		program := asm(t, "0 0x03 0x000001")
+		assert.ErrorIs(t, VerifyScript(nil, program, nil, flags, &fakeChecker{}), ErrWitnessProgramWrongLength)
+	})
+
+	t.Run("future witness version", func(t *testing.T) {
+		program := asm(t, "16 0x02 0x0001")
+		assert.NoError(t, VerifyScript(nil, program, nil, flags, &fakeChecker{}))
+
+		flags := flags | VerifyDiscourageUpgradableWitnessProgram
+		assert.ErrorIs(t, VerifyScript(nil, program, nil, flags, &fakeChecker{}), ErrDiscourageUpgradableWitnessProgram)
+	})
+}
+
+func TestTxSigChecker(t *testing.T) {
+	tx := &btc.Transaction{
+		Version:  2,
+		TxIn:     []btc.TxInput{{Sequence: 10}},
+		LockTime: 1000,
+	}
+	checker := NewTxSigChecker(tx, 0, nil)
+
+	t.Run("lock time", func(t *testing.T) {
+		assert.True(t, checker.CheckLockTime(1000))
+		assert.False(t, checker.CheckLockTime(1001))
+		// timestamps can't be compared to heights
+		assert.False(t, checker.CheckLockTime(btc.LockTimeThreshold))
+	})
+
+	t.Run("final input disables lock time", func(t *testing.T) {
+		tx := &btc.Transaction{TxIn: []btc.TxInput{{Sequence: btc.SequenceFinal}}, LockTime: 1000}
+		assert.False(t, NewTxSigChecker(tx, 0, nil).CheckLockTime(1000))
+	})
+
+	t.Run("sequence", func(t *testing.T) {
+		assert.True(t, checker.CheckSequence(10))
+		assert.False(t, checker.CheckSequence(11))
+		assert.False(t, checker.CheckSequence(btc.SequenceLockTimeTypeFlag|5))
+	})
+
+	t.Run("sequence requires version 2", func(t *testing.T) {
+		tx := &btc.Transaction{Version: 1, TxIn: []btc.TxInput{{Sequence: 10}}}
+		assert.False(t, NewTxSigChecker(tx, 0, nil).CheckSequence(10))
+	})
+
+	t.Run("lock time is checked by the script", func(t *testing.T) {
+		err := VerifyScript(nil, asm(t, "1000 CHECKLOCKTIMEVERIFY"), nil, VerifyCheckLockTimeVerify, checker)
+		assert.NoError(t, err)
+
+		err = VerifyScript(nil, asm(t, "1001 CHECKLOCKTIMEVERIFY"), nil, VerifyCheckLockTimeVerify, checker)
+		assert.ErrorIs(t, err, ErrUnsatisfiedLockTime)
+	})
+}
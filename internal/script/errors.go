@@ -0,0 +1,59 @@
+package script
+
+import "errors"
+
+var (
+	ErrEvalFalse                          = errors.New("script evaluated without error but finished with a false/empty top stack element")
+	ErrOpReturn                           = errors.New("OP_RETURN was encountered")
+	ErrScriptSize                         = errors.New("script is too big")
+	ErrPushSize                           = errors.New("push value size limit exceeded")
+	ErrOpCount                            = errors.New("operation limit exceeded")
+	ErrStackSize                          = errors.New("stack size limit exceeded")
+	ErrSigCount                           = errors.New("signature count negative or greater than pubkey count")
+	ErrPubKeyCount                        = errors.New("pubkey count negative or limit exceeded")
+	ErrVerify                             = errors.New("script failed an OP_VERIFY operation")
+	ErrEqualVerify                        = errors.New("script failed an OP_EQUALVERIFY operation")
+	ErrCheckMultiSigVerify                = errors.New("script failed an OP_CHECKMULTISIGVERIFY operation")
+	ErrCheckSigVerify                     = errors.New("script failed an OP_CHECKSIGVERIFY operation")
+	ErrNumEqualVerify                     = errors.New("script failed an OP_NUMEQUALVERIFY operation")
+	ErrBadOpcode                          = errors.New("opcode missing or not understood")
+	ErrDisabledOpcode                     = errors.New("attempted to use a disabled opcode")
+	ErrInvalidStackOperation              = errors.New("operation not valid with the current stack size")
+	ErrInvalidAltStackOperation           = errors.New("operation not valid with the current altstack size")
+	ErrUnbalancedConditional              = errors.New("invalid OP_IF construction")
+	ErrNegativeLockTime                   = errors.New("negative locktime")
+	ErrUnsatisfiedLockTime                = errors.New("locktime requirement not satisfied")
+	ErrScriptNumOverflow                  = errors.New("script number overflow")
+	ErrSigHashType                        = errors.New("signature hash type missing or not understood")
+	ErrSigDER                             = errors.New("non-canonical DER signature")
+	ErrMinimalData                        = errors.New("data push larger than necessary")
+	ErrSigPushOnly                        = errors.New("only push operators allowed in signatures")
+	ErrSigHighS                           = errors.New("non-canonical signature: S value is unnecessarily high")
+	ErrSigNullDummy                       = errors.New("dummy CHECKMULTISIG argument must be zero")
+	ErrPubKeyType                         = errors.New("public key is neither compressed or uncompressed")
+	ErrCleanStack                         = errors.New("stack size must be exactly one after execution")
+	ErrMinimalIf                          = errors.New("OP_IF/NOTIF argument must be minimal")
+	ErrSigNullFail                        = errors.New("signature must be zero for failed CHECK(MULTI)SIG operation")
+	ErrDiscourageUpgradableNops           = errors.New("NOPx reserved for soft-fork upgrades")
+	ErrDiscourageUpgradableWitnessProgram = errors.New("witness version reserved for soft-fork upgrades")
+	ErrWitnessProgramWrongLength          = errors.New("witness program has incorrect length")
+	ErrWitnessProgramWitnessEmpty         = errors.New("witness program was passed an empty witness")
+	ErrWitnessProgramMismatch             = errors.New("witness program hash mismatch")
+	ErrWitnessMalleated                   = errors.New("witness requires empty scriptSig")
+	ErrWitnessMalleatedP2SH               = errors.New("witness requires only-redeemscript scriptSig")
+	ErrWitnessUnexpected                  = errors.New("witness provided for non-witness script")
+	ErrWitnessPubKeyType                  = errors.New("using non-compressed keys in segwit")
+	ErrSigFindAndDelete                   = errors.New("signature is found in scriptCode")
+	ErrOpCodeSeparator                    = errors.New("using OP_CODESEPARATOR in non-witness script")
+	ErrSchnorrSigSize                     = errors.New("invalid Schnorr signature size")
+	ErrSchnorrSigHashType                 = errors.New("invalid Schnorr signature hash type")
+	ErrSchnorrSig                         = errors.New("invalid Schnorr signature")
+	ErrTaprootWrongControlSize            = errors.New("invalid taproot control block size")
+	ErrTapscriptValidationWeight          = errors.New("too much signature validation relative to witness weight")
+	ErrTapscriptCheckMultiSig             = errors.New("OP_CHECKMULTISIG(VERIFY) is not available in tapscript")
+	ErrTapscriptMinimalIf                 = errors.New("OP_IF/NOTIF argument must be minimal in tapscript")
+	ErrDiscourageUpgradableTaprootVersion = errors.New("taproot version reserved for soft-fork upgrades")
+	ErrDiscourageOpSuccess                = errors.New("OP_SUCCESSx reserved for soft-fork upgrades")
+	ErrDiscourageUpgradablePubKeyType     = errors.New("public key version reserved for soft-fork upgrades")
+	ErrPrevOutCount                       = errors.New("number of spent outputs doesn't match number of inputs")
+)
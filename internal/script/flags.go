@@ -0,0 +1,77 @@
+package script
+
+// VerifyFlags select the rules that are enforced when verifying a script. Consensus rules that were added by soft forks
+// are only enabled by a flag, so that blocks from before their activation can still be validated.
+type VerifyFlags uint32
+
+const (
+	// VerifyP2SH evaluates pay-to-script-hash subscripts (BIP16).
+	VerifyP2SH VerifyFlags = 1 << iota
+	// VerifyStrictEnc requires signatures and public keys to be strictly encoded.
+	VerifyStrictEnc
+	// VerifyDERSig requires signatures to be strict DER (BIP66).
+	VerifyDERSig
+	// VerifyLowS requires the S value of signatures to be at most half the curve order (BIP146).
+	VerifyLowS
+	// VerifyNullDummy requires the extra stack element consumed by OP_CHECKMULTISIG to be empty (BIP147).
+	VerifyNullDummy
+	// VerifySigPushOnly requires the signature script to only contain push operations.
+	VerifySigPushOnly
+	// VerifyMinimalData requires pushes and numbers to use the shortest possible encoding.
+	VerifyMinimalData
+	// VerifyDiscourageUpgradableNops makes the use of the NOPs reserved for upgrades an error.
+	VerifyDiscourageUpgradableNops
+	// VerifyCleanStack requires exactly one element on the stack after evaluation.
+	VerifyCleanStack
+	// VerifyCheckLockTimeVerify enables OP_CHECKLOCKTIMEVERIFY (BIP65).
+	VerifyCheckLockTimeVerify
+	// VerifyCheckSequenceVerify enables OP_CHECKSEQUENCEVERIFY (BIP112).
+	VerifyCheckSequenceVerify
+	// VerifyWitness evaluates segregated witness programs (BIP141).
+	VerifyWitness
+	// VerifyDiscourageUpgradableWitnessProgram makes spending witness programs of unknown versions an error.
+	VerifyDiscourageUpgradableWitnessProgram
+	// VerifyMinimalIf requires the argument of OP_IF and OP_NOTIF in witness scripts to be empty or 0x01.
+	VerifyMinimalIf
+	// VerifyNullFail requires signatures to be empty if a signature check fails (BIP146).
+	VerifyNullFail
+	// VerifyWitnessPubKeyType requires public keys in witness v0 scripts to be compressed.
+	VerifyWitnessPubKeyType
+	// VerifyConstScriptCode makes OP_CODESEPARATOR and signatures in the script code an error in legacy scripts.
+	VerifyConstScriptCode
+	// VerifyTaproot evaluates witness v1 programs (BIP341 and BIP342).
+	VerifyTaproot
+	// VerifyDiscourageUpgradableTaprootVersion makes spending taproot script paths with unknown leaf versions an error.
+	VerifyDiscourageUpgradableTaprootVersion
+	// VerifyDiscourageOpSuccess makes the use of OP_SUCCESSx opcodes in tapscript an error.
+	VerifyDiscourageOpSuccess
+	// VerifyDiscourageUpgradablePubKeyType makes signature checks with public keys of unknown types in tapscript an
+	// error.
+	VerifyDiscourageUpgradablePubKeyType
+)
+
+// MandatoryVerifyFlags are the flags for all soft forks that are active on mainnet, which every block after the taproot
+// activation must satisfy.
+const MandatoryVerifyFlags = VerifyP2SH | VerifyDERSig | VerifyNullDummy | VerifyCheckLockTimeVerify |
+	VerifyCheckSequenceVerify | VerifyWitness | VerifyTaproot
+
+// StandardVerifyFlags are the flags Bitcoin Core uses for relaying transactions. They include policy rules on top of
+// the consensus rules.
+const StandardVerifyFlags = MandatoryVerifyFlags | VerifyStrictEnc | VerifyMinimalData |
+	VerifyDiscourageUpgradableNops | VerifyCleanStack | VerifyDiscourageUpgradableWitnessProgram | VerifyLowS |
+	VerifyMinimalIf | VerifyNullFail | VerifyWitnessPubKeyType | VerifyConstScriptCode |
+	VerifyDiscourageUpgradableTaprootVersion | VerifyDiscourageOpSuccess | VerifyDiscourageUpgradablePubKeyType
+
+// SigVersion determines how a script is executed and how signatures in it are hashed.
+type SigVersion int
+
+const (
+	// SigVersionBase is used for legacy scripts and P2SH redeem scripts.
+	SigVersionBase SigVersion = iota
+	// SigVersionWitnessV0 is used for P2WPKH and P2WSH scripts (BIP143).
+	SigVersionWitnessV0
+	// SigVersionTaproot is used for taproot key path spends (BIP341).
+	SigVersionTaproot
+	// SigVersionTapscript is used for taproot script path spends (BIP342).
+	SigVersionTapscript
+)
@@ -0,0 +1,81 @@
+package script
+
+import "math"
+
+// defaultNumSize is the maximum length of numbers that arithmetic opcodes accept as input. Results may be longer.
+const defaultNumSize = 4
+
+// scriptNum is a number on the script stack. Numbers are encoded in little-endian byte order with the sign in the most
+// significant bit of the last byte.
+type scriptNum int64
+
+// makeScriptNum decodes a number of at most maxLen bytes. If requireMinimal is set, encodings with unnecessary leading
+// zero bytes are rejected.
+func makeScriptNum(b []byte, requireMinimal bool, maxLen int) (scriptNum, error) {
+	if len(b) > maxLen {
+		return 0, ErrScriptNumOverflow
+	}
+
+	if requireMinimal && len(b) > 0 {
+		// the most significant byte may only be zero (apart from the sign bit) if the byte before it has its highest
+		// bit set, which would otherwise be taken as the sign
+		if b[len(b)-1]&0x7f == 0 && (len(b) == 1 || b[len(b)-2]&0x80 == 0) {
+			return 0, ErrMinimalData
+		}
+	}
+
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	var result int64
+	for i, v := range b {
+		result |= int64(v) << (8 * i)
+	}
+
+	if b[len(b)-1]&0x80 != 0 {
+		return -scriptNum(result &^ (int64(0x80) << (8 * (len(b) - 1)))), nil
+	}
+	return scriptNum(result), nil
+}
+
+// Bytes returns the minimal encoding of the number.
+func (n scriptNum) Bytes() []byte {
+	if n == 0 {
+		return []byte{}
+	}
+
+	negative := n < 0
+	abs := uint64(n)
+	if negative {
+		abs = uint64(-n)
+	}
+
+	var result []byte
+	for abs > 0 {
+		result = append(result, byte(abs&0xff))
+		abs >>= 8
+	}
+
+	if result[len(result)-1]&0x80 != 0 {
+		if negative {
+			result = append(result, 0x80)
+		} else {
+			result = append(result, 0x00)
+		}
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+	return result
+}
+
+// Int32 returns the number clamped to the range of an int32.
+func (n scriptNum) Int32() int32 {
+	if n > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	if n < math.MinInt32 {
+		return math.MinInt32
+	}
+	return int32(n)
+}
@@ -0,0 +1,282 @@
+package script
+
+import "fmt"
+
+type Opcode byte
+
+const (
+	Op0         Opcode = 0x00
+	OpPushData1 Opcode = 0x4c
+	OpPushData2 Opcode = 0x4d
+	OpPushData4 Opcode = 0x4e
+	Op1Negate   Opcode = 0x4f
+	OpReserved  Opcode = 0x50
+	Op1         Opcode = 0x51
+	Op2         Opcode = 0x52
+	Op3         Opcode = 0x53
+	Op4         Opcode = 0x54
+	Op5         Opcode = 0x55
+	Op6         Opcode = 0x56
+	Op7         Opcode = 0x57
+	Op8         Opcode = 0x58
+	Op9         Opcode = 0x59
+	Op10        Opcode = 0x5a
+	Op11        Opcode = 0x5b
+	Op12        Opcode = 0x5c
+	Op13        Opcode = 0x5d
+	Op14        Opcode = 0x5e
+	Op15        Opcode = 0x5f
+	Op16        Opcode = 0x60
+
+	// control
+	OpNop      Opcode = 0x61
+	OpVer      Opcode = 0x62
+	OpIf       Opcode = 0x63
+	OpNotIf    Opcode = 0x64
+	OpVerIf    Opcode = 0x65
+	OpVerNotIf Opcode = 0x66
+	OpElse     Opcode = 0x67
+	OpEndIf    Opcode = 0x68
+	OpVerify   Opcode = 0x69
+	OpReturn   Opcode = 0x6a
+
+	// stack
+	OpToAltStack   Opcode = 0x6b
+	OpFromAltStack Opcode = 0x6c
+	Op2Drop        Opcode = 0x6d
+	Op2Dup         Opcode = 0x6e
+	Op3Dup         Opcode = 0x6f
+	Op2Over        Opcode = 0x70
+	Op2Rot         Opcode = 0x71
+	Op2Swap        Opcode = 0x72
+	OpIfDup        Opcode = 0x73
+	OpDepth        Opcode = 0x74
+	OpDrop         Opcode = 0x75
+	OpDup          Opcode = 0x76
+	OpNip          Opcode = 0x77
+	OpOver         Opcode = 0x78
+	OpPick         Opcode = 0x79
+	OpRoll         Opcode = 0x7a
+	OpRot          Opcode = 0x7b
+	OpSwap         Opcode = 0x7c
+	OpTuck         Opcode = 0x7d
+
+	// splice
+	OpCat    Opcode = 0x7e
+	OpSubstr Opcode = 0x7f
+	OpLeft   Opcode = 0x80
+	OpRight  Opcode = 0x81
+	OpSize   Opcode = 0x82
+
+	// bit logic
+	OpInvert      Opcode = 0x83
+	OpAnd         Opcode = 0x84
+	OpOr          Opcode = 0x85
+	OpXor         Opcode = 0x86
+	OpEqual       Opcode = 0x87
+	OpEqualVerify Opcode = 0x88
+	OpReserved1   Opcode = 0x89
+	OpReserved2   Opcode = 0x8a
+
+	// numeric
+	Op1Add               Opcode = 0x8b
+	Op1Sub               Opcode = 0x8c
+	Op2Mul               Opcode = 0x8d
+	Op2Div               Opcode = 0x8e
+	OpNegate             Opcode = 0x8f
+	OpAbs                Opcode = 0x90
+	OpNot                Opcode = 0x91
+	Op0NotEqual          Opcode = 0x92
+	OpAdd                Opcode = 0x93
+	OpSub                Opcode = 0x94
+	OpMul                Opcode = 0x95
+	OpDiv                Opcode = 0x96
+	OpMod                Opcode = 0x97
+	OpLShift             Opcode = 0x98
+	OpRShift             Opcode = 0x99
+	OpBoolAnd            Opcode = 0x9a
+	OpBoolOr             Opcode = 0x9b
+	OpNumEqual           Opcode = 0x9c
+	OpNumEqualVerify     Opcode = 0x9d
+	OpNumNotEqual        Opcode = 0x9e
+	OpLessThan           Opcode = 0x9f
+	OpGreaterThan        Opcode = 0xa0
+	OpLessThanOrEqual    Opcode = 0xa1
+	OpGreaterThanOrEqual Opcode = 0xa2
+	OpMin                Opcode = 0xa3
+	OpMax                Opcode = 0xa4
+	OpWithin             Opcode = 0xa5
+
+	// crypto
+	OpRipemd160           Opcode = 0xa6
+	OpSha1                Opcode = 0xa7
+	OpSha256              Opcode = 0xa8
+	OpHash160             Opcode = 0xa9
+	OpHash256             Opcode = 0xaa
+	OpCodeSeparator       Opcode = 0xab
+	OpCheckSig            Opcode = 0xac
+	OpCheckSigVerify      Opcode = 0xad
+	OpCheckMultiSig       Opcode = 0xae
+	OpCheckMultiSigVerify Opcode = 0xaf
+
+	// expansion
+	OpNop1                Opcode = 0xb0
+	OpCheckLockTimeVerify Opcode = 0xb1
+	OpCheckSequenceVerify Opcode = 0xb2
+	OpNop4                Opcode = 0xb3
+	OpNop5                Opcode = 0xb4
+	OpNop6                Opcode = 0xb5
+	OpNop7                Opcode = 0xb6
+	OpNop8                Opcode = 0xb7
+	OpNop9                Opcode = 0xb8
+	OpNop10               Opcode = 0xb9
+
+	// tapscript
+	OpCheckSigAdd Opcode = 0xba
+
+	OpInvalidOpcode Opcode = 0xff
+)
+
+var opcodeNames = map[Opcode]string{
+	Op0:                   "0",
+	OpPushData1:           "OP_PUSHDATA1",
+	OpPushData2:           "OP_PUSHDATA2",
+	OpPushData4:           "OP_PUSHDATA4",
+	Op1Negate:             "-1",
+	OpReserved:            "OP_RESERVED",
+	OpNop:                 "OP_NOP",
+	OpVer:                 "OP_VER",
+	OpIf:                  "OP_IF",
+	OpNotIf:               "OP_NOTIF",
+	OpVerIf:               "OP_VERIF",
+	OpVerNotIf:            "OP_VERNOTIF",
+	OpElse:                "OP_ELSE",
+	OpEndIf:               "OP_ENDIF",
+	OpVerify:              "OP_VERIFY",
+	OpReturn:              "OP_RETURN",
+	OpToAltStack:          "OP_TOALTSTACK",
+	OpFromAltStack:        "OP_FROMALTSTACK",
+	Op2Drop:               "OP_2DROP",
+	Op2Dup:                "OP_2DUP",
+	Op3Dup:                "OP_3DUP",
+	Op2Over:               "OP_2OVER",
+	Op2Rot:                "OP_2ROT",
+	Op2Swap:               "OP_2SWAP",
+	OpIfDup:               "OP_IFDUP",
+	OpDepth:               "OP_DEPTH",
+	OpDrop:                "OP_DROP",
+	OpDup:                 "OP_DUP",
+	OpNip:                 "OP_NIP",
+	OpOver:                "OP_OVER",
+	OpPick:                "OP_PICK",
+	OpRoll:                "OP_ROLL",
+	OpRot:                 "OP_ROT",
+	OpSwap:                "OP_SWAP",
+	OpTuck:                "OP_TUCK",
+	OpCat:                 "OP_CAT",
+	OpSubstr:              "OP_SUBSTR",
+	OpLeft:                "OP_LEFT",
+	OpRight:               "OP_RIGHT",
+	OpSize:                "OP_SIZE",
+	OpInvert:              "OP_INVERT",
+	OpAnd:                 "OP_AND",
+	OpOr:                  "OP_OR",
+	OpXor:                 "OP_XOR",
+	OpEqual:               "OP_EQUAL",
+	OpEqualVerify:         "OP_EQUALVERIFY",
+	OpReserved1:           "OP_RESERVED1",
+	OpReserved2:           "OP_RESERVED2",
+	Op1Add:                "OP_1ADD",
+	Op1Sub:                "OP_1SUB",
+	Op2Mul:                "OP_2MUL",
+	Op2Div:                "OP_2DIV",
+	OpNegate:              "OP_NEGATE",
+	OpAbs:                 "OP_ABS",
+	OpNot:                 "OP_NOT",
+	Op0NotEqual:           "OP_0NOTEQUAL",
+	OpAdd:                 "OP_ADD",
+	OpSub:                 "OP_SUB",
+	OpMul:                 "OP_MUL",
+	OpDiv:                 "OP_DIV",
+	OpMod:                 "OP_MOD",
+	OpLShift:              "OP_LSHIFT",
+	OpRShift:              "OP_RSHIFT",
+	OpBoolAnd:             "OP_BOOLAND",
+	OpBoolOr:              "OP_BOOLOR",
+	OpNumEqual:            "OP_NUMEQUAL",
+	OpNumEqualVerify:      "OP_NUMEQUALVERIFY",
+	OpNumNotEqual:         "OP_NUMNOTEQUAL",
+	OpLessThan:            "OP_LESSTHAN",
+	OpGreaterThan:         "OP_GREATERTHAN",
+	OpLessThanOrEqual:     "OP_LESSTHANOREQUAL",
+	OpGreaterThanOrEqual:  "OP_GREATERTHANOREQUAL",
+	OpMin:                 "OP_MIN",
+	OpMax:                 "OP_MAX",
+	OpWithin:              "OP_WITHIN",
+	OpRipemd160:           "OP_RIPEMD160",
+	OpSha1:                "OP_SHA1",
+	OpSha256:              "OP_SHA256",
+	OpHash160:             "OP_HASH160",
+	OpHash256:             "OP_HASH256",
+	OpCodeSeparator:       "OP_CODESEPARATOR",
+	OpCheckSig:            "OP_CHECKSIG",
+	OpCheckSigVerify:      "OP_CHECKSIGVERIFY",
+	OpCheckMultiSig:       "OP_CHECKMULTISIG",
+	OpCheckMultiSigVerify: "OP_CHECKMULTISIGVERIFY",
+	OpNop1:                "OP_NOP1",
+	OpCheckLockTimeVerify: "OP_CHECKLOCKTIMEVERIFY",
+	OpCheckSequenceVerify: "OP_CHECKSEQUENCEVERIFY",
+	OpNop4:                "OP_NOP4",
+	OpNop5:                "OP_NOP5",
+	OpNop6:                "OP_NOP6",
+	OpNop7:                "OP_NOP7",
+	OpNop8:                "OP_NOP8",
+	OpNop9:                "OP_NOP9",
+	OpNop10:               "OP_NOP10",
+	OpCheckSigAdd:         "OP_CHECKSIGADD",
+	OpInvalidOpcode:       "OP_INVALIDOPCODE",
+}
+
+// String returns the name of the opcode as used by Bitcoin Core.
+func (op Opcode) String() string {
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+	if op >= Op1 && op <= Op16 {
+		return fmt.Sprintf("%d", op-Op1+1)
+	}
+	if op < OpPushData1 {
+		return fmt.Sprintf("OP_PUSHBYTES_%d", op)
+	}
+	return "OP_UNKNOWN"
+}
+
+// isSmallInt returns true if the opcode pushes a number from 0 to 16.
+func isSmallInt(op Opcode) bool {
+	return op == Op0 || (op >= Op1 && op <= Op16)
+}
+
+// decodeSmallInt returns the number pushed by an opcode for which isSmallInt returns true.
+func decodeSmallInt(op Opcode) int {
+	if op == Op0 {
+		return 0
+	}
+	return int(op-Op1) + 1
+}
+
+// isDisabled returns true for opcodes that make a script invalid even if they are not executed.
+func isDisabled(op Opcode) bool {
+	switch op {
+	case OpCat, OpSubstr, OpLeft, OpRight, OpInvert, OpAnd, OpOr, OpXor, Op2Mul, Op2Div, OpMul, OpDiv, OpMod,
+		OpLShift, OpRShift:
+		return true
+	}
+	return false
+}
+
+// isOpSuccess returns true for the opcodes that make a tapscript succeed unconditionally (BIP342), so that they can be
+// given new meaning by soft forks.
+func isOpSuccess(op Opcode) bool {
+	return op == 80 || op == 98 || (op >= 126 && op <= 129) || (op >= 131 && op <= 134) || (op >= 137 && op <= 138) ||
+		(op >= 141 && op <= 142) || (op >= 149 && op <= 153) || (op >= 187 && op <= 254)
+}
@@ -0,0 +1,208 @@
+// Package script implements the Bitcoin Script language used to lock and unlock transaction outputs.
+package script
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// instruction is a single operation of a script together with the data it pushes, if any.
+type instruction struct {
+	op   Opcode
+	data []byte
+}
+
+// tokenizer iterates over the instructions of a script. A script that ends in the middle of a push is malformed, which
+// is reported via err once the tokenizer reaches it.
+type tokenizer struct {
+	script []byte
+	pos    int
+	instr  instruction
+	err    error
+}
+
+func newTokenizer(script []byte) *tokenizer {
+	return &tokenizer{script: script}
+}
+
+// next advances to the next instruction. It returns false at the end of the script or if the script is malformed.
+func (t *tokenizer) next() bool {
+	if t.err != nil || t.pos >= len(t.script) {
+		return false
+	}
+
+	op := Opcode(t.script[t.pos])
+	pos := t.pos + 1
+
+	if op > OpPushData4 {
+		t.instr = instruction{op: op}
+		t.pos = pos
+		return true
+	}
+
+	var size int
+	switch {
+	case op < OpPushData1:
+		size = int(op)
+	case op == OpPushData1:
+		if len(t.script)-pos < 1 {
+			t.err = ErrBadOpcode
+			return false
+		}
+		size = int(t.script[pos])
+		pos++
+	case op == OpPushData2:
+		if len(t.script)-pos < 2 {
+			t.err = ErrBadOpcode
+			return false
+		}
+		size = int(binary.LittleEndian.Uint16(t.script[pos:]))
+		pos += 2
+	case op == OpPushData4:
+		if len(t.script)-pos < 4 {
+			t.err = ErrBadOpcode
+			return false
+		}
+		size64 := uint64(binary.LittleEndian.Uint32(t.script[pos:]))
+		pos += 4
+		if size64 > uint64(len(t.script)-pos) {
+			t.err = ErrBadOpcode
+			return false
+		}
+		size = int(size64)
+	}
+
+	if len(t.script)-pos < size {
+		t.err = ErrBadOpcode
+		return false
+	}
+
+	t.instr = instruction{op: op, data: t.script[pos : pos+size]}
+	t.pos = pos + size
+	return true
+}
+
+// IsUnspendable returns whether an output with the given script can never be spent. Such outputs don't need to be kept
+// in the set of unspent outputs.
+func IsUnspendable(script []byte) bool {
+	return (len(script) > 0 && Opcode(script[0]) == OpReturn) || len(script) > MaxScriptSize
+}
+
+// IsPushOnly returns true if the script only consists of push operations, including OP_1NEGATE, OP_RESERVED and
+// OP_1 to OP_16.
+func IsPushOnly(script []byte) bool {
+	t := newTokenizer(script)
+	for t.next() {
+		if t.instr.op > Op16 {
+			return false
+		}
+	}
+	return t.err == nil
+}
+
+// IsPayToScriptHash returns true if the script has the form OP_HASH160 <20 bytes> OP_EQUAL (BIP16).
+func IsPayToScriptHash(script []byte) bool {
+	return len(script) == 23 &&
+		Opcode(script[0]) == OpHash160 &&
+		script[1] == 20 &&
+		Opcode(script[22]) == OpEqual
+}
+
+// IsWitnessProgram returns the version and program of a segwit output script, which consists of a version opcode
+// followed by a single push of 2 to 40 bytes (BIP141).
+func IsWitnessProgram(script []byte) (version int, program []byte, ok bool) {
+	if len(script) < 4 || len(script) > 42 {
+		return 0, nil, false
+	}
+
+	op := Opcode(script[0])
+	if op != Op0 && (op < Op1 || op > Op16) {
+		return 0, nil, false
+	}
+
+	if int(script[1])+2 != len(script) {
+		return 0, nil, false
+	}
+	return decodeSmallInt(op), script[2:], true
+}
+
+// AppendPushData appends an operation that pushes data to the script, using the smallest push opcode that can hold the
+// data. Unlike the minimal push rules of the engine, it doesn't replace single byte values with OP_1 to OP_16.
+func AppendPushData(script, data []byte) []byte {
+	switch {
+	case len(data) < int(OpPushData1):
+		script = append(script, byte(len(data)))
+	case len(data) <= 0xff:
+		script = append(script, byte(OpPushData1), byte(len(data)))
+	case len(data) <= 0xffff:
+		script = append(script, byte(OpPushData2))
+		script = binary.LittleEndian.AppendUint16(script, uint16(len(data)))
+	default:
+		script = append(script, byte(OpPushData4))
+		script = binary.LittleEndian.AppendUint32(script, uint32(len(data)))
+	}
+	return append(script, data...)
+}
+
+// AppendInt appends an operation that pushes the number n to the script.
+func AppendInt(script []byte, n int64) []byte {
+	if n == -1 || (n >= 1 && n <= 16) {
+		return append(script, byte(int64(Op1)+n-1))
+	}
+	if n == 0 {
+		return append(script, byte(Op0))
+	}
+	return AppendPushData(script, scriptNum(n).Bytes())
+}
+
+// checkMinimalPush returns true if data is pushed with the smallest possible operation.
+func checkMinimalPush(data []byte, op Opcode) bool {
+	switch {
+	case len(data) == 0:
+		return op == Op0
+	case len(data) == 1 && data[0] >= 1 && data[0] <= 16:
+		return op == Op1+Opcode(data[0]-1)
+	case len(data) == 1 && data[0] == 0x81:
+		return op == Op1Negate
+	case len(data) <= 75:
+		return op == Opcode(len(data))
+	case len(data) <= 255:
+		return op == OpPushData1
+	case len(data) <= 65535:
+		return op == OpPushData2
+	}
+	return true
+}
+
+// findAndDelete removes all occurrences of sig that start at an instruction boundary from the script and returns the
+// result along with the number of occurrences. This quirk of legacy signature checking has to be reproduced exactly.
+func findAndDelete(script, sig []byte) ([]byte, int) {
+	if len(sig) == 0 {
+		return script, 0
+	}
+
+	found := 0
+	result := make([]byte, 0, len(script))
+	pos, copied := 0, 0
+	t := newTokenizer(script)
+
+	for {
+		result = append(result, script[copied:pos]...)
+		for len(script)-pos >= len(sig) && bytes.Equal(script[pos:pos+len(sig)], sig) {
+			pos += len(sig)
+			found++
+		}
+		copied = pos
+
+		t.pos = pos
+		if !t.next() {
+			break
+		}
+		pos = t.pos
+	}
+
+	if found == 0 {
+		return script, 0
+	}
+	return append(result, script[copied:]...), found
+}
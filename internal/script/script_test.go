@@ -0,0 +1,174 @@
+package script
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// asm assembles a script from the short notation used in Bitcoin Core's script tests: numbers are pushed as script
+// numbers, 0x-prefixed hex is inserted as raw bytes, quoted strings are pushed and everything else is an opcode name
+// with or without the OP_ prefix.
+func asm(t *testing.T, s string) []byte {
+	t.Helper()
+
+	names := make(map[string]Opcode)
+	for op, name := range opcodeNames {
+		names[name] = op
+		names[strings.TrimPrefix(name, "OP_")] = op
+	}
+
+	var script []byte
+	for _, token := range strings.Fields(s) {
+		if n, err := strconv.ParseInt(token, 10, 64); err == nil {
+			script = AppendInt(script, n)
+			continue
+		}
+
+		if strings.HasPrefix(token, "0x") {
+			raw, err := hex.DecodeString(token[2:])
+			require.NoError(t, err)
+			script = append(script, raw...)
+			continue
+		}
+
+		if strings.HasPrefix(token, "'") && strings.HasSuffix(token, "'") {
+			script = AppendPushData(script, []byte(token[1:len(token)-1]))
+			continue
+		}
+
+		op, ok := names[token]
+		require.True(t, ok, "unknown opcode %s", token)
+		script = append(script, byte(op))
+	}
+	return script
+}
+
+func TestScriptNum(t *testing.T) {
+	t.Run("encoding", func(t *testing.T) {
+		tests := []struct {
+			n        scriptNum
+			expected string
+		}{
+			{0, ""},
+			{1, "01"},
+			{-1, "81"},
+			{127, "7f"},
+			{-127, "ff"},
+			{128, "8000"},
+			{-128, "8080"},
+			{255, "ff00"},
+			{256, "0001"},
+			{-256, "0081"},
+			{32767, "ff7f"},
+			{32768, "008000"},
+			{2147483647, "ffffff7f"},
+			{-2147483647, "ffffffff"},
+			{2147483648, "0000008000"},
+		}
+
+		for _, test := range tests {
+			encoded := test.n.Bytes()
+			assert.Equal(t, test.expected, hex.EncodeToString(encoded))
+
+			decoded, err := makeScriptNum(encoded, true, 5)
+			assert.NoError(t, err)
+			assert.Equal(t, test.n, decoded)
+		}
+	})
+
+	t.Run("non-minimal encodings", func(t *testing.T) {
+		for _, s := range []string{"00", "80", "0100", "0080", "ff0000"} {
+			b, _ := hex.DecodeString(s)
+
+			_, err := makeScriptNum(b, true, defaultNumSize)
+			assert.ErrorIs(t, err, ErrMinimalData, s)
+
+			_, err = makeScriptNum(b, false, defaultNumSize)
+			assert.NoError(t, err, s)
+		}
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		_, err := makeScriptNum([]byte{1, 2, 3, 4, 5}, false, defaultNumSize)
+		assert.ErrorIs(t, err, ErrScriptNumOverflow)
+	})
+}
+
+func TestCastToBool(t *testing.T) {
+	assert.False(t, castToBool(nil))
+	assert.False(t, castToBool([]byte{0, 0}))
+	assert.False(t, castToBool([]byte{0x80}))
+	assert.False(t, castToBool([]byte{0, 0, 0x80}))
+	assert.True(t, castToBool([]byte{0x80, 0}))
+	assert.True(t, castToBool([]byte{0, 1}))
+}
+
+func TestFindAndDelete(t *testing.T) {
+	// cases from Bitcoin Core's script_FindAndDelete test
+	tests := []struct {
+		script   string
+		sig      string
+		expected string
+		found    int
+	}{
+		{"0302ff03", "0302ff03", "", 1},
+		{"0302ff030302ff03", "0302ff03", "", 2},
+		{"0302ff030302ff03", "02", "0302ff030302ff03", 0},
+		{"0302ff030302ff03", "ff", "0302ff030302ff03", 0},
+		{"0302ff030302ff03", "03", "02ff0302ff03", 2},
+		{"0302ff030302ff03", "02ff03", "0302ff030302ff03", 0},
+		{"00", "0001", "00", 0},
+		{"0003feed", "03feed", "00", 1},
+		{"0003feed", "00", "03feed", 1},
+		// the remaining bytes after a malformed push are kept
+		{"0302ff0303", "0302ff03", "03", 1},
+	}
+
+	for _, test := range tests {
+		script, _ := hex.DecodeString(test.script)
+		sig, _ := hex.DecodeString(test.sig)
+
+		result, found := findAndDelete(script, sig)
+		assert.Equal(t, test.expected, hex.EncodeToString(result), test.script)
+		assert.Equal(t, test.found, found, test.script)
+	}
+}
+
+func TestIsWitnessProgram(t *testing.T) {
+	version, program, ok := IsWitnessProgram(asm(t, "0 0x14 0x0000000000000000000000000000000000000000"))
+	assert.True(t, ok)
+	assert.Equal(t, 0, version)
+	assert.Len(t, program, 20)
+
+	version, _, ok = IsWitnessProgram(asm(t, "1 0x02 0x4e73"))
+	assert.True(t, ok)
+	assert.Equal(t, 1, version)
+
+	_, _, ok = IsWitnessProgram(asm(t, "0 0x01 0x00"))
+	assert.False(t, ok)
+
+	_, _, ok = IsWitnessProgram(asm(t, "-1 0x02 0x4e73"))
+	assert.False(t, ok)
+
+	_, _, ok = IsWitnessProgram(asm(t, "0 0x4c02 0x4e73"))
+	assert.False(t, ok)
+}
+
+func TestAppendInt(t *testing.T) {
+	assert.Equal(t, []byte{0x00}, AppendInt(nil, 0))
+	assert.Equal(t, []byte{0x4f}, AppendInt(nil, -1))
+	assert.Equal(t, []byte{0x60}, AppendInt(nil, 16))
+	assert.Equal(t, []byte{0x01, 0x11}, AppendInt(nil, 17))
+	assert.Equal(t, []byte{0x03, 0xa0, 0x86, 0x01}, AppendInt(nil, 100000))
+}
+
+func TestOpcodeString(t *testing.T) {
+	assert.Equal(t, "OP_CHECKSIG", OpCheckSig.String())
+	assert.Equal(t, "5", Op5.String())
+	assert.Equal(t, "OP_PUSHBYTES_20", Opcode(20).String())
+	assert.Equal(t, "OP_UNKNOWN", Opcode(0xbb).String())
+}
@@ -0,0 +1,292 @@
+package script
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/secp256k1"
+	"github.com/haikoschol/btc-node-challenge/internal/vartypes"
+)
+
+const tapSighashTag = "TapSighash"
+
+// sigHashOne is returned by the legacy signature hash algorithm when the input index is out of range. Because of a
+// bug in the original implementation, this value is signed instead of failing.
+var sigHashOne = [32]byte{1}
+
+// LegacySignatureHash computes the hash that a signature with the given hash type in a legacy (non-segwit) input
+// commits to. scriptCode is the script being executed, starting after the last executed OP_CODESEPARATOR.
+func LegacySignatureHash(tx *btc.Transaction, inputIndex int, scriptCode []byte, hashType uint32) ([32]byte, error) {
+	if inputIndex >= len(tx.TxIn) {
+		return sigHashOne, nil
+	}
+
+	baseType := hashType & 0x1f
+	if baseType == SigHashSingle && inputIndex >= len(tx.TxOut) {
+		return sigHashOne, nil
+	}
+
+	scriptCode = removeCodeSeparators(scriptCode)
+	txCopy := btc.Transaction{
+		Version:  tx.Version,
+		LockTime: tx.LockTime,
+	}
+
+	if hashType&SigHashAnyoneCanPay != 0 {
+		in := tx.TxIn[inputIndex]
+		txCopy.TxIn = []btc.TxInput{{
+			PreviousOutput:  in.PreviousOutput,
+			SignatureScript: scriptCode,
+			Sequence:        in.Sequence,
+		}}
+	} else {
+		txCopy.TxIn = make([]btc.TxInput, len(tx.TxIn))
+		for i, in := range tx.TxIn {
+			txCopy.TxIn[i] = btc.TxInput{
+				PreviousOutput: in.PreviousOutput,
+				Sequence:       in.Sequence,
+			}
+
+			if i == inputIndex {
+				txCopy.TxIn[i].SignatureScript = scriptCode
+			} else if baseType == SigHashNone || baseType == SigHashSingle {
+				// other inputs can be updated without invalidating the signature
+				txCopy.TxIn[i].Sequence = 0
+			}
+		}
+	}
+
+	switch baseType {
+	case SigHashNone:
+		txCopy.TxOut = nil
+	case SigHashSingle:
+		// only the output with the same index as the input is signed, the ones before it are blanked out
+		txCopy.TxOut = make([]btc.TxOutput, inputIndex+1)
+		for i := 0; i < inputIndex; i++ {
+			txCopy.TxOut[i] = btc.TxOutput{Value: -1}
+		}
+		txCopy.TxOut[inputIndex] = tx.TxOut[inputIndex]
+	default:
+		txCopy.TxOut = tx.TxOut
+	}
+
+	encoded, err := txCopy.EncodeWithoutWitness()
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	encoded = binary.LittleEndian.AppendUint32(encoded, hashType)
+	return hash256(encoded), nil
+}
+
+// PrecomputedTxData holds the hashes of the parts of a transaction that are signed by every segwit input, so that
+// they don't have to be computed again for each signature.
+type PrecomputedTxData struct {
+	// SpentOutputs are the outputs spent by the inputs of the transaction, in the same order.
+	SpentOutputs []btc.TxOutput
+
+	// single SHA256 hashes used by BIP341
+	shaPrevouts      [32]byte
+	shaAmounts       [32]byte
+	shaScriptPubKeys [32]byte
+	shaSequences     [32]byte
+	shaOutputs       [32]byte
+
+	// double SHA256 hashes used by BIP143
+	hashPrevouts [32]byte
+	hashSequence [32]byte
+	hashOutputs  [32]byte
+}
+
+func NewPrecomputedTxData(tx *btc.Transaction, spentOutputs []btc.TxOutput) (*PrecomputedTxData, error) {
+	if len(spentOutputs) != len(tx.TxIn) {
+		return nil, ErrPrevOutCount
+	}
+
+	var prevouts, sequences, amounts, scriptPubKeys, outputs []byte
+	for i, in := range tx.TxIn {
+		prevouts = appendOutPoint(prevouts, in.PreviousOutput)
+		sequences = binary.LittleEndian.AppendUint32(sequences, in.Sequence)
+		amounts = binary.LittleEndian.AppendUint64(amounts, uint64(spentOutputs[i].Value))
+		scriptPubKeys = appendScript(scriptPubKeys, spentOutputs[i].ScriptPubKey)
+	}
+
+	for _, out := range tx.TxOut {
+		encoded, err := out.Encode()
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, encoded...)
+	}
+
+	d := &PrecomputedTxData{
+		SpentOutputs:     spentOutputs,
+		shaPrevouts:      sha256.Sum256(prevouts),
+		shaAmounts:       sha256.Sum256(amounts),
+		shaScriptPubKeys: sha256.Sum256(scriptPubKeys),
+		shaSequences:     sha256.Sum256(sequences),
+		shaOutputs:       sha256.Sum256(outputs),
+	}
+	d.hashPrevouts = sha256.Sum256(d.shaPrevouts[:])
+	d.hashSequence = sha256.Sum256(d.shaSequences[:])
+	d.hashOutputs = sha256.Sum256(d.shaOutputs[:])
+	return d, nil
+}
+
+// WitnessV0SignatureHash computes the hash that a signature in a segwit v0 input commits to (BIP143). Unlike the
+// legacy algorithm, it commits to the amount spent by the input.
+func WitnessV0SignatureHash(tx *btc.Transaction, inputIndex int, scriptCode []byte, hashType uint32, txData *PrecomputedTxData) ([32]byte, error) {
+	if txData == nil || inputIndex >= len(tx.TxIn) || inputIndex >= len(txData.SpentOutputs) {
+		return [32]byte{}, ErrPrevOutCount
+	}
+
+	baseType := hashType & 0x1f
+	anyoneCanPay := hashType&SigHashAnyoneCanPay != 0
+
+	var hashPrevouts, hashSequence, hashOutputs [32]byte
+	if !anyoneCanPay {
+		hashPrevouts = txData.hashPrevouts
+		if baseType != SigHashSingle && baseType != SigHashNone {
+			hashSequence = txData.hashSequence
+		}
+	}
+
+	if baseType != SigHashSingle && baseType != SigHashNone {
+		hashOutputs = txData.hashOutputs
+	} else if baseType == SigHashSingle && inputIndex < len(tx.TxOut) {
+		encoded, err := tx.TxOut[inputIndex].Encode()
+		if err != nil {
+			return [32]byte{}, err
+		}
+		hashOutputs = hash256(encoded)
+	}
+
+	in := tx.TxIn[inputIndex]
+	preimage := binary.LittleEndian.AppendUint32(nil, tx.Version)
+	preimage = append(preimage, hashPrevouts[:]...)
+	preimage = append(preimage, hashSequence[:]...)
+	preimage = appendOutPoint(preimage, in.PreviousOutput)
+	preimage = appendScript(preimage, scriptCode)
+	preimage = binary.LittleEndian.AppendUint64(preimage, uint64(txData.SpentOutputs[inputIndex].Value))
+	preimage = binary.LittleEndian.AppendUint32(preimage, in.Sequence)
+	preimage = append(preimage, hashOutputs[:]...)
+	preimage = binary.LittleEndian.AppendUint32(preimage, tx.LockTime)
+	preimage = binary.LittleEndian.AppendUint32(preimage, hashType)
+	return hash256(preimage), nil
+}
+
+// TaprootSignatureHash computes the hash that a Schnorr signature in a taproot key path spend (BIP341) or tapscript
+// (BIP342) commits to. It commits to the amounts and output scripts spent by all inputs.
+func TaprootSignatureHash(tx *btc.Transaction, inputIndex int, hashType byte, sigVersion SigVersion, execData *ExecData, txData *PrecomputedTxData) ([32]byte, error) {
+	if txData == nil || inputIndex >= len(tx.TxIn) || len(txData.SpentOutputs) != len(tx.TxIn) {
+		return [32]byte{}, ErrPrevOutCount
+	}
+
+	var extFlag byte
+	if sigVersion == SigVersionTapscript {
+		extFlag = 1
+	}
+
+	if hashType > SigHashSingle && (hashType < SigHashAnyoneCanPay|SigHashAll || hashType > SigHashAnyoneCanPay|SigHashSingle) {
+		return [32]byte{}, ErrSchnorrSigHashType
+	}
+
+	outputType := hashType & 3
+	if hashType == SigHashDefault {
+		outputType = SigHashAll
+	}
+	anyoneCanPay := hashType&SigHashAnyoneCanPay != 0
+
+	// the epoch allows for new signature hash algorithms that reuse the tag
+	msg := []byte{0, hashType}
+	msg = binary.LittleEndian.AppendUint32(msg, tx.Version)
+	msg = binary.LittleEndian.AppendUint32(msg, tx.LockTime)
+
+	if !anyoneCanPay {
+		msg = append(msg, txData.shaPrevouts[:]...)
+		msg = append(msg, txData.shaAmounts[:]...)
+		msg = append(msg, txData.shaScriptPubKeys[:]...)
+		msg = append(msg, txData.shaSequences[:]...)
+	}
+	if outputType == SigHashAll {
+		msg = append(msg, txData.shaOutputs[:]...)
+	}
+
+	spendType := extFlag * 2
+	if execData.annexPresent {
+		spendType++
+	}
+	msg = append(msg, spendType)
+
+	if anyoneCanPay {
+		in := tx.TxIn[inputIndex]
+		spent := txData.SpentOutputs[inputIndex]
+		msg = appendOutPoint(msg, in.PreviousOutput)
+		msg = binary.LittleEndian.AppendUint64(msg, uint64(spent.Value))
+		msg = appendScript(msg, spent.ScriptPubKey)
+		msg = binary.LittleEndian.AppendUint32(msg, in.Sequence)
+	} else {
+		msg = binary.LittleEndian.AppendUint32(msg, uint32(inputIndex))
+	}
+
+	if execData.annexPresent {
+		msg = append(msg, execData.annexHash[:]...)
+	}
+
+	if outputType == SigHashSingle {
+		// unlike the legacy algorithm, signing a non-existent output is an error
+		if inputIndex >= len(tx.TxOut) {
+			return [32]byte{}, ErrSchnorrSigHashType
+		}
+
+		encoded, err := tx.TxOut[inputIndex].Encode()
+		if err != nil {
+			return [32]byte{}, err
+		}
+		shaOutput := sha256.Sum256(encoded)
+		msg = append(msg, shaOutput[:]...)
+	}
+
+	if extFlag == 1 {
+		msg = append(msg, execData.tapLeafHash[:]...)
+		// key version, reserved for future public key types
+		msg = append(msg, 0)
+		msg = binary.LittleEndian.AppendUint32(msg, execData.codeSeparatorPos)
+	}
+	return secp256k1.TaggedHash(tapSighashTag, msg), nil
+}
+
+func appendOutPoint(b []byte, outPoint btc.OutPoint) []byte {
+	b = append(b, outPoint.Hash[:]...)
+	return binary.LittleEndian.AppendUint32(b, outPoint.Index)
+}
+
+// appendScript appends a script prefixed with its length.
+func appendScript(b, script []byte) []byte {
+	b = append(b, vartypes.NewVarInt(uint64(len(script))).Encode()...)
+	return append(b, script...)
+}
+
+// removeCodeSeparators returns the script without OP_CODESEPARATOR operations.
+func removeCodeSeparators(script []byte) []byte {
+	var result []byte
+	start := 0
+
+	t := newTokenizer(script)
+	for t.next() {
+		if t.instr.op == OpCodeSeparator {
+			result = append(result, script[start:t.pos-1]...)
+			start = t.pos
+		}
+	}
+
+	if start == 0 {
+		return script
+	}
+	return append(result, script[start:]...)
+}
+
+func hash256(b []byte) [32]byte {
+	inner := sha256.Sum256(b)
+	return sha256.Sum256(inner[:])
+}
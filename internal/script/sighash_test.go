@@ -0,0 +1,173 @@
+package script
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const (
+	// block170Tx is the first transaction that spent bitcoin, sending 10 BTC from the coinbase of block 9 to Hal Finney.
+	block170Tx = "0100000001c997a5e56e104102fa209c6a852dd90660a20b2d9c352423edce25857fcd3704000000004847304402204e45e16932b8af514961a1d3a1a25fdf3f4f7732e9d624c6c61548ab5fb8cd410220181522ec8eca07de4860a4acdd12909d831cc56cbbac4622082221a8768d1d0901ffffffff0200ca9a3b00000000434104ae1a62fe09c5f51b13905f07f06b99a2f7159b2225f374cd378d71302fa28414e7aab37397f554a7df5f142c21c1b7303b8a0626f1baded5c72a704f7e6cd84cac00286bee0000000043410411db93e1dcdb8a016b49840f8c53bc1eb68a382e97b1482ecad7b148a6909a5cb2e0eaddfb84ccf9744464f82e160bfa9b8b64f9d4c03f999b8643f656b412a3ac00000000"
+	// block9PubKeyScript is the P2PK output script of the coinbase of block 9.
+	block9PubKeyScript = "410411db93e1dcdb8a016b49840f8c53bc1eb68a382e97b1482ecad7b148a6909a5cb2e0eaddfb84ccf9744464f82e160bfa9b8b64f9d4c03f999b8643f656b412a3ac"
+)
+
+func decodeTx(t *testing.T, s string) *btc.Transaction {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+
+	tx, err := btc.DecodeTransaction(bytes.NewBuffer(b))
+	require.NoError(t, err)
+	return tx
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func newTxSigChecker(t *testing.T, tx *btc.Transaction, inputIndex int, spentOutputs ...btc.TxOutput) *TxSigChecker {
+	t.Helper()
+
+	txData, err := NewPrecomputedTxData(tx, spentOutputs)
+	require.NoError(t, err)
+	return NewTxSigChecker(tx, inputIndex, txData)
+}
+
+func TestLegacySignatureHash(t *testing.T) {
+	tx := decodeTx(t, block170Tx)
+	scriptCode := mustDecodeHex(t, block9PubKeyScript)
+
+	t.Run("sighash all", func(t *testing.T) {
+		hash, err := LegacySignatureHash(tx, 0, scriptCode, SigHashAll)
+		require.NoError(t, err)
+		assert.Equal(t, "7a05c6145f10101e9d6325494245adf1297d80f8f38d4d576d57cdba220bcb19", hex.EncodeToString(hash[:]))
+	})
+
+	t.Run("code separators are not signed", func(t *testing.T) {
+		withSeparators := append([]byte{byte(OpCodeSeparator)}, scriptCode...)
+		withSeparators = append(withSeparators, byte(OpCodeSeparator))
+
+		hash, err := LegacySignatureHash(tx, 0, withSeparators, SigHashAll)
+		require.NoError(t, err)
+		assert.Equal(t, "7a05c6145f10101e9d6325494245adf1297d80f8f38d4d576d57cdba220bcb19", hex.EncodeToString(hash[:]))
+	})
+
+	t.Run("hash types commit to different parts of the transaction", func(t *testing.T) {
+		hashes := make(map[[32]byte]bool)
+		for _, hashType := range []uint32{SigHashAll, SigHashNone, SigHashSingle, SigHashAll | SigHashAnyoneCanPay} {
+			hash, err := LegacySignatureHash(tx, 0, scriptCode, hashType)
+			require.NoError(t, err)
+			hashes[hash] = true
+		}
+		assert.Len(t, hashes, 4)
+	})
+
+	t.Run("sighash none ignores outputs", func(t *testing.T) {
+		expected, err := LegacySignatureHash(tx, 0, scriptCode, SigHashNone)
+		require.NoError(t, err)
+
+		modified := *tx
+		modified.TxOut = []btc.TxOutput{{Value: 1}}
+		hash, err := LegacySignatureHash(&modified, 0, scriptCode, SigHashNone)
+		require.NoError(t, err)
+		assert.Equal(t, expected, hash)
+	})
+
+	t.Run("sighash single without matching output", func(t *testing.T) {
+		modified := *tx
+		modified.TxIn = append(modified.TxIn, tx.TxIn[0], tx.TxIn[0])
+
+		hash, err := LegacySignatureHash(&modified, 2, scriptCode, SigHashSingle)
+		require.NoError(t, err)
+		assert.Equal(t, sigHashOne, hash)
+	})
+}
+
+func TestVerifyECDSASpend(t *testing.T) {
+	tx := decodeTx(t, block170Tx)
+	scriptPubKey := mustDecodeHex(t, block9PubKeyScript)
+	flags := StandardVerifyFlags
+	spent := btc.TxOutput{Value: 50_0000_0000, ScriptPubKey: scriptPubKey}
+
+	t.Run("valid spend", func(t *testing.T) {
+		checker := newTxSigChecker(t, tx, 0, spent)
+		assert.NoError(t, VerifyScript(tx.TxIn[0].SignatureScript, scriptPubKey, nil, flags, checker))
+	})
+
+	t.Run("modified transaction", func(t *testing.T) {
+		modified := *tx
+		modified.LockTime = 1
+
+		checker := newTxSigChecker(t, &modified, 0, spent)
+		err := VerifyScript(tx.TxIn[0].SignatureScript, scriptPubKey, nil, flags, checker)
+		assert.ErrorIs(t, err, ErrSigNullFail)
+
+		err = VerifyScript(tx.TxIn[0].SignatureScript, scriptPubKey, nil, MandatoryVerifyFlags, checker)
+		assert.ErrorIs(t, err, ErrEvalFalse)
+	})
+
+	t.Run("wrong public key", func(t *testing.T) {
+		otherKey := mustDecodeHex(t, "4104ae1a62fe09c5f51b13905f07f06b99a2f7159b2225f374cd378d71302fa28414e7aab37397f554a7df5f142c21c1b7303b8a0626f1baded5c72a704f7e6cd84cac")
+
+		checker := newTxSigChecker(t, tx, 0, spent)
+		err := VerifyScript(tx.TxIn[0].SignatureScript, otherKey, nil, MandatoryVerifyFlags, checker)
+		assert.ErrorIs(t, err, ErrEvalFalse)
+	})
+
+	t.Run("p2pkh", func(t *testing.T) {
+		pubKey := scriptPubKey[1 : len(scriptPubKey)-1]
+		p2pkh := asm(t, "DUP HASH160 0x14 0x"+hex.EncodeToString(Hash160(pubKey))+" EQUALVERIFY CHECKSIG")
+
+		// the signature commits to the script code, so it is only valid for the P2PK script
+		scriptSig := AppendPushData(tx.TxIn[0].SignatureScript, pubKey)
+		checker := newTxSigChecker(t, tx, 0, spent)
+		err := VerifyScript(scriptSig, p2pkh, nil, MandatoryVerifyFlags, checker)
+		assert.ErrorIs(t, err, ErrEvalFalse)
+	})
+}
+
+func TestWitnessV0SignatureHash(t *testing.T) {
+	// the signed native P2WPKH example from BIP143, with a P2PK input and a P2WPKH input
+	tx := decodeTx(t, "01000000000102fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f00000000494830450221008b9d1dc26ba6a9cb62127b02742fa9d754cd3bebf337f7a55d114c8e5cdd30be022040529b194ba3f9281a99f2b1c0a19c0489bc22ede944ccf4ecbab4cc618ef3ed01eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac000247304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d5447a12fb1366d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d43c212a8caed02de67eebee0121025476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee635711000000")
+	spent := []btc.TxOutput{
+		{Value: 625000000, ScriptPubKey: mustDecodeHex(t, "2103c9f4836b9a4f77fc0d81f7bcb01b7f1b35916864b9476c241ce9fc198bd25432ac")},
+		{Value: 600000000, ScriptPubKey: mustDecodeHex(t, "00141d0f172a0ecb48aee1be1f2687d2963ae33f71a1")},
+	}
+	txData, err := NewPrecomputedTxData(tx, spent)
+	require.NoError(t, err)
+
+	scriptCode := mustDecodeHex(t, "76a9141d0f172a0ecb48aee1be1f2687d2963ae33f71a188ac")
+	hash, err := WitnessV0SignatureHash(tx, 1, scriptCode, SigHashAll, txData)
+	require.NoError(t, err)
+	assert.Equal(t, "c37af31116d1b27caf68aae9e3ac82f1477929014d5b917657d0eb49478cb670", hex.EncodeToString(hash[:]))
+
+	for i, in := range tx.TxIn {
+		checker := NewTxSigChecker(tx, i, txData)
+		assert.NoError(t, VerifyScript(in.SignatureScript, spent[i].ScriptPubKey, in.Witness, MandatoryVerifyFlags, checker))
+	}
+
+	t.Run("signature commits to the amount", func(t *testing.T) {
+		spent := []btc.TxOutput{spent[0], {Value: spent[1].Value + 1, ScriptPubKey: spent[1].ScriptPubKey}}
+		checker := newTxSigChecker(t, tx, 1, spent...)
+		err := VerifyScript(nil, spent[1].ScriptPubKey, tx.TxIn[1].Witness, MandatoryVerifyFlags, checker)
+		assert.ErrorIs(t, err, ErrEvalFalse)
+	})
+
+	t.Run("spent outputs are required", func(t *testing.T) {
+		_, err := NewPrecomputedTxData(tx, spent[:1])
+		assert.ErrorIs(t, err, ErrPrevOutCount)
+
+		_, err = WitnessV0SignatureHash(tx, 1, scriptCode, SigHashAll, nil)
+		assert.ErrorIs(t, err, ErrPrevOutCount)
+	})
+}
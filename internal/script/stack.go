@@ -0,0 +1,61 @@
+package script
+
+// stack is the main or alt stack of the script engine. The top of the stack is the last element.
+type stack [][]byte
+
+func (s *stack) push(b []byte) {
+	*s = append(*s, b)
+}
+
+func (s *stack) pushBool(v bool) {
+	if v {
+		s.push([]byte{1})
+	} else {
+		s.push([]byte{})
+	}
+}
+
+func (s *stack) pushNum(n scriptNum) {
+	s.push(n.Bytes())
+}
+
+func (s *stack) pop() []byte {
+	top := (*s)[len(*s)-1]
+	*s = (*s)[:len(*s)-1]
+	return top
+}
+
+// top returns the element at the given negative offset from the end, so that top(-1) is the topmost element.
+func (s stack) top(offset int) []byte {
+	return s[len(s)+offset]
+}
+
+// remove deletes the element at the given negative offset from the end.
+func (s *stack) remove(offset int) {
+	i := len(*s) + offset
+	*s = append((*s)[:i], (*s)[i+1:]...)
+}
+
+func (s stack) swap(a, b int) {
+	s[len(s)+a], s[len(s)+b] = s[len(s)+b], s[len(s)+a]
+}
+
+func (s stack) copyStack() stack {
+	c := make(stack, len(s))
+	copy(c, s)
+	return c
+}
+
+// castToBool interprets a stack element as boolean. Any non-zero value is true, except for negative zero.
+func castToBool(b []byte) bool {
+	for i, v := range b {
+		if v != 0 {
+			// negative zero is false
+			if i == len(b)-1 && v == 0x80 {
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/secp256k1"
+	"github.com/haikoschol/btc-node-challenge/internal/vartypes"
+)
+
+const (
+	taprootProgramSize = 32
+	// annexTag is the first byte of the last witness element if it is an annex, which is reserved for future use.
+	annexTag = 0x50
+
+	TaprootLeafMask      = 0xfe
+	TaprootLeafTapscript = 0xc0
+
+	TaprootControlBaseSize     = 33
+	TaprootControlNodeSize     = 32
+	TaprootControlMaxNodeCount = 128
+	TaprootControlMaxSize      = TaprootControlBaseSize + TaprootControlNodeSize*TaprootControlMaxNodeCount
+
+	// ValidationWeightPerSigOp is the part of the witness size budget that each executed signature check in tapscript
+	// uses up.
+	ValidationWeightPerSigOp = 50
+	ValidationWeightOffset   = 50
+
+	// noCodeSeparator is the code separator position signed by tapscript signatures if no OP_CODESEPARATOR was executed.
+	noCodeSeparator = 0xffffffff
+
+	tapLeafTag   = "TapLeaf"
+	tapBranchTag = "TapBranch"
+)
+
+// ExecData holds data about a taproot spend that signatures commit to (BIP341) or that limit its execution (BIP342).
+type ExecData struct {
+	annexPresent bool
+	// annexHash is the SHA256 hash of the serialized annex.
+	annexHash   [32]byte
+	tapLeafHash [32]byte
+	// codeSeparatorPos is the opcode position of the last executed OP_CODESEPARATOR.
+	codeSeparatorPos     uint32
+	validationWeightLeft int64
+}
+
+// verifyTaproot verifies the spend of a witness v1 program, which is either a signature for the output key (key path)
+// or a script committed to by the output key and its inputs (script path).
+func verifyTaproot(witness btc.TxWitness, program []byte, flags VerifyFlags, checker SigChecker) error {
+	st := stack(witness).copyStack()
+	if len(st) == 0 {
+		return ErrWitnessProgramWitnessEmpty
+	}
+
+	execData := &ExecData{}
+	if len(st) >= 2 && len(st.top(-1)) > 0 && st.top(-1)[0] == annexTag {
+		annex := st.pop()
+		execData.annexHash = sha256.Sum256(append(vartypes.NewVarInt(uint64(len(annex))).Encode(), annex...))
+		execData.annexPresent = true
+	}
+
+	if len(st) == 1 {
+		return checker.CheckSchnorrSignature(st[0], program, SigVersionTaproot, execData)
+	}
+
+	control := st.pop()
+	script := st.pop()
+	if len(control) < TaprootControlBaseSize || len(control) > TaprootControlMaxSize ||
+		(len(control)-TaprootControlBaseSize)%TaprootControlNodeSize != 0 {
+		return ErrTaprootWrongControlSize
+	}
+
+	leafVersion := control[0] & TaprootLeafMask
+	execData.tapLeafHash = TapLeafHash(leafVersion, script)
+	if !verifyTaprootCommitment(control, program, execData.tapLeafHash) {
+		return ErrWitnessProgramMismatch
+	}
+
+	if leafVersion != TaprootLeafTapscript {
+		if flags&VerifyDiscourageUpgradableTaprootVersion != 0 {
+			return ErrDiscourageUpgradableTaprootVersion
+		}
+		// unknown leaf versions are anyone-can-spend, so that they can be assigned meaning later
+		return nil
+	}
+
+	execData.validationWeightLeft = int64(witnessSize(witness)) + ValidationWeightOffset
+	return executeWitnessScript(st, script, flags, checker, SigVersionTapscript, execData)
+}
+
+// TapLeafHash computes the hash of a leaf of a taproot script tree.
+func TapLeafHash(leafVersion byte, script []byte) [32]byte {
+	scriptLen := vartypes.NewVarInt(uint64(len(script))).Encode()
+	return secp256k1.TaggedHash(tapLeafTag, []byte{leafVersion}, scriptLen, script)
+}
+
+// TapBranchHash computes the hash of an inner node of a taproot script tree. The children are sorted, so that a
+// merkle proof doesn't need to specify the side of each node.
+func TapBranchHash(a, b []byte) [32]byte {
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+	return secp256k1.TaggedHash(tapBranchTag, a, b)
+}
+
+// verifyTaprootCommitment checks that the output key (program) commits to the script tree that contains the leaf, with
+// the internal key and merkle path given in the control block.
+func verifyTaprootCommitment(control, program []byte, tapLeafHash [32]byte) bool {
+	internalKey, err := secp256k1.ParseXOnlyPubKey(control[1:TaprootControlBaseSize])
+	if err != nil {
+		return false
+	}
+
+	node := tapLeafHash
+	for i := TaprootControlBaseSize; i < len(control); i += TaprootControlNodeSize {
+		node = TapBranchHash(node[:], control[i:i+TaprootControlNodeSize])
+	}
+
+	outputKey, err := secp256k1.TapTweak(internalKey, node[:])
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(outputKey.SerializeXOnly(), program) && outputKey.Y.Bit(0) == uint(control[0]&1)
+}
+
+// witnessSize returns the size of the serialized witness.
+func witnessSize(witness btc.TxWitness) int {
+	size := len(vartypes.NewVarInt(uint64(len(witness))).Encode())
+	for _, element := range witness {
+		size += len(vartypes.NewVarInt(uint64(len(element))).Encode()) + len(element)
+	}
+	return size
+}
+
+// evalCheckSigTapscript executes OP_CHECKSIG, OP_CHECKSIGVERIFY or OP_CHECKSIGADD in tapscript (BIP342).
+func evalCheckSigTapscript(sig, pubKey []byte, ctx *scriptContext) (bool, error) {
+	// an empty signature fails the check without an error and without using up the validation weight budget
+	success := len(sig) > 0
+	if success {
+		ctx.execData.validationWeightLeft -= ValidationWeightPerSigOp
+		if ctx.execData.validationWeightLeft < 0 {
+			return false, ErrTapscriptValidationWeight
+		}
+	}
+
+	switch len(pubKey) {
+	case 0:
+		return false, ErrPubKeyType
+	case secp256k1.XOnlyPubKeySize:
+		if success {
+			if err := ctx.checker.CheckSchnorrSignature(sig, pubKey, ctx.sigVersion, ctx.execData); err != nil {
+				return false, err
+			}
+		}
+	default:
+		// public keys of other sizes are reserved for soft forks and treated as valid for any non-empty signature
+		if ctx.flags&VerifyDiscourageUpgradablePubKeyType != 0 {
+			return false, ErrDiscourageUpgradablePubKeyType
+		}
+	}
+	return success, nil
+}
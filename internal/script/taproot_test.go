@@ -0,0 +1,208 @@
+package script
+
+import (
+	"encoding/hex"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/secp256k1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// taprootOutput returns the output script of a taproot output that commits to a single leaf, and the control block
+// for spending it. The internal key is the generator point.
+func taprootOutput(t *testing.T, leafVersion byte, script []byte) (scriptPubKey, control []byte) {
+	t.Helper()
+
+	x, y, _ := secp256k1.ScalarBaseMult(big.NewInt(1))
+	internalKey := &secp256k1.PublicKey{X: x, Y: y}
+
+	leafHash := TapLeafHash(leafVersion, script)
+	outputKey, err := secp256k1.TapTweak(internalKey, leafHash[:])
+	require.NoError(t, err)
+
+	scriptPubKey = asm(t, "1 0x20 0x"+hex.EncodeToString(outputKey.SerializeXOnly()))
+	control = append([]byte{leafVersion | byte(outputKey.Y.Bit(0))}, internalKey.SerializeXOnly()...)
+	return scriptPubKey, control
+}
+
+func TestTapscript(t *testing.T) {
+	flags := VerifyP2SH | VerifyWitness | VerifyTaproot
+	key := strings.Repeat("k", 32)
+	otherKey := strings.Repeat("o", 32)
+
+	tests := []struct {
+		name     string
+		stack    []string
+		script   string
+		flags    VerifyFlags
+		expected error
+	}{
+		{"checksig", []string{"sig" + key}, "'" + key + "' CHECKSIG", 0, nil},
+		{"invalid signature is an error", []string{"sig" + otherKey}, "'" + key + "' CHECKSIG", 0, ErrSchnorrSig},
+		{"empty signature fails", []string{""}, "'" + key + "' CHECKSIG", 0, ErrEvalFalse},
+		{"empty public key", []string{"sig"}, "0 CHECKSIG", 0, ErrPubKeyType},
+		{"checksigverify", []string{""}, "'" + key + "' CHECKSIGVERIFY 1", 0, ErrCheckSigVerify},
+		{"checksigadd", []string{"sig" + otherKey, "sig" + key}, "'" + key + "' CHECKSIG '" + otherKey + "' CHECKSIGADD 2 NUMEQUAL", 0, nil},
+		{"checksigadd with empty signature", []string{"", "sig" + key}, "'" + key + "' CHECKSIG '" + otherKey + "' CHECKSIGADD 1 NUMEQUAL", 0, nil},
+		{"checkmultisig is disabled", []string{""}, "0 0 CHECKMULTISIG", 0, ErrTapscriptCheckMultiSig},
+		{"unknown public key type", []string{"x"}, "'key' CHECKSIG", 0, nil},
+		{"discouraged public key type", []string{"x"}, "'key' CHECKSIG", VerifyDiscourageUpgradablePubKeyType, ErrDiscourageUpgradablePubKeyType},
+		{"validation weight", []string{"x"}, strings.Repeat("DUP 'k' CHECKSIGVERIFY ", 10) + "1", 0, ErrTapscriptValidationWeight},
+		{"op_success", nil, "RETURN CAT", 0, nil},
+		{"op_success before invalid opcode", nil, "CAT VERIF", 0, nil},
+		{"discouraged op_success", nil, "RETURN CAT", VerifyDiscourageOpSuccess, ErrDiscourageOpSuccess},
+		{"minimal if", []string{"\x02"}, "IF 1 ELSE 1 ENDIF", 0, ErrTapscriptMinimalIf},
+		{"no op limit", nil, strings.Repeat("NOP ", MaxOpsPerScript+1) + "1", 0, nil},
+		{"clean stack", []string{"\x01"}, "1", 0, ErrCleanStack},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script := asm(t, test.script)
+			scriptPubKey, control := taprootOutput(t, TaprootLeafTapscript, script)
+
+			var witness btc.TxWitness
+			for _, element := range test.stack {
+				witness = append(witness, []byte(element))
+			}
+			witness = append(witness, script, control)
+
+			err := VerifyScript(nil, scriptPubKey, witness, flags|test.flags, &fakeChecker{})
+			if test.expected == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, test.expected)
+			}
+		})
+	}
+}
+
+func TestTaproot(t *testing.T) {
+	flags := VerifyP2SH | VerifyWitness | VerifyTaproot
+	script := asm(t, "1")
+	scriptPubKey, control := taprootOutput(t, TaprootLeafTapscript, script)
+	program := scriptPubKey[2:]
+
+	t.Run("key path", func(t *testing.T) {
+		witness := btc.TxWitness{append([]byte("sig"), program...)}
+		assert.NoError(t, VerifyScript(nil, scriptPubKey, witness, flags, &fakeChecker{}))
+
+		witness = btc.TxWitness{[]byte("sig")}
+		assert.ErrorIs(t, VerifyScript(nil, scriptPubKey, witness, flags, &fakeChecker{}), ErrSchnorrSig)
+	})
+
+	t.Run("annex is not part of the stack", func(t *testing.T) {
+		witness := btc.TxWitness{script, control, {annexTag, 1}}
+		assert.NoError(t, VerifyScript(nil, scriptPubKey, witness, flags, &fakeChecker{}))
+	})
+
+	t.Run("empty witness", func(t *testing.T) {
+		assert.ErrorIs(t, VerifyScript(nil, scriptPubKey, nil, flags, &fakeChecker{}), ErrWitnessProgramWitnessEmpty)
+	})
+
+	t.Run("control block size", func(t *testing.T) {
+		for _, size := range []int{TaprootControlBaseSize - 1, TaprootControlBaseSize + 1, TaprootControlMaxSize + TaprootControlNodeSize} {
+			invalid := make([]byte, size)
+			copy(invalid, control)
+			witness := btc.TxWitness{script, invalid}
+			assert.ErrorIs(t, VerifyScript(nil, scriptPubKey, witness, flags, &fakeChecker{}), ErrTaprootWrongControlSize)
+		}
+	})
+
+	t.Run("commitment mismatch", func(t *testing.T) {
+		wrongParity := append([]byte{control[0] ^ 1}, control[1:]...)
+		witness := btc.TxWitness{script, wrongParity}
+		assert.ErrorIs(t, VerifyScript(nil, scriptPubKey, witness, flags, &fakeChecker{}), ErrWitnessProgramMismatch)
+
+		witness = btc.TxWitness{asm(t, "2"), control}
+		assert.ErrorIs(t, VerifyScript(nil, scriptPubKey, witness, flags, &fakeChecker{}), ErrWitnessProgramMismatch)
+	})
+
+	t.Run("merkle path", func(t *testing.T) {
+		sibling := TapLeafHash(TaprootLeafTapscript, asm(t, "2"))
+		leafHash := TapLeafHash(TaprootLeafTapscript, script)
+		root := TapBranchHash(leafHash[:], sibling[:])
+		assert.Equal(t, root, TapBranchHash(sibling[:], leafHash[:]))
+
+		internalKey, err := secp256k1.ParseXOnlyPubKey(control[1:])
+		require.NoError(t, err)
+		outputKey, err := secp256k1.TapTweak(internalKey, root[:])
+		require.NoError(t, err)
+
+		scriptPubKey := asm(t, "1 0x20 0x"+hex.EncodeToString(outputKey.SerializeXOnly()))
+		control := append([]byte{TaprootLeafTapscript | byte(outputKey.Y.Bit(0))}, control[1:]...)
+		witness := btc.TxWitness{script, append(control, sibling[:]...)}
+		assert.NoError(t, VerifyScript(nil, scriptPubKey, witness, flags, &fakeChecker{}))
+	})
+
+	t.Run("unknown leaf version", func(t *testing.T) {
+		scriptPubKey, control := taprootOutput(t, 0xc2, asm(t, "RETURN"))
+		witness := btc.TxWitness{asm(t, "RETURN"), control}
+		assert.NoError(t, VerifyScript(nil, scriptPubKey, witness, flags, &fakeChecker{}))
+
+		flags := flags | VerifyDiscourageUpgradableTaprootVersion
+		assert.ErrorIs(t, VerifyScript(nil, scriptPubKey, witness, flags, &fakeChecker{}), ErrDiscourageUpgradableTaprootVersion)
+	})
+
+	t.Run("taproot ignored without flag", func(t *testing.T) {
+		witness := btc.TxWitness{[]byte("sig")}
+		assert.NoError(t, VerifyScript(nil, scriptPubKey, witness, VerifyP2SH|VerifyWitness, &fakeChecker{}))
+	})
+}
+
+func TestTaprootSignatureHash(t *testing.T) {
+	t.Run("key path", func(t *testing.T) {
+		tx := decodeTx(t, "02000000000101aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa0000000000fdffffff01905f010000000000160014000102030405060708090a0b0c0d0e0f1011121301406924eeaa32dd1d692f6341f2ca59b0185e9be8859468656455de261b570f05cc0e8d39a0349143d9ddde2bb71913fed1910693a835037a638affe7188ded3c9100000000")
+		scriptPubKey := mustDecodeHex(t, "5120d699a08dbae4a347c82d5b74361b7178f626a6279e85900978952abff063eb71")
+		spent := btc.TxOutput{Value: 100000, ScriptPubKey: scriptPubKey}
+
+		checker := newTxSigChecker(t, tx, 0, spent)
+		hash, err := TaprootSignatureHash(tx, 0, SigHashDefault, SigVersionTaproot, &ExecData{}, checker.TxData)
+		require.NoError(t, err)
+		assert.Equal(t, "e7d11f2141dbb111f87879e94fdb1f8cfddc14612d504d8d9cf519245c6a3328", hex.EncodeToString(hash[:]))
+
+		witness := tx.TxIn[0].Witness
+		assert.NoError(t, VerifyScript(nil, scriptPubKey, witness, StandardVerifyFlags, checker))
+
+		// the signature commits to the amount
+		spent.Value++
+		checker = newTxSigChecker(t, tx, 0, spent)
+		assert.ErrorIs(t, VerifyScript(nil, scriptPubKey, witness, StandardVerifyFlags, checker), ErrSchnorrSig)
+
+		explicitDefault := btc.TxWitness{append(append([]byte{}, witness[0]...), SigHashDefault)}
+		assert.ErrorIs(t, VerifyScript(nil, scriptPubKey, explicitDefault, StandardVerifyFlags, checker), ErrSchnorrSigHashType)
+
+		assert.ErrorIs(t, VerifyScript(nil, scriptPubKey, btc.TxWitness{witness[0][1:]}, StandardVerifyFlags, checker), ErrSchnorrSigSize)
+	})
+
+	t.Run("script path with annex", func(t *testing.T) {
+		// input 0 is signed with SIGHASH_SINGLE|SIGHASH_ANYONECANPAY by a tapscript with a single CHECKSIG
+		tx := decodeTx(t, "02000000000102bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb0100000000ffffffffcccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc0000000000ffffffff02905f010000000000160014000102030405060708090a0b0c0d0e0f101112138813000000000000016a044114b772f493324ded1a58f7868efbadc34c7d77960554445006b50419e47a24dee9562c500aa90e137121054bd04c792fc17a821436aac8c5f13a8f5c3870e38683222036298306e869232f364a2daf2000a5b4e990bb249182d7b4ebe02065d8ca1a79ac21c0361254815107ab55f94f825ea9f83d491551bcfaa1790471c96f8eccbeee75af035001020000000000")
+		spent := []btc.TxOutput{
+			{Value: 100000, ScriptPubKey: mustDecodeHex(t, "512013bb655c56beda3f7908978d075ad39784bfaecaad01d8942c87434182e54ae7")},
+			{Value: 20000, ScriptPubKey: asm(t, "1")},
+		}
+
+		witness := tx.TxIn[0].Witness
+		leafHash := TapLeafHash(TaprootLeafTapscript, witness[1])
+		assert.Equal(t, "ac2f8c868a294ff62c402cb7df00a09dc21b3ad9a1f1278f3e93f4500e263a1d", hex.EncodeToString(leafHash[:]))
+
+		checker := newTxSigChecker(t, tx, 0, spent...)
+		assert.NoError(t, VerifyScript(nil, spent[0].ScriptPubKey, witness, StandardVerifyFlags, checker))
+
+		checker = newTxSigChecker(t, tx, 1, spent...)
+		assert.NoError(t, VerifyScript(nil, spent[1].ScriptPubKey, nil, StandardVerifyFlags, checker))
+
+		// the signature doesn't commit to the other input, but to the annex
+		modified := *tx
+		modified.TxIn = []btc.TxInput{tx.TxIn[0]}
+		checker = newTxSigChecker(t, &modified, 0, spent[0])
+		assert.NoError(t, VerifyScript(nil, spent[0].ScriptPubKey, witness, StandardVerifyFlags, checker))
+
+		otherAnnex := btc.TxWitness{witness[0], witness[1], witness[2], {annexTag}}
+		assert.ErrorIs(t, VerifyScript(nil, spent[0].ScriptPubKey, otherAnnex, StandardVerifyFlags, checker), ErrSchnorrSig)
+	})
+}
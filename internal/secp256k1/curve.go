@@ -0,0 +1,211 @@
+// Package secp256k1 implements the elliptic curve operations needed to verify Bitcoin signatures and for the key
+// exchange of the v2 P2P transport.
+//
+// The arithmetic is built on math/big and is NOT constant time: the timing of scalar multiplications depends on the
+// private key, so the package is not safe against side-channel attacks and must not be used with long-lived private
+// keys. The v2 transport only uses it with ephemeral keys, which are generated for a single connection and discarded
+// after the key exchange.
+package secp256k1
+
+import "math/big"
+
+var (
+	// P is the prime of the field the curve y^2 = x^3 + 7 is defined over.
+	P = fromHex("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f")
+	// N is the order of the group generated by G.
+	N = fromHex("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141")
+
+	gx = fromHex("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798")
+	gy = fromHex("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8")
+
+	curveB = big.NewInt(7)
+	// sqrtExp is (P + 1) / 4. Because P = 3 mod 4, a^sqrtExp is a square root of a if one exists.
+	sqrtExp = new(big.Int).Rsh(new(big.Int).Add(P, big.NewInt(1)), 2)
+)
+
+func fromHex(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("invalid hex constant " + s)
+	}
+	return n
+}
+
+// jacobianPoint is a point in Jacobian coordinates, which represent the affine point (x / z^2, y / z^3). They allow
+// adding and doubling points without computing a modular inverse each time. The point at infinity has z = 0.
+type jacobianPoint struct {
+	x, y, z *big.Int
+}
+
+func infinity() *jacobianPoint {
+	return &jacobianPoint{x: new(big.Int), y: new(big.Int), z: new(big.Int)}
+}
+
+func fromAffine(x, y *big.Int) *jacobianPoint {
+	return &jacobianPoint{x: new(big.Int).Set(x), y: new(big.Int).Set(y), z: big.NewInt(1)}
+}
+
+func generator() *jacobianPoint {
+	return fromAffine(gx, gy)
+}
+
+func (p *jacobianPoint) isInfinity() bool {
+	return p.z.Sign() == 0
+}
+
+// toAffine returns the affine coordinates of the point. ok is false for the point at infinity.
+func (p *jacobianPoint) toAffine() (x, y *big.Int, ok bool) {
+	if p.isInfinity() {
+		return nil, nil, false
+	}
+
+	zInv := new(big.Int).ModInverse(p.z, P)
+	zInv2 := mulMod(zInv, zInv)
+
+	x = mulMod(p.x, zInv2)
+	y = mulMod(p.y, mulMod(zInv2, zInv))
+	return x, y, true
+}
+
+func mulMod(a, b *big.Int) *big.Int {
+	r := new(big.Int).Mul(a, b)
+	return r.Mod(r, P)
+}
+
+func subMod(a, b *big.Int) *big.Int {
+	r := new(big.Int).Sub(a, b)
+	return r.Mod(r, P)
+}
+
+func addMod(a, b *big.Int) *big.Int {
+	r := new(big.Int).Add(a, b)
+	return r.Mod(r, P)
+}
+
+// double returns 2p using the dbl-2009-l formulas for curves with a = 0.
+func (p *jacobianPoint) double() *jacobianPoint {
+	if p.isInfinity() || p.y.Sign() == 0 {
+		return infinity()
+	}
+
+	a := mulMod(p.x, p.x)
+	b := mulMod(p.y, p.y)
+	c := mulMod(b, b)
+
+	xb := addMod(p.x, b)
+	d := subMod(subMod(mulMod(xb, xb), a), c)
+	d = addMod(d, d)
+
+	e := addMod(addMod(a, a), a)
+	f := mulMod(e, e)
+
+	x := subMod(f, addMod(d, d))
+
+	c8 := new(big.Int).Lsh(c, 3)
+	y := subMod(mulMod(e, subMod(d, x)), c8)
+
+	z := mulMod(p.y, p.z)
+	z = addMod(z, z)
+
+	return &jacobianPoint{x: x, y: y, z: z}
+}
+
+// add returns p + q using the add-2007-bl formulas.
+func (p *jacobianPoint) add(q *jacobianPoint) *jacobianPoint {
+	if p.isInfinity() {
+		return q
+	}
+	if q.isInfinity() {
+		return p
+	}
+
+	z1z1 := mulMod(p.z, p.z)
+	z2z2 := mulMod(q.z, q.z)
+	u1 := mulMod(p.x, z2z2)
+	u2 := mulMod(q.x, z1z1)
+	s1 := mulMod(p.y, mulMod(q.z, z2z2))
+	s2 := mulMod(q.y, mulMod(p.z, z1z1))
+
+	h := subMod(u2, u1)
+	r := subMod(s2, s1)
+	if h.Sign() == 0 {
+		if r.Sign() == 0 {
+			return p.double()
+		}
+		return infinity()
+	}
+
+	h2 := addMod(h, h)
+	i := mulMod(h2, h2)
+	j := mulMod(h, i)
+	r = addMod(r, r)
+	v := mulMod(u1, i)
+
+	x := subMod(subMod(mulMod(r, r), j), addMod(v, v))
+
+	s1j := mulMod(s1, j)
+	y := subMod(mulMod(r, subMod(v, x)), addMod(s1j, s1j))
+
+	zz := addMod(p.z, q.z)
+	z := mulMod(subMod(subMod(mulMod(zz, zz), z1z1), z2z2), h)
+
+	return &jacobianPoint{x: x, y: y, z: z}
+}
+
+// jointScalarMult computes k1 * p1 + k2 * p2 with Shamir's trick, which shares the doublings between both products.
+func jointScalarMult(k1 *big.Int, p1 *jacobianPoint, k2 *big.Int, p2 *jacobianPoint) *jacobianPoint {
+	sum := p1.add(p2)
+	result := infinity()
+
+	for i := max(k1.BitLen(), k2.BitLen()) - 1; i >= 0; i-- {
+		result = result.double()
+
+		b1, b2 := k1.Bit(i), k2.Bit(i)
+		switch {
+		case b1 == 1 && b2 == 1:
+			result = result.add(sum)
+		case b1 == 1:
+			result = result.add(p1)
+		case b2 == 1:
+			result = result.add(p2)
+		}
+	}
+	return result
+}
+
+// ScalarBaseMult returns the affine coordinates of k * G. ok is false if the result is the point at infinity.
+func ScalarBaseMult(k *big.Int) (x, y *big.Int, ok bool) {
+	return jointScalarMult(k, generator(), new(big.Int), infinity()).toAffine()
+}
+
+// IsOnCurve returns true if (x, y) is a point on the curve.
+func IsOnCurve(x, y *big.Int) bool {
+	if x.Sign() < 0 || x.Cmp(P) >= 0 || y.Sign() < 0 || y.Cmp(P) >= 0 {
+		return false
+	}
+	return mulMod(y, y).Cmp(curveY2(x)) == 0
+}
+
+// curveY2 returns x^3 + 7 mod P.
+func curveY2(x *big.Int) *big.Int {
+	return addMod(mulMod(mulMod(x, x), x), curveB)
+}
+
+// liftX returns the y coordinate of the point with the given x coordinate whose y is even or odd depending on odd. ok
+// is false if there is no such point.
+func liftX(x *big.Int, odd bool) (y *big.Int, ok bool) {
+	if x.Sign() < 0 || x.Cmp(P) >= 0 {
+		return nil, false
+	}
+
+	y2 := curveY2(x)
+	y = new(big.Int).Exp(y2, sqrtExp, P)
+	if mulMod(y, y).Cmp(y2) != 0 {
+		return nil, false
+	}
+
+	if (y.Bit(0) == 1) != odd {
+		y.Sub(P, y)
+	}
+	return y, true
+}
@@ -0,0 +1,141 @@
+package secp256k1
+
+import (
+	"errors"
+	"math/big"
+)
+
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// halfN is the largest S value of a signature in low-S form.
+var halfN = new(big.Int).Rsh(N, 1)
+
+// Signature is an ECDSA signature.
+type Signature struct {
+	R, S *big.Int
+}
+
+// ParseDERSignature parses a DER encoded signature as leniently as Bitcoin Core does for verification. Lots of
+// signatures from before BIP66 violate DER in various ways, like padding or length bytes in long form, and are still
+// valid. Values of R or S that don't fit into 32 bytes result in a signature that never verifies, not an error, like in
+// libsecp256k1.
+func ParseDERSignature(b []byte) (*Signature, error) {
+	pos := 0
+
+	// sequence tag and length, which is ignored
+	if pos == len(b) || b[pos] != 0x30 {
+		return nil, ErrInvalidSignature
+	}
+	pos++
+
+	if pos == len(b) {
+		return nil, ErrInvalidSignature
+	}
+	lenByte := int(b[pos])
+	pos++
+	if lenByte&0x80 != 0 {
+		lenByte -= 0x80
+		if lenByte > len(b)-pos {
+			return nil, ErrInvalidSignature
+		}
+		pos += lenByte
+	}
+
+	r, pos, err := parseDERInteger(b, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	s, _, err := parseDERInteger(b, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	sig := &Signature{R: new(big.Int), S: new(big.Int)}
+	if len(r) > 32 || len(s) > 32 {
+		return sig, nil
+	}
+
+	sig.R.SetBytes(r)
+	sig.S.SetBytes(s)
+	if sig.R.Cmp(N) >= 0 || sig.S.Cmp(N) >= 0 {
+		sig.R.SetInt64(0)
+		sig.S.SetInt64(0)
+	}
+	return sig, nil
+}
+
+// parseDERInteger returns the bytes of the integer at pos without leading zeros and the position after it.
+func parseDERInteger(b []byte, pos int) ([]byte, int, error) {
+	if pos == len(b) || b[pos] != 0x02 {
+		return nil, 0, ErrInvalidSignature
+	}
+	pos++
+
+	if pos == len(b) {
+		return nil, 0, ErrInvalidSignature
+	}
+	lenByte := int(b[pos])
+	pos++
+
+	length := lenByte
+	if lenByte&0x80 != 0 {
+		lenByte -= 0x80
+		if lenByte > len(b)-pos {
+			return nil, 0, ErrInvalidSignature
+		}
+
+		for lenByte > 0 && b[pos] == 0 {
+			pos++
+			lenByte--
+		}
+		if lenByte >= 4 {
+			return nil, 0, ErrInvalidSignature
+		}
+
+		length = 0
+		for ; lenByte > 0; lenByte-- {
+			length = length<<8 + int(b[pos])
+			pos++
+		}
+	}
+
+	if length > len(b)-pos {
+		return nil, 0, ErrInvalidSignature
+	}
+
+	value := b[pos : pos+length]
+	for len(value) > 0 && value[0] == 0 {
+		value = value[1:]
+	}
+	return value, pos + length, nil
+}
+
+// IsLowS returns true if S is at most half the group order. For every valid signature (R, S), (R, N - S) is also valid,
+// so only allowing the lower value prevents third parties from changing the signature.
+func (sig *Signature) IsLowS() bool {
+	return sig.S.Cmp(halfN) <= 0
+}
+
+// Verify returns true if sig is a valid signature of the 32 byte hash by key. High S values are accepted.
+func (sig *Signature) Verify(hash []byte, key *PublicKey) bool {
+	if sig.R.Sign() <= 0 || sig.S.Sign() <= 0 || sig.R.Cmp(N) >= 0 || sig.S.Cmp(N) >= 0 {
+		return false
+	}
+
+	e := new(big.Int).SetBytes(hash)
+	w := new(big.Int).ModInverse(sig.S, N)
+
+	u1 := new(big.Int).Mul(e, w)
+	u1.Mod(u1, N)
+	u2 := new(big.Int).Mul(sig.R, w)
+	u2.Mod(u2, N)
+
+	x, _, ok := jointScalarMult(u1, generator(), u2, key.jacobian()).toAffine()
+	if !ok {
+		return false
+	}
+
+	x.Mod(x, N)
+	return x.Cmp(sig.R) == 0
+}
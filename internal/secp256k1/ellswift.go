@@ -0,0 +1,223 @@
+package secp256k1
+
+import (
+	"errors"
+	"io"
+	"math/big"
+)
+
+const (
+	// EllSwiftPubKeySize is the size of an ElligatorSwift encoded public key (BIP324).
+	EllSwiftPubKeySize = 64
+
+	ellSwiftECDHTag = "bip324_ellswift_xonly_ecdh"
+)
+
+var ErrInvalidPrivKey = errors.New("invalid private key")
+
+var (
+	// sqrtMinus3 is a square root of -3 mod P, which the SwiftEC mapping is built on.
+	sqrtMinus3, _ = fieldSqrt(new(big.Int).Sub(P, big.NewInt(3)))
+	big1          = big.NewInt(1)
+	big2          = big.NewInt(2)
+	big4          = big.NewInt(4)
+)
+
+// EllSwiftCreate generates a private key and the ElligatorSwift encoding of its public key. The encoding is 64 bytes
+// that are indistinguishable from random data, which is why BIP324 uses it for the key exchange of the v2 transport.
+func EllSwiftCreate(rand io.Reader) (*big.Int, [EllSwiftPubKeySize]byte, error) {
+	var encoded [EllSwiftPubKeySize]byte
+
+	priv, err := randomScalar(rand, N)
+	if err != nil {
+		return nil, encoded, err
+	}
+
+	x, _, _ := ScalarBaseMult(priv)
+	for {
+		u, err := randomScalar(rand, P)
+		if err != nil {
+			return nil, encoded, err
+		}
+
+		var c [1]byte
+		if _, err := io.ReadFull(rand, c[:]); err != nil {
+			return nil, encoded, err
+		}
+
+		t, ok := xSwiftECInv(x, u, int(c[0]&7))
+		if ok {
+			u.FillBytes(encoded[:32])
+			t.FillBytes(encoded[32:])
+			return priv, encoded, nil
+		}
+	}
+}
+
+// EllSwiftDecode returns the x coordinate of the public key in ElligatorSwift encoding. Every 64 byte string is a valid
+// encoding.
+func EllSwiftDecode(encoded [EllSwiftPubKeySize]byte) *big.Int {
+	u := new(big.Int).SetBytes(encoded[:32])
+	t := new(big.Int).SetBytes(encoded[32:])
+	return xSwiftEC(u.Mod(u, P), t.Mod(t, P))
+}
+
+// EllSwiftECDH computes the shared secret of the BIP324 key exchange between the holder of priv, whose public key is
+// ours, and the peer with the public key theirs. Both public keys are ElligatorSwift encoded. initiator tells whether
+// ours belongs to the side that initiated the connection, since the hash commits to the keys in that order. The
+// multiplication with priv is not constant time, so priv should be an ephemeral key from EllSwiftCreate.
+func EllSwiftECDH(
+	priv *big.Int,
+	ours, theirs [EllSwiftPubKeySize]byte,
+	initiator bool,
+) ([32]byte, error) {
+	if priv.Sign() <= 0 || priv.Cmp(N) >= 0 {
+		return [32]byte{}, ErrInvalidPrivKey
+	}
+
+	x := EllSwiftDecode(theirs)
+	y, ok := liftX(x, false)
+	if !ok {
+		return [32]byte{}, ErrInvalidPubKey
+	}
+
+	sx, _, ok := jointScalarMult(priv, fromAffine(x, y), new(big.Int), infinity()).toAffine()
+	if !ok {
+		return [32]byte{}, ErrInvalidPubKey
+	}
+
+	var shared [32]byte
+	sx.FillBytes(shared[:])
+
+	if initiator {
+		return TaggedHash(ellSwiftECDHTag, ours[:], theirs[:], shared[:]), nil
+	}
+	return TaggedHash(ellSwiftECDHTag, theirs[:], ours[:], shared[:]), nil
+}
+
+// xSwiftEC maps the field elements u and t to the x coordinate of a point on the curve.
+func xSwiftEC(u, t *big.Int) *big.Int {
+	if u.Sign() == 0 {
+		u = big1
+	}
+	if t.Sign() == 0 {
+		t = big1
+	}
+
+	u3Plus7 := curveY2(u)
+	if addMod(u3Plus7, mulMod(t, t)).Sign() == 0 {
+		t = addMod(t, t)
+	}
+
+	// X = (u^3 + 7 - t^2) / (2 * t), Y = (X + t) / (sqrt(-3) * u)
+	bigX := mulMod(subMod(u3Plus7, mulMod(t, t)), invMod(addMod(t, t)))
+	bigY := mulMod(addMod(bigX, t), invMod(mulMod(sqrtMinus3, u)))
+
+	// one of the three candidates is always on the curve
+	x := addMod(u, mulMod(big4, mulMod(bigY, bigY)))
+	if isValidX(x) {
+		return x
+	}
+
+	xOverY := mulMod(bigX, invMod(bigY))
+	half := invMod(big2)
+
+	x = mulMod(subMod(subMod(new(big.Int), xOverY), u), half)
+	if isValidX(x) {
+		return x
+	}
+
+	return mulMod(subMod(xOverY, u), half)
+}
+
+// xSwiftECInv returns t such that xSwiftEC(u, t) = x. Depending on x and u there are up to eight such values, of which
+// c selects one. ok is false if the selected one doesn't exist.
+func xSwiftECInv(x, u *big.Int, c int) (t *big.Int, ok bool) {
+	var s, v *big.Int
+
+	if c&2 == 0 {
+		if isValidX(subMod(subMod(new(big.Int), x), u)) {
+			return nil, false
+		}
+
+		v = x
+		// s = -(u^3 + 7) / (u^2 + u * v + v^2)
+		denominator := addMod(addMod(mulMod(u, u), mulMod(u, v)), mulMod(v, v))
+		if denominator.Sign() == 0 {
+			return nil, false
+		}
+		s = mulMod(subMod(new(big.Int), curveY2(u)), invMod(denominator))
+	} else {
+		s = subMod(x, u)
+		if s.Sign() == 0 {
+			return nil, false
+		}
+
+		// r = sqrt(-s * (4 * (u^3 + 7) + 3 * s * u^2))
+		inner := addMod(mulMod(big4, curveY2(u)), mulMod(big.NewInt(3), mulMod(s, mulMod(u, u))))
+		r, ok := fieldSqrt(mulMod(subMod(new(big.Int), s), inner))
+		if !ok {
+			return nil, false
+		}
+		if c&1 == 1 && r.Sign() == 0 {
+			return nil, false
+		}
+
+		// v = (-u + r / s) / 2
+		v = mulMod(addMod(subMod(new(big.Int), u), mulMod(r, invMod(s))), invMod(big2))
+	}
+
+	w, ok := fieldSqrt(s)
+	if !ok {
+		return nil, false
+	}
+
+	// u * (1 - sqrt(-3)) / 2 + v and u * (1 + sqrt(-3)) / 2 + v
+	half := invMod(big2)
+	minus := addMod(mulMod(mulMod(u, subMod(big1, sqrtMinus3)), half), v)
+	plus := addMod(mulMod(mulMod(u, addMod(big1, sqrtMinus3)), half), v)
+
+	switch c & 5 {
+	case 0:
+		return subMod(new(big.Int), mulMod(w, minus)), true
+	case 1:
+		return mulMod(w, plus), true
+	case 4:
+		return mulMod(w, minus), true
+	default:
+		return subMod(new(big.Int), mulMod(w, plus)), true
+	}
+}
+
+// fieldSqrt returns a square root of a mod P. ok is false if a is not a square.
+func fieldSqrt(a *big.Int) (*big.Int, bool) {
+	r := new(big.Int).Exp(a, sqrtExp, P)
+	if mulMod(r, r).Cmp(new(big.Int).Mod(a, P)) != 0 {
+		return nil, false
+	}
+	return r, true
+}
+
+func invMod(a *big.Int) *big.Int {
+	return new(big.Int).ModInverse(a, P)
+}
+
+// isValidX returns true if x is the x coordinate of a point on the curve.
+func isValidX(x *big.Int) bool {
+	return big.Jacobi(curveY2(x), P) >= 0
+}
+
+// randomScalar returns a uniformly random integer in [1, max).
+func randomScalar(rand io.Reader, max *big.Int) (*big.Int, error) {
+	var b [32]byte
+	for {
+		if _, err := io.ReadFull(rand, b[:]); err != nil {
+			return nil, err
+		}
+
+		k := new(big.Int).SetBytes(b[:])
+		if k.Sign() > 0 && k.Cmp(max) < 0 {
+			return k, nil
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package secp256k1
+
+import (
+	"errors"
+	"math/big"
+)
+
+var ErrInvalidPubKey = errors.New("invalid public key")
+
+const (
+	PubKeySizeCompressed   = 33
+	PubKeySizeUncompressed = 65
+
+	pubKeyEven         = 0x02
+	pubKeyOdd          = 0x03
+	pubKeyUncompressed = 0x04
+	pubKeyHybridEven   = 0x06
+	pubKeyHybridOdd    = 0x07
+)
+
+// PublicKey is a point on the curve other than the point at infinity.
+type PublicKey struct {
+	X, Y *big.Int
+}
+
+// ParsePubKey parses a public key in compressed (0x02/0x03 prefix), uncompressed (0x04) or hybrid (0x06/0x07) format.
+// The hybrid format contains both coordinates like the uncompressed one, but the prefix also encodes whether y is odd.
+// It is non-standard, but valid in scripts that don't require strict encoding.
+func ParsePubKey(b []byte) (*PublicKey, error) {
+	if len(b) == 0 {
+		return nil, ErrInvalidPubKey
+	}
+
+	switch b[0] {
+	case pubKeyEven, pubKeyOdd:
+		if len(b) != PubKeySizeCompressed {
+			return nil, ErrInvalidPubKey
+		}
+
+		x := new(big.Int).SetBytes(b[1:])
+		y, ok := liftX(x, b[0] == pubKeyOdd)
+		if !ok {
+			return nil, ErrInvalidPubKey
+		}
+		return &PublicKey{X: x, Y: y}, nil
+	case pubKeyUncompressed, pubKeyHybridEven, pubKeyHybridOdd:
+		if len(b) != PubKeySizeUncompressed {
+			return nil, ErrInvalidPubKey
+		}
+
+		x := new(big.Int).SetBytes(b[1:33])
+		y := new(big.Int).SetBytes(b[33:])
+		if !IsOnCurve(x, y) {
+			return nil, ErrInvalidPubKey
+		}
+
+		if b[0] != pubKeyUncompressed && (y.Bit(0) == 1) != (b[0] == pubKeyHybridOdd) {
+			return nil, ErrInvalidPubKey
+		}
+		return &PublicKey{X: x, Y: y}, nil
+	default:
+		return nil, ErrInvalidPubKey
+	}
+}
+
+// SerializeCompressed returns the 33 byte encoding of the key with the parity of y in the first byte.
+func (k *PublicKey) SerializeCompressed() []byte {
+	b := make([]byte, PubKeySizeCompressed)
+	b[0] = pubKeyEven
+	if k.Y.Bit(0) == 1 {
+		b[0] = pubKeyOdd
+	}
+	k.X.FillBytes(b[1:])
+	return b
+}
+
+// SerializeUncompressed returns the 65 byte encoding of the key containing both coordinates.
+func (k *PublicKey) SerializeUncompressed() []byte {
+	b := make([]byte, PubKeySizeUncompressed)
+	b[0] = pubKeyUncompressed
+	k.X.FillBytes(b[1:33])
+	k.Y.FillBytes(b[33:])
+	return b
+}
+
+func (k *PublicKey) jacobian() *jacobianPoint {
+	return fromAffine(k.X, k.Y)
+}
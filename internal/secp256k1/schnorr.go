@@ -0,0 +1,93 @@
+package secp256k1
+
+import (
+	"crypto/sha256"
+	"math/big"
+)
+
+const (
+	XOnlyPubKeySize = 32
+	SchnorrSigSize  = 64
+	bip340Challenge = "BIP0340/challenge"
+	tapTweakTag     = "TapTweak"
+)
+
+// TaggedHash computes SHA256(SHA256(tag) || SHA256(tag) || msg) as defined in BIP340. Prefixing the message with the
+// tag makes hashes computed for different purposes independent of each other.
+func TaggedHash(tag string, msg ...[]byte) [32]byte {
+	tagHash := sha256.Sum256([]byte(tag))
+
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, m := range msg {
+		h.Write(m)
+	}
+
+	var result [32]byte
+	copy(result[:], h.Sum(nil))
+	return result
+}
+
+// ParseXOnlyPubKey parses a 32 byte public key as used by BIP340, which is the x coordinate of the point with even y.
+func ParseXOnlyPubKey(b []byte) (*PublicKey, error) {
+	if len(b) != XOnlyPubKeySize {
+		return nil, ErrInvalidPubKey
+	}
+
+	x := new(big.Int).SetBytes(b)
+	y, ok := liftX(x, false)
+	if !ok {
+		return nil, ErrInvalidPubKey
+	}
+	return &PublicKey{X: x, Y: y}, nil
+}
+
+// SerializeXOnly returns the 32 byte x coordinate of the key.
+func (k *PublicKey) SerializeXOnly() []byte {
+	b := make([]byte, XOnlyPubKeySize)
+	k.X.FillBytes(b)
+	return b
+}
+
+// VerifySchnorr returns true if sig is a valid BIP340 signature of the 32 byte message msg by key.
+func VerifySchnorr(sig, msg []byte, key *PublicKey) bool {
+	if len(sig) != SchnorrSigSize {
+		return false
+	}
+
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if r.Cmp(P) >= 0 || s.Cmp(N) >= 0 {
+		return false
+	}
+
+	e := TaggedHash(bip340Challenge, sig[:32], key.SerializeXOnly(), msg)
+	negE := new(big.Int).SetBytes(e[:])
+	negE.Mod(negE, N)
+	negE.Sub(N, negE)
+
+	// R = s * G - e * P
+	rx, ry, ok := jointScalarMult(s, generator(), negE, key.jacobian()).toAffine()
+	if !ok {
+		return false
+	}
+	return ry.Bit(0) == 0 && rx.Cmp(r) == 0
+}
+
+// TapTweak returns the output key Q = P + hashTapTweak(P || merkleRoot) * G of a taproot output with the internal key
+// P (BIP341). merkleRoot is empty for outputs without a script path.
+func TapTweak(internalKey *PublicKey, merkleRoot []byte) (*PublicKey, error) {
+	tweak := TaggedHash(tapTweakTag, internalKey.SerializeXOnly(), merkleRoot)
+
+	t := new(big.Int).SetBytes(tweak[:])
+	if t.Cmp(N) >= 0 {
+		return nil, ErrInvalidPubKey
+	}
+
+	x, y, ok := jointScalarMult(big.NewInt(1), internalKey.jacobian(), t, generator()).toAffine()
+	if !ok {
+		return nil, ErrInvalidPubKey
+	}
+	return &PublicKey{X: x, Y: y}, nil
+}
@@ -0,0 +1,277 @@
+package secp256k1
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	mathrand "math/rand"
+	"strings"
+	"testing"
+)
+
+// the public key of the coinbase output of block 9 and the signature spending it in block 170
+const (
+	block9PubKey    = "0411db93e1dcdb8a016b49840f8c53bc1eb68a382e97b1482ecad7b148a6909a5cb2e0eaddfb84ccf9744464f82e160bfa9b8b64f9d4c03f999b8643f656b412a3"
+	block170Sig     = "304402204e45e16932b8af514961a1d3a1a25fdf3f4f7732e9d624c6c61548ab5fb8cd410220181522ec8eca07de4860a4acdd12909d831cc56cbbac4622082221a8768d1d09"
+	block170SigHash = "7a05c6145f10101e9d6325494245adf1297d80f8f38d4d576d57cdba220bcb19"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func TestScalarBaseMult(t *testing.T) {
+	tests := []struct {
+		k    int64
+		x, y string
+	}{
+		{1, "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", "483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"},
+		{2, "c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5", "1ae168fea63dc339a3c58419466ceaeef7f632653266d0e1236431a950cfe52a"},
+		{3, "f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9", "388f7b0f632de8140fe337e62a37f3566500a99934c2231b6cb9fd7584b8e672"},
+	}
+
+	for _, test := range tests {
+		x, y, ok := ScalarBaseMult(big.NewInt(test.k))
+		assert.True(t, ok)
+		assert.Equal(t, test.x, hex.EncodeToString(x.Bytes()))
+		assert.Equal(t, test.y, hex.EncodeToString(y.Bytes()))
+	}
+
+	_, _, ok := ScalarBaseMult(N)
+	assert.False(t, ok)
+
+	// (N - 1) * G = -G
+	x, y, ok := ScalarBaseMult(new(big.Int).Sub(N, big.NewInt(1)))
+	assert.True(t, ok)
+	assert.Equal(t, gx, x)
+	assert.Equal(t, new(big.Int).Sub(P, gy), y)
+}
+
+func TestParsePubKey(t *testing.T) {
+	uncompressed := mustDecodeHex(t, block9PubKey)
+
+	key, err := ParsePubKey(uncompressed)
+	require.NoError(t, err)
+	assert.Equal(t, uncompressed, key.SerializeUncompressed())
+
+	compressed := key.SerializeCompressed()
+	assert.Equal(t, byte(0x03), compressed[0])
+
+	decompressed, err := ParsePubKey(compressed)
+	require.NoError(t, err)
+	assert.Equal(t, key.X, decompressed.X)
+	assert.Equal(t, key.Y, decompressed.Y)
+
+	t.Run("hybrid", func(t *testing.T) {
+		hybrid := append([]byte{0x07}, uncompressed[1:]...)
+		_, err := ParsePubKey(hybrid)
+		assert.NoError(t, err)
+
+		hybrid[0] = 0x06
+		_, err = ParsePubKey(hybrid)
+		assert.ErrorIs(t, err, ErrInvalidPubKey)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		notOnCurve := append([]byte{}, uncompressed...)
+		notOnCurve[64] ^= 1
+
+		// x = 5 is not the x coordinate of any point on the curve
+		noSquareRoot := append([]byte{0x02}, make([]byte, 32)...)
+		noSquareRoot[32] = 5
+
+		for _, b := range [][]byte{nil, {0x02}, compressed[:32], uncompressed[:64], notOnCurve, noSquareRoot, append([]byte{0x05}, compressed[1:]...)} {
+			_, err := ParsePubKey(b)
+			assert.ErrorIs(t, err, ErrInvalidPubKey, hex.EncodeToString(b))
+		}
+	})
+}
+
+func TestParseDERSignature(t *testing.T) {
+	strict := mustDecodeHex(t, block170Sig)
+	sig, err := ParseDERSignature(strict)
+	require.NoError(t, err)
+	assert.Equal(t, "4e45e16932b8af514961a1d3a1a25fdf3f4f7732e9d624c6c61548ab5fb8cd41", hex.EncodeToString(sig.R.Bytes()))
+	assert.Equal(t, "181522ec8eca07de4860a4acdd12909d831cc56cbbac4622082221a8768d1d09", hex.EncodeToString(sig.S.Bytes()))
+
+	t.Run("lax encodings", func(t *testing.T) {
+		// zero padded R, long form lengths and trailing garbage
+		padded := mustDecodeHex(t, "30450221004e45e16932b8af514961a1d3a1a25fdf3f4f7732e9d624c6c61548ab5fb8cd410220181522ec8eca07de4860a4acdd12909d831cc56cbbac4622082221a8768d1d09")
+		longForm := mustDecodeHex(t, "308144028120"+"4e45e16932b8af514961a1d3a1a25fdf3f4f7732e9d624c6c61548ab5fb8cd41"+"02820020181522ec8eca07de4860a4acdd12909d831cc56cbbac4622082221a8768d1d09"+"ffff")
+
+		for _, b := range [][]byte{padded, longForm} {
+			lax, err := ParseDERSignature(b)
+			require.NoError(t, err)
+			assert.Equal(t, sig.R, lax.R)
+			assert.Equal(t, sig.S, lax.S)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, s := range []string{"", "31", "30", "3044", "304403", "30440220", "3006020101020201"} {
+			_, err := ParseDERSignature(mustDecodeHex(t, s))
+			assert.ErrorIs(t, err, ErrInvalidSignature, s)
+		}
+	})
+
+	t.Run("overflowing values never verify", func(t *testing.T) {
+		// R is 2^256, which doesn't fit into 32 bytes
+		tooLong := mustDecodeHex(t, "30260221"+"01"+strings.Repeat("00", 32)+"020101")
+		// R is exactly N
+		notBelowN := mustDecodeHex(t, "30250220"+hex.EncodeToString(N.Bytes())+"020101")
+
+		for _, b := range [][]byte{tooLong, notBelowN} {
+			overflow, err := ParseDERSignature(b)
+			require.NoError(t, err)
+			assert.Equal(t, 0, overflow.R.Sign())
+			assert.Equal(t, 0, overflow.S.Sign())
+		}
+	})
+}
+
+func TestVerify(t *testing.T) {
+	key, err := ParsePubKey(mustDecodeHex(t, block9PubKey))
+	require.NoError(t, err)
+
+	sig, err := ParseDERSignature(mustDecodeHex(t, block170Sig))
+	require.NoError(t, err)
+
+	hash := mustDecodeHex(t, block170SigHash)
+	assert.True(t, sig.Verify(hash, key))
+	assert.True(t, sig.IsLowS())
+
+	t.Run("high S is valid", func(t *testing.T) {
+		highS := &Signature{R: sig.R, S: new(big.Int).Sub(N, sig.S)}
+		assert.False(t, highS.IsLowS())
+		assert.True(t, highS.Verify(hash, key))
+	})
+
+	t.Run("wrong hash", func(t *testing.T) {
+		wrong := append([]byte{}, hash...)
+		wrong[0] ^= 1
+		assert.False(t, sig.Verify(wrong, key))
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		x, y, _ := ScalarBaseMult(big.NewInt(2))
+		assert.False(t, sig.Verify(hash, &PublicKey{X: x, Y: y}))
+	})
+
+	t.Run("out of range values", func(t *testing.T) {
+		assert.False(t, (&Signature{R: new(big.Int), S: sig.S}).Verify(hash, key))
+		assert.False(t, (&Signature{R: sig.R, S: N}).Verify(hash, key))
+	})
+}
+
+func TestVerifySchnorr(t *testing.T) {
+	// test vectors 0 and 1 from BIP340
+	tests := []struct {
+		pubKey, msg, sig string
+	}{
+		{
+			"f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9",
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"e907831f80848d1069a5371b402410364bdf1c5f8307b0084c55f1ce2dca821525f66a4a85ea8b71e482a74f382d2ce5ebeee8fdb2172f477df4900d310536c0",
+		},
+		{
+			"dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659",
+			"243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c89",
+			"6896bd60eeae296db48a229ff71dfe071bde413e6d43f917dc8dcf8c78de33418906d11ac976abccb20b091292bff4ea897efcb639ea871cfa95f6de339e4b0a",
+		},
+	}
+
+	for _, test := range tests {
+		key, err := ParseXOnlyPubKey(mustDecodeHex(t, test.pubKey))
+		require.NoError(t, err)
+		assert.Equal(t, test.pubKey, hex.EncodeToString(key.SerializeXOnly()))
+
+		msg := mustDecodeHex(t, test.msg)
+		sig := mustDecodeHex(t, test.sig)
+		assert.True(t, VerifySchnorr(sig, msg, key))
+
+		wrongMsg := append([]byte{}, msg...)
+		wrongMsg[31] ^= 1
+		assert.False(t, VerifySchnorr(sig, wrongMsg, key))
+
+		wrongSig := append([]byte{}, sig...)
+		wrongSig[63] ^= 1
+		assert.False(t, VerifySchnorr(wrongSig, msg, key))
+		assert.False(t, VerifySchnorr(sig[:63], msg, key))
+	}
+
+	t.Run("public key not on the curve", func(t *testing.T) {
+		// test vector 5 from BIP340
+		_, err := ParseXOnlyPubKey(mustDecodeHex(t, "eefdea4cdb677750a420fee807eacf21eb9898ae79b9768766e4faa04a2d4a34"))
+		assert.ErrorIs(t, err, ErrInvalidPubKey)
+	})
+}
+
+func TestEllSwift(t *testing.T) {
+	rand := mathrand.New(mathrand.NewSource(1))
+
+	t.Run("decode", func(t *testing.T) {
+		// the first test vector of BIP324's ellswift_decode_test_vectors.csv
+		x := EllSwiftDecode([EllSwiftPubKeySize]byte{})
+		assert.Equal(t, "edd1fd3e327ce90cc7a3542614289aee9682003e9cf7dcc9cf2ca9743be5aa0c", hex.EncodeToString(x.Bytes()))
+
+		for i := 0; i < 100; i++ {
+			var encoded [EllSwiftPubKeySize]byte
+			rand.Read(encoded[:])
+			assert.True(t, isValidX(EllSwiftDecode(encoded)))
+		}
+	})
+
+	t.Run("encode", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			priv, encoded, err := EllSwiftCreate(rand)
+			require.NoError(t, err)
+
+			x, _, _ := ScalarBaseMult(priv)
+			assert.Equal(t, x, EllSwiftDecode(encoded))
+		}
+	})
+
+	t.Run("every case of the inverse maps back to x", func(t *testing.T) {
+		x, _, _ := ScalarBaseMult(big.NewInt(42))
+		found := 0
+
+		for i := 0; i < 50; i++ {
+			u, err := randomScalar(rand, P)
+			require.NoError(t, err)
+
+			for c := 0; c < 8; c++ {
+				tt, ok := xSwiftECInv(x, u, c)
+				if !ok {
+					continue
+				}
+				found++
+				assert.Equal(t, x, xSwiftEC(u, tt), "u=%x case=%d", u, c)
+			}
+		}
+		assert.Greater(t, found, 0)
+	})
+
+	t.Run("ecdh", func(t *testing.T) {
+		privA, pubA, err := EllSwiftCreate(rand)
+		require.NoError(t, err)
+		privB, pubB, err := EllSwiftCreate(rand)
+		require.NoError(t, err)
+
+		secretA, err := EllSwiftECDH(privA, pubA, pubB, true)
+		require.NoError(t, err)
+		secretB, err := EllSwiftECDH(privB, pubB, pubA, false)
+		require.NoError(t, err)
+		assert.Equal(t, secretA, secretB)
+
+		other, err := EllSwiftECDH(privB, pubB, pubA, true)
+		require.NoError(t, err)
+		assert.NotEqual(t, secretA, other, "the keys are hashed in the order of initiator and responder")
+
+		_, err = EllSwiftECDH(new(big.Int), pubA, pubB, true)
+		assert.ErrorIs(t, err, ErrInvalidPrivKey)
+	})
+}
@@ -0,0 +1,30 @@
+package siphash
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSum64(t *testing.T) {
+	// test vectors from the SipHash reference implementation. the key is 00 01 .. 0f and the input consists of the
+	// first n bytes of 00 01 02 ..
+	const k0, k1 = 0x0706050403020100, 0x0f0e0d0c0b0a0908
+	tests := []struct {
+		length   int
+		expected uint64
+	}{
+		{0, 0x726fdb47dd0e0e31},
+		{1, 0x74f839c593dc67fd},
+		{8, 0x93f5f5799a932462},
+		{15, 0xa129ca6149be45e5},
+		{16, 0x3f2acc7f57c29bdb},
+	}
+
+	for _, test := range tests {
+		data := make([]byte, test.length)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		assert.Equal(t, test.expected, Sum64(k0, k1, data), "length %d", test.length)
+	}
+}
@@ -0,0 +1,200 @@
+package utxo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/vartypes"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var ErrCorruptData = errors.New("corrupt UTXO set data")
+
+// Save writes the set including its undo data to the file at path. The file is replaced atomically, so that a crash
+// while writing doesn't leave a partially written set behind.
+func (s *Set) Save(path string) error {
+	encoded, err := s.encode()
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer tmpFile.Close()
+
+	written, err := tmpFile.Write(encoded)
+	if err != nil {
+		return err
+	}
+	if written != len(encoded) {
+		return io.ErrShortWrite
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// Load reads a set written by Save. If the file doesn't exist, it returns an empty set.
+func Load(path string) (*Set, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return NewSet(), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return decodeSet(bytes.NewBuffer(data))
+}
+
+func (s *Set) encode() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.Write(s.tip[:])
+	if err := binary.Write(buf, binary.LittleEndian, s.height); err != nil {
+		return nil, err
+	}
+
+	if err := vartypes.WriteAsVarInt(buf, uint64(len(s.coins))); err != nil {
+		return nil, err
+	}
+
+	for outPoint, coin := range s.coins {
+		buf.Write(outPoint.Hash[:])
+		if err := binary.Write(buf, binary.LittleEndian, outPoint.Index); err != nil {
+			return nil, err
+		}
+
+		if err := encodeCoin(buf, coin); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := vartypes.WriteAsVarInt(buf, uint64(len(s.undo))); err != nil {
+		return nil, err
+	}
+
+	for _, undo := range s.undo {
+		buf.Write(undo.Hash[:])
+		if err := vartypes.WriteAsVarInt(buf, uint64(len(undo.Spent))); err != nil {
+			return nil, err
+		}
+
+		for _, coin := range undo.Spent {
+			if err := encodeCoin(buf, coin); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decodeSet(buf *bytes.Buffer) (*Set, error) {
+	s := NewSet()
+	if buf.Len() < btc.BlockHashSize+4 {
+		return nil, ErrCorruptData
+	}
+
+	copy(s.tip[:], buf.Next(btc.BlockHashSize))
+	s.height = int32(binary.LittleEndian.Uint32(buf.Next(4)))
+
+	count, ok := vartypes.DecodeVarInt(buf)
+	if !ok {
+		return nil, ErrCorruptData
+	}
+
+	for i := uint64(0); i < count.Value; i++ {
+		if buf.Len() < btc.TxHashSize+4 {
+			return nil, ErrCorruptData
+		}
+
+		var outPoint btc.OutPoint
+		copy(outPoint.Hash[:], buf.Next(btc.TxHashSize))
+		outPoint.Index = binary.LittleEndian.Uint32(buf.Next(4))
+
+		coin, err := decodeCoin(buf)
+		if err != nil {
+			return nil, err
+		}
+		s.coins[outPoint] = coin
+	}
+
+	undoCount, ok := vartypes.DecodeVarInt(buf)
+	if !ok || undoCount.Value > MaxUndoDepth {
+		return nil, ErrCorruptData
+	}
+
+	for i := uint64(0); i < undoCount.Value; i++ {
+		if buf.Len() < btc.BlockHashSize {
+			return nil, ErrCorruptData
+		}
+
+		undo := new(BlockUndo)
+		copy(undo.Hash[:], buf.Next(btc.BlockHashSize))
+
+		spentCount, ok := vartypes.DecodeVarInt(buf)
+		if !ok {
+			return nil, ErrCorruptData
+		}
+
+		for j := uint64(0); j < spentCount.Value; j++ {
+			coin, err := decodeCoin(buf)
+			if err != nil {
+				return nil, err
+			}
+			undo.Spent = append(undo.Spent, coin)
+		}
+		s.undo = append(s.undo, undo)
+	}
+
+	if buf.Len() > 0 {
+		return nil, ErrCorruptData
+	}
+	return s, nil
+}
+
+// encodeCoin writes the height of the coin shifted left by one with the coinbase flag in the lowest bit, followed by
+// the output.
+func encodeCoin(buf *bytes.Buffer, coin btc.Coin) error {
+	code := uint64(coin.Height) << 1
+	if coin.IsCoinbase {
+		code |= 1
+	}
+
+	if err := vartypes.WriteAsVarInt(buf, code); err != nil {
+		return err
+	}
+
+	encoded, err := coin.Output.Encode()
+	if err != nil {
+		return err
+	}
+
+	_, err = buf.Write(encoded)
+	return err
+}
+
+func decodeCoin(buf *bytes.Buffer) (btc.Coin, error) {
+	code, ok := vartypes.DecodeVarInt(buf)
+	if !ok || code.Value>>1 > uint64(^uint32(0)>>1) {
+		return btc.Coin{}, ErrCorruptData
+	}
+
+	out, err := btc.DecodeTxOutput(buf)
+	if err != nil {
+		return btc.Coin{}, err
+	}
+
+	return btc.Coin{
+		Output:     out,
+		Height:     int32(code.Value >> 1),
+		IsCoinbase: code.Value&1 == 1,
+	}, nil
+}
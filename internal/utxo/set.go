@@ -0,0 +1,185 @@
+// Package utxo maintains the set of unspent transaction outputs of the best chain.
+package utxo
+
+import (
+	"errors"
+	"fmt"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/haikoschol/btc-node-challenge/internal/script"
+)
+
+// MaxUndoDepth is the number of most recent blocks for which undo data is kept, which limits how deep a reorg can be.
+const MaxUndoDepth = 288
+
+var (
+	ErrMissingInput = errors.New("input spends a missing or already spent output")
+	ErrNotOnTip     = errors.New("block doesn't connect to the tip of the UTXO set")
+	ErrNoUndoData   = errors.New("no undo data for block")
+)
+
+// BlockUndo holds the outputs spent by a block, which are needed to restore them when the block is disconnected.
+type BlockUndo struct {
+	Hash btc.BlockHash
+	// Spent are the coins spent by the inputs of the block, in the order of the inputs.
+	Spent []btc.Coin
+}
+
+// Set is the set of unspent transaction outputs after connecting the blocks of a chain in order. It keeps the undo
+// data of the last MaxUndoDepth blocks, so that they can be disconnected again.
+type Set struct {
+	coins  map[btc.OutPoint]btc.Coin
+	tip    btc.BlockHash
+	height int32
+	// undo holds the undo data of the most recent blocks, oldest first
+	undo []*BlockUndo
+}
+
+// NewSet returns an empty set that the genesis block can be connected to.
+func NewSet() *Set {
+	return &Set{
+		coins:  make(map[btc.OutPoint]btc.Coin),
+		height: -1,
+	}
+}
+
+func (s *Set) Coin(outPoint btc.OutPoint) (btc.Coin, bool) {
+	coin, ok := s.coins[outPoint]
+	return coin, ok
+}
+
+// Tip returns the hash and height of the last connected block. The height is -1 if no block has been connected yet.
+func (s *Set) Tip() (btc.BlockHash, int32) {
+	return s.tip, s.height
+}
+
+// Size returns the number of unspent outputs.
+func (s *Set) Size() int {
+	return len(s.coins)
+}
+
+// UndoDepth returns the number of blocks that can be disconnected with the undo data kept by the set.
+func (s *Set) UndoDepth() int {
+	return len(s.undo)
+}
+
+// ConnectBlock spends the outputs referenced by the inputs of the block and adds its outputs to the set. The block
+// must be the child of the tip. Outputs created by a transaction can be spent by later transactions in the same block.
+// If an input spends a missing output, the set is left unchanged.
+func (s *Set) ConnectBlock(block *btc.Block) error {
+	hash, err := block.Hash()
+	if err != nil {
+		return err
+	}
+
+	if block.Header.PrevBlock != s.tip {
+		return ErrNotOnTip
+	}
+
+	height := s.height + 1
+	undo := &BlockUndo{Hash: hash}
+
+	// the outputs of the genesis block are not spendable, because it is never connected in the original implementation
+	if height > 0 {
+		for i := range block.Transactions {
+			if err := s.connectTx(&block.Transactions[i], height, undo); err != nil {
+				s.disconnectTxs(block.Transactions[:i], undo.Spent)
+				return err
+			}
+		}
+	}
+
+	s.tip = hash
+	s.height = height
+	s.undo = append(s.undo, undo)
+	if len(s.undo) > MaxUndoDepth {
+		s.undo = s.undo[1:]
+	}
+	return nil
+}
+
+// DisconnectBlock reverts ConnectBlock for the tip, removing the outputs of the block and restoring the outputs it
+// spent.
+func (s *Set) DisconnectBlock(block *btc.Block) error {
+	hash, err := block.Hash()
+	if err != nil {
+		return err
+	}
+
+	if hash != s.tip {
+		return ErrNotOnTip
+	}
+
+	if len(s.undo) == 0 || s.undo[len(s.undo)-1].Hash != hash {
+		return fmt.Errorf("%w %s", ErrNoUndoData, hash)
+	}
+	undo := s.undo[len(s.undo)-1]
+
+	if s.height > 0 {
+		s.disconnectTxs(block.Transactions, undo.Spent)
+	}
+
+	s.undo = s.undo[:len(s.undo)-1]
+	s.tip = block.Header.PrevBlock
+	s.height--
+	return nil
+}
+
+func (s *Set) connectTx(tx *btc.Transaction, height int32, undo *BlockUndo) error {
+	txid, err := tx.TxID()
+	if err != nil {
+		return err
+	}
+
+	isCoinbase := tx.IsCoinbase()
+	if !isCoinbase {
+		for i, in := range tx.TxIn {
+			coin, ok := s.coins[in.PreviousOutput]
+			if !ok {
+				s.restoreInputs(tx.TxIn[:i], undo.Spent[len(undo.Spent)-i:])
+				undo.Spent = undo.Spent[:len(undo.Spent)-i]
+				return fmt.Errorf("%w %s:%d", ErrMissingInput, in.PreviousOutput.Hash, in.PreviousOutput.Index)
+			}
+
+			delete(s.coins, in.PreviousOutput)
+			undo.Spent = append(undo.Spent, coin)
+		}
+	}
+
+	for i, out := range tx.TxOut {
+		if script.IsUnspendable(out.ScriptPubKey) {
+			continue
+		}
+
+		// outputs of duplicate coinbase transactions overwrite the earlier ones, which makes those unspendable (BIP30)
+		outPoint := btc.OutPoint{Hash: txid, Index: uint32(i)}
+		s.coins[outPoint] = btc.Coin{Output: out, Height: height, IsCoinbase: isCoinbase}
+	}
+	return nil
+}
+
+// disconnectTxs reverts connectTx for the given transactions in reverse order. spent are the coins spent by their
+// inputs.
+func (s *Set) disconnectTxs(txs []btc.Transaction, spent []btc.Coin) {
+	for i := len(txs) - 1; i >= 0; i-- {
+		tx := &txs[i]
+		txid, err := tx.TxID()
+		if err != nil {
+			continue
+		}
+
+		for j := range tx.TxOut {
+			delete(s.coins, btc.OutPoint{Hash: txid, Index: uint32(j)})
+		}
+
+		if !tx.IsCoinbase() {
+			s.restoreInputs(tx.TxIn, spent[len(spent)-len(tx.TxIn):])
+			spent = spent[:len(spent)-len(tx.TxIn)]
+		}
+	}
+}
+
+func (s *Set) restoreInputs(inputs []btc.TxInput, spent []btc.Coin) {
+	for i, in := range inputs {
+		s.coins[in.PreviousOutput] = spent[i]
+	}
+}
@@ -0,0 +1,214 @@
+package utxo
+
+import (
+	"bytes"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maps"
+	"path/filepath"
+	"testing"
+)
+
+func coinbaseTx(tag byte, values ...int64) btc.Transaction {
+	tx := btc.Transaction{
+		Version: 1,
+		TxIn: []btc.TxInput{{
+			PreviousOutput:  btc.OutPoint{Index: 0xffffffff},
+			SignatureScript: []byte{tag},
+		}},
+	}
+	for _, value := range values {
+		tx.TxOut = append(tx.TxOut, btc.TxOutput{Value: value, ScriptPubKey: []byte{0x51}})
+	}
+	return tx
+}
+
+func spendTx(t *testing.T, prev []btc.Transaction, outputs ...int64) btc.Transaction {
+	t.Helper()
+
+	tx := btc.Transaction{Version: 1}
+	for _, p := range prev {
+		txid, err := p.TxID()
+		require.NoError(t, err)
+		tx.TxIn = append(tx.TxIn, btc.TxInput{PreviousOutput: btc.OutPoint{Hash: txid}})
+	}
+	for _, value := range outputs {
+		tx.TxOut = append(tx.TxOut, btc.TxOutput{Value: value, ScriptPubKey: []byte{0x51}})
+	}
+	return tx
+}
+
+func newBlock(t *testing.T, prev *btc.Block, txs ...btc.Transaction) *btc.Block {
+	t.Helper()
+
+	block := &btc.Block{Transactions: txs}
+	if prev != nil {
+		hash, err := prev.Hash()
+		require.NoError(t, err)
+		block.Header.PrevBlock = hash
+		block.Header.Nonce = prev.Header.Nonce + 1
+	}
+	return block
+}
+
+func outPoint(t *testing.T, tx btc.Transaction, index uint32) btc.OutPoint {
+	t.Helper()
+
+	txid, err := tx.TxID()
+	require.NoError(t, err)
+	return btc.OutPoint{Hash: txid, Index: index}
+}
+
+func TestConnectBlock(t *testing.T) {
+	genesisCoinbase := coinbaseTx(0, 50)
+	genesis := newBlock(t, nil, genesisCoinbase)
+
+	coinbase1 := coinbaseTx(1, 50)
+	block1 := newBlock(t, genesis, coinbase1)
+
+	coinbase2 := coinbaseTx(2, 50)
+	spend := spendTx(t, []btc.Transaction{coinbase1}, 20, 30)
+	// spends an output created earlier in the same block
+	chained := spendTx(t, []btc.Transaction{spend}, 20)
+	opReturn := btc.Transaction{Version: 1, TxIn: []btc.TxInput{{PreviousOutput: outPoint(t, spend, 1)}}, TxOut: []btc.TxOutput{{ScriptPubKey: []byte{0x6a}}}}
+	block2 := newBlock(t, block1, coinbase2, spend, chained, opReturn)
+
+	s := NewSet()
+	require.NoError(t, s.ConnectBlock(genesis))
+	_, ok := s.Coin(outPoint(t, genesisCoinbase, 0))
+	assert.False(t, ok, "genesis outputs are unspendable")
+
+	require.NoError(t, s.ConnectBlock(block1))
+	coin, ok := s.Coin(outPoint(t, coinbase1, 0))
+	require.True(t, ok)
+	assert.Equal(t, btc.Coin{Output: coinbase1.TxOut[0], Height: 1, IsCoinbase: true}, coin)
+
+	require.NoError(t, s.ConnectBlock(block2))
+	hash2, err := block2.Hash()
+	require.NoError(t, err)
+	tip, height := s.Tip()
+	assert.Equal(t, hash2, tip)
+	assert.Equal(t, int32(2), height)
+
+	for _, spent := range []btc.OutPoint{outPoint(t, coinbase1, 0), outPoint(t, spend, 0), outPoint(t, spend, 1)} {
+		_, ok := s.Coin(spent)
+		assert.False(t, ok)
+	}
+	_, ok = s.Coin(outPoint(t, opReturn, 0))
+	assert.False(t, ok, "OP_RETURN outputs are not added")
+	assert.Equal(t, 2, s.Size())
+
+	t.Run("disconnect", func(t *testing.T) {
+		s := NewSet()
+		require.NoError(t, s.ConnectBlock(genesis))
+		require.NoError(t, s.ConnectBlock(block1))
+		before := maps.Clone(s.coins)
+
+		require.NoError(t, s.ConnectBlock(block2))
+		assert.ErrorIs(t, s.DisconnectBlock(block1), ErrNotOnTip)
+
+		require.NoError(t, s.DisconnectBlock(block2))
+		assert.Equal(t, before, s.coins)
+
+		hash1, err := block1.Hash()
+		require.NoError(t, err)
+		tip, height := s.Tip()
+		assert.Equal(t, hash1, tip)
+		assert.Equal(t, int32(1), height)
+
+		require.NoError(t, s.DisconnectBlock(block1))
+		require.NoError(t, s.DisconnectBlock(genesis))
+		assert.Equal(t, 0, s.Size())
+		_, height = s.Tip()
+		assert.Equal(t, int32(-1), height)
+	})
+
+	t.Run("missing input leaves the set unchanged", func(t *testing.T) {
+		s := NewSet()
+		require.NoError(t, s.ConnectBlock(genesis))
+		require.NoError(t, s.ConnectBlock(block1))
+		before := len(s.coins)
+
+		doubleSpend := spendTx(t, []btc.Transaction{coinbase1}, 50)
+		invalid := newBlock(t, block1, coinbase2, spend, chained, doubleSpend)
+		assert.ErrorIs(t, s.ConnectBlock(invalid), ErrMissingInput)
+		assert.Len(t, s.coins, before)
+
+		_, ok := s.Coin(outPoint(t, coinbase1, 0))
+		assert.True(t, ok)
+
+		// the block can be connected after a failed attempt to connect a sibling
+		assert.NoError(t, s.ConnectBlock(block2))
+	})
+
+	t.Run("block must extend the tip", func(t *testing.T) {
+		s := NewSet()
+		require.NoError(t, s.ConnectBlock(genesis))
+		assert.ErrorIs(t, s.ConnectBlock(block2), ErrNotOnTip)
+	})
+
+	t.Run("undo data is limited", func(t *testing.T) {
+		s := NewSet()
+		blocks := []*btc.Block{genesis}
+		require.NoError(t, s.ConnectBlock(genesis))
+
+		for i := 0; i < MaxUndoDepth+1; i++ {
+			block := newBlock(t, blocks[len(blocks)-1], coinbaseTx(byte(i), 1))
+			require.NoError(t, s.ConnectBlock(block))
+			blocks = append(blocks, block)
+		}
+		assert.Equal(t, MaxUndoDepth, s.UndoDepth())
+
+		for i := 0; i < MaxUndoDepth; i++ {
+			require.NoError(t, s.DisconnectBlock(blocks[len(blocks)-1-i]))
+		}
+		assert.ErrorIs(t, s.DisconnectBlock(blocks[len(blocks)-1-MaxUndoDepth]), ErrNoUndoData)
+	})
+}
+
+func TestSaveLoad(t *testing.T) {
+	genesis := newBlock(t, nil, coinbaseTx(0, 50))
+	coinbase1 := coinbaseTx(1, 50)
+	block1 := newBlock(t, genesis, coinbase1)
+	block2 := newBlock(t, block1, coinbaseTx(2, 50), spendTx(t, []btc.Transaction{coinbase1}, 10, 40))
+
+	s := NewSet()
+	for _, block := range []*btc.Block{genesis, block1, block2} {
+		require.NoError(t, s.ConnectBlock(block))
+	}
+
+	path := filepath.Join(t.TempDir(), "utxo.bin")
+	require.NoError(t, s.Save(path))
+
+	loaded, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, s.coins, loaded.coins)
+	assert.Equal(t, s.undo, loaded.undo)
+	tip, height := loaded.Tip()
+	expectedTip, expectedHeight := s.Tip()
+	assert.Equal(t, expectedTip, tip)
+	assert.Equal(t, expectedHeight, height)
+
+	// the undo data survives the restart
+	require.NoError(t, loaded.DisconnectBlock(block2))
+	_, ok := loaded.Coin(outPoint(t, coinbase1, 0))
+	assert.True(t, ok)
+
+	t.Run("missing file", func(t *testing.T) {
+		s, err := Load(filepath.Join(t.TempDir(), "missing.bin"))
+		require.NoError(t, err)
+		assert.Equal(t, 0, s.Size())
+	})
+
+	t.Run("corrupt file", func(t *testing.T) {
+		encoded, err := s.encode()
+		require.NoError(t, err)
+
+		_, err = decodeSet(bytes.NewBuffer(encoded[:len(encoded)-1]))
+		assert.Error(t, err)
+
+		_, err = decodeSet(bytes.NewBuffer(append(encoded, 0)))
+		assert.ErrorIs(t, err, ErrCorruptData)
+	})
+}
@@ -43,18 +43,20 @@ func (v VarInt) Encode() []byte {
 		e[0] = byte(v.Value)
 	case Uint16Size:
 		e[0] = 0xFD
-		binary.BigEndian.PutUint16(e[1:], uint16(v.Value))
+		binary.LittleEndian.PutUint16(e[1:], uint16(v.Value))
 	case Uint32Size:
 		e[0] = 0xFE
-		binary.BigEndian.PutUint32(e[1:], uint32(v.Value))
+		binary.LittleEndian.PutUint32(e[1:], uint32(v.Value))
 	case Uint64Size:
 		e[0] = 0xFF
-		binary.BigEndian.PutUint64(e[1:], v.Value)
+		binary.LittleEndian.PutUint64(e[1:], v.Value)
 	}
 
 	return e
 }
 
+// DecodeVarInt reads a variable length integer from buf. Values that are not encoded with the smallest possible size
+// are rejected, so that decoding and encoding a value always results in the same bytes.
 func DecodeVarInt(buf *bytes.Buffer) (res VarInt, ok bool) {
 	if buf.Len() == 0 {
 		return
@@ -69,26 +71,30 @@ func DecodeVarInt(buf *bytes.Buffer) (res VarInt, ok bool) {
 	switch b {
 	case 0xFD:
 		res.Size = Uint16Size
-		if buf.Len() < int(res.Size) {
+		if buf.Len() < int(res.Size-1) {
 			return
 		}
 		res.Value = uint64(binary.LittleEndian.Uint16(buf.Next(int(res.Size - 1))))
 	case 0xFE:
-		if buf.Len() < int(res.Size) {
+		res.Size = Uint32Size
+		if buf.Len() < int(res.Size-1) {
 			return
 		}
-		res.Size = Uint32Size
 		res.Value = uint64(binary.LittleEndian.Uint32(buf.Next(int(res.Size - 1))))
 	case 0xFF:
-		if buf.Len() < int(res.Size) {
+		res.Size = Uint64Size
+		if buf.Len() < int(res.Size-1) {
 			return
 		}
-		res.Size = Uint64Size
 		res.Value = binary.LittleEndian.Uint64(buf.Next(int(res.Size - 1)))
 	default:
 		res.Value = uint64(b)
 	}
 
+	if NewVarInt(res.Value).Size != res.Size {
+		return VarInt{}, false
+	}
+
 	return res, true
 }
 
@@ -0,0 +1,47 @@
+package vartypes
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVarInt(t *testing.T) {
+	cases := []struct {
+		value   uint64
+		encoded []byte
+	}{
+		{0, []byte{0x00}},
+		{0xFC, []byte{0xFC}},
+		{0xFD, []byte{0xFD, 0xFD, 0x00}},
+		{0x1234, []byte{0xFD, 0x34, 0x12}},
+		{0x10000, []byte{0xFE, 0x00, 0x00, 0x01, 0x00}},
+		{0x100000000, []byte{0xFF, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00}},
+	}
+
+	for _, c := range cases {
+		t.Run("encodes little endian", func(t *testing.T) {
+			assert.Equal(t, c.encoded, NewVarInt(c.value).Encode())
+		})
+
+		t.Run("decodes what it encodes", func(t *testing.T) {
+			v, ok := DecodeVarInt(bytes.NewBuffer(c.encoded))
+			assert.True(t, ok)
+			assert.Equal(t, NewVarInt(c.value), v)
+		})
+	}
+
+	t.Run("rejects truncated values", func(t *testing.T) {
+		for _, encoded := range [][]byte{{}, {0xFD, 0x00}, {0xFE, 0x00, 0x00, 0x01}, {0xFF, 0x00}} {
+			_, ok := DecodeVarInt(bytes.NewBuffer(encoded))
+			assert.False(t, ok)
+		}
+	})
+
+	t.Run("rejects non-canonical encodings", func(t *testing.T) {
+		for _, encoded := range [][]byte{{0xFD, 0x01, 0x00}, {0xFE, 0xFF, 0xFF, 0x00, 0x00}, {0xFF, 0x01, 0, 0, 0, 0, 0, 0, 0}} {
+			_, ok := DecodeVarInt(bytes.NewBuffer(encoded))
+			assert.False(t, ok)
+		}
+	})
+}
@@ -2,17 +2,54 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"github.com/haikoschol/btc-node-challenge/internal/btc"
 	"github.com/haikoschol/btc-node-challenge/internal/network"
 	"log"
+	"math/rand/v2"
+	"net"
 	"net/netip"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"time"
 )
 
 func main() {
-	statePath, err := getStatePath("state.bin")
+	networkName := flag.String(
+		"network",
+		btc.MainNetParams.Name,
+		"network to join: mainnet, testnet3, testnet4, signet or regtest",
+	)
+	connect := flag.String(
+		"connect",
+		"",
+		"host[:port] of the first node to connect to. defaults to a node returned by the DNS seeds of the network",
+	)
+	generate := flag.Int("generate", 0, "number of blocks to mine on startup. only supported on regtest")
+	generateAddr := flag.String("address", "", "address the blocks mined with -generate pay to")
+	listen := flag.String("listen", "", "host:port to accept inbound connections on, e.g. :8333. disabled by default")
+	maxInbound := flag.Int("maxinbound", 100, "maximum number of inbound connections")
+	flag.Parse()
+
+	params, err := btc.ParamsByName(*networkName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	statePath, err := getStatePath(params, "state.bin")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	utxoPath, err := getStatePath(params, "utxo.bin")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	peerAddr, peerPort, err := findPeer(params, *connect)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -20,14 +57,27 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
 
-	peerAddr := netip.MustParseAddr("95.168.169.66")
-	peerPort := uint16(8333)
-
-	pool, err := network.NewNodePool(peerAddr, peerPort, 10, statePath)
+	pool, err := network.NewNodePool(params, peerAddr, peerPort, 10, statePath, utxoPath)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if *listen != "" {
+		if err := pool.Listen(*listen, *maxInbound); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *generate > 0 {
+		hashes, err := pool.GenerateToAddress(*generate, *generateAddr)
+		for _, hash := range hashes {
+			log.Printf("generated block %s", hash)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	select {
 	case <-ctx.Done():
 		log.Println("shutting down...")
@@ -50,11 +100,69 @@ func shutdown(pool *network.NodePool) {
 	os.Exit(1)
 }
 
-func getStatePath(name string) (string, error) {
-	wd, err := os.Getwd()
+// getStatePath returns the path of a state file in the working directory. The files of networks other than mainnet are
+// kept in a subdirectory named after the network.
+func getStatePath(params *btc.ChainParams, name string) (string, error) {
+	dir, err := os.Getwd()
 	if err != nil {
 		return "", err
 	}
 
-	return filepath.Abs(filepath.Join(wd, name))
+	if params != &btc.MainNetParams {
+		dir = filepath.Join(dir, params.Name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return "", err
+		}
+	}
+
+	return filepath.Abs(filepath.Join(dir, name))
+}
+
+// findPeer returns the address of the first node to connect to. That is either the given host[:port] or a node the DNS
+// seeds of the network return.
+func findPeer(params *btc.ChainParams, hostPort string) (netip.Addr, uint16, error) {
+	if hostPort != "" {
+		return resolvePeer(hostPort, params.DefaultPort)
+	}
+
+	for _, seed := range params.DNSSeeds {
+		addrs, err := net.LookupHost(seed)
+		if err != nil || len(addrs) == 0 {
+			log.Printf("looking up DNS seed %s failed: %v", seed, err)
+			continue
+		}
+
+		addr, err := netip.ParseAddr(addrs[rand.IntN(len(addrs))])
+		if err == nil {
+			return addr.Unmap(), params.DefaultPort, nil
+		}
+	}
+
+	return netip.Addr{}, 0, fmt.Errorf("no node found via the DNS seeds of %s. use -connect", params.Name)
+}
+
+func resolvePeer(hostPort string, defaultPort uint16) (netip.Addr, uint16, error) {
+	host, portStr, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		host, portStr = hostPort, strconv.Itoa(int(defaultPort))
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return netip.Addr{}, 0, fmt.Errorf("invalid port in %s: %w", hostPort, err)
+	}
+
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return netip.Addr{}, 0, err
+	}
+	if len(addrs) == 0 {
+		return netip.Addr{}, 0, fmt.Errorf("no address found for %s", host)
+	}
+
+	addr, err := netip.ParseAddr(addrs[0])
+	if err != nil {
+		return netip.Addr{}, 0, err
+	}
+	return addr.Unmap(), uint16(port), nil
 }